@@ -9,15 +9,34 @@ import (
 	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
 	"github.com/spaghetti-lover/sharingan-db/internal/sql"
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
 )
 
-const (
-	dbFile   = "sharingan.db"
-	walFile  = "sharingan.wal"
-	metaFile = "sharingan.wal.meta"
+// Files of the REPL's database, in the working directory
+var (
+	dbFile  = "sharingan.db"
+	walFile = "sharingan.wal"
 )
 
 func main() {
+	// sharingan-db load ... bulk loads a file and exits (see load.go)
+	if len(os.Args) > 1 && os.Args[1] == "load" {
+		if err := runLoad(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Load failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// sharingan-db restore <file> replaces the database with a backup and exits
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := runRestore(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Restore failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Println("🔥 Sharingan DB - Interactive Shell")
 	fmt.Println("Type 'help' for commands, 'exit' to quit")
 	fmt.Println()
@@ -38,7 +57,7 @@ func main() {
 func initDatabase() (*bptree.BPTree, storage.Pager, *storage.BufferPool, error) {
 	// Check if database exists
 	dbExists := fileExists(dbFile)
-	walExists := fileExists(walFile) && fileExists(metaFile)
+	walExists := fileExists(walFile)
 
 	if !dbExists {
 		fmt.Println("📁 Creating new database...")
@@ -65,7 +84,7 @@ func initDatabase() (*bptree.BPTree, storage.Pager, *storage.BufferPool, error)
 	bufferPool := storage.NewBufferPool(pager, 128)
 
 	// Load metadata
-	rootPageID, order, err := bptree.LoadMetadata(metaFile)
+	rootPageID, order, err := bptree.LoadMetadata(bufferPool, walFile)
 	if err != nil {
 		bufferPool.Close()
 		return nil, nil, nil, fmt.Errorf("failed to load metadata: %w", err)
@@ -100,12 +119,6 @@ func createFreshDatabase() (*bptree.BPTree, storage.Pager, *storage.BufferPool,
 
 // recoverFromWAL recovers database from WAL
 func recoverFromWAL() (*bptree.BPTree, storage.Pager, *storage.BufferPool, error) {
-	// Load metadata
-	rootPageID, order, err := bptree.LoadMetadata(metaFile)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
 	// Open pager
 	pager, err := storage.NewFilePager(dbFile)
 	if err != nil {
@@ -115,6 +128,13 @@ func recoverFromWAL() (*bptree.BPTree, storage.Pager, *storage.BufferPool, error
 	// Create buffer pool
 	bufferPool := storage.NewBufferPool(pager, 128)
 
+	// Load metadata
+	rootPageID, order, err := bptree.LoadMetadata(bufferPool, walFile)
+	if err != nil {
+		bufferPool.Close()
+		return nil, nil, nil, err
+	}
+
 	// Load tree (will replay WAL automatically)
 	tree, err := bptree.LoadBPTree(bufferPool, rootPageID, order, walFile)
 	if err != nil {
@@ -129,8 +149,15 @@ func recoverFromWAL() (*bptree.BPTree, storage.Pager, *storage.BufferPool, error
 func runREPL(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 	scanner := bufio.NewScanner(os.Stdin)
 
+	// One SQL session for the whole shell so BEGIN ... COMMIT can span lines
+	executor := sql.NewExecutor(tree)
+
 	for {
-		fmt.Print("db> ")
+		if executor.InTransaction() {
+			fmt.Print("db*> ")
+		} else {
+			fmt.Print("db> ")
+		}
 
 		if !scanner.Scan() {
 			break
@@ -161,8 +188,11 @@ func runREPL(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 		}
 
 		// Execute SQL query
-		result, err := sql.ParseAndExecute(line, tree)
+		result, err := executor.ExecuteSQL(line)
 		if err != nil {
+			if result != "" {
+				fmt.Println(result)
+			}
 			fmt.Printf("Error: %v\n", err)
 			continue
 		}
@@ -170,6 +200,11 @@ func runREPL(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 		fmt.Println(result)
 	}
 
+	if executor.InTransaction() {
+		executor.ExecuteSQL("ROLLBACK;")
+		fmt.Println("⚠️  Open transaction rolled back")
+	}
+
 	if err := scanner.Err(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
 	}
@@ -177,7 +212,9 @@ func runREPL(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 
 // handleMetaCommand handles meta commands (starting with .)
 func handleMetaCommand(cmd string, tree *bptree.BPTree, bufferPool *storage.BufferPool) {
-	switch cmd {
+	fields := strings.Fields(cmd)
+
+	switch fields[0] {
 	case ".stats", ".statistics":
 		showStats(tree, bufferPool)
 
@@ -197,6 +234,24 @@ func handleMetaCommand(cmd string, tree *bptree.BPTree, bufferPool *storage.Buff
 	case ".keys":
 		showAllKeys(tree)
 
+	case ".tables":
+		showTables(tree)
+
+	case ".checkpoint":
+		runCheckpoint(tree)
+
+	case ".vacuum":
+		runVacuum(tree)
+
+	case ".backup":
+		runBackup(tree, fields[1:])
+
+	case ".sync":
+		runSync(tree, fields[1:])
+
+	case ".archive":
+		runArchive(tree, fields[1:])
+
 	default:
 		fmt.Printf("Unknown meta command: %s\n", cmd)
 		fmt.Println("Type '.help' for available meta commands")
@@ -206,9 +261,23 @@ func handleMetaCommand(cmd string, tree *bptree.BPTree, bufferPool *storage.Buff
 // showStats displays database statistics
 func showStats(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 	fmt.Println("\n📊 Database Statistics:")
+	fmt.Printf("   File Format: v%d\n", bufferPool.Superblock().Version)
+	fmt.Printf("   Page Size: %d bytes\n", bufferPool.PageSize())
 	fmt.Printf("   Root Page: %d\n", tree.GetRootPageID())
 	fmt.Printf("   Tree Order: %d\n", tree.GetOrder())
 	fmt.Printf("   WAL Syncs: %d\n", tree.GetWALSyncCount())
+	fmt.Printf("   Checkpoint LSN: %d\n", tree.GetCheckpointLSN())
+	if err := tree.CheckpointError(); err != nil {
+		fmt.Printf("   Last auto checkpoint failed: %v\n", err)
+	}
+	fmt.Printf("   Sync Mode: %s\n", tree.GetWALDurability())
+
+	commit := tree.GetWALGroupCommitStats()
+	fmt.Printf("\n📝 WAL Group Commit:\n")
+	fmt.Printf("   Batches: %d\n", commit.Batches)
+	fmt.Printf("   Avg Batch Size: %.2f (max %d)\n", commit.AvgBatchSize, commit.MaxBatchSize)
+	fmt.Printf("   Avg Sync Time: %v\n", commit.AvgSyncTime)
+	fmt.Printf("   Avg Commit Latency: %v\n", commit.AvgCommitLatency)
 
 	stats := bufferPool.GetStats()
 	fmt.Printf("\n📦 Buffer Pool:\n")
@@ -226,7 +295,7 @@ func showStats(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 		fmt.Printf("\n📚 Data:\n")
 		fmt.Printf("   Total Keys: %d\n", len(keys))
 		if len(keys) > 0 {
-			fmt.Printf("   Key Range: [%d, %d]\n", keys[0], keys[len(keys)-1])
+			fmt.Printf("   Key Range: [%s, %s]\n", bptree.FormatKey(keys[0]), bptree.FormatKey(keys[len(keys)-1]))
 		}
 	}
 
@@ -242,6 +311,54 @@ func showStats(tree *bptree.BPTree, bufferPool *storage.BufferPool) {
 	fmt.Println()
 }
 
+// runCheckpoint flushes dirty pages and truncates the WAL
+func runCheckpoint(tree *bptree.BPTree) {
+	info, err := tree.Checkpoint()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Println("\n💾 Checkpoint complete:")
+	fmt.Printf("   Checkpoint LSN: %d\n", info.LSN)
+	fmt.Printf("   WAL Freed: %.2f KB\n", float64(info.WALFreed)/1024)
+	fmt.Printf("   Duration: %v\n", info.Duration)
+	fmt.Println()
+}
+
+// runVacuum moves live pages to the front of the file and truncates it
+func runVacuum(tree *bptree.BPTree) {
+	info, err := tree.Vacuum()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	fmt.Println("\n🧹 Vacuum complete:")
+	fmt.Printf("   Pages: %d -> %d (%d moved)\n", info.PagesBefore, info.PagesAfter, info.PagesMoved)
+	fmt.Printf("   Reclaimed: %.2f KB\n", float64(info.BytesReclaimed)/1024)
+	fmt.Printf("   Duration: %v\n", info.Duration)
+	fmt.Println()
+}
+
+// runSync shows the WAL sync mode, or changes it when a mode is given
+func runSync(tree *bptree.BPTree, args []string) {
+	if len(args) > 0 {
+		mode, err := wal.ParseSyncMode(args[0])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+
+		if err := tree.SetWALSyncMode(mode, 0); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
+
+	fmt.Printf("Sync mode: %s\n", tree.GetWALDurability())
+}
+
 // showTreeInfo displays B+ Tree structure info
 func showTreeInfo(tree *bptree.BPTree) {
 	fmt.Println("\n🌲 B+ Tree Information:")
@@ -258,8 +375,8 @@ func showTreeInfo(tree *bptree.BPTree) {
 	fmt.Printf("   Total Keys: %d\n", len(keys))
 
 	if len(keys) > 0 {
-		fmt.Printf("   Min Key: %d\n", keys[0])
-		fmt.Printf("   Max Key: %d\n", keys[len(keys)-1])
+		fmt.Printf("   Min Key: %s\n", bptree.FormatKey(keys[0]))
+		fmt.Printf("   Max Key: %s\n", bptree.FormatKey(keys[len(keys)-1]))
 	}
 
 	fmt.Println()
@@ -285,9 +402,28 @@ func showBufferPoolStats(bufferPool *storage.BufferPool) {
 	fmt.Println("   Memory:")
 	fmt.Printf("     Dirty Pages: %d\n", stats.DirtyPages)
 	fmt.Printf("     Clean Pages: %d\n", stats.Size-stats.DirtyPages)
+	fmt.Printf("     Pinned Pages: %d\n", stats.PinnedPages)
 	fmt.Println()
 }
 
+// showTables lists the tables of the database with their indexes
+func showTables(tree *bptree.BPTree) {
+	showTable(sql.DefaultTable, tree)
+	for _, name := range tree.Tables() {
+		table, _ := tree.Table(name)
+		showTable(name, table)
+	}
+}
+
+// showTable prints a table name followed by its indexes, if any
+func showTable(name string, table *bptree.BPTree) {
+	if indexes := table.Indexes(); len(indexes) > 0 {
+		fmt.Printf("%s (indexes: %s)\n", name, strings.Join(indexes, ", "))
+		return
+	}
+	fmt.Println(name)
+}
+
 // showAllKeys displays all keys in the database
 func showAllKeys(tree *bptree.BPTree) {
 	keys, err := tree.InOrderTraversal()
@@ -313,14 +449,14 @@ func showAllKeys(tree *bptree.BPTree) {
 		if i > 0 && i%10 == 0 {
 			fmt.Println()
 		}
-		fmt.Printf("%d ", keys[i])
+		fmt.Printf("%s ", bptree.FormatKey(keys[i]))
 	}
 
 	if len(keys) > limit {
 		fmt.Printf("\n... (%d more keys)", len(keys)-limit)
 	}
 
-	fmt.Println("\n")
+	fmt.Print("\n\n")
 }
 
 // showHelp displays available commands
@@ -330,12 +466,39 @@ func showHelp() {
 	fmt.Println("  SQL Commands:")
 	fmt.Println("    INSERT INTO kv VALUES (<key>, '<value>');  - Insert a key-value pair")
 	fmt.Println("    SELECT * FROM kv WHERE key = <key>;        - Query by key")
+	fmt.Println("    SELECT * FROM kv WHERE key BETWEEN <a> AND <b> [LIMIT <n>];")
+	fmt.Println("                                               - Range scan (also >, >=, <, <=)")
+	fmt.Println("    SELECT * FROM kv WHERE key < <a> ORDER BY key DESC LIMIT <n>;")
+	fmt.Println("                                               - Newest first (reverse scan)")
+	fmt.Println("    INSERT INTO kv VALUES (<key>, '<value>') ON CONFLICT DO UPDATE;")
+	fmt.Println("                                               - Insert or replace")
+	fmt.Println("    UPDATE kv SET value = '<value>' WHERE key = <key>;")
+	fmt.Println("                                               - Update an existing key")
+	fmt.Println("    DELETE FROM kv WHERE key = <key>;          - Delete by key")
+	fmt.Println("    BEGIN; ... COMMIT;                         - Apply several writes atomically")
+	fmt.Println("    ROLLBACK;                                  - Discard the open transaction")
+	fmt.Println("    CREATE TABLE <name>;                       - Create a key-value table (kv always exists)")
+	fmt.Println("    CREATE TABLE <name> (id INT PRIMARY KEY, name TEXT NOT NULL, active BOOL);")
+	fmt.Println("                                               - Create a typed table (INT, TEXT, BOOL)")
+	fmt.Println("    SELECT name, active FROM <name> WHERE id = <id>;")
+	fmt.Println("                                               - Project columns, NULL for missing values")
+	fmt.Println("    DROP TABLE <name>;                         - Drop a table and its rows")
+	fmt.Println("    CREATE INDEX <name> ON kv(value);          - Index values of a key-value table")
+	fmt.Println("    SELECT * FROM kv WHERE value = '<value>';  - Find keys by value (index or scan)")
+	fmt.Println("    DROP INDEX <name>;                         - Drop an index")
+	fmt.Println("    VACUUM;                                    - Compact the file, report bytes reclaimed")
 	fmt.Println()
 	fmt.Println("  Meta Commands (start with .):")
 	fmt.Println("    .stats         - Show database statistics")
 	fmt.Println("    .tree          - Show B+ Tree information")
 	fmt.Println("    .buffer        - Show buffer pool statistics")
 	fmt.Println("    .keys          - List all keys")
+	fmt.Println("    .tables        - List tables and their indexes")
+	fmt.Println("    .checkpoint    - Flush dirty pages and truncate the WAL")
+	fmt.Println("    .vacuum        - Compact the database file (same as VACUUM;)")
+	fmt.Println("    .backup <file> - Back up the database while it stays in use")
+	fmt.Println("    .sync [mode]   - Show or set WAL sync mode (full, normal, off)")
+	fmt.Println("    .archive [on]  - Show or turn on WAL archiving for point-in-time restore")
 	fmt.Println("    .clear         - Clear screen")
 	fmt.Println("    .help          - Show this help")
 	fmt.Println()
@@ -4,19 +4,25 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+	"github.com/spaghetti-lover/sharingan-db/internal/sql"
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 )
 
+// tempDatabase returns database and WAL paths in a directory removed after the test
+func tempDatabase(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	return filepath.Join(dir, "test.db"), filepath.Join(dir, "test.wal")
+}
+
 func TestREPLBasicCommands(t *testing.T) {
-	// Clean up test files
-	testDB := "test_repl.db"
-	testWAL := "test_repl.wal"
-	defer os.Remove(testDB)
-	defer os.Remove(testWAL)
+	testDB, testWAL := tempDatabase(t)
 
 	// Create test database
 	pager, err := storage.NewFilePager(testDB)
@@ -59,7 +65,7 @@ func TestREPLBasicCommands(t *testing.T) {
 
 func TestREPLFileExists(t *testing.T) {
 	// Test fileExists helper
-	testFile := "test_exists.tmp"
+	testFile := filepath.Join(t.TempDir(), "exists.tmp")
 
 	// File doesn't exist
 	if fileExists(testFile) {
@@ -69,7 +75,6 @@ func TestREPLFileExists(t *testing.T) {
 	// Create file
 	f, _ := os.Create(testFile)
 	f.Close()
-	defer os.Remove(testFile)
 
 	// File exists
 	if !fileExists(testFile) {
@@ -78,18 +83,13 @@ func TestREPLFileExists(t *testing.T) {
 }
 
 func TestREPLInitialization(t *testing.T) {
-	testDB := "test_init.db"
-	testWAL := "test_init.wal"
-	defer os.Remove(testDB)
-	defer os.Remove(testWAL)
-
-	// Temporarily override globals for this test
-	oldDBFile := dbFile
-	oldWALFile := walFile
+	testDB, testWAL := tempDatabase(t)
+
+	// Point the REPL's files into the test directory for this test
+	oldDBFile, oldWALFile := dbFile, walFile
+	dbFile, walFile = testDB, testWAL
 	defer func() {
-		// Restore (though we can't actually modify consts)
-		_ = oldDBFile
-		_ = oldWALFile
+		dbFile, walFile = oldDBFile, oldWALFile
 	}()
 
 	// Test creating fresh database
@@ -108,10 +108,7 @@ func TestREPLInitialization(t *testing.T) {
 // Additional tests for cmd/repl/main_test.go
 
 func TestREPLMetaCommands(t *testing.T) {
-	testDB := "test_repl_meta.db"
-	testWAL := "test_repl_meta.wal"
-	defer os.Remove(testDB)
-	defer os.Remove(testWAL)
+	testDB, testWAL := tempDatabase(t)
 
 	pager, err := storage.NewFilePager(testDB)
 	if err != nil {
@@ -130,7 +127,7 @@ func TestREPLMetaCommands(t *testing.T) {
 
 	// Insert test data
 	for i := 1; i <= 10; i++ {
-		tree.Insert(uint32(i), fmt.Sprintf("value-%d", i))
+		tree.Insert(storage.Uint32Key(uint32(i)), fmt.Sprintf("value-%d", i))
 	}
 
 	tests := []struct {
@@ -158,6 +155,26 @@ func TestREPLMetaCommands(t *testing.T) {
 			cmd:      ".keys",
 			contains: []string{"All Keys", "10 total"},
 		},
+		{
+			name:     "Checkpoint command",
+			cmd:      ".checkpoint",
+			contains: []string{"Checkpoint complete", "Checkpoint LSN: 10"},
+		},
+		{
+			name:     "Sync command",
+			cmd:      ".sync",
+			contains: []string{"Sync mode: FULL"},
+		},
+		{
+			name:     "Sync command sets mode",
+			cmd:      ".sync normal",
+			contains: []string{"Sync mode: NORMAL", "up to 1s"},
+		},
+		{
+			name:     "Sync command rejects unknown mode",
+			cmd:      ".sync sometimes",
+			contains: []string{"unknown sync mode"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -216,10 +233,7 @@ func TestREPLHelpCommand(t *testing.T) {
 }
 
 func TestREPLUnknownMetaCommand(t *testing.T) {
-	testDB := "test_unknown_meta.db"
-	testWAL := "test_unknown_meta.wal"
-	defer os.Remove(testDB)
-	defer os.Remove(testWAL)
+	testDB, testWAL := tempDatabase(t)
 
 	pager, _ := storage.NewFilePager(testDB)
 	defer pager.Close()
@@ -247,3 +261,125 @@ func TestREPLUnknownMetaCommand(t *testing.T) {
 		t.Error("Expected 'Unknown meta command' message")
 	}
 }
+
+func TestLoadCSV(t *testing.T) {
+	testDB, testWAL := tempDatabase(t)
+
+	pager, err := storage.NewFilePager(testDB)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, testWAL)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	defer tree.Close()
+
+	var input strings.Builder
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&input, "%d,\"value, %d\"\n", i*2, i)
+	}
+
+	info, err := tree.BulkLoad(newCSVSource(strings.NewReader(input.String())), bptree.BulkLoadOptions{})
+	if err != nil {
+		t.Fatalf("Failed to load CSV: %v", err)
+	}
+	if info.Records != 1000 {
+		t.Errorf("Loaded %d rows, expected 1000", info.Records)
+	}
+	if value, found, _ := tree.Search(storage.Uint32Key(1000)); !found || value != "value, 500" {
+		t.Errorf("Key 1000 read back as %q (found=%v)", value, found)
+	}
+
+	// Loaded keys are those SQL statements use
+	result, err := sql.NewExecutor(tree).ExecuteSQL("SELECT * FROM kv WHERE key = 1000")
+	if err != nil || !strings.Contains(result, "value, 500") {
+		t.Errorf("SELECT of a loaded key returned %q (err=%v)", result, err)
+	}
+
+	// Bad keys name their line
+	table, err := tree.CreateTable("bad")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	_, err = table.BulkLoad(newCSVSource(strings.NewReader("1,a\nx,b\n")), bptree.BulkLoadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error on line 2, got %v", err)
+	}
+	_, err = table.BulkLoad(newCSVSource(strings.NewReader("1,a\n4294967296,b\n")), bptree.BulkLoadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected a key out of range on line 2, got %v", err)
+	}
+}
+
+func TestBackupToFile(t *testing.T) {
+	testDB, testWAL := tempDatabase(t)
+	dir := filepath.Dir(testDB)
+	backupFile := filepath.Join(dir, "backup.bak")
+
+	pager, err := storage.NewFilePager(testDB)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, testWAL)
+	if err != nil {
+		t.Fatalf("Failed to create tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := uint32(1); i <= 100; i++ {
+		if err := tree.Insert(storage.Uint32Key(i), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	info, err := backupToFile(tree, backupFile)
+	if err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if stat, err := os.Stat(backupFile); err != nil || stat.Size() != info.Bytes {
+		t.Fatalf("Backup file has %v (err=%v), expected %d bytes", stat, err, info.Bytes)
+	}
+	if fileExists(backupFile + ".tmp") {
+		t.Error("Backup left its temporary file")
+	}
+
+	file, err := os.Open(backupFile)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer file.Close()
+	if _, err := bptree.Restore(file, filepath.Join(dir, "restored.db"), filepath.Join(dir, "restored.wal")); err != nil {
+		t.Errorf("Failed to restore backup file: %v", err)
+	}
+}
+
+func TestParseRecoveryTime(t *testing.T) {
+	now := time.Date(2026, 10, 16, 18, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"14:02", time.Date(2026, 10, 16, 14, 2, 0, 0, time.Local)},
+		{"14:02:30", time.Date(2026, 10, 16, 14, 2, 30, 0, time.Local)},
+		{"2026-10-15 14:02", time.Date(2026, 10, 15, 14, 2, 0, 0, time.Local)},
+		{"2026-10-15 14:02:30", time.Date(2026, 10, 15, 14, 2, 30, 0, time.Local)},
+		{"2026-10-15", time.Date(2026, 10, 15, 0, 0, 0, 0, time.Local)},
+		{"2026-10-15T14:02:00Z", time.Date(2026, 10, 15, 14, 2, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseRecoveryTime(tt.value, now)
+		if err != nil || !got.Equal(tt.expected) {
+			t.Errorf("parseRecoveryTime(%q) = %v (err=%v), expected %v", tt.value, got, err, tt.expected)
+		}
+	}
+
+	if _, err := parseRecoveryTime("just before two", now); err == nil {
+		t.Error("Expected error for an invalid time")
+	}
+}
@@ -0,0 +1,149 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+func TestBPTreeWriteBatch(t *testing.T) {
+	dbFile := "test_batch.db"
+	walFile := "test_batch.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 4, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	tree.Insert(k(5000), "old")
+
+	batch := NewWriteBatch()
+	for i := 1; i <= 1000; i++ {
+		batch.Put(k(uint32(i)), fmt.Sprintf("value-%d", i))
+	}
+	for i := 2; i <= 1000; i += 2 {
+		batch.Delete(k(uint32(i)))
+	}
+	batch.Put(k(5000), "new")
+	batch.Delete(k(9999)) // Missing key is a no-op
+
+	syncsBefore := tree.GetWALSyncCount()
+	if err := tree.Write(batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if syncs := tree.GetWALSyncCount() - syncsBefore; syncs != 1 {
+		t.Errorf("Batch of %d writes took %d fsyncs, expected 1", batch.Len(), syncs)
+	}
+
+	keys, _ := tree.InOrderTraversal()
+	if len(keys) != 501 {
+		t.Errorf("Expected 501 keys after batch, got %d", len(keys))
+	}
+	if value, found, _ := tree.Search(k(999)); !found || value != "value-999" {
+		t.Errorf("Key 999: value=%q found=%v", value, found)
+	}
+	if _, found, _ := tree.Search(k(998)); found {
+		t.Error("Key 998 should have been deleted by the batch")
+	}
+	if value, _, _ := tree.Search(k(5000)); value != "new" {
+		t.Errorf("Put should replace existing value, got %q", value)
+	}
+
+	batch.Reset()
+	if batch.Len() != 0 {
+		t.Errorf("Reset left %d writes", batch.Len())
+	}
+	if err := tree.Write(batch); err != nil {
+		t.Errorf("Empty batch failed: %v", err)
+	}
+
+	t.Logf("✓ 1502 writes applied with one fsync")
+}
+
+func TestBPTreeWriteBatchRecovery(t *testing.T) {
+	dbFile := "test_batch_recovery.db"
+	walFile := "test_batch_recovery.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	// Phase 1: two batches after a checkpoint, the second one torn by a crash mid-write
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+		bufferPool := storage.NewBufferPool(pager, 256)
+
+		tree, err := NewBPTree(bufferPool, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+		tree.SetCheckpointPolicy(CheckpointPolicy{}) // manual only
+		if _, err := tree.Checkpoint(); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+
+		first := NewWriteBatch()
+		for i := 1; i <= 100; i++ {
+			first.Put(k(uint32(i)), "first")
+		}
+		if err := tree.Write(first); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		firstSize := tree.wal.Size()
+
+		second := NewWriteBatch()
+		for i := 101; i <= 200; i++ {
+			second.Put(k(uint32(i)), "second")
+		}
+		if err := tree.Write(second); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+
+		// Don't flush the buffer pool - simulate crash halfway through the second record
+		tree.wal.Close()
+		pager.Close()
+		os.Truncate(walFile, wal.FileHeaderSize+firstSize+100)
+	}
+
+	// Phase 2: the complete batch is replayed, the torn one not at all
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+
+		tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		defer tree.Close()
+
+		keys, _ := tree.InOrderTraversal()
+		if len(keys) != 100 || num(keys[0]) != 1 || num(keys[99]) != 100 {
+			t.Errorf("Expected exactly the first batch (100 keys), got %d", len(keys))
+		}
+
+		t.Logf("✓ Torn batch discarded as a whole")
+	}
+}
@@ -0,0 +1,481 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Bulk loading
+//
+// BulkLoad fills an empty table from keys in ascending order without going
+// through Insert: records are packed into leaves left to right up to the
+// fill factor, and every finished page is added to the open page of the
+// level above, so the internal levels grow bottom-up along with the leaves
+// and no page is ever split. Each page is written once, except the last
+// page of a level, which may take a child from its left sibling so that no
+// internal page is left with a single child
+//
+// The pages are not logged. The load checkpoints with writes stopped, logs
+// one OpBulkLoad marker (whose LSN stamps the records), builds the tree in
+// new pages, flushes them, swaps the root of the table and checkpoints
+// again. A crash before the second checkpoint leaves the table empty (the
+// new pages are leaked until VACUUM), after it the load is complete
+
+var (
+	// ErrTableNotEmpty is returned when bulk loading a table that has rows
+	ErrTableNotEmpty = errors.New("table is not empty")
+	// ErrUnsorted is returned when bulk load input is not in ascending key order
+	ErrUnsorted = errors.New("bulk load input is not sorted")
+)
+
+// DefaultFillFactor leaves a tenth of each page free, so the first writes
+// after a load do not split every page they touch
+const DefaultFillFactor = 0.9
+
+// BulkSource streams the rows of a bulk load in ascending key order
+// Next returns io.EOF after the last row
+type BulkSource interface {
+	Next() (key []byte, value string, err error)
+}
+
+// BulkLoadOptions controls a bulk load
+type BulkLoadOptions struct {
+	// FillFactor is the share of each page filled, in (0, 1]
+	// 0 uses DefaultFillFactor
+	FillFactor float64
+}
+
+// BulkLoadInfo describes a completed bulk load
+type BulkLoadInfo struct {
+	Records  int           // rows loaded
+	Leaves   int           // leaf pages written
+	Pages    int           // leaf and internal pages written, overflow pages aside
+	Height   int           // levels of the new tree, leaves included
+	LSN      uint64        // LSN of the WAL marker, the commit timestamp of the rows
+	Duration time.Duration // time spent, checkpoints included
+}
+
+// sliceSource is a BulkSource over rows in memory
+type sliceSource struct {
+	rows []KeyValue
+}
+
+// SliceSource returns a BulkSource reading rows, which must be sorted
+func SliceSource(rows []KeyValue) BulkSource {
+	return &sliceSource{rows: rows}
+}
+
+// Next returns the next row
+func (s *sliceSource) Next() ([]byte, string, error) {
+	if len(s.rows) == 0 {
+		return nil, "", io.EOF
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row.Key, row.Value, nil
+}
+
+// BulkLoad fills the table from source, whose keys must be strictly ascending
+// in the order of the table. Returns ErrTableNotEmpty if the table has rows
+// Reads, writes and DDL of the file wait until the load is done. Indexes of
+// the table are filled from the loaded rows
+func (tree *BPTree) BulkLoad(source BulkSource, opts BulkLoadOptions) (BulkLoadInfo, error) {
+	if tree.indexed != nil {
+		return BulkLoadInfo{}, fmt.Errorf("indexes cannot be bulk loaded")
+	}
+
+	fill := opts.FillFactor
+	if fill == 0 {
+		fill = DefaultFillFactor
+	}
+	if fill <= 0 || fill > 1 {
+		return BulkLoadInfo{}, fmt.Errorf("invalid fill factor %v: must be in (0, 1]", fill)
+	}
+
+	start := time.Now()
+
+	tree.ddlMu.Lock()
+	defer tree.ddlMu.Unlock()
+	tree.checkpointMu.Lock()
+	defer tree.checkpointMu.Unlock()
+	tree.writeLatch.Lock()
+	defer tree.writeLatch.Unlock()
+	tree.rootLatch.Lock()
+	defer tree.rootLatch.Unlock()
+
+	oldRoot, err := readPageStruct(tree.pager, tree.rootPage)
+	if err != nil {
+		return BulkLoadInfo{}, fmt.Errorf("failed to read root page: %w", err)
+	}
+	if !oldRoot.IsLeaf() || oldRoot.Header.NumKeys != 0 {
+		return BulkLoadInfo{}, ErrTableNotEmpty
+	}
+
+	// Nothing before the marker is left for replay to apply on top of the load
+	if _, err := tree.checkpoint(time.Now(), tree.wal.LastLSN(), tree.oldestActiveTxLSN()); err != nil {
+		return BulkLoadInfo{}, fmt.Errorf("failed to checkpoint: %w", err)
+	}
+
+	b := &bulkBuilder{tree: tree, fill: fill, snapshots: tree.openSnapshots()}
+	if err := b.load(source); err != nil {
+		b.abandon()
+		return BulkLoadInfo{}, err
+	}
+	info := BulkLoadInfo{
+		Records: b.records,
+		Leaves:  b.leaves,
+		Pages:   b.pages,
+		Height:  len(b.levels) + 1,
+		LSN:     b.ts,
+	}
+
+	// The rows are stamped with the marker LSN, which snapshots taken before
+	// the load are older than
+	if b.versioned {
+		tree.garbage.Store(true)
+	}
+
+	// Indexes read the new tree before it is reachable
+	built := tree.newTable(tree.id, b.root, tree.schema)
+	for _, index := range tree.valueIndexes {
+		if err := built.fillIndex(index, b.ts); err != nil {
+			b.abandon()
+			return BulkLoadInfo{}, err
+		}
+	}
+
+	// The pages must be on disk before the root points to them
+	if err := tree.wal.Sync(); err != nil {
+		return BulkLoadInfo{}, fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	if err := tree.pager.Flush(); err != nil {
+		return BulkLoadInfo{}, fmt.Errorf("failed to flush pages: %w", err)
+	}
+
+	oldRootID := tree.rootPage
+	tree.metaMu.Lock()
+	tree.rootPage = b.root
+	tree.metaMu.Unlock()
+	if tree.id != 0 {
+		if err := tree.saveCatalog(); err != nil {
+			return BulkLoadInfo{}, fmt.Errorf("failed to update catalog: %w", err)
+		}
+	}
+
+	// Saves the root of the default table and flushes the catalog
+	if _, err := tree.checkpoint(time.Now(), b.ts, tree.oldestActiveTxLSN()); err != nil {
+		return BulkLoadInfo{}, fmt.Errorf("failed to checkpoint: %w", err)
+	}
+
+	tree.freed.Add(1)
+	if err := tree.pager.FreePage(oldRootID); err != nil {
+		return BulkLoadInfo{}, fmt.Errorf("failed to free old root: %w", err)
+	}
+
+	info.Duration = time.Since(start)
+	return info, nil
+}
+
+// bulkBuilder builds a tree from sorted records, holding the rightmost
+// page of each level until it is full
+type bulkBuilder struct {
+	tree      *BPTree
+	fill      float64
+	snapshots []uint64
+	ts        uint64 // commit timestamp of the records
+	versioned bool   // some record kept its timestamp for an open snapshot
+
+	leafID  uint64        // leaf being filled
+	leaf    *storage.Page // nil until the first record
+	leafSep []byte        // separator in front of the leaf, nil for the first
+	prevID  uint64        // previous leaf
+	lastKey []byte
+
+	levels []*bulkLevel // internal levels, levels[0] right above the leaves
+	root   uint64
+
+	records int
+	leaves  int
+	pages   int
+}
+
+// bulkLevel is the open page of an internal level
+type bulkLevel struct {
+	pageID uint64
+	page   *storage.Page
+	sep    []byte // separator in front of the page
+	prevID uint64 // last page of the level already written, 0 if none
+}
+
+// load logs the marker and builds the tree from source
+func (b *bulkBuilder) load(source BulkSource) error {
+	tree := b.tree
+
+	marker := wal.NewBulkLoadEntry()
+	marker.Table = tree.id
+	if err := tree.wal.Append(marker); err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	b.ts = marker.LSN
+
+	for {
+		key, value, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read row %d: %w", b.records+1, err)
+		}
+		if err := b.add(key, value); err != nil {
+			return err
+		}
+	}
+
+	return b.finish()
+}
+
+// add appends a record to the open leaf, starting a new leaf when the
+// record would fill it past the fill factor
+func (b *bulkBuilder) add(key []byte, value string) error {
+	tree := b.tree
+
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := tree.checkIndexedKey(key); err != nil {
+		return err
+	}
+	if b.lastKey != nil && tree.cmp(b.lastKey, key) >= 0 {
+		return fmt.Errorf("%w: %s after %s", ErrUnsorted, FormatKey(key), FormatKey(b.lastKey))
+	}
+
+	stored, overflow, err := tree.storeValue(value, false)
+	if err != nil {
+		return err
+	}
+	record := storage.NewRecord(key, []byte(stored))
+	record.Overflow = overflow
+	record.CommitTS = b.ts
+	record = pruneVersions(record, b.snapshots)
+	if record.Versioned() {
+		b.versioned = true
+	}
+
+	if b.leaf != nil {
+		leaf := tree.leafPage(b.leaf)
+		size := record.Size() + 2 // +2 for slot
+		if leaf.AvailableSpace() < size || float64(leaf.UsedSpace()+size) > b.fill*float64(leaf.Capacity()) {
+			nextID, err := tree.pager.AllocatePage()
+			if err != nil {
+				return fmt.Errorf("failed to allocate leaf: %w", err)
+			}
+			if err := b.finishLeaf(nextID); err != nil {
+				return err
+			}
+			b.openLeaf(nextID, tree.separator(b.lastKey, key))
+		}
+	} else {
+		leafID, err := tree.pager.AllocatePage()
+		if err != nil {
+			return fmt.Errorf("failed to allocate leaf: %w", err)
+		}
+		b.openLeaf(leafID, nil)
+	}
+
+	if err := tree.leafPage(b.leaf).InsertRecord(record); err != nil {
+		return fmt.Errorf("failed to insert key %s: %w", FormatKey(key), err)
+	}
+	b.lastKey = slices.Clone(key)
+	b.records++
+	return nil
+}
+
+// openLeaf starts a new leaf on page leafID
+func (b *bulkBuilder) openLeaf(leafID uint64, sep []byte) {
+	b.leafID = leafID
+	b.leaf = storage.NewPage(storage.PageTypeLeaf, b.tree.pager.PageSize())
+	b.leaf.Header.PrevPage = uint32(b.prevID)
+	b.leafSep = sep
+	b.leaves++
+	b.pages++
+}
+
+// finishLeaf adds the open leaf to its parent and writes it, linked to nextID
+func (b *bulkBuilder) finishLeaf(nextID uint64) error {
+	parentID, err := b.addChild(0, b.leafSep, b.leafID)
+	if err != nil {
+		return err
+	}
+
+	b.leaf.Header.NextPage = uint32(nextID)
+	b.leaf.Header.Parent = uint32(parentID)
+	if err := writePageStruct(b.tree.pager, b.leafID, b.leaf); err != nil {
+		return fmt.Errorf("failed to write leaf %d: %w", b.leafID, err)
+	}
+	b.prevID = b.leafID
+	return nil
+}
+
+// addChild adds a finished page to the open page of a level, closing that
+// page first if the entry would fill it past the fill factor
+// Returns the page the child was added to
+func (b *bulkBuilder) addChild(level int, sep []byte, childID uint64) (uint64, error) {
+	tree := b.tree
+	if level == len(b.levels) {
+		b.levels = append(b.levels, &bulkLevel{})
+	}
+	l := b.levels[level]
+
+	if l.page != nil {
+		// Pages keep at least three children, so a page whose last child
+		// moves to its right sibling (see finish) is never left with one
+		internal := tree.internalPage(l.page)
+		target := b.fill * float64(internal.Capacity())
+		if internal.Fits(sep) && (internal.NumKeys() < 2 || float64(internal.UsedSpace()+storage.InternalEntrySize(sep)) <= target) {
+			if err := internal.InsertEntry(sep, childID); err != nil {
+				return 0, err
+			}
+			return l.pageID, nil
+		}
+
+		if err := b.closeLevel(level); err != nil {
+			return 0, err
+		}
+	}
+
+	pageID, err := tree.pager.AllocatePage()
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate internal page: %w", err)
+	}
+	l.pageID = pageID
+	l.page = storage.NewPage(storage.PageTypeInternal, tree.pager.PageSize())
+	l.sep = sep
+	if err := tree.internalPage(l.page).SetLeftmostPointer(childID); err != nil {
+		return 0, err
+	}
+	b.pages++
+	return pageID, nil
+}
+
+// closeLevel adds the open page of a level to the level above and writes it
+func (b *bulkBuilder) closeLevel(level int) error {
+	l := b.levels[level]
+
+	parentID, err := b.addChild(level+1, l.sep, l.pageID)
+	if err != nil {
+		return err
+	}
+	l.page.Header.Parent = uint32(parentID)
+	if err := writePageStruct(b.tree.pager, l.pageID, l.page); err != nil {
+		return fmt.Errorf("failed to write internal page %d: %w", l.pageID, err)
+	}
+
+	l.prevID = l.pageID
+	l.page = nil
+	return nil
+}
+
+// finish writes the open pages bottom-up and sets root
+func (b *bulkBuilder) finish() error {
+	tree := b.tree
+
+	// An empty load still needs a root
+	if b.leaf == nil {
+		leafID, err := tree.pager.AllocatePage()
+		if err != nil {
+			return fmt.Errorf("failed to allocate leaf: %w", err)
+		}
+		b.openLeaf(leafID, nil)
+	}
+
+	if b.leaves == 1 {
+		b.root = b.leafID
+		return writePageStruct(tree.pager, b.leafID, b.leaf)
+	}
+	if err := b.finishLeaf(0); err != nil {
+		return err
+	}
+
+	for level := 0; level < len(b.levels); level++ {
+		l := b.levels[level]
+		if tree.internalPage(l.page).NumKeys() == 0 {
+			if err := b.borrowChild(l); err != nil {
+				return err
+			}
+		}
+
+		// The top level only ever has one page
+		if level == len(b.levels)-1 {
+			b.root = l.pageID
+			if err := writePageStruct(tree.pager, l.pageID, l.page); err != nil {
+				return fmt.Errorf("failed to write root %d: %w", l.pageID, err)
+			}
+			break
+		}
+		if err := b.closeLevel(level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// borrowChild moves the last child of the left sibling of the open page of
+// a level into it, when the page got a single child
+func (b *bulkBuilder) borrowChild(l *bulkLevel) error {
+	tree := b.tree
+
+	prevPage, err := readPageStruct(tree.pager, l.prevID)
+	if err != nil {
+		return fmt.Errorf("failed to read internal page %d: %w", l.prevID, err)
+	}
+	prev := tree.internalPage(prevPage)
+	sep, childID, err := prev.GetKeyPointer(prev.NumKeys() - 1)
+	if err != nil {
+		return err
+	}
+	if err := prev.RemoveEntry(prev.NumKeys() - 1); err != nil {
+		return err
+	}
+
+	internal := tree.internalPage(l.page)
+	onlyChild, err := internal.GetLeftmostPointer()
+	if err != nil {
+		return err
+	}
+	if err := internal.SetLeftmostPointer(childID); err != nil {
+		return err
+	}
+	if err := internal.InsertEntry(l.sep, onlyChild); err != nil {
+		return err
+	}
+	l.sep = sep
+
+	child, err := readPageStruct(tree.pager, childID)
+	if err != nil {
+		return fmt.Errorf("failed to read page %d: %w", childID, err)
+	}
+	child.Header.Parent = uint32(l.pageID)
+	if err := writePageStruct(tree.pager, childID, child); err != nil {
+		return err
+	}
+	return writePageStruct(tree.pager, l.prevID, prevPage)
+}
+
+// abandon frees the pages of a failed load, as far as they form a tree
+func (b *bulkBuilder) abandon() {
+	if b.leaf == nil && b.root == 0 {
+		return
+	}
+	if b.root == 0 {
+		if err := b.finish(); err != nil {
+			return // leaked until VACUUM
+		}
+	}
+	b.tree.newTable(b.tree.id, b.root, nil).freeSubtree(b.root)
+}
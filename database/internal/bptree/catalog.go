@@ -0,0 +1,536 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Catalog
+//
+// A file holds the default table, whose root is in the superblock, and any
+// number of named tables listed in the catalog page. Each table is a B+ tree
+// of its own with a catalog ID; WAL records carry the ID of the table they
+// write, so all tables share one WAL, one checkpoint and one transaction
+//
+// Catalog page layout (after the page header, NumKeys = number of entries):
+// [nextTableID: 4 bytes] then per table or index
+// [kind: 1 byte][nameSize: 2 bytes][name][ID: 4 bytes][root: 8 bytes]
+// [indexed table ID: 4 bytes][schemaSize: 2 bytes][schema]
+//
+// The schema is opaque to the tree, it is kept for the layer above (the SQL
+// column definitions); tables created without one have none. The indexed
+// table ID is only meaningful for indexes (see index.go)
+//
+// CREATE and DROP log a record of the change and checkpoint before
+// returning, so crash recovery finds them in the catalog. Replay from an
+// older checkpoint (a point-in-time restore) redoes them from the record.
+// Table IDs are never reused, so replay skips the records of dropped tables
+
+var (
+	// ErrTableExists is returned when creating a table whose name is taken
+	ErrTableExists = errors.New("table already exists")
+	// ErrTableNotFound is returned when dropping a table that does not exist
+	ErrTableNotFound = errors.New("table not found")
+	// ErrCatalogFull is returned when the catalog page has no room for another table
+	ErrCatalogFull = errors.New("catalog full")
+)
+
+// MaxTableNameSize is the maximum length of a table or index name in bytes
+const MaxTableNameSize = 64
+
+// Kinds of catalog entries
+const (
+	catalogTable byte = 0
+	catalogIndex byte = 1
+)
+
+// catalogEntrySize returns the bytes a table or index takes in the catalog page
+func catalogEntrySize(name string, schema []byte) int {
+	return 1 + 2 + len(name) + 4 + 8 + 4 + 2 + len(schema)
+}
+
+// CreateTable creates an empty table in the file
+// Returns ErrTableExists if the name is taken
+func (tree *BPTree) CreateTable(name string) (*BPTree, error) {
+	return tree.CreateTableWithSchema(name, nil)
+}
+
+// CreateTableWithSchema creates an empty table whose catalog entry keeps schema,
+// returned by Schema
+func (tree *BPTree) CreateTableWithSchema(name string, schema []byte) (*BPTree, error) {
+	if name == "" || len(name) > MaxTableNameSize {
+		return nil, fmt.Errorf("invalid table name %q: must be 1 to %d bytes", name, MaxTableNameSize)
+	}
+
+	tree.ddlMu.Lock()
+	defer tree.ddlMu.Unlock()
+
+	if _, ok := tree.Table(name); ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableExists, name)
+	}
+
+	table, err := tree.createTable(name, 0, slices.Clone(schema))
+	if err != nil {
+		return nil, err
+	}
+	change := wal.CatalogChange{Op: wal.CatalogCreateTable, Name: name, Schema: table.schema}
+	if err := tree.logCatalogChange(table.id, change); err != nil {
+		return nil, err
+	}
+
+	// The table is durable once the catalog and its root are checkpointed
+	if _, err := tree.Checkpoint(); err != nil {
+		return nil, fmt.Errorf("failed to checkpoint catalog: %w", err)
+	}
+
+	return table, nil
+}
+
+// createTable allocates the root of a new table and adds it to the catalog
+// under id, 0 for the next unused ID
+func (tree *BPTree) createTable(name string, id uint32, schema []byte) (*BPTree, error) {
+	rootPageID, rootPage, err := allocatePageWithType(tree.pager, storage.PageTypeLeaf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate root page: %w", err)
+	}
+	if err := writePageStruct(tree.pager, rootPageID, rootPage); err != nil {
+		return nil, fmt.Errorf("failed to write root page: %w", err)
+	}
+
+	table, err := tree.addTable(name, id, rootPageID, schema)
+	if err != nil {
+		tree.pager.FreePage(rootPageID)
+		return nil, err
+	}
+	return table, nil
+}
+
+// addTable registers a new table rooted at rootPageID under id (0 for the
+// next unused ID) and saves the catalog
+func (tree *BPTree) addTable(name string, id uint32, rootPageID uint64, schema []byte) (*BPTree, error) {
+	tree.catalogMu.Lock()
+	defer tree.catalogMu.Unlock()
+
+	if err := tree.allocateCatalog(); err != nil {
+		return nil, err
+	}
+
+	table := tree.newTable(tree.takeTableID(id), rootPageID, schema)
+	tree.tables[name] = table
+	tree.tablesByID[table.id] = table
+
+	if err := tree.writeCatalog(); err != nil {
+		delete(tree.tables, name)
+		delete(tree.tablesByID, table.id)
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// takeTableID returns id, the ID of a replayed change, or the next unused
+// ID if it is 0, and keeps later IDs above it
+// The caller holds catalogMu exclusively
+func (tree *BPTree) takeTableID(id uint32) uint32 {
+	if id == 0 {
+		id = tree.nextTableID + 1
+	}
+	tree.nextTableID = max(tree.nextTableID, id)
+	return id
+}
+
+// logCatalogChange logs a catalog change to the table or index id, which
+// the caller then checkpoints (see wal.NewCatalogEntry)
+// The caller holds ddlMu, so the record and the change are on the same side
+// of a backup
+func (tree *BPTree) logCatalogChange(id uint32, change wal.CatalogChange) error {
+	record := wal.NewCatalogEntry(change)
+	record.Table = id
+	if err := tree.wal.Append(record); err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	return nil
+}
+
+// replayCatalogChange redoes the catalog change of a WAL record logged after
+// the checkpoint, unless the catalog already has it: a checkpoint can run
+// between the change and its record
+func (tree *BPTree) replayCatalogChange(entry *wal.Entry) error {
+	change, err := entry.CatalogChange()
+	if err != nil {
+		return err
+	}
+
+	switch change.Op {
+	case wal.CatalogCreateTable:
+		if tree.tableByID(entry.Table) != nil {
+			return nil
+		}
+		_, err := tree.createTable(change.Name, entry.Table, change.Schema)
+		return err
+
+	case wal.CatalogDropTable:
+		if table, ok := tree.Table(change.Name); !ok || table.id != entry.Table {
+			return nil
+		}
+		table, err := tree.removeTable(change.Name)
+		if err != nil {
+			return err
+		}
+		return table.freeTable()
+
+	case wal.CatalogCreateIndex:
+		table := tree.tableByID(change.Indexed)
+		if table == nil || tree.tableByID(entry.Table) != nil {
+			return nil // dropped since, or already built
+		}
+		_, err := table.createIndex(change.Name, entry.Table, entry.LSN)
+		return err
+
+	case wal.CatalogDropIndex:
+		if index := tree.tableByID(entry.Table); index == nil || index.indexed == nil {
+			return nil
+		}
+		index, err := tree.detachIndex(change.Name)
+		if err != nil {
+			return err
+		}
+		return index.free()
+
+	default:
+		return fmt.Errorf("unknown catalog change %d", change.Op)
+	}
+}
+
+// allocateCatalog creates the catalog page if the file has none yet
+// The caller holds catalogMu exclusively
+func (tree *BPTree) allocateCatalog() error {
+	if tree.catalogPage != 0 {
+		return nil
+	}
+
+	pageID, page, err := allocatePageWithType(tree.pager, storage.PageTypeCatalog)
+	if err != nil {
+		return fmt.Errorf("failed to allocate catalog page: %w", err)
+	}
+	if err := writePageStruct(tree.pager, pageID, page); err != nil {
+		return fmt.Errorf("failed to write catalog page: %w", err)
+	}
+	tree.catalogPage = pageID
+	return nil
+}
+
+// DropTable removes a table and its indexes and frees their pages
+// The dropped tree must not be used afterwards
+func (tree *BPTree) DropTable(name string) error {
+	tree.ddlMu.Lock()
+	defer tree.ddlMu.Unlock()
+
+	table, err := tree.removeTable(name)
+	if err != nil {
+		return err
+	}
+	if err := tree.logCatalogChange(table.id, wal.CatalogChange{Op: wal.CatalogDropTable, Name: name}); err != nil {
+		return err
+	}
+
+	// Once the catalog without the table is durable nothing refers to its pages
+	if _, err := tree.Checkpoint(); err != nil {
+		return fmt.Errorf("failed to checkpoint catalog: %w", err)
+	}
+
+	return table.freeTable()
+}
+
+// removeTable removes a table and its indexes from the catalog
+func (tree *BPTree) removeTable(name string) (*BPTree, error) {
+	tree.catalogMu.Lock()
+	defer tree.catalogMu.Unlock()
+
+	table, ok := tree.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+	delete(tree.tables, name)
+	delete(tree.tablesByID, table.id)
+	for indexName, index := range tree.indexes {
+		if index.indexed == table {
+			delete(tree.indexes, indexName)
+			delete(tree.tablesByID, index.id)
+		}
+	}
+	if err := tree.writeCatalog(); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// freeTable frees the pages of a removed table and its indexes
+// ddlMu keeps the indexes of the table from changing
+func (tree *BPTree) freeTable() error {
+	for _, index := range tree.valueIndexes {
+		if err := index.free(); err != nil {
+			return err
+		}
+	}
+	return tree.free()
+}
+
+// free returns all pages of a dropped table or index to the free list
+func (tree *BPTree) free() error {
+	tree.writeLatch.Lock()
+	defer tree.writeLatch.Unlock()
+	tree.rootLatch.Lock()
+	defer tree.rootLatch.Unlock()
+
+	return tree.freeSubtree(tree.rootPage)
+}
+
+// freeSubtree returns a page and everything below it to the free list
+func (tree *BPTree) freeSubtree(pageID uint64) error {
+	page, err := readPageStruct(tree.pager, pageID)
+	if err != nil {
+		return fmt.Errorf("failed to read page %d: %w", pageID, err)
+	}
+
+	if page.IsLeaf() {
+		records, err := tree.leafPage(page).GetAllRecords()
+		if err != nil {
+			return fmt.Errorf("failed to get records from page %d: %w", pageID, err)
+		}
+		for _, record := range records {
+			if err := tree.freeChains(record, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	if page.IsInternal() {
+		internal := tree.internalPage(page)
+		for i := 0; i <= internal.NumKeys(); i++ {
+			childID, err := internal.GetChild(i)
+			if err != nil {
+				return err
+			}
+			if err := tree.freeSubtree(childID); err != nil {
+				return err
+			}
+		}
+	}
+
+	tree.freed.Add(1)
+	return tree.pager.FreePage(pageID)
+}
+
+// Table returns the table named name
+func (tree *BPTree) Table(name string) (*BPTree, bool) {
+	tree.catalogMu.RLock()
+	defer tree.catalogMu.RUnlock()
+
+	table, ok := tree.tables[name]
+	return table, ok
+}
+
+// Tables returns the names of the tables in the catalog in sorted order
+// The default table is not listed
+func (tree *BPTree) Tables() []string {
+	tree.catalogMu.RLock()
+	defer tree.catalogMu.RUnlock()
+
+	names := make([]string, 0, len(tree.tables))
+	for name := range tree.tables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TableID returns the catalog ID of the table, 0 for the default table
+func (tree *BPTree) TableID() uint32 {
+	return tree.id
+}
+
+// Schema returns the schema the table was created with, nil if it has none
+// The caller must not modify it
+func (tree *BPTree) Schema() []byte {
+	return tree.schema
+}
+
+// tableByID returns the table (or index) with catalog ID id, nil if it was dropped
+func (tree *BPTree) tableByID(id uint32) *BPTree {
+	if id == 0 {
+		return tree.main
+	}
+
+	tree.catalogMu.RLock()
+	defer tree.catalogMu.RUnlock()
+	return tree.tablesByID[id]
+}
+
+// allTables returns the default table followed by the catalog tables and
+// indexes by ID
+func (tree *BPTree) allTables() []*BPTree {
+	tree.catalogMu.RLock()
+	defer tree.catalogMu.RUnlock()
+
+	tables := []*BPTree{tree.main}
+	for _, table := range tree.tablesByID {
+		tables = append(tables, table)
+	}
+	slices.SortFunc(tables[1:], func(a, b *BPTree) int {
+		return int(a.id) - int(b.id)
+	})
+	return tables
+}
+
+// newTable returns the tree of a catalog table, sharing the file with tree
+func (tree *BPTree) newTable(id uint32, rootPageID uint64, schema []byte) *BPTree {
+	return &BPTree{
+		sharedState: tree.sharedState,
+		id:          id,
+		schema:      schema,
+		pager:       tree.pager,
+		rootPage:    rootPageID,
+		order:       tree.order,
+		cmp:         tree.cmp,
+		bytewise:    tree.bytewise,
+	}
+}
+
+// saveCatalog writes the catalog page after a table's root changed
+func (tree *BPTree) saveCatalog() error {
+	tree.catalogMu.Lock()
+	defer tree.catalogMu.Unlock()
+	return tree.writeCatalog()
+}
+
+// writeCatalog writes the catalog page
+// The caller holds catalogMu exclusively
+func (tree *BPTree) writeCatalog() error {
+	page := storage.NewPage(storage.PageTypeCatalog, tree.pager.PageSize())
+	binary.LittleEndian.PutUint32(page.Data[0:4], tree.nextTableID)
+
+	// Tables first, so loading finds the table of an index before it
+	offset := 4
+	entries := 0
+	for _, kind := range []byte{catalogTable, catalogIndex} {
+		trees := tree.tables
+		if kind == catalogIndex {
+			trees = tree.indexes
+		}
+
+		names := make([]string, 0, len(trees))
+		for name := range trees {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			table := trees[name]
+			if offset+catalogEntrySize(name, table.schema) > len(page.Data) {
+				return fmt.Errorf("%w: no room for %s", ErrCatalogFull, name)
+			}
+
+			tree.metaMu.Lock()
+			rootPageID := table.rootPage
+			tree.metaMu.Unlock()
+
+			var indexedID uint32
+			if table.indexed != nil {
+				indexedID = table.indexed.id
+			}
+
+			page.Data[offset] = kind
+			binary.LittleEndian.PutUint16(page.Data[offset+1:offset+3], uint16(len(name)))
+			offset += 3
+			offset += copy(page.Data[offset:], name)
+			binary.LittleEndian.PutUint32(page.Data[offset:offset+4], table.id)
+			binary.LittleEndian.PutUint64(page.Data[offset+4:offset+12], rootPageID)
+			binary.LittleEndian.PutUint32(page.Data[offset+12:offset+16], indexedID)
+			binary.LittleEndian.PutUint16(page.Data[offset+16:offset+18], uint16(len(table.schema)))
+			offset += 18
+			offset += copy(page.Data[offset:], table.schema)
+			entries++
+		}
+	}
+	page.Header.NumKeys = uint16(entries)
+
+	return writePageStruct(tree.pager, tree.catalogPage, page)
+}
+
+// loadCatalog opens the tables and indexes listed in the catalog page
+func (tree *BPTree) loadCatalog() error {
+	if tree.catalogPage == 0 {
+		return nil
+	}
+
+	page, err := readPageStruct(tree.pager, tree.catalogPage)
+	if err != nil {
+		return fmt.Errorf("failed to read catalog page %d: %w", tree.catalogPage, err)
+	}
+	if page.Header.PageType != storage.PageTypeCatalog {
+		return fmt.Errorf("page %d is a %s page, not the catalog", tree.catalogPage, page.Header.PageType)
+	}
+
+	tree.catalogMu.Lock()
+	defer tree.catalogMu.Unlock()
+
+	tree.nextTableID = binary.LittleEndian.Uint32(page.Data[0:4])
+	offset := 4
+	for i := 0; i < int(page.Header.NumKeys); i++ {
+		if offset+3 > len(page.Data) {
+			return fmt.Errorf("catalog entry %d truncated", i)
+		}
+		kind := page.Data[offset]
+		nameSize := int(binary.LittleEndian.Uint16(page.Data[offset+1 : offset+3]))
+		offset += 3
+		if offset+nameSize+18 > len(page.Data) {
+			return fmt.Errorf("catalog entry %d truncated", i)
+		}
+
+		name := string(page.Data[offset : offset+nameSize])
+		offset += nameSize
+		id := binary.LittleEndian.Uint32(page.Data[offset : offset+4])
+		rootPageID := binary.LittleEndian.Uint64(page.Data[offset+4 : offset+12])
+		indexedID := binary.LittleEndian.Uint32(page.Data[offset+12 : offset+16])
+		schemaSize := int(binary.LittleEndian.Uint16(page.Data[offset+16 : offset+18]))
+		offset += 18
+		if offset+schemaSize > len(page.Data) {
+			return fmt.Errorf("catalog entry %d truncated", i)
+		}
+
+		var schema []byte
+		if schemaSize > 0 {
+			schema = slices.Clone(page.Data[offset : offset+schemaSize])
+		}
+		offset += schemaSize
+
+		switch kind {
+		case catalogTable:
+			table := tree.newTable(id, rootPageID, schema)
+			tree.tables[name] = table
+			tree.tablesByID[id] = table
+		case catalogIndex:
+			table := tree.main
+			if indexedID != 0 {
+				table = tree.tablesByID[indexedID]
+			}
+			if table == nil {
+				return fmt.Errorf("index %s: table %d not found", name, indexedID)
+			}
+			index := tree.newIndex(id, rootPageID, table)
+			table.valueIndexes = append(table.valueIndexes, index)
+			tree.indexes[name] = index
+			tree.tablesByID[id] = index
+		default:
+			return fmt.Errorf("catalog entry %d has unknown kind %d", i, kind)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeCatalog(t *testing.T) {
+	dbFile := "test_catalog.db"
+	walFile := "test_catalog.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		users, err := tree.CreateTable("users")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		orders, err := tree.CreateTableWithSchema("orders", []byte("schema"))
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		if _, err := tree.CreateTable("users"); !errors.Is(err, ErrTableExists) {
+			t.Errorf("Expected ErrTableExists, got %v", err)
+		}
+
+		// The same keys in three tables, enough to split the table roots
+		for i := 1; i <= 1000; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("kv-%d", i)); err != nil {
+				t.Fatalf("Failed to insert into default table: %v", err)
+			}
+			if err := users.Insert(k(uint32(i)), fmt.Sprintf("user-%d", i)); err != nil {
+				t.Fatalf("Failed to insert into users: %v", err)
+			}
+		}
+		if err := orders.Insert(k(1), "order-1"); err != nil {
+			t.Fatalf("Failed to insert into orders: %v", err)
+		}
+
+		// One transaction writing two tables
+		tx := tree.Begin()
+		if err := tx.In(users).Upsert(k(1), "alice"); err != nil {
+			t.Fatalf("Failed to write users in tx: %v", err)
+		}
+		if err := tx.In(orders).Upsert(k(2), "order-2"); err != nil {
+			t.Fatalf("Failed to write orders in tx: %v", err)
+		}
+		if value, _, _ := tx.Search(k(1)); value != "kv-1" {
+			t.Errorf("Tx on the default table saw %q, expected kv-1", value)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+		t.Log("✓ Wrote three tables")
+
+		// Crash without closing, replay routes each record to its table
+		pager.Close()
+	}
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	rootPageID, order, err := LoadMetadata(pager, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+
+	if names := tree.Tables(); len(names) != 2 || names[0] != "orders" || names[1] != "users" {
+		t.Fatalf("Tables() = %v, expected [orders users]", names)
+	}
+	users, _ := tree.Table("users")
+	orders, _ := tree.Table("orders")
+
+	for _, check := range []struct {
+		table *BPTree
+		key   uint32
+		value string
+	}{
+		{tree, 1, "kv-1"},
+		{tree, 1000, "kv-1000"},
+		{users, 1, "alice"},
+		{users, 1000, "user-1000"},
+		{orders, 1, "order-1"},
+		{orders, 2, "order-2"},
+	} {
+		value, found, err := check.table.Search(k(check.key))
+		if err != nil || !found || value != check.value {
+			t.Errorf("Table %d key %d: value=%q found=%v err=%v, expected %q",
+				check.table.TableID(), check.key, value, found, err, check.value)
+		}
+	}
+	if _, found, _ := orders.Search(k(3)); found {
+		t.Error("Key 3 leaked into orders")
+	}
+	if string(orders.Schema()) != "schema" || users.Schema() != nil {
+		t.Errorf("Schemas: orders=%q users=%q", orders.Schema(), users.Schema())
+	}
+	t.Log("✓ Tables recovered from the catalog and the WAL")
+
+	if err := tree.DropTable("users"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+	if err := tree.DropTable("users"); !errors.Is(err, ErrTableNotFound) {
+		t.Errorf("Expected ErrTableNotFound, got %v", err)
+	}
+	if pager.FreeListSize() == 0 {
+		t.Error("Dropped table pages were not freed")
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("Failed to close tree: %v", err)
+	}
+	pager.Close()
+
+	pager, err = storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err = LoadBPTree(pager, rootPageID, order, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	defer tree.Close()
+
+	if _, ok := tree.Table("users"); ok {
+		t.Error("Dropped table came back")
+	}
+	orders, ok := tree.Table("orders")
+	if !ok {
+		t.Fatal("Table orders is missing")
+	}
+	if value, found, _ := orders.Search(k(2)); !found || value != "order-2" {
+		t.Errorf("orders key 2: value=%q found=%v", value, found)
+	}
+	t.Log("✓ Dropped table stays dropped")
+}
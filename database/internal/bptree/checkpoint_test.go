@@ -0,0 +1,587 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeCheckpoint(t *testing.T) {
+	dbFile := "test_checkpoint.db"
+	walFile := "test_checkpoint.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	// Phase 1: checkpoint, write more, then crash without flushing the buffer pool
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+		bufferPool := storage.NewBufferPool(pager, 256)
+
+		tree, err := NewBPTree(bufferPool, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+		tree.SetCheckpointPolicy(CheckpointPolicy{}) // manual only
+
+		for i := 1; i <= 1000; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert key=%d: %v", i, err)
+			}
+		}
+
+		info, err := tree.Checkpoint()
+		if err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+		if info.LSN != 1000 || tree.GetCheckpointLSN() != 1000 {
+			t.Errorf("Checkpoint LSN=%d, expected 1000", info.LSN)
+		}
+		if info.WALFreed <= 0 {
+			t.Errorf("Checkpoint freed %d WAL bytes, expected > 0", info.WALFreed)
+		}
+		if size := tree.wal.Size(); size != 0 {
+			t.Errorf("WAL size after checkpoint: %d, expected 0", size)
+		}
+		if dirty := bufferPool.GetStats().DirtyPages; dirty != 0 {
+			t.Errorf("%d dirty pages after checkpoint, expected 0", dirty)
+		}
+
+		// These only reach the WAL and the buffer pool
+		for i := 1001; i <= 1020; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert key=%d: %v", i, err)
+			}
+		}
+		if _, err := tree.Delete(k(1)); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+
+		// Don't close properly - simulate crash
+		tree.wal.Close()
+		pager.Close()
+	}
+
+	// Phase 2: recover from checkpoint + WAL tail
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+
+		tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		defer tree.Close()
+
+		// Replay ends with a checkpoint covering the 21 replayed entries
+		if tree.GetCheckpointLSN() != 1021 {
+			t.Errorf("Checkpoint LSN after recovery=%d, expected 1021", tree.GetCheckpointLSN())
+		}
+
+		for i := 1; i <= 1020; i++ {
+			value, found, err := tree.Search(k(uint32(i)))
+			if err != nil {
+				t.Fatalf("Search(%d) failed: %v", i, err)
+			}
+			if i == 1 {
+				if found {
+					t.Error("Key=1 should stay deleted after recovery")
+				}
+				continue
+			}
+			if !found || value != fmt.Sprintf("value-%d", i) {
+				t.Errorf("Key=%d: found=%v value=%s", i, found, value)
+			}
+		}
+	}
+}
+
+func TestBPTreeRootSplitCrash(t *testing.T) {
+	dbFile := "test_root_split_crash.db"
+	walFile := "test_root_split_crash.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+
+	// Phase 1: split the root, then crash before any checkpoint flushes the pages
+	var firstRoot uint64
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+		bufferPool := storage.NewBufferPool(pager, 256)
+
+		tree, err := NewBPTree(bufferPool, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+		tree.SetCheckpointPolicy(CheckpointPolicy{}) // manual only
+		firstRoot = tree.GetRootPageID()
+
+		for i := 1; i <= 500; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert key=%d: %v", i, err)
+			}
+		}
+		if tree.GetRootPageID() == firstRoot {
+			t.Fatal("Expected the root to split")
+		}
+
+		// The new root is only in memory until a checkpoint
+		if root := pager.Superblock().RootPage; root != firstRoot {
+			t.Fatalf("Superblock root=%d before any checkpoint, expected %d", root, firstRoot)
+		}
+
+		// Don't close properly - simulate crash
+		tree.wal.Close()
+		pager.Close()
+	}
+
+	// Phase 2: the superblock still names the flushed root, replay splits it again
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+		if rootPageID != firstRoot {
+			t.Fatalf("Recovered root=%d, expected the flushed root %d", rootPageID, firstRoot)
+		}
+
+		tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+
+		for i := 1; i <= 500; i++ {
+			value, found, err := tree.Search(k(uint32(i)))
+			if err != nil || !found || value != fmt.Sprintf("value-%d", i) {
+				t.Fatalf("Key=%d after recovery: found=%v value=%s err=%v", i, found, value, err)
+			}
+		}
+		checkParents(t, tree, tree.GetRootPageID(), 0)
+		t.Logf("✓ Recovered 500 keys from root %d, now rooted at %d", firstRoot, tree.GetRootPageID())
+
+		// The replay checkpoint saved the new root once its pages were flushed
+		if root := pager.Superblock().RootPage; root != tree.GetRootPageID() {
+			t.Errorf("Superblock root=%d after recovery, expected %d", root, tree.GetRootPageID())
+		}
+		tree.Close()
+	}
+
+	// A root that is not a tree page is refused instead of replayed into
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		freshPage, err := pager.AllocatePage()
+		if err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		super := pager.Superblock()
+		super.RootPage = freshPage
+		if err := pager.WriteSuperblock(super); err != nil {
+			t.Fatalf("Failed to write superblock: %v", err)
+		}
+
+		_, err = LoadBPTree(pager, freshPage, int(super.Order), walFile)
+		if !errors.Is(err, ErrInvalidRoot) {
+			t.Fatalf("Expected ErrInvalidRoot for a free root page, got %v", err)
+		}
+		t.Logf("✓ Free root page refused: %v", err)
+	}
+}
+
+func TestBPTreeEvictionCrash(t *testing.T) {
+	dbFile := "test_eviction_crash.db"
+	walFile := "test_eviction_crash.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+
+	const checkpointed, total = 500, 3000
+
+	// Phase 1: a pool of 8 pages evicts split halves and new parents to the
+	// file long before the next checkpoint, then crash
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+		bufferPool := storage.NewBufferPool(pager, 8)
+
+		tree, err := NewBPTree(bufferPool, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+		tree.SetCheckpointPolicy(CheckpointPolicy{}) // manual only
+
+		for i := 1; i <= checkpointed; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert key=%d: %v", i, err)
+			}
+		}
+		if _, err := tree.Checkpoint(); err != nil {
+			t.Fatalf("Failed to checkpoint: %v", err)
+		}
+
+		// Keys go in from both ends, splitting checkpointed leaves and new ones
+		evictions := bufferPool.GetStats().Evictions
+		for n := 0; n < total-checkpointed; n++ {
+			key := uint32(checkpointed + 1 + n/2)
+			if n%2 == 1 {
+				key = uint32(total - n/2)
+			}
+			if err := tree.Insert(k(key), fmt.Sprintf("value-%d", key)); err != nil {
+				t.Fatalf("Failed to insert key=%d: %v", key, err)
+			}
+		}
+		if bufferPool.GetStats().Evictions == evictions {
+			t.Fatal("Expected evictions after the checkpoint")
+		}
+		if _, err := os.Stat(dbFile + storage.JournalSuffix); err != nil {
+			t.Fatalf("Expected a journal of the overwritten pages: %v", err)
+		}
+
+		// Don't close properly - simulate crash
+		tree.wal.Close()
+		pager.Close()
+	}
+
+	// Phase 2: the journal puts the checkpointed pages back, the WAL
+	// replays every insert after them
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+		if _, err := os.Stat(dbFile + storage.JournalSuffix); !os.IsNotExist(err) {
+			t.Fatalf("Expected the journal to be rolled back and removed, got %v", err)
+		}
+
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+		tree, err := LoadBPTree(storage.NewBufferPool(pager, 8), rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		defer tree.Close()
+
+		for i := 1; i <= total; i++ {
+			value, found, err := tree.Search(k(uint32(i)))
+			if err != nil || !found || value != fmt.Sprintf("value-%d", i) {
+				t.Fatalf("Key=%d after recovery: found=%v value=%s err=%v", i, found, value, err)
+			}
+		}
+		checkParents(t, tree, tree.GetRootPageID(), 0)
+		checkLeafChain(t, tree)
+		t.Logf("✓ Recovered %d keys after evictions and a crash", total)
+	}
+}
+
+func TestBPTreeAutoCheckpoint(t *testing.T) {
+	dbFile := "test_auto_checkpoint.db"
+	walFile := "test_auto_checkpoint.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bufferPool := storage.NewBufferPool(pager, 128)
+
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	walLimit := int64(4096)
+	tree.SetCheckpointPolicy(CheckpointPolicy{WALSize: walLimit})
+
+	for i := 1; i <= 2000; i++ {
+		if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+
+		// The WAL never grows past the threshold
+		if size := tree.wal.Size(); size >= walLimit {
+			t.Fatalf("WAL size %d reached the %d byte limit after key=%d", size, walLimit, i)
+		}
+	}
+
+	if tree.GetCheckpointCount() == 0 {
+		t.Fatal("Expected automatic checkpoints")
+	}
+	t.Logf("✓ %d automatic checkpoints, last LSN %d", tree.GetCheckpointCount(), tree.GetCheckpointLSN())
+
+	if tree.GetCheckpointLSN() > tree.wal.LastLSN() || tree.wal.LastLSN() != 2000 {
+		t.Errorf("Checkpoint LSN=%d, WAL last LSN=%d", tree.GetCheckpointLSN(), tree.wal.LastLSN())
+	}
+}
+
+func TestBPTreeTornWALRecovery(t *testing.T) {
+	dbFile := "test_torn_wal.db"
+	walFile := "test_torn_wal.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	// Phase 1: insert, then crash in the middle of writing a record
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		for i := 1; i <= 50; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+
+		tree.wal.Close()
+		pager.Close()
+
+		// Half of a record header followed by nothing
+		f, err := os.OpenFile(walFile, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatalf("Failed to open WAL: %v", err)
+		}
+		f.Write([]byte{0x20, 0x00, 0x00, 0x00, 0xde, 0xad, 0xbe})
+		f.Close()
+	}
+
+	// Phase 2: the database still opens and keeps every complete record
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+
+		tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("LoadBPTree refused a torn WAL: %v", err)
+		}
+		defer tree.Close()
+
+		if discarded := tree.wal.DiscardedBytes(); discarded != 7 {
+			t.Errorf("Discarded %d bytes, expected 7", discarded)
+		}
+
+		for i := 1; i <= 50; i++ {
+			if _, found, _ := tree.Search(k(uint32(i))); !found {
+				t.Errorf("Key=%d lost after torn-tail recovery", i)
+			}
+		}
+
+		// New writes continue the LSN sequence
+		if err := tree.Insert(k(51), "value-51"); err != nil {
+			t.Fatalf("Insert after recovery failed: %v", err)
+		}
+		if tree.wal.LastLSN() != 51 {
+			t.Errorf("LSN after recovery=%d, expected 51", tree.wal.LastLSN())
+		}
+	}
+}
+
+func TestMetadataUpgrade(t *testing.T) {
+	dbFile := "test_metadata_upgrade.db"
+	walFile := "test_metadata_upgrade.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+	defer os.Remove(walFile + ".meta")
+
+	var super storage.Superblock
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+		if _, _, err := LoadMetadata(pager, walFile); !errors.Is(err, ErrNoMetadata) {
+			t.Errorf("Expected ErrNoMetadata for a file without a tree, got %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+		users, err := tree.CreateTable("users")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		for i := 1; i <= 500; i++ {
+			tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i))
+			users.Insert(k(uint32(i)), fmt.Sprintf("user-%d", i))
+		}
+		if _, err := tree.Checkpoint(); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+		super = pager.Superblock()
+		tree.Close()
+		pager.Close()
+	}
+
+	// Rewrite the file the way it was before the superblock: the free list
+	// in page 0 and the metadata next to the WAL
+	file, err := os.OpenFile(dbFile, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	freeList := make([]byte, storage.DefaultPageSize)
+	file.ReadAt(freeList, int64(super.FreeListPage)*storage.DefaultPageSize)
+	file.WriteAt(freeList, 0)
+	file.Close()
+
+	meta := make([]byte, 28)
+	binary.LittleEndian.PutUint64(meta[0:8], super.RootPage)
+	binary.LittleEndian.PutUint32(meta[8:12], super.Order)
+	binary.LittleEndian.PutUint64(meta[12:20], super.CheckpointLSN)
+	binary.LittleEndian.PutUint64(meta[20:28], super.CatalogPage)
+	if err := os.WriteFile(walFile+".meta", meta, 0644); err != nil {
+		t.Fatalf("Failed to write metadata file: %v", err)
+	}
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to open pre-superblock file: %v", err)
+	}
+	defer pager.Close()
+	rootPageID, order, err := LoadMetadata(pager, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	if rootPageID != super.RootPage || order != 100 {
+		t.Errorf("Loaded root %d order %d, expected root %d order 100", rootPageID, order, super.RootPage)
+	}
+	if _, err := os.Stat(walFile + ".meta"); !os.IsNotExist(err) {
+		t.Error("Metadata file kept after the upgrade")
+	}
+
+	tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	defer tree.Close()
+
+	if value, found, _ := tree.Search(k(250)); !found || value != "value-250" {
+		t.Errorf("Search(250) = %q, %v", value, found)
+	}
+	users, ok := tree.Table("users")
+	if !ok {
+		t.Fatal("Table users lost in the upgrade")
+	}
+	if value, found, _ := users.Search(k(500)); !found || value != "user-500" {
+		t.Errorf("users.Search(500) = %q, %v", value, found)
+	}
+	if lsn := tree.GetCheckpointLSN(); lsn != super.CheckpointLSN {
+		t.Errorf("Checkpoint LSN %d, expected %d", lsn, super.CheckpointLSN)
+	}
+	t.Log("✓ Metadata file moved into the superblock")
+}
+
+// superblockFailPager fails superblock writes while fail is set
+type superblockFailPager struct {
+	storage.Pager
+	fail bool
+}
+
+func (p *superblockFailPager) WriteSuperblock(sb storage.Superblock) error {
+	if p.fail {
+		return errors.New("injected superblock write failure")
+	}
+	return p.Pager.WriteSuperblock(sb)
+}
+
+func TestBPTreeAutoCheckpointFailure(t *testing.T) {
+	dbFile := "test_checkpoint_failure.db"
+	walFile := "test_checkpoint_failure.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	filePager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer filePager.Close()
+
+	pager := &superblockFailPager{Pager: filePager}
+	tree, err := NewBPTree(storage.NewBufferPool(pager, 128), 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	tree.SetCheckpointPolicy(CheckpointPolicy{WALSize: 1})
+	pager.fail = true
+
+	// The writes are committed in the WAL, the failed checkpoint does not fail them
+	for i := 1; i <= 10; i++ {
+		if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Insert key=%d failed because of the checkpoint: %v", i, err)
+		}
+	}
+	if err := tree.CheckpointError(); err == nil {
+		t.Fatal("Expected the checkpoint failure to be kept")
+	}
+	for i := 1; i <= 10; i++ {
+		if _, found, err := tree.Search(k(uint32(i))); err != nil || !found {
+			t.Fatalf("Key=%d not found after its insert: %v", i, err)
+		}
+	}
+	t.Logf("✓ Writes succeed while checkpoints fail: %v", tree.CheckpointError())
+
+	pager.fail = false
+	if err := tree.Insert(k(11), "value-11"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if err := tree.CheckpointError(); err != nil {
+		t.Errorf("Checkpoint error not cleared after a successful checkpoint: %v", err)
+	}
+}
@@ -0,0 +1,511 @@
+package bptree
+
+import (
+	"fmt"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Delete removes a key from the B+ Tree
+// Returns (found, error)
+func (tree *BPTree) Delete(key []byte) (bool, error) {
+	found := false
+
+	err := tree.writeKey(key, tree.versionSafe(key, "", true), func(path *writePath, leafPageID uint64, leafPage *storage.Page) error {
+		walEntry := &wal.Entry{
+			OpType: wal.OpDelete,
+			Table:  tree.id,
+			Key:    key,
+		}
+
+		if err := tree.wal.Append(walEntry); err != nil {
+			return fmt.Errorf("failed to write WAL: %w", err)
+		}
+
+		var err error
+		found, err = tree.writeVersion(path, leafPageID, leafPage, key, "", true, walEntry.LSN)
+		return err
+	})
+	if err != nil {
+		return found, err
+	}
+
+	tree.maybeCheckpoint()
+	return found, nil
+}
+
+// deleteFromLeaf deletes key from its latched leaf, rebalancing when the leaf underflows
+func (tree *BPTree) deleteFromLeaf(path *writePath, leafPageID uint64, leafPage *storage.Page, key []byte) (bool, error) {
+	leaf := tree.leafPage(leafPage)
+	if !leaf.DeleteRecord(key) {
+		return false, nil
+	}
+
+	if err := writePageStruct(tree.pager, leafPageID, leafPage); err != nil {
+		return false, err
+	}
+
+	// Root leaf is allowed to be under-full (even empty)
+	if leafPage.Header.Parent == 0 || !isLeafUnderflow(leaf) {
+		return true, nil
+	}
+
+	if err := tree.rebalanceLeaf(path, leafPageID, leafPage); err != nil {
+		return true, fmt.Errorf("failed to rebalance leaf %d: %w", leafPageID, err)
+	}
+
+	return true, nil
+}
+
+// isLeafUnderflow reports whether a leaf is less than half full
+func isLeafUnderflow(leaf *storage.LeafPage) bool {
+	return leaf.UsedSpace() < leaf.Capacity()/2
+}
+
+// isInternalUnderflow reports whether an internal node is less than half full
+func isInternalUnderflow(internal *storage.InternalPage) bool {
+	return internal.UsedSpace() < internal.Capacity()/2
+}
+
+// siblingPair returns two adjacent children of parent, one of them being childID
+// Returns (leftID, rightID, separatorIndex, error)
+// Prefers the left sibling; the leftmost child pairs with its right sibling
+func siblingPair(parent *storage.InternalPage, childID uint64) (uint64, uint64, int, error) {
+	index := parent.FindChildIndex(childID)
+	if index < 0 {
+		return 0, 0, 0, fmt.Errorf("page %d not found in parent", childID)
+	}
+
+	if parent.NumKeys() == 0 {
+		return 0, 0, 0, fmt.Errorf("page %d has no sibling", childID)
+	}
+
+	if index > 0 {
+		leftID, err := parent.GetChild(index - 1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		return leftID, childID, index - 1, nil
+	}
+
+	rightID, err := parent.GetChild(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return childID, rightID, 0, nil
+}
+
+// rebalanceLeaf fixes an under-full leaf by merging it with a sibling,
+// or by borrowing records from it when both do not fit in one page
+// The parent is already latched: the leaf was not safe
+func (tree *BPTree) rebalanceLeaf(path *writePath, pageID uint64, page *storage.Page) error {
+	parentID := uint64(page.Header.Parent)
+	parentPage, err := readPageStruct(tree.pager, parentID)
+	if err != nil {
+		return fmt.Errorf("failed to load parent: %w", err)
+	}
+	parent := tree.internalPage(parentPage)
+
+	leftID, rightID, sepIndex, err := siblingPair(parent, pageID)
+	if err != nil {
+		return err
+	}
+	path.lockPair(leftID, rightID)
+
+	leftPage, err := readPageStruct(tree.pager, leftID)
+	if err != nil {
+		return fmt.Errorf("failed to load left leaf: %w", err)
+	}
+	rightPage, err := readPageStruct(tree.pager, rightID)
+	if err != nil {
+		return fmt.Errorf("failed to load right leaf: %w", err)
+	}
+
+	left := tree.leafPage(leftPage)
+	right := tree.leafPage(rightPage)
+
+	if left.UsedSpace()+right.UsedSpace() <= left.Capacity() {
+		return tree.mergeLeaves(path, parentID, parentPage, leftID, leftPage, rightID, rightPage, sepIndex)
+	}
+
+	return tree.redistributeLeaves(parentID, parentPage, leftID, leftPage, rightID, rightPage, sepIndex)
+}
+
+// mergeLeaves moves all records of the right leaf into the left leaf,
+// frees the right leaf and removes its separator from the parent
+func (tree *BPTree) mergeLeaves(path *writePath, parentID uint64, parentPage *storage.Page, leftID uint64, leftPage *storage.Page,
+	rightID uint64, rightPage *storage.Page, sepIndex int) error {
+	left := tree.leafPage(leftPage)
+	right := tree.leafPage(rightPage)
+
+	records, err := right.GetAllRecords()
+	if err != nil {
+		return fmt.Errorf("failed to get records: %w", err)
+	}
+
+	for _, record := range records {
+		if err := left.InsertRecord(record); err != nil {
+			return fmt.Errorf("failed to insert into left leaf: %w", err)
+		}
+	}
+
+	// Unlink right leaf from the leaf chain
+	leftPage.Header.NextPage = rightPage.Header.NextPage
+	if nextPageID := uint64(rightPage.Header.NextPage); nextPageID != 0 {
+		if err := tree.setPrevLeaf(path, nextPageID, leftID); err != nil {
+			return err
+		}
+	}
+
+	if err := writePageStruct(tree.pager, leftID, leftPage); err != nil {
+		return err
+	}
+
+	if err := path.free(rightID); err != nil {
+		return fmt.Errorf("failed to free page %d: %w", rightID, err)
+	}
+
+	parent := tree.internalPage(parentPage)
+	if err := parent.RemoveEntry(sepIndex); err != nil {
+		return err
+	}
+
+	if err := writePageStruct(tree.pager, parentID, parentPage); err != nil {
+		return err
+	}
+
+	return tree.rebalanceInternal(path, parentID, parentPage)
+}
+
+// redistributeLeaves splits the records of two sibling leaves evenly
+// and updates the separator key in the parent
+func (tree *BPTree) redistributeLeaves(parentID uint64, parentPage *storage.Page, leftID uint64, leftPage *storage.Page,
+	rightID uint64, rightPage *storage.Page, sepIndex int) error {
+	left := tree.leafPage(leftPage)
+	right := tree.leafPage(rightPage)
+
+	leftRecords, err := left.GetAllRecords()
+	if err != nil {
+		return fmt.Errorf("failed to get records: %w", err)
+	}
+	rightRecords, err := right.GetAllRecords()
+	if err != nil {
+		return fmt.Errorf("failed to get records: %w", err)
+	}
+
+	// Left keys are all smaller than right keys, so this is already sorted
+	allRecords := append(leftRecords, rightRecords...)
+	splitIndex := leafSplitIndex(allRecords)
+
+	// New separator falls between the two halves. If a longer separator no
+	// longer fits in the parent, leave the leaves as they are (under-full)
+	separator := tree.separator(allRecords[splitIndex-1].Key, allRecords[splitIndex].Key)
+	parent := tree.internalPage(parentPage)
+	if !canSetKey(parent, sepIndex, separator) {
+		return nil
+	}
+	if err := parent.SetKey(sepIndex, separator); err != nil {
+		return err
+	}
+
+	left.Clear()
+	for i := 0; i < splitIndex; i++ {
+		if err := left.InsertRecord(allRecords[i]); err != nil {
+			return fmt.Errorf("failed to insert into left leaf: %w", err)
+		}
+	}
+
+	right.Clear()
+	for i := splitIndex; i < len(allRecords); i++ {
+		if err := right.InsertRecord(allRecords[i]); err != nil {
+			return fmt.Errorf("failed to insert into right leaf: %w", err)
+		}
+	}
+
+	if err := writePageStruct(tree.pager, leftID, leftPage); err != nil {
+		return err
+	}
+	if err := writePageStruct(tree.pager, rightID, rightPage); err != nil {
+		return err
+	}
+	return writePageStruct(tree.pager, parentID, parentPage)
+}
+
+// rebalanceInternal fixes an under-full internal node after one of its
+// children was merged away. Handles recursive merging up the tree
+func (tree *BPTree) rebalanceInternal(path *writePath, pageID uint64, page *storage.Page) error {
+	internal := tree.internalPage(page)
+
+	if page.Header.Parent == 0 {
+		// Root only needs fixing once it has a single child left
+		if internal.NumKeys() > 0 {
+			return nil
+		}
+		return tree.collapseRoot(path, pageID, page)
+	}
+
+	if !isInternalUnderflow(internal) {
+		return nil
+	}
+
+	parentID := uint64(page.Header.Parent)
+	parentPage, err := readPageStruct(tree.pager, parentID)
+	if err != nil {
+		return fmt.Errorf("failed to load parent: %w", err)
+	}
+	parent := tree.internalPage(parentPage)
+
+	leftID, rightID, sepIndex, err := siblingPair(parent, pageID)
+	if err != nil {
+		return err
+	}
+	path.lockPair(leftID, rightID)
+
+	leftPage, err := readPageStruct(tree.pager, leftID)
+	if err != nil {
+		return fmt.Errorf("failed to load left internal: %w", err)
+	}
+	rightPage, err := readPageStruct(tree.pager, rightID)
+	if err != nil {
+		return fmt.Errorf("failed to load right internal: %w", err)
+	}
+
+	left := tree.internalPage(leftPage)
+	right := tree.internalPage(rightPage)
+
+	sepKey, _, err := parent.GetKeyPointer(sepIndex)
+	if err != nil {
+		return err
+	}
+
+	// The separator is pulled down from the parent on merge
+	if left.UsedSpace()+right.UsedSpace()+storage.InternalEntrySize(sepKey) <= left.Capacity() {
+		return tree.mergeInternal(path, parentID, parentPage, leftID, leftPage, rightID, rightPage, sepIndex)
+	}
+
+	if pageID == leftID {
+		return tree.borrowFromRightInternal(path, parentID, parentPage, leftID, leftPage, rightID, rightPage, sepIndex)
+	}
+	return tree.borrowFromLeftInternal(path, parentID, parentPage, leftID, leftPage, rightID, rightPage, sepIndex)
+}
+
+// mergeInternal pulls the separator down and moves all entries of the right
+// node into the left node, then frees the right node
+func (tree *BPTree) mergeInternal(path *writePath, parentID uint64, parentPage *storage.Page, leftID uint64, leftPage *storage.Page,
+	rightID uint64, rightPage *storage.Page, sepIndex int) error {
+	parent := tree.internalPage(parentPage)
+	left := tree.internalPage(leftPage)
+	right := tree.internalPage(rightPage)
+
+	sepKey, _, err := parent.GetKeyPointer(sepIndex)
+	if err != nil {
+		return err
+	}
+
+	rightLeftmost, err := right.GetLeftmostPointer()
+	if err != nil {
+		return err
+	}
+
+	// Separator now divides left's children from right's leftmost child
+	movedChildren := []uint64{rightLeftmost}
+	if err := left.InsertEntry(sepKey, rightLeftmost); err != nil {
+		return fmt.Errorf("failed to insert into left internal: %w", err)
+	}
+
+	for i := 0; i < right.NumKeys(); i++ {
+		k, p, err := right.GetKeyPointer(i)
+		if err != nil {
+			return fmt.Errorf("failed to get entry %d: %w", i, err)
+		}
+		if err := left.InsertEntry(k, p); err != nil {
+			return fmt.Errorf("failed to insert into left internal: %w", err)
+		}
+		movedChildren = append(movedChildren, p)
+	}
+
+	for _, childID := range movedChildren {
+		if err := tree.setParent(path, childID, leftID); err != nil {
+			return err
+		}
+	}
+
+	if err := writePageStruct(tree.pager, leftID, leftPage); err != nil {
+		return err
+	}
+
+	if err := path.free(rightID); err != nil {
+		return fmt.Errorf("failed to free page %d: %w", rightID, err)
+	}
+
+	if err := parent.RemoveEntry(sepIndex); err != nil {
+		return err
+	}
+
+	if err := writePageStruct(tree.pager, parentID, parentPage); err != nil {
+		return err
+	}
+
+	return tree.rebalanceInternal(path, parentID, parentPage)
+}
+
+// borrowFromRightInternal rotates the first entry of the right node
+// through the parent into the end of the left node
+func (tree *BPTree) borrowFromRightInternal(path *writePath, parentID uint64, parentPage *storage.Page, leftID uint64, leftPage *storage.Page,
+	rightID uint64, rightPage *storage.Page, sepIndex int) error {
+	parent := tree.internalPage(parentPage)
+	left := tree.internalPage(leftPage)
+	right := tree.internalPage(rightPage)
+
+	sepKey, _, err := parent.GetKeyPointer(sepIndex)
+	if err != nil {
+		return err
+	}
+
+	movedChild, err := right.GetLeftmostPointer()
+	if err != nil {
+		return err
+	}
+
+	firstKey, firstPtr, err := right.GetKeyPointer(0)
+	if err != nil {
+		return err
+	}
+
+	// Keys vary in size: skip the rotation (leaving the left node under-full)
+	// when either key does not fit where it moves
+	if !left.Fits(sepKey) || !canSetKey(parent, sepIndex, firstKey) {
+		return nil
+	}
+
+	// Separator comes down to the left node with right's leftmost child
+	if err := left.InsertEntry(sepKey, movedChild); err != nil {
+		return fmt.Errorf("failed to insert into left internal: %w", err)
+	}
+
+	// Right's first key goes up, its pointer becomes the new leftmost
+	right.SetLeftmostPointer(firstPtr)
+	if err := right.RemoveEntry(0); err != nil {
+		return err
+	}
+	if err := parent.SetKey(sepIndex, firstKey); err != nil {
+		return err
+	}
+
+	if err := tree.setParent(path, movedChild, leftID); err != nil {
+		return err
+	}
+
+	if err := writePageStruct(tree.pager, leftID, leftPage); err != nil {
+		return err
+	}
+	if err := writePageStruct(tree.pager, rightID, rightPage); err != nil {
+		return err
+	}
+	return writePageStruct(tree.pager, parentID, parentPage)
+}
+
+// borrowFromLeftInternal rotates the last entry of the left node
+// through the parent into the front of the right node
+func (tree *BPTree) borrowFromLeftInternal(path *writePath, parentID uint64, parentPage *storage.Page, leftID uint64, leftPage *storage.Page,
+	rightID uint64, rightPage *storage.Page, sepIndex int) error {
+	parent := tree.internalPage(parentPage)
+	left := tree.internalPage(leftPage)
+	right := tree.internalPage(rightPage)
+
+	sepKey, _, err := parent.GetKeyPointer(sepIndex)
+	if err != nil {
+		return err
+	}
+
+	lastIndex := left.NumKeys() - 1
+	lastKey, movedChild, err := left.GetKeyPointer(lastIndex)
+	if err != nil {
+		return err
+	}
+
+	// Keys vary in size: skip the rotation (leaving the right node under-full)
+	// when either key does not fit where it moves
+	if !right.Fits(sepKey) || !canSetKey(parent, sepIndex, lastKey) {
+		return nil
+	}
+
+	// Separator comes down in front of right's old leftmost child
+	// (it is smaller than every key in right, so it lands at index 0)
+	oldLeftmost, err := right.GetLeftmostPointer()
+	if err != nil {
+		return err
+	}
+	if err := right.InsertEntry(sepKey, oldLeftmost); err != nil {
+		return fmt.Errorf("failed to insert into right internal: %w", err)
+	}
+	right.SetLeftmostPointer(movedChild)
+
+	// Left's last key goes up
+	if err := left.RemoveEntry(lastIndex); err != nil {
+		return err
+	}
+	if err := parent.SetKey(sepIndex, lastKey); err != nil {
+		return err
+	}
+
+	if err := tree.setParent(path, movedChild, rightID); err != nil {
+		return err
+	}
+
+	if err := writePageStruct(tree.pager, leftID, leftPage); err != nil {
+		return err
+	}
+	if err := writePageStruct(tree.pager, rightID, rightPage); err != nil {
+		return err
+	}
+	return writePageStruct(tree.pager, parentID, parentPage)
+}
+
+// collapseRoot replaces an internal root that has no keys with its only child
+// The root was not safe, so the write still holds rootLatch
+func (tree *BPTree) collapseRoot(path *writePath, rootID uint64, rootPage *storage.Page) error {
+	root := tree.internalPage(rootPage)
+
+	childID, err := root.GetLeftmostPointer()
+	if err != nil {
+		return err
+	}
+
+	if err := tree.setParent(path, childID, 0); err != nil {
+		return err
+	}
+
+	if err := tree.setRoot(childID); err != nil {
+		return err
+	}
+
+	if err := path.free(rootID); err != nil {
+		return fmt.Errorf("failed to free page %d: %w", rootID, err)
+	}
+
+	return nil
+}
+
+// canSetKey reports whether the key at index can be replaced by key without
+// overflowing the page
+func canSetKey(internal *storage.InternalPage, index int, key []byte) bool {
+	old, _, err := internal.GetKeyPointer(index)
+	if err != nil {
+		return false
+	}
+	return internal.AvailableSpace() >= len(key)-len(old)
+}
+
+// setParent updates the parent pointer of a page
+func (tree *BPTree) setParent(path *writePath, pageID uint64, parentID uint64) error {
+	path.lock(pageID)
+
+	page, err := readPageStruct(tree.pager, pageID)
+	if err != nil {
+		return fmt.Errorf("failed to load page %d: %w", pageID, err)
+	}
+	page.Header.Parent = uint32(parentID)
+	return writePageStruct(tree.pager, pageID, page)
+}
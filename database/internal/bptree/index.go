@@ -0,0 +1,336 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Secondary indexes
+//
+// An index is a catalog tree mapping the values of a table to its keys. Its
+// records have no value and are keyed
+// [value, cut to IndexedValuePrefix bytes][table key][table key size: 1 byte]
+// in bytewise order, so the keys of one value are adjacent and a lookup
+// seeks to the value and reads on while the prefix matches. Values longer
+// than the prefix can share entries, lookups check the table row
+//
+// Index entries are not logged: writeVersion derives them from each write to
+// the table, stamped with the same timestamp, so the WAL record of a write
+// (single key, batch or transaction) also covers its index entries and
+// replay keeps them in sync. CREATE and DROP INDEX checkpoint like tables,
+// replaying CREATE INDEX builds the index again from the replayed table
+
+var (
+	// ErrIndexExists is returned when creating an index whose name is taken
+	ErrIndexExists = errors.New("index already exists")
+	// ErrIndexNotFound is returned when dropping an index that does not exist
+	ErrIndexNotFound = errors.New("index not found")
+)
+
+const (
+	// IndexedValuePrefix is how many bytes of a value an index entry keeps
+	IndexedValuePrefix = 64
+	// MaxIndexedKeySize is the maximum key size of a table with an index,
+	// what is left of storage.MaxKeySize next to the value prefix
+	MaxIndexedKeySize = storage.MaxKeySize - IndexedValuePrefix - 1
+)
+
+// indexKey returns the index entry of key holding value
+func indexKey(value string, key []byte) []byte {
+	prefix := value[:min(len(value), IndexedValuePrefix)]
+
+	entry := make([]byte, 0, len(prefix)+len(key)+1)
+	entry = append(entry, prefix...)
+	entry = append(entry, key...)
+	return append(entry, byte(len(key)))
+}
+
+// splitIndexKey returns the value prefix and table key of an index entry
+func splitIndexKey(entry []byte) (prefix, key []byte) {
+	keySize := int(entry[len(entry)-1])
+	split := len(entry) - 1 - keySize
+	return entry[:split], entry[split : len(entry)-1]
+}
+
+// CreateIndex indexes the values of the table under name, so SearchValue
+// no longer scans it
+// Writes to the table wait while the index is built. Once the table has an
+// index its keys are limited to MaxIndexedKeySize bytes
+func (tree *BPTree) CreateIndex(name string) error {
+	if name == "" || len(name) > MaxTableNameSize {
+		return fmt.Errorf("invalid index name %q: must be 1 to %d bytes", name, MaxTableNameSize)
+	}
+	if tree.indexed != nil {
+		return fmt.Errorf("index %s: indexes cannot be indexed", name)
+	}
+
+	tree.ddlMu.Lock()
+	defer tree.ddlMu.Unlock()
+
+	tree.catalogMu.Lock()
+	_, exists := tree.indexes[name]
+	tree.catalogMu.Unlock()
+	if exists {
+		return fmt.Errorf("%w: %s", ErrIndexExists, name)
+	}
+
+	index, err := tree.createIndex(name, 0, 0)
+	if err != nil {
+		return err
+	}
+	change := wal.CatalogChange{Op: wal.CatalogCreateIndex, Name: name, Indexed: tree.id}
+	if err := tree.logCatalogChange(index.id, change); err != nil {
+		return err
+	}
+
+	// The index is durable once the catalog and its pages are checkpointed
+	if _, err := tree.Checkpoint(); err != nil {
+		return fmt.Errorf("failed to checkpoint catalog: %w", err)
+	}
+
+	return nil
+}
+
+// createIndex builds an index on the values of the table under id (0 for
+// the next unused ID), its entries stamped with ts (0 for the last LSN)
+func (tree *BPTree) createIndex(name string, id uint32, ts uint64) (*BPTree, error) {
+	rootPageID, rootPage, err := allocatePageWithType(tree.pager, storage.PageTypeLeaf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate root page: %w", err)
+	}
+	if err := writePageStruct(tree.pager, rootPageID, rootPage); err != nil {
+		return nil, fmt.Errorf("failed to write root page: %w", err)
+	}
+
+	// The ID and catalog page are set up before the build: a root split
+	// saves the root of the index in the catalog
+	tree.catalogMu.Lock()
+	if err := tree.allocateCatalog(); err != nil {
+		tree.catalogMu.Unlock()
+		tree.pager.FreePage(rootPageID)
+		return nil, err
+	}
+	index := tree.newIndex(tree.takeTableID(id), rootPageID, tree)
+	tree.catalogMu.Unlock()
+
+	if err := tree.buildIndex(name, index, ts); err != nil {
+		index.free()
+		return nil, err
+	}
+	return index, nil
+}
+
+// buildIndex fills index from the rows of the table and attaches it, with no
+// write in flight, so no write lands between the build and the attach
+func (tree *BPTree) buildIndex(name string, index *BPTree, ts uint64) error {
+	tree.writeLatch.Lock()
+	defer tree.writeLatch.Unlock()
+
+	if ts == 0 {
+		ts = tree.wal.LastLSN()
+	}
+	if err := tree.fillIndex(index, ts); err != nil {
+		return err
+	}
+
+	// Registered in the same critical section as attached: a checkpoint sees
+	// the index in the catalog exactly when writes maintain it
+	tree.catalogMu.Lock()
+	defer tree.catalogMu.Unlock()
+
+	tree.indexes[name] = index
+	tree.tablesByID[index.id] = index
+	if err := tree.writeCatalog(); err != nil {
+		delete(tree.indexes, name)
+		delete(tree.tablesByID, index.id)
+		return err
+	}
+
+	tree.valueIndexes = append(tree.valueIndexes, index)
+	return nil
+}
+
+// fillIndex adds the entries of every row of the table to index, stamped
+// with ts. The caller holds writeLatch exclusively
+func (tree *BPTree) fillIndex(index *BPTree, ts uint64) error {
+	cursor := tree.NewCursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		key := cursor.Key()
+		if len(key) > MaxIndexedKeySize {
+			return fmt.Errorf("%w: key %s of %d bytes cannot be indexed, max %d",
+				ErrKeyTooLarge, FormatKey(key), len(key), MaxIndexedKeySize)
+		}
+		if _, err := index.applyVersion(indexKey(cursor.Value(), key), "", false, ts); err != nil {
+			return fmt.Errorf("failed to index key %s: %w", FormatKey(key), err)
+		}
+	}
+	return cursor.Err()
+}
+
+// DropIndex removes an index of any table of the file and frees its pages
+func (tree *BPTree) DropIndex(name string) error {
+	tree.ddlMu.Lock()
+	defer tree.ddlMu.Unlock()
+
+	index, err := tree.detachIndex(name)
+	if err != nil {
+		return err
+	}
+	if err := tree.logCatalogChange(index.id, wal.CatalogChange{Op: wal.CatalogDropIndex, Name: name}); err != nil {
+		return err
+	}
+
+	// Once the catalog without the index is durable nothing refers to its pages
+	if _, err := tree.Checkpoint(); err != nil {
+		return fmt.Errorf("failed to checkpoint catalog: %w", err)
+	}
+
+	return index.free()
+}
+
+// detachIndex stops maintaining an index and removes it from the catalog,
+// with no write in flight
+func (tree *BPTree) detachIndex(name string) (*BPTree, error) {
+	tree.writeLatch.Lock()
+	defer tree.writeLatch.Unlock()
+	tree.catalogMu.Lock()
+	defer tree.catalogMu.Unlock()
+
+	index, ok := tree.indexes[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+
+	delete(tree.indexes, name)
+	delete(tree.tablesByID, index.id)
+	if err := tree.writeCatalog(); err != nil {
+		tree.indexes[name] = index
+		tree.tablesByID[index.id] = index
+		return nil, err
+	}
+
+	table := index.indexed
+	table.valueIndexes = slices.DeleteFunc(table.valueIndexes, func(other *BPTree) bool {
+		return other == index
+	})
+	return index, nil
+}
+
+// Indexes returns the names of the indexes on the values of the table in sorted order
+func (tree *BPTree) Indexes() []string {
+	tree.catalogMu.RLock()
+	defer tree.catalogMu.RUnlock()
+
+	names := make([]string, 0)
+	for name, index := range tree.indexes {
+		if index.indexed == tree {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// newIndex returns the tree of an index on the values of table
+// Index entries are ordered bytewise whatever the comparator of the file
+func (tree *BPTree) newIndex(id uint32, rootPageID uint64, table *BPTree) *BPTree {
+	index := tree.newTable(id, rootPageID, nil)
+	index.indexed = table
+	index.cmp = storage.CompareBytes
+	index.bytewise = true
+	return index
+}
+
+// checkIndexedKey rejects keys too large for the indexes of the table
+// The caller holds writeLatch, so indexes cannot be added meanwhile
+func (tree *BPTree) checkIndexedKey(key []byte) error {
+	if len(tree.valueIndexes) > 0 && len(key) > MaxIndexedKeySize {
+		return fmt.Errorf("%w: %d bytes, max %d in an indexed table", ErrKeyTooLarge, len(key), MaxIndexedKeySize)
+	}
+	return nil
+}
+
+// updateIndexes moves the index entries of key from its old value (if it
+// existed) to its new one (unless deleted), stamped with ts
+// Called by writeVersion with the leaf of key latched, so writes to the same
+// key update its entries in order
+func (tree *BPTree) updateIndexes(key []byte, oldValue string, existed bool, value string, deleted bool, ts uint64) error {
+	if existed && !deleted && oldValue == value {
+		return nil
+	}
+
+	for _, index := range tree.valueIndexes {
+		if existed {
+			if _, err := index.applyVersion(indexKey(oldValue, key), "", true, ts); err != nil {
+				return fmt.Errorf("failed to update index: %w", err)
+			}
+		}
+		if !deleted {
+			if _, err := index.applyVersion(indexKey(value, key), "", false, ts); err != nil {
+				return fmt.Errorf("failed to update index: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// SearchValue returns the key-value pairs whose value is value in ascending
+// key order, through an index of the table if it has one, scanning it otherwise
+// limit <= 0 means no limit
+func (tree *BPTree) SearchValue(value string, limit int) ([]KeyValue, error) {
+	tree.writeLatch.RLock()
+	var index *BPTree
+	if len(tree.valueIndexes) > 0 {
+		index = tree.valueIndexes[0]
+	}
+	tree.writeLatch.RUnlock()
+
+	results := make([]KeyValue, 0)
+	if index == nil {
+		cursor := tree.NewCursor()
+		for ok := cursor.First(); ok; ok = cursor.Next() {
+			if cursor.Value() == value {
+				results = append(results, KeyValue{Key: cursor.Key(), Value: value})
+			}
+			if limit > 0 && len(results) == limit {
+				break
+			}
+		}
+		return results, cursor.Err()
+	}
+
+	prefix := []byte(value[:min(len(value), IndexedValuePrefix)])
+	cursor := index.NewCursor()
+	for ok := cursor.Seek(prefix); ok && bytes.HasPrefix(cursor.Key(), prefix); ok = cursor.Next() {
+		entryPrefix, key := splitIndexKey(cursor.Key())
+		if !bytes.Equal(entryPrefix, prefix) {
+			continue // a longer value starting with this one
+		}
+
+		// The entry may be stale by now, or cut short, the row has the value
+		current, found, err := tree.Search(key)
+		if err != nil {
+			return nil, err
+		}
+		if found && current == value {
+			results = append(results, KeyValue{Key: bytes.Clone(key), Value: value})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	// Index order is bytewise, the table's may differ
+	slices.SortFunc(results, func(a, b KeyValue) int {
+		return tree.cmp(a.Key, b.Key)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
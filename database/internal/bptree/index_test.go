@@ -0,0 +1,197 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// searchKeys returns the numeric keys SearchValue finds for value
+func searchKeys(t *testing.T, tree *BPTree, value string) []uint32 {
+	t.Helper()
+	rows, err := tree.SearchValue(value, 0)
+	if err != nil {
+		t.Fatalf("Failed to search value %q: %v", value, err)
+	}
+
+	keys := make([]uint32, len(rows))
+	for i, row := range rows {
+		keys[i] = num(row.Key)
+	}
+	return keys
+}
+
+// countEntries returns the number of live entries of an index
+func countEntries(t *testing.T, tree *BPTree, name string) int {
+	t.Helper()
+	index, ok := tree.indexes[name]
+	if !ok {
+		t.Fatalf("Index %s not found", name)
+	}
+	keys, err := index.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("Failed to walk index %s: %v", name, err)
+	}
+	return len(keys)
+}
+
+func TestBPTreeIndex(t *testing.T) {
+	dbFile := "test_index.db"
+	walFile := "test_index.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	long := strings.Repeat("x", IndexedValuePrefix)
+
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		// Rows written before the index is built
+		for i := 1; i <= 1000; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("group-%d", i%10)); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+		if err := tree.CreateIndex("by_value"); err != nil {
+			t.Fatalf("Failed to create index: %v", err)
+		}
+		if err := tree.CreateIndex("by_value"); !errors.Is(err, ErrIndexExists) {
+			t.Errorf("Expected ErrIndexExists, got %v", err)
+		}
+		if got := countEntries(t, tree, "by_value"); got != 1000 {
+			t.Errorf("Index has %d entries, expected 1000", got)
+		}
+		if keys := searchKeys(t, tree, "group-3"); len(keys) != 100 || keys[0] != 3 || keys[99] != 993 {
+			t.Errorf("group-3: %d keys from %v", len(keys), keys[:min(len(keys), 3)])
+		}
+		t.Log("✓ Index built from existing rows")
+
+		// Every kind of write keeps the index in sync
+		if err := tree.Update(k(3), "moved"); err != nil {
+			t.Fatalf("Failed to update: %v", err)
+		}
+		if _, err := tree.Delete(k(13)); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+		batch := NewWriteBatch()
+		batch.Put(k(2000), "moved")
+		batch.Delete(k(23))
+		if err := tree.Write(batch); err != nil {
+			t.Fatalf("Failed to write batch: %v", err)
+		}
+
+		tx := tree.Begin()
+		if err := tx.Upsert(k(33), "moved"); err != nil {
+			t.Fatalf("Failed to write in tx: %v", err)
+		}
+		rows, err := tx.SearchValue("moved", 0)
+		if err != nil || len(rows) != 3 {
+			t.Errorf("Tx saw %d moved rows (err=%v), expected 3", len(rows), err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+
+		// Values longer than the indexed prefix share it
+		if err := tree.Upsert(k(5000), long+"a"); err != nil {
+			t.Fatalf("Failed to upsert: %v", err)
+		}
+		if err := tree.Upsert(k(5001), long+"b"); err != nil {
+			t.Fatalf("Failed to upsert: %v", err)
+		}
+
+		if err := tree.Upsert(k(9000), strings.Repeat("k", MaxIndexedKeySize+1)); err != nil {
+			t.Fatalf("Failed to upsert: %v", err)
+		}
+		if err := tree.Insert(make([]byte, MaxIndexedKeySize+1), "too long"); !errors.Is(err, ErrKeyTooLarge) {
+			t.Errorf("Expected ErrKeyTooLarge for a long key, got %v", err)
+		}
+
+		// Crash without closing, replay maintains the index again
+		pager.Close()
+	}
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+	rootPageID, order, err := LoadMetadata(pager, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	defer tree.Close()
+
+	if names := tree.Indexes(); len(names) != 1 || names[0] != "by_value" {
+		t.Fatalf("Indexes() = %v, expected [by_value]", names)
+	}
+	if keys := searchKeys(t, tree, "moved"); fmt.Sprint(keys) != "[3 33 2000]" {
+		t.Errorf("moved: %v, expected [3 33 2000]", keys)
+	}
+	if keys := searchKeys(t, tree, "group-3"); len(keys) != 96 {
+		t.Errorf("group-3: %d keys, expected 96", len(keys))
+	}
+	if keys := searchKeys(t, tree, long+"b"); fmt.Sprint(keys) != "[5001]" {
+		t.Errorf("Long value: %v, expected [5001]", keys)
+	}
+	if keys := searchKeys(t, tree, long); len(keys) != 0 {
+		t.Errorf("Prefix of long values matched %v", keys)
+	}
+	// 1000 rows, 2 deleted, 4 added
+	if got := countEntries(t, tree, "by_value"); got != 1002 {
+		t.Errorf("Index has %d entries, expected 1002", got)
+	}
+	t.Log("✓ Index recovered and in sync after replay")
+
+	// Without the index lookups scan the table
+	free := pager.FreeListSize()
+	if err := tree.DropIndex("by_value"); err != nil {
+		t.Fatalf("Failed to drop index: %v", err)
+	}
+	if err := tree.DropIndex("by_value"); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("Expected ErrIndexNotFound, got %v", err)
+	}
+	if pager.FreeListSize() <= free {
+		t.Error("Dropped index pages were not freed")
+	}
+	if keys := searchKeys(t, tree, "moved"); fmt.Sprint(keys) != "[3 33 2000]" {
+		t.Errorf("moved by scan: %v, expected [3 33 2000]", keys)
+	}
+	if err := tree.Insert(make([]byte, MaxIndexedKeySize+1), "fits"); err != nil {
+		t.Errorf("Long key rejected without an index: %v", err)
+	}
+	t.Log("✓ Dropped index falls back to a scan")
+
+	// Dropping a table drops its indexes
+	users, err := tree.CreateTable("users")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := users.CreateIndex("users_value"); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	if err := tree.DropTable("users"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+	if err := tree.DropIndex("users_value"); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("Index outlived its table: %v", err)
+	}
+	t.Log("✓ Table indexes dropped with the table")
+}
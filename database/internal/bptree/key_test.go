@@ -0,0 +1,252 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeStringKeys(t *testing.T) {
+	dbFile := "test_string_keys.db"
+	walFile := "test_string_keys.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// Keys of very different lengths sharing long prefixes, enough for
+	// internal splits
+	keyFor := func(i int) []byte {
+		return []byte(fmt.Sprintf("user:%s:%05d", strings.Repeat("x", i%200), i))
+	}
+
+	const numKeys = 3000
+	for i := 0; i < numKeys; i++ {
+		if err := tree.Insert(keyFor(i), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert key %d: %v", i, err)
+		}
+	}
+	t.Logf("✓ Inserted %d variable-length keys", numKeys)
+
+	for i := 0; i < numKeys; i++ {
+		value, found, err := tree.Search(keyFor(i))
+		if err != nil || !found || value != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("Search(%d): value=%q found=%v err=%v", i, value, found, err)
+		}
+	}
+
+	keys, err := tree.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("Traversal failed: %v", err)
+	}
+	if len(keys) != numKeys {
+		t.Fatalf("Traversal returned %d keys, expected %d", len(keys), numKeys)
+	}
+	for i := 1; i < len(keys); i++ {
+		if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+			t.Fatalf("Keys not sorted: %q after %q", keys[i], keys[i-1])
+		}
+	}
+	t.Log("✓ Keys traverse in byte order")
+
+	// Prefix scan
+	rows, err := tree.Scan([]byte("user:x"), []byte("user:xx"), 0)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	for _, row := range rows {
+		if !bytes.HasPrefix(row.Key, []byte("user:x:")) {
+			t.Errorf("Scan returned %q outside the range", row.Key)
+		}
+	}
+	if len(rows) != numKeys/200 {
+		t.Errorf("Scan returned %d rows, expected %d", len(rows), numKeys/200)
+	}
+
+	// Delete every other key, merging leaves and internal nodes
+	for i := 0; i < numKeys; i += 2 {
+		if found, err := tree.Delete(keyFor(i)); err != nil || !found {
+			t.Fatalf("Delete(%d): found=%v err=%v", i, found, err)
+		}
+	}
+
+	count := 0
+	reverse := tree.NewReverseCursor()
+	var prev []byte
+	for ok := reverse.First(); ok; ok = reverse.Next() {
+		if prev != nil && bytes.Compare(reverse.Key(), prev) >= 0 {
+			t.Fatalf("Reverse cursor not descending: %q after %q", reverse.Key(), prev)
+		}
+		prev = reverse.Key()
+		count++
+	}
+	if err := reverse.Err(); err != nil {
+		t.Fatalf("Cursor error: %v", err)
+	}
+	if count != numKeys/2 {
+		t.Errorf("Reverse cursor saw %d keys, expected %d", count, numKeys/2)
+	}
+	t.Logf("✓ %d keys left after deletes, reverse order intact", count)
+
+	if err := tree.Insert(bytes.Repeat([]byte("k"), storage.MaxKeySize+1), "v"); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("Expected ErrKeyTooLarge, got %v", err)
+	}
+	t.Log("✓ Oversized key rejected")
+}
+
+func TestBPTreeComparator(t *testing.T) {
+	dbFile := "test_comparator.db"
+	walFile := "test_comparator.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	descending := func(a, b []byte) int { return bytes.Compare(b, a) }
+
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTreeWithComparator(pager, 100, walFile, descending)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		for i := 1; i <= 2000; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert key=%d: %v", i, err)
+			}
+		}
+
+		// Crash without closing, replay must use the same order
+		pager.Close()
+	}
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+
+	// The superblock has the root of the last checkpoint, replay redoes the splits since
+	rootPageID, order, err := LoadMetadata(pager, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+
+	tree, err := LoadBPTreeWithComparator(pager, rootPageID, order, walFile, descending)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	defer tree.Close()
+
+	keys, err := tree.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("Traversal failed: %v", err)
+	}
+	if len(keys) != 2000 || num(keys[0]) != 2000 || num(keys[1999]) != 1 {
+		t.Fatalf("Expected 2000 keys from 2000 down to 1, got %d", len(keys))
+	}
+
+	// Range bounds follow the comparator: start comes first in its order
+	rows, err := tree.Scan(k(100), k(91), 0)
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if len(rows) != 10 || num(rows[0].Key) != 100 || num(rows[9].Key) != 91 {
+		t.Errorf("Scan(100, 91) returned %d rows", len(rows))
+	}
+	t.Log("✓ Custom comparator orders keys and survives recovery")
+}
+
+func TestBPTreeFixedWidthComparator(t *testing.T) {
+	dir := t.TempDir()
+
+	// Reads exactly 8 bytes, so it panics if handed a shortened separator
+	uint64Order := func(a, b []byte) int {
+		x, y := binary.BigEndian.Uint64(a), binary.BigEndian.Uint64(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	key := func(i int) []byte { return binary.BigEndian.AppendUint64(nil, uint64(i)) }
+	value := func(i int) string { return fmt.Sprintf("%d:%s", i, strings.Repeat("v", 40)) }
+
+	pager, err := storage.NewFilePager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTreeWithComparator(pager, 100, filepath.Join(dir, "test.wal"), uint64Order)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// Splits, then merges and redistributions as most keys go
+	for i := 0; i < 3000; i++ {
+		if err := tree.Insert(key(i), value(i)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+	for i := 0; i < 3000; i++ {
+		if i%5 == 0 {
+			continue
+		}
+		if _, err := tree.Delete(key(i)); err != nil {
+			t.Fatalf("Failed to delete key=%d: %v", i, err)
+		}
+	}
+	for i := 0; i < 3000; i += 5 {
+		if got, found, err := tree.Search(key(i)); err != nil || !found || got != value(i) {
+			t.Fatalf("Key %d: got %q, found=%v, err=%v", i, got, found, err)
+		}
+	}
+	checkLeafChain(t, tree)
+
+	// Bulk load builds its separators the same way
+	table, err := tree.CreateTable("bulk")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	rows := make([]KeyValue, 3000)
+	for i := range rows {
+		rows[i] = KeyValue{Key: key(i), Value: value(i)}
+	}
+	if _, err := table.BulkLoad(SliceSource(rows), BulkLoadOptions{}); err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	for i := 0; i < 3000; i += 7 {
+		if got, found, err := table.Search(key(i)); err != nil || !found || got != value(i) {
+			t.Fatalf("Bulk loaded key %d: got %q, found=%v, err=%v", i, got, found, err)
+		}
+	}
+	checkLeafChain(t, table)
+
+	t.Log("✓ Fixed-width comparator splits, merges and bulk loads with whole-key separators")
+}
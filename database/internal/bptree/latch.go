@@ -0,0 +1,345 @@
+package bptree
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// Concurrency
+//
+// Every page has a read/write latch. Operations latch pages top-down from the
+// root and never wait for a page above one they hold (latch crabbing):
+//
+//   - Readers hold a shared latch on a page until its child is latched, so a
+//     reader holds at most two pages and always lands on the right leaf
+//   - Writers take exclusive latches and release everything above a page as
+//     soon as that page is safe, i.e. the write cannot split or merge it, so
+//     nothing above it can change. Writes to different leaves run in parallel
+//
+// Siblings and moved children are latched while their parent is held
+// exclusively, and siblings and leaves along the leaf chain are latched left
+// to right (see lockPair and setPrevLeaf), so writers cannot deadlock.
+// Cursors walking the chain only try the latch of the next leaf (see
+// cursor.go), so they never wait while holding a leaf. rootLatch stands in
+// for the parent of the root: it guards rootPage and is held by writers that
+// may replace the root
+//
+// A page that is freed or moved bumps freed (see sharedState): a cursor
+// following a link from a leaf it read earlier checks that nothing was freed
+// since, so the leaf is still the one it read
+//
+// A writer also pins the pages it latches in the buffer pool, so a split or
+// merge in flight is never half written to the file by an eviction
+
+// latchTable hands out one read/write latch per page
+type latchTable struct {
+	mu      sync.Mutex
+	latches map[uint64]*sync.RWMutex
+}
+
+// get returns the latch of a page, creating it on first use
+func (lt *latchTable) get(pageID uint64) *sync.RWMutex {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if lt.latches == nil {
+		lt.latches = make(map[uint64]*sync.RWMutex)
+	}
+
+	latch, ok := lt.latches[pageID]
+	if !ok {
+		latch = &sync.RWMutex{}
+		lt.latches[pageID] = latch
+	}
+	return latch
+}
+
+// pagePinner is a pager that keeps pinned pages cached and its pages behind
+// the WAL (storage.BufferPool)
+type pagePinner interface {
+	FetchPage(id uint64) ([]byte, error)
+	UnpinPage(id uint64, dirty bool) error
+	SetWAL(log storage.WriteAheadLog)
+}
+
+// writePath holds the exclusive latches of one write, top-down from the
+// highest page the write may still change
+type writePath struct {
+	tree   *BPTree
+	root   bool     // rootLatch is held, the root may be replaced
+	held   []uint64 // latched pages in the order they were latched
+	pinned []uint64 // latched pages pinned in the buffer pool
+}
+
+// lock latches a page exclusively unless the write already holds it, and
+// pins it
+func (p *writePath) lock(pageID uint64) {
+	if p.holds(pageID) {
+		return
+	}
+
+	p.tree.latches.get(pageID).Lock()
+	p.held = append(p.held, pageID)
+
+	// A page that cannot be read is not pinned, reading it reports the error
+	if p.tree.pins != nil {
+		if _, err := p.tree.pins.FetchPage(pageID); err == nil {
+			p.pinned = append(p.pinned, pageID)
+		}
+	}
+}
+
+// lockPair latches two adjacent siblings, the left one first
+// The write already holds the one it descended to. If that is the right
+// one, it lets go of it and latches both in order: their parent is held
+// exclusively, so no other write reaches the right one in between
+func (p *writePath) lockPair(leftID, rightID uint64) {
+	if p.holds(rightID) && !p.holds(leftID) {
+		p.tree.latches.get(rightID).Unlock()
+		p.tree.latches.get(leftID).Lock()
+		p.tree.latches.get(rightID).Lock()
+
+		p.held = append(p.held, leftID)
+		if p.tree.pins != nil {
+			if _, err := p.tree.pins.FetchPage(leftID); err == nil {
+				p.pinned = append(p.pinned, leftID)
+			}
+		}
+		return
+	}
+
+	p.lock(leftID)
+	p.lock(rightID)
+}
+
+// holds reports whether the write has latched a page
+func (p *writePath) holds(pageID uint64) bool {
+	return slices.Contains(p.held, pageID)
+}
+
+// unpin releases the pins on every page but keep
+// The write changes pages through copies, so none is dirtied here
+func (p *writePath) unpin(keep uint64) {
+	kept := p.pinned[:0]
+	for _, id := range p.pinned {
+		if id == keep {
+			kept = append(kept, id)
+			continue
+		}
+		p.tree.pins.UnpinPage(id, false)
+	}
+	p.pinned = kept
+}
+
+// free returns a page the write holds to the free list, a pinned page
+// cannot be freed
+func (p *writePath) free(pageID uint64) error {
+	for i, id := range p.pinned {
+		if id == pageID {
+			p.tree.pins.UnpinPage(id, false)
+			p.pinned = append(p.pinned[:i], p.pinned[i+1:]...)
+			break
+		}
+	}
+	p.tree.freed.Add(1)
+	return p.tree.pager.FreePage(pageID)
+}
+
+// releaseAbove releases every latch above the last latched page
+// Called once that page is safe
+func (p *writePath) releaseAbove() {
+	if p.root {
+		p.tree.rootLatch.Unlock()
+		p.root = false
+	}
+
+	last := len(p.held) - 1
+	p.unpin(p.held[last])
+	for _, id := range p.held[:last] {
+		p.tree.latches.get(id).Unlock()
+	}
+	p.held = append(p.held[:0], p.held[last])
+}
+
+// release releases every latch of the write
+func (p *writePath) release() {
+	if p.root {
+		p.tree.rootLatch.Unlock()
+		p.root = false
+	}
+
+	for _, id := range p.pinned {
+		p.tree.pins.UnpinPage(id, false)
+	}
+	p.pinned = nil
+	for _, id := range p.held {
+		p.tree.latches.get(id).Unlock()
+	}
+	p.held = nil
+}
+
+// descendExclusive latches the path from the root to the leaf for key
+// exclusively, releasing ancestors of every page that safe accepts
+// Returns the path (release it when done), the leaf ID and a copy of the leaf
+func (tree *BPTree) descendExclusive(key []byte, safe func(*storage.Page) bool) (*writePath, uint64, *storage.Page, error) {
+	tree.rootLatch.Lock()
+	path := &writePath{tree: tree, root: true}
+
+	pageID := tree.rootPage
+	for {
+		path.lock(pageID)
+
+		page, err := readPageStruct(tree.pager, pageID)
+		if err != nil {
+			path.release()
+			return nil, 0, nil, err
+		}
+
+		if safe(page) {
+			path.releaseAbove()
+		}
+
+		if page.IsLeaf() {
+			return path, pageID, page, nil
+		}
+
+		pageID, err = tree.internalPage(page).SearchChild(key)
+		if err != nil {
+			path.release()
+			return nil, 0, nil, err
+		}
+	}
+}
+
+// fence bounds the keys of a leaf as seen by the descent that reached it:
+// low <= key < high, an unset bound is open
+type fence struct {
+	low, high       []byte
+	hasLow, hasHigh bool
+}
+
+// descendShared walks from the root to a leaf with shared latches, following
+// the child at the index chosen by next
+// Returns the leaf ID, a copy of the leaf and the separators around it
+func (tree *BPTree) descendShared(next func(*storage.InternalPage) int) (uint64, *storage.Page, fence, error) {
+	pageID, page, bounds, latch, err := tree.descendLatched(next)
+	if err != nil {
+		return 0, nil, fence{}, err
+	}
+	latch.RUnlock()
+	return pageID, page, bounds, nil
+}
+
+// descendLatched is descendShared returning with the shared latch of the leaf
+// still held, for readers following its overflow chains. The caller releases it
+func (tree *BPTree) descendLatched(next func(*storage.InternalPage) int) (uint64, *storage.Page, fence, *sync.RWMutex, error) {
+	var bounds fence
+
+	tree.rootLatch.RLock()
+	pageID := tree.rootPage
+	latch := tree.latches.get(pageID)
+	latch.RLock()
+	tree.rootLatch.RUnlock()
+
+	for {
+		page, err := readPageStruct(tree.pager, pageID)
+		if err != nil {
+			latch.RUnlock()
+			return 0, nil, fence{}, nil, err
+		}
+
+		if page.IsLeaf() {
+			return pageID, page, bounds, latch, nil
+		}
+
+		internal := tree.internalPage(page)
+		index := next(internal)
+
+		// Child i holds keys in [key i-1, key i)
+		if index > 0 {
+			bounds.low, _, _ = internal.GetKeyPointer(index - 1)
+			bounds.hasLow = true
+		}
+		if index < internal.NumKeys() {
+			bounds.high, _, _ = internal.GetKeyPointer(index)
+			bounds.hasHigh = true
+		}
+
+		childID, err := internal.GetChild(index)
+		if err != nil {
+			latch.RUnlock()
+			return 0, nil, fence{}, nil, err
+		}
+
+		// Latch the child before letting go of the parent
+		childLatch := tree.latches.get(childID)
+		childLatch.RLock()
+		latch.RUnlock()
+
+		pageID, latch = childID, childLatch
+	}
+}
+
+// childIndexFor chooses the child whose range holds key
+func childIndexFor(key []byte) func(*storage.InternalPage) int {
+	return func(internal *storage.InternalPage) int {
+		return internal.ChildIndex(key)
+	}
+}
+
+// childIndexBefore chooses the child whose range holds the keys just below key
+func childIndexBefore(key []byte) func(*storage.InternalPage) int {
+	return func(internal *storage.InternalPage) int {
+		return internal.ChildIndexBefore(key)
+	}
+}
+
+// readShared reads a copy of a page under its shared latch
+func (tree *BPTree) readShared(pageID uint64) (*storage.Page, error) {
+	latch := tree.latches.get(pageID)
+	latch.RLock()
+	defer latch.RUnlock()
+
+	return readPageStruct(tree.pager, pageID)
+}
+
+// deleteSafe accepts pages that stay out of underflow when key is deleted
+// (or a child merge removes one of their entries), so they are not rebalanced
+// Must agree with the checks in deleteFromLeaf and rebalanceInternal
+func (tree *BPTree) deleteSafe(key []byte) func(*storage.Page) bool {
+	return func(page *storage.Page) bool {
+		isRoot := page.Header.Parent == 0
+
+		if page.IsLeaf() {
+			if isRoot {
+				return true // root leaf may be under-full
+			}
+
+			leaf := tree.leafPage(page)
+			records, err := leaf.GetAllRecords()
+			if err != nil {
+				return false
+			}
+
+			// Deleting compacts the page, so count live records only
+			used, found := 0, false
+			for _, record := range records {
+				if tree.cmp(record.Key, key) == 0 {
+					found = true
+					continue
+				}
+				used += record.Size() + 2
+			}
+			return !found || used >= leaf.Capacity()/2
+		}
+
+		internal := tree.internalPage(page)
+		if isRoot {
+			return internal.NumKeys() > 1 // collapses once it has no keys
+		}
+		// Losing the largest possible entry must keep it at least half full
+		return internal.UsedSpace()-storage.MaxInternalEntrySize >= internal.Capacity()/2
+	}
+}
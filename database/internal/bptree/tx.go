@@ -0,0 +1,400 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// ErrTxDone is returned when a committed or rolled back transaction is used
+var ErrTxDone = errors.New("transaction already committed or rolled back")
+
+// txWrite is the latest buffered change of a key inside a transaction
+type txWrite struct {
+	value   string
+	deleted bool
+}
+
+// txKey names a key of one table
+type txKey struct {
+	table uint32
+	key   string
+}
+
+// Tx groups writes that become visible (and survive a crash) all together or not at all
+//
+// Writes are logged to the WAL as they happen (BEGIN before the first one)
+// but only buffered in memory; Commit logs COMMIT, waits for it to be durable
+// and then applies the buffer to the tree. Rollback logs ABORT and drops the
+// buffer. Replay applies a transaction only if its COMMIT record made it to disk
+//
+// A Tx is not safe for concurrent use. Writes made outside the transaction
+// while it is open may be overwritten when it commits
+//
+// A Tx reads and writes the table it was begun on, In returns the same
+// transaction working on another table of the file
+type Tx struct {
+	tree *BPTree
+	*txState
+}
+
+// txState is the state of a transaction, shared by its views of every table
+type txState struct {
+	id      uint64
+	logged  []*wal.Entry      // writes in the order they were made
+	pending map[txKey]txWrite // latest write per key, read by Search and Scan
+	started bool              // BEGIN was logged
+	done    bool
+}
+
+// Begin starts a transaction
+func (tree *BPTree) Begin() *Tx {
+	tree.txMu.Lock()
+	defer tree.txMu.Unlock()
+
+	tree.nextTxID++
+	return &Tx{
+		tree: tree,
+		txState: &txState{
+			id:      tree.nextTxID,
+			pending: make(map[txKey]txWrite),
+		},
+	}
+}
+
+// In returns the transaction working on table, which must belong to the same file
+func (tx *Tx) In(table *BPTree) *Tx {
+	return &Tx{tree: table, txState: tx.txState}
+}
+
+// ID returns the transaction ID written to its WAL records
+func (tx *Tx) ID() uint64 {
+	return tx.id
+}
+
+// Search looks up a key, seeing the transaction's own writes
+func (tx *Tx) Search(key []byte) (string, bool, error) {
+	if write, ok := tx.pending[txKey{tx.tree.id, string(key)}]; ok {
+		return write.value, !write.deleted, nil
+	}
+	return tx.tree.Search(key)
+}
+
+// Insert adds a key-value pair
+// Returns ErrKeyExists if the key is present
+func (tx *Tx) Insert(key []byte, value string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	_, found, err := tx.Search(key)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("%w: %s", ErrKeyExists, FormatKey(key))
+	}
+
+	return tx.write(&wal.Entry{OpType: wal.OpInsert, Key: key, Value: value})
+}
+
+// Upsert inserts a key-value pair, replacing the value if the key exists
+func (tx *Tx) Upsert(key []byte, value string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return tx.write(&wal.Entry{OpType: wal.OpUpdate, Key: key, Value: value})
+}
+
+// Update replaces the value of an existing key
+// Returns ErrKeyNotFound if the key is absent
+func (tx *Tx) Update(key []byte, value string) error {
+	_, found, err := tx.Search(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, FormatKey(key))
+	}
+
+	return tx.Upsert(key, value)
+}
+
+// Delete removes a key
+// Returns true if the key existed
+func (tx *Tx) Delete(key []byte) (bool, error) {
+	_, found, err := tx.Search(key)
+	if err != nil || !found {
+		return false, err
+	}
+
+	return true, tx.write(&wal.Entry{OpType: wal.OpDelete, Key: key})
+}
+
+// Scan returns key-value pairs with start <= key <= end in ascending order,
+// including the transaction's own writes
+// A nil start or end leaves that side of the range open
+// limit <= 0 means no limit
+func (tx *Tx) Scan(start, end []byte, limit int) ([]KeyValue, error) {
+	return tx.scan(start, end, limit, false)
+}
+
+// ScanReverse is Scan in descending order
+func (tx *Tx) ScanReverse(start, end []byte, limit int) ([]KeyValue, error) {
+	return tx.scan(start, end, limit, true)
+}
+
+// scan merges the committed range with the buffered writes
+func (tx *Tx) scan(start, end []byte, limit int, reverse bool) ([]KeyValue, error) {
+	cmp := tx.tree.cmp
+
+	rows, err := tx.tree.Scan(start, end, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]string, len(rows))
+	for _, row := range rows {
+		merged[string(row.Key)] = row.Value
+	}
+	for pending, write := range tx.pending {
+		if pending.table != tx.tree.id {
+			continue
+		}
+		key := pending.key
+		if (start != nil && cmp([]byte(key), start) < 0) || (end != nil && cmp([]byte(key), end) > 0) {
+			continue
+		}
+		if write.deleted {
+			delete(merged, key)
+		} else {
+			merged[key] = write.value
+		}
+	}
+
+	return sortRows(merged, cmp, limit, reverse), nil
+}
+
+// SearchValue returns the key-value pairs whose value is value in ascending
+// key order, including the transaction's own writes
+// limit <= 0 means no limit
+func (tx *Tx) SearchValue(value string, limit int) ([]KeyValue, error) {
+	rows, err := tx.tree.SearchValue(value, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]string, len(rows))
+	for _, row := range rows {
+		merged[string(row.Key)] = row.Value
+	}
+	for pending, write := range tx.pending {
+		if pending.table != tx.tree.id {
+			continue
+		}
+		if write.deleted || write.value != value {
+			delete(merged, pending.key)
+		} else {
+			merged[pending.key] = value
+		}
+	}
+
+	return sortRows(merged, tx.tree.cmp, limit, false), nil
+}
+
+// sortRows returns the key-value pairs of rows ordered by cmp, cut to limit
+func sortRows(rows map[string]string, cmp storage.Comparator, limit int, reverse bool) []KeyValue {
+	results := make([]KeyValue, 0, len(rows))
+	for key, value := range rows {
+		results = append(results, KeyValue{Key: []byte(key), Value: value})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if reverse {
+			return cmp(results[i].Key, results[j].Key) > 0
+		}
+		return cmp(results[i].Key, results[j].Key) < 0
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Commit makes the transaction's writes durable and applies them to the tree
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	// Nothing was written, nothing to log
+	if !tx.started {
+		return nil
+	}
+
+	if err := tx.commit(); err != nil {
+		return err
+	}
+
+	tx.tree.maybeCheckpoint()
+	return nil
+}
+
+// commit logs COMMIT and applies the writes with no other write in flight,
+// so a checkpoint sees the transaction either open or applied
+func (tx *Tx) commit() error {
+	tx.tree.writeLatch.Lock()
+	defer tx.tree.writeLatch.Unlock()
+
+	tx.tree.txMu.Lock()
+	delete(tx.tree.activeTxs, tx.id)
+	tx.tree.txMu.Unlock()
+
+	// An index created since a write may not take its key, the transaction
+	// is then left without COMMIT, which replay drops
+	if err := tx.checkIndexedKeys(); err != nil {
+		return fmt.Errorf("failed to commit transaction %d: %w", tx.id, err)
+	}
+
+	// The COMMIT fsync also covers the writes logged before it
+	commit := &wal.Entry{OpType: wal.OpCommit, TxID: tx.id}
+	if err := tx.tree.wal.Append(commit); err != nil {
+		return fmt.Errorf("failed to write WAL commit: %w", err)
+	}
+
+	// All writes share the COMMIT LSN, so snapshots see all of them or none
+	// applyEntry routes each one to its table
+	for _, entry := range tx.logged {
+		if err := tx.tree.applyEntry(entry, commit.LSN); err != nil {
+			return fmt.Errorf("failed to apply transaction %d: %w", tx.id, err)
+		}
+	}
+
+	return nil
+}
+
+// checkIndexedKeys checks the keys written against the indexes of their tables
+// The caller holds writeLatch
+func (tx *Tx) checkIndexedKeys() error {
+	for _, entry := range tx.logged {
+		table := tx.tree.tableByID(entry.Table)
+		if table == nil || entry.OpType == wal.OpDelete {
+			continue
+		}
+		if err := table.checkIndexedKey(entry.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards the transaction's writes
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	if !tx.started {
+		return nil
+	}
+
+	tx.tree.txMu.Lock()
+	delete(tx.tree.activeTxs, tx.id)
+	tx.tree.txMu.Unlock()
+
+	// Replay already drops transactions without COMMIT, ABORT just says so sooner
+	if err := tx.tree.wal.AppendWithoutSync(&wal.Entry{OpType: wal.OpAbort, TxID: tx.id}); err != nil {
+		return fmt.Errorf("failed to write WAL abort: %w", err)
+	}
+
+	return nil
+}
+
+// write logs a change (after BEGIN for the first one) and buffers it
+func (tx *Tx) write(entry *wal.Entry) error {
+	if tx.done {
+		return ErrTxDone
+	}
+
+	if !tx.started {
+		if err := tx.begin(); err != nil {
+			return err
+		}
+	}
+
+	entry.TxID = tx.id
+	entry.Table = tx.tree.id
+	if err := tx.tree.wal.AppendWithoutSync(entry); err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+
+	tx.logged = append(tx.logged, entry)
+	tx.pending[txKey{tx.tree.id, string(entry.Key)}] = txWrite{value: entry.Value, deleted: entry.OpType == wal.OpDelete}
+	return nil
+}
+
+// begin logs BEGIN and registers the transaction as open
+// Both happen under txMu, so a checkpoint that captured an LSN past
+// BEGIN also finds the transaction open
+func (tx *Tx) begin() error {
+	tx.tree.txMu.Lock()
+	defer tx.tree.txMu.Unlock()
+
+	begin := &wal.Entry{OpType: wal.OpBegin, TxID: tx.id}
+	if err := tx.tree.wal.AppendWithoutSync(begin); err != nil {
+		return fmt.Errorf("failed to write WAL begin: %w", err)
+	}
+
+	tx.tree.activeTxs[tx.id] = begin.LSN
+	tx.started = true
+	return nil
+}
+
+// applyEntry applies a logged write to its table without logging it again,
+// stamped with commit timestamp ts
+// Inserts are applied as upserts so replay stays idempotent
+func (tree *BPTree) applyEntry(entry *wal.Entry, ts uint64) error {
+	// Writes to a table dropped since are skipped
+	table := tree.tableByID(entry.Table)
+	if table == nil {
+		return nil
+	}
+
+	switch entry.OpType {
+	case wal.OpInsert, wal.OpUpdate:
+		_, err := table.applyVersion(entry.Key, entry.Value, false, ts)
+		return err
+	case wal.OpDelete:
+		// Deleting a key that is already gone is a no-op
+		_, err := table.applyVersion(entry.Key, "", true, ts)
+		return err
+	case wal.OpBatch:
+		ops, err := entry.BatchOps()
+		if err != nil {
+			return err
+		}
+		return table.applyBatch(ops, ts)
+	default:
+		return fmt.Errorf("unsupported WAL operation: %d", entry.OpType)
+	}
+}
+
+// oldestActiveTxLSN returns the BEGIN LSN of the oldest open transaction (0 if none)
+// Checkpoints keep the WAL from there on, the transaction is not in the pages yet
+func (tree *BPTree) oldestActiveTxLSN() uint64 {
+	tree.txMu.Lock()
+	defer tree.txMu.Unlock()
+
+	oldest := uint64(0)
+	for _, lsn := range tree.activeTxs {
+		if oldest == 0 || lsn < oldest {
+			oldest = lsn
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,203 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeUpdateAndUpsert(t *testing.T) {
+	dbFile := "test_update.db"
+	walFile := "test_update.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	if err := tree.Insert(k(1), "Naruto"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+
+	// Duplicate insert is rejected
+	if err := tree.Insert(k(1), "Boruto"); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Insert duplicate: err=%v, expected ErrKeyExists", err)
+	}
+
+	// Update of a missing key is rejected
+	if err := tree.Update(k(2), "Sasuke"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Update missing: err=%v, expected ErrKeyNotFound", err)
+	}
+
+	if err := tree.Update(k(1), "Hokage"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// Upsert inserts then replaces
+	if err := tree.Upsert(k(2), "Sasuke"); err != nil {
+		t.Fatalf("Upsert (insert) failed: %v", err)
+	}
+	if err := tree.Upsert(k(2), "Rogue ninja"); err != nil {
+		t.Fatalf("Upsert (replace) failed: %v", err)
+	}
+
+	expected := map[uint32]string{1: "Hokage", 2: "Rogue ninja"}
+	for key, want := range expected {
+		value, found, err := tree.Search(k(key))
+		if err != nil || !found {
+			t.Fatalf("Search(%d): found=%v err=%v", key, found, err)
+		}
+		if value != want {
+			t.Errorf("Key=%d: value=%s, expected %s", key, value, want)
+		}
+	}
+
+	keys, _ := tree.InOrderTraversal()
+	if len(keys) != 2 {
+		t.Errorf("Traversal returned %d keys, expected 2 (no duplicates)", len(keys))
+	}
+}
+
+// TestBPTreeUpdateRelocatesRecord grows values until they no longer fit in their leaf
+func TestBPTreeUpdateRelocatesRecord(t *testing.T) {
+	dbFile := "test_update_grow.db"
+	walFile := "test_update_grow.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	numRecords := 100
+	for i := 1; i <= numRecords; i++ {
+		if err := tree.Insert(k(uint32(i)), "small"); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+	rootBefore := tree.GetRootPageID()
+
+	// 100 x 500 bytes cannot fit in one leaf, forcing relocation and splits
+	for i := 1; i <= numRecords; i++ {
+		value := fmt.Sprintf("%d-%s", i, strings.Repeat("v", 500))
+		if err := tree.Update(k(uint32(i)), value); err != nil {
+			t.Fatalf("Failed to update key=%d: %v", i, err)
+		}
+	}
+
+	if tree.GetRootPageID() == rootBefore {
+		t.Error("Growing values should have split the root leaf")
+	}
+
+	for i := 1; i <= numRecords; i++ {
+		value, found, err := tree.Search(k(uint32(i)))
+		if err != nil || !found {
+			t.Fatalf("Search(%d): found=%v err=%v", i, found, err)
+		}
+		if !strings.HasPrefix(value, fmt.Sprintf("%d-", i)) || len(value) < 500 {
+			t.Errorf("Key=%d: unexpected value %.20s...", i, value)
+		}
+	}
+
+	keys, _ := tree.InOrderTraversal()
+	if len(keys) != numRecords {
+		t.Errorf("Traversal returned %d keys, expected %d", len(keys), numRecords)
+	}
+}
+
+func TestBPTreeUpdateWALRecovery(t *testing.T) {
+	dbFile := "test_update_recovery.db"
+	walFile := "test_update_recovery.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	// Phase 1: insert and update, then crash
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		for i := 1; i <= 50; i++ {
+			if err := tree.Insert(k(uint32(i)), "old"); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+		for i := 1; i <= 50; i += 2 {
+			if err := tree.Update(k(uint32(i)), "new"); err != nil {
+				t.Fatalf("Failed to update: %v", err)
+			}
+		}
+
+		// Don't close properly - simulate crash
+		pager.Close()
+	}
+
+	// Phase 2: replay onto pages that already contain the changes
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+
+		tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		defer tree.Close()
+
+		for i := 1; i <= 50; i++ {
+			value, found, err := tree.Search(k(uint32(i)))
+			if err != nil || !found {
+				t.Fatalf("Search(%d): found=%v err=%v", i, found, err)
+			}
+			want := "old"
+			if i%2 == 1 {
+				want = "new"
+			}
+			if value != want {
+				t.Errorf("Key=%d: value=%s, expected %s", i, value, want)
+			}
+		}
+
+		keys, _ := tree.InOrderTraversal()
+		if len(keys) != 50 {
+			t.Errorf("Traversal returned %d keys after replay, expected 50", len(keys))
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package lock
+
+// waitsFor returns the transactions tx waits on: the holders of its key with
+// conflicting locks and the conflicting requests queued ahead of it
+func (m *Manager) waitsFor(tx uint64) []uint64 {
+	req, ok := m.waiting[tx]
+	if !ok {
+		return nil
+	}
+
+	kl := m.keys[req.key]
+	blockers := make([]uint64, 0, len(kl.holders))
+	for holder, held := range kl.holders {
+		if holder != tx && !compatible(held, req.mode) {
+			blockers = append(blockers, holder)
+		}
+	}
+
+	for _, queued := range kl.queue {
+		if queued == req {
+			break
+		}
+		if queued.tx != tx && !compatible(queued.mode, req.mode) {
+			blockers = append(blockers, queued.tx)
+		}
+	}
+	return blockers
+}
+
+// findDeadlock looks for a cycle through tx in the wait-for graph
+// Returns the youngest transaction of the cycle (the victim) if there is one
+func (m *Manager) findDeadlock(tx uint64) (uint64, bool) {
+	visited := make(map[uint64]bool)
+	path := []uint64{tx}
+
+	var walk func(current uint64) bool
+	walk = func(current uint64) bool {
+		for _, next := range m.waitsFor(current) {
+			if next == tx {
+				return true
+			}
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+
+			path = append(path, next)
+			if walk(next) {
+				return true
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+
+	if !walk(tx) {
+		return 0, false
+	}
+
+	victim := path[0]
+	for _, member := range path[1:] {
+		victim = max(victim, member)
+	}
+	return victim, true
+}
@@ -0,0 +1,287 @@
+// Package lock implements key-level locks for two-phase locking
+//
+// A transaction takes a shared lock on every key it reads and an exclusive
+// lock on every key it writes, and releases them all at once when it commits
+// or rolls back (strict two-phase locking), which makes transactions that only
+// touch keys by name serializable. A transaction that holds a shared lock may
+// upgrade it to exclusive
+//
+// Waiters queue per key in arrival order, except upgrades, which go first.
+// Every time a request has to wait the wait-for graph is checked: if it closes
+// a cycle, the youngest transaction of the cycle (highest ID) is the victim
+// and its pending request fails with ErrDeadlock. Waits also give up after a
+// timeout with ErrTimeout
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Mode is the strength of a lock
+type Mode int
+
+const (
+	// Shared locks are taken by readers and are compatible with each other
+	Shared Mode = iota + 1
+	// Exclusive locks are taken by writers and are compatible with nothing
+	Exclusive
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Shared:
+		return "S"
+	case Exclusive:
+		return "X"
+	default:
+		return fmt.Sprintf("Mode(%d)", int(m))
+	}
+}
+
+// compatible reports whether locks of mode a and b can be held together
+func compatible(a, b Mode) bool {
+	return a == Shared && b == Shared
+}
+
+var (
+	// ErrDeadlock is returned to the transaction chosen to break a deadlock
+	// It must roll back so the others can go on
+	ErrDeadlock = errors.New("deadlock detected, transaction chosen as victim")
+	// ErrTimeout is returned when a lock is not granted within the timeout
+	ErrTimeout = errors.New("lock wait timeout")
+)
+
+// DefaultTimeout is how long Lock waits before giving up
+const DefaultTimeout = 5 * time.Second
+
+// Stats counts lock waits and their outcomes
+type Stats struct {
+	Waits     int // requests that could not be granted at once
+	Deadlocks int // victims aborted
+	Timeouts  int // waits that timed out
+}
+
+// Manager grants key-level locks to transactions
+// It is safe for concurrent use
+type Manager struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	nextID  uint64
+
+	keys    map[string]*keyLock
+	held    map[uint64]map[string]Mode // transaction -> keys it holds
+	waiting map[uint64]*request        // transaction -> request it waits on
+
+	stats Stats
+}
+
+// keyLock is the lock table entry of one key
+type keyLock struct {
+	holders map[uint64]Mode
+	queue   []*request // waiting requests, granted front to back
+}
+
+// request is a lock request waiting to be granted
+type request struct {
+	tx   uint64
+	key  string
+	mode Mode
+	done chan error // nil once granted, ErrDeadlock if chosen as victim
+}
+
+// NewManager creates a lock manager whose waits time out after timeout
+// (0 waits forever)
+func NewManager(timeout time.Duration) *Manager {
+	return &Manager{
+		timeout: timeout,
+		keys:    make(map[string]*keyLock),
+		held:    make(map[uint64]map[string]Mode),
+		waiting: make(map[uint64]*request),
+	}
+}
+
+// SetTimeout changes how long Lock waits (0 waits forever)
+func (m *Manager) SetTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timeout = timeout
+}
+
+// Begin returns a new transaction ID to lock with
+// IDs grow, so a higher ID is a younger transaction
+func (m *Manager) Begin() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	return m.nextID
+}
+
+// Lock acquires a lock on key for transaction tx, waiting while other
+// transactions hold conflicting locks
+// Returns at once if tx already holds the key in mode or a stronger one;
+// a shared lock is upgraded when exclusive is requested
+func (m *Manager) Lock(tx uint64, key []byte, mode Mode) error {
+	name := string(key)
+
+	m.mu.Lock()
+
+	kl, ok := m.keys[name]
+	if !ok {
+		kl = &keyLock{holders: make(map[uint64]Mode)}
+		m.keys[name] = kl
+	}
+
+	current := kl.holders[tx]
+	if current >= mode {
+		m.mu.Unlock()
+		return nil
+	}
+
+	// Upgrades only wait for the other holders, new requests also queue
+	// behind earlier ones so writers are not starved by a stream of readers
+	upgrade := current != 0
+	if kl.grantable(tx, mode) && (upgrade || len(kl.queue) == 0) {
+		m.grant(kl, tx, name, mode)
+		m.mu.Unlock()
+		return nil
+	}
+
+	req := &request{tx: tx, key: name, mode: mode, done: make(chan error, 1)}
+	if upgrade {
+		kl.queue = append([]*request{req}, kl.queue...)
+	} else {
+		kl.queue = append(kl.queue, req)
+	}
+	m.waiting[tx] = req
+	m.stats.Waits++
+
+	if victim, found := m.findDeadlock(tx); found {
+		m.stats.Deadlocks++
+		m.abort(m.waiting[victim], ErrDeadlock)
+	}
+
+	timeout := m.timeout
+	m.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-expired:
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Granted or aborted while the timer fired
+	select {
+	case err := <-req.done:
+		return err
+	default:
+	}
+
+	m.stats.Timeouts++
+	m.abort(req, nil)
+	return fmt.Errorf("%w: %s lock on key %q after %v", ErrTimeout, mode, key, timeout)
+}
+
+// Release drops every lock of transaction tx, ending its two-phase locking
+func (m *Manager) Release(tx uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.held[tx] {
+		kl := m.keys[key]
+		delete(kl.holders, tx)
+		m.grantWaiters(kl)
+
+		if len(kl.holders) == 0 && len(kl.queue) == 0 {
+			delete(m.keys, key)
+		}
+	}
+	delete(m.held, tx)
+}
+
+// Held returns the mode in which tx holds key (0 if it does not)
+func (m *Manager) Held(tx uint64, key []byte) Mode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.held[tx][string(key)]
+}
+
+// Stats returns lock wait counters
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// grantable reports whether tx can hold key in mode next to the current holders
+func (kl *keyLock) grantable(tx uint64, mode Mode) bool {
+	for holder, held := range kl.holders {
+		if holder != tx && !compatible(held, mode) {
+			return false
+		}
+	}
+	return true
+}
+
+// grant records tx as holder of key in mode
+func (m *Manager) grant(kl *keyLock, tx uint64, key string, mode Mode) {
+	kl.holders[tx] = mode
+
+	keys, ok := m.held[tx]
+	if !ok {
+		keys = make(map[string]Mode)
+		m.held[tx] = keys
+	}
+	keys[key] = mode
+}
+
+// grantWaiters grants queued requests front to back until one has to wait
+func (m *Manager) grantWaiters(kl *keyLock) {
+	for len(kl.queue) > 0 {
+		req := kl.queue[0]
+		if !kl.grantable(req.tx, req.mode) {
+			return
+		}
+
+		kl.queue = kl.queue[1:]
+		delete(m.waiting, req.tx)
+		m.grant(kl, req.tx, req.key, req.mode)
+		req.done <- nil
+	}
+}
+
+// abort takes a waiting request out of its queue and fails it with err
+// (nil when its waiter already gave up)
+func (m *Manager) abort(req *request, err error) {
+	kl := m.keys[req.key]
+	for i, queued := range kl.queue {
+		if queued == req {
+			kl.queue = append(kl.queue[:i], kl.queue[i+1:]...)
+			break
+		}
+	}
+	delete(m.waiting, req.tx)
+
+	if err != nil {
+		req.done <- err
+	}
+
+	// Requests queued behind it may be grantable now
+	m.grantWaiters(kl)
+	if len(kl.holders) == 0 && len(kl.queue) == 0 {
+		delete(m.keys, req.key)
+	}
+}
@@ -0,0 +1,234 @@
+package lock
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// lockAsync requests a lock in the background
+func lockAsync(m *Manager, tx uint64, key []byte, mode Mode) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- m.Lock(tx, key, mode)
+	}()
+	return result
+}
+
+// waitForWaiters blocks until n requests are queued
+func waitForWaiters(t *testing.T, m *Manager, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		waiting := len(m.waiting)
+		m.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d waiting requests", n)
+}
+
+// expectBlocked fails if a lock request already finished
+func expectBlocked(t *testing.T, result <-chan error) {
+	t.Helper()
+	select {
+	case err := <-result:
+		t.Fatalf("Request should be waiting, returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// expectGranted waits for a lock request to succeed
+func expectGranted(t *testing.T, result <-chan error) {
+	t.Helper()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Request was not granted")
+	}
+}
+
+func TestSharedAndExclusiveLocks(t *testing.T) {
+	m := NewManager(0)
+	reader1, reader2, writer := m.Begin(), m.Begin(), m.Begin()
+
+	// Readers share a key
+	if err := m.Lock(reader1, []byte("k1"), Shared); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if err := m.Lock(reader2, []byte("k1"), Shared); err != nil {
+		t.Fatalf("Shared locks should be compatible: %v", err)
+	}
+
+	// A writer waits for both
+	write := lockAsync(m, writer, []byte("k1"), Exclusive)
+	expectBlocked(t, write)
+
+	// A later reader queues behind the writer instead of starving it
+	reader3 := m.Begin()
+	read := lockAsync(m, reader3, []byte("k1"), Shared)
+	expectBlocked(t, read)
+
+	m.Release(reader1)
+	expectBlocked(t, write)
+	m.Release(reader2)
+	expectGranted(t, write)
+	expectBlocked(t, read)
+
+	if mode := m.Held(writer, []byte("k1")); mode != Exclusive {
+		t.Errorf("Writer holds %v, expected X", mode)
+	}
+
+	m.Release(writer)
+	expectGranted(t, read)
+
+	// Locks already held return at once
+	if err := m.Lock(reader3, []byte("k1"), Shared); err != nil {
+		t.Errorf("Relock failed: %v", err)
+	}
+
+	if stats := m.Stats(); stats.Waits != 2 {
+		t.Errorf("Waits = %d, expected 2", stats.Waits)
+	}
+
+	t.Logf("✓ Shared locks shared, exclusive locks granted in arrival order")
+}
+
+func TestLockUpgrade(t *testing.T) {
+	m := NewManager(0)
+	tx1, tx2 := m.Begin(), m.Begin()
+
+	// Sole holder upgrades at once
+	m.Lock(tx1, []byte("k1"), Shared)
+	if err := m.Lock(tx1, []byte("k1"), Exclusive); err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+	if mode := m.Held(tx1, []byte("k1")); mode != Exclusive {
+		t.Fatalf("Held %v after upgrade", mode)
+	}
+
+	// Exclusive covers shared
+	if err := m.Lock(tx1, []byte("k1"), Shared); err != nil || m.Held(tx1, []byte("k1")) != Exclusive {
+		t.Fatal("Shared request downgraded an exclusive lock")
+	}
+	m.Release(tx1)
+
+	// An upgrade waits for the other readers but goes ahead of queued writers
+	tx3 := m.Begin()
+	m.Lock(tx1, []byte("k2"), Shared)
+	m.Lock(tx2, []byte("k2"), Shared)
+
+	write := lockAsync(m, tx3, []byte("k2"), Exclusive)
+	waitForWaiters(t, m, 1)
+	upgrade := lockAsync(m, tx1, []byte("k2"), Exclusive)
+	waitForWaiters(t, m, 2)
+
+	m.Release(tx2)
+	expectGranted(t, upgrade)
+	expectBlocked(t, write)
+
+	m.Release(tx1)
+	expectGranted(t, write)
+
+	t.Logf("✓ Shared locks upgrade to exclusive")
+}
+
+func TestDeadlockDetection(t *testing.T) {
+	m := NewManager(0)
+	older, younger := m.Begin(), m.Begin()
+
+	m.Lock(older, []byte("k1"), Exclusive)
+	m.Lock(younger, []byte("k2"), Exclusive)
+
+	// older waits for younger...
+	first := lockAsync(m, older, []byte("k2"), Exclusive)
+	waitForWaiters(t, m, 1)
+
+	// ...and younger closing the cycle is the victim
+	err := m.Lock(younger, []byte("k1"), Exclusive)
+	if !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("Expected ErrDeadlock, got %v", err)
+	}
+	expectBlocked(t, first)
+
+	// Rolling the victim back lets the other transaction go on
+	m.Release(younger)
+	expectGranted(t, first)
+
+	// The victim is the youngest even when an older transaction closes the cycle
+	m.Release(older)
+	a, b := m.Begin(), m.Begin()
+	m.Lock(a, []byte("k1"), Exclusive)
+	m.Lock(b, []byte("k2"), Exclusive)
+
+	victim := lockAsync(m, b, []byte("k1"), Exclusive)
+	waitForWaiters(t, m, 1)
+	closing := lockAsync(m, a, []byte("k2"), Exclusive)
+
+	select {
+	case err := <-victim:
+		if !errors.Is(err, ErrDeadlock) {
+			t.Fatalf("Expected ErrDeadlock for the younger transaction, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No victim chosen")
+	}
+	m.Release(b)
+	expectGranted(t, closing)
+
+	// Two readers upgrading the same key deadlock too
+	m.Release(a)
+	r1, r2 := m.Begin(), m.Begin()
+	m.Lock(r1, []byte("k3"), Shared)
+	m.Lock(r2, []byte("k3"), Shared)
+	upgrade := lockAsync(m, r1, []byte("k3"), Exclusive)
+	waitForWaiters(t, m, 1)
+	if err := m.Lock(r2, []byte("k3"), Exclusive); !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("Expected upgrade deadlock, got %v", err)
+	}
+	m.Release(r2)
+	expectGranted(t, upgrade)
+
+	if stats := m.Stats(); stats.Deadlocks != 3 {
+		t.Errorf("Deadlocks = %d, expected 3", stats.Deadlocks)
+	}
+
+	t.Logf("✓ Deadlocks broken by aborting the youngest transaction")
+}
+
+func TestLockTimeout(t *testing.T) {
+	m := NewManager(30 * time.Millisecond)
+	holder, waiter, next := m.Begin(), m.Begin(), m.Begin()
+
+	m.Lock(holder, []byte("k1"), Exclusive)
+
+	start := time.Now()
+	err := m.Lock(waiter, []byte("k1"), Shared)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Timed out after %v", elapsed)
+	}
+	if mode := m.Held(waiter, []byte("k1")); mode != 0 {
+		t.Errorf("Timed out request holds %v", mode)
+	}
+
+	// The abandoned request no longer blocks anyone
+	m.SetTimeout(0)
+	result := lockAsync(m, next, []byte("k1"), Exclusive)
+	m.Release(holder)
+	expectGranted(t, result)
+
+	if stats := m.Stats(); stats.Timeouts != 1 {
+		t.Errorf("Timeouts = %d, expected 1", stats.Timeouts)
+	}
+
+	t.Logf("✓ Lock waits time out")
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
 	"github.com/spaghetti-lover/sharingan-db/internal/sql"
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 )
 
 // Query represents a SQL-like query
@@ -14,11 +15,18 @@ type Query struct {
 	Type  string // "SELECT", "INSERT", etc.
 	Key   uint32
 	Value string
+
+	// Range bounds for "SCAN" (inclusive), Limit 0 = no limit
+	Start      uint32
+	End        uint32
+	Limit      int
+	Descending bool
 }
 
 // ParseQuery parses BOTH simple syntax and SQL syntax
-// Simple: INSERT 100 Naruto, SELECT 100
-// SQL: INSERT INTO kv VALUES (100, 'Naruto'); SELECT * FROM kv WHERE key = 100;
+// Simple: INSERT 100 Naruto, UPDATE 100 Hokage, SELECT 100, DELETE 100, SCAN 100 200 [limit]
+// SQL: INSERT INTO kv VALUES (100, 'Naruto'); SELECT * FROM kv WHERE key = 100; DELETE FROM kv WHERE key = 100;
+// SQL range: SELECT * FROM kv WHERE key BETWEEN 100 AND 200;
 func ParseQuery(input string) (*Query, error) {
 	input = strings.TrimSpace(input)
 
@@ -53,18 +61,44 @@ func parseSQL(input string) (*Query, error) {
 	// Convert to Query
 	switch s := stmt.(type) {
 	case *sql.SelectStatement:
+		if s.IsRange {
+			return &Query{
+				Type:       "SCAN",
+				Start:      s.Start,
+				End:        s.End,
+				Limit:      s.Limit,
+				Descending: s.Descending,
+			}, nil
+		}
 		return &Query{
 			Type: "SELECT",
 			Key:  s.Key,
 		}, nil
 
 	case *sql.InsertStatement:
+		queryType := "INSERT"
+		if s.Upsert {
+			queryType = "UPSERT"
+		}
 		return &Query{
-			Type:  "INSERT",
+			Type:  queryType,
+			Key:   s.Key,
+			Value: s.Value,
+		}, nil
+
+	case *sql.UpdateStatement:
+		return &Query{
+			Type:  "UPDATE",
 			Key:   s.Key,
 			Value: s.Value,
 		}, nil
 
+	case *sql.DeleteStatement:
+		return &Query{
+			Type: "DELETE",
+			Key:  s.Key,
+		}, nil
+
 	default:
 		return nil, fmt.Errorf("unsupported statement type: %T", stmt)
 	}
@@ -112,6 +146,67 @@ func parseSimple(input string) (*Query, error) {
 			Value: parts[2],
 		}, nil
 
+	case "UPDATE":
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("UPDATE syntax: UPDATE <key> <value>")
+		}
+
+		key, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key: %v", err)
+		}
+
+		return &Query{
+			Type:  "UPDATE",
+			Key:   uint32(key),
+			Value: parts[2],
+		}, nil
+
+	case "DELETE":
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("DELETE syntax: DELETE <key>")
+		}
+
+		key, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key: %v", err)
+		}
+
+		return &Query{
+			Type: "DELETE",
+			Key:  uint32(key),
+		}, nil
+
+	case "SCAN":
+		if len(parts) != 3 && len(parts) != 4 {
+			return nil, fmt.Errorf("SCAN syntax: SCAN <start> <end> [limit]")
+		}
+
+		start, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start key: %v", err)
+		}
+
+		end, err := strconv.ParseUint(parts[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end key: %v", err)
+		}
+
+		limit := 0
+		if len(parts) == 4 {
+			limit, err = strconv.Atoi(parts[3])
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("invalid limit: %s", parts[3])
+			}
+		}
+
+		return &Query{
+			Type:  "SCAN",
+			Start: uint32(start),
+			End:   uint32(end),
+			Limit: limit,
+		}, nil
+
 	default:
 		return nil, fmt.Errorf("unsupported command: %s", cmd)
 	}
@@ -121,7 +216,7 @@ func parseSimple(input string) (*Query, error) {
 func Execute(tree *bptree.BPTree, query *Query) (string, error) {
 	switch query.Type {
 	case "SELECT":
-		value, found, err := tree.Search(query.Key)
+		value, found, err := tree.Search(storage.Uint32Key(query.Key))
 		if err != nil {
 			return "", fmt.Errorf("search failed: %w", err)
 		}
@@ -131,11 +226,44 @@ func Execute(tree *bptree.BPTree, query *Query) (string, error) {
 		return value, nil
 
 	case "INSERT":
-		if err := tree.Insert(query.Key, query.Value); err != nil {
+		if err := tree.Insert(storage.Uint32Key(query.Key), query.Value); err != nil {
+			return "", fmt.Errorf("insert failed: %w", err)
+		}
+		return "OK", nil
+
+	case "UPDATE":
+		if err := tree.Update(storage.Uint32Key(query.Key), query.Value); err != nil {
+			return "", fmt.Errorf("update failed: %w", err)
+		}
+		return "OK", nil
+
+	case "UPSERT":
+		if err := tree.Upsert(storage.Uint32Key(query.Key), query.Value); err != nil {
 			return "", fmt.Errorf("insert failed: %w", err)
 		}
 		return "OK", nil
 
+	case "DELETE":
+		found, err := tree.Delete(storage.Uint32Key(query.Key))
+		if err != nil {
+			return "", fmt.Errorf("delete failed: %w", err)
+		}
+		if !found {
+			return "", fmt.Errorf("key %d not found", query.Key)
+		}
+		return "OK", nil
+
+	case "SCAN":
+		scan := tree.Scan
+		if query.Descending {
+			scan = tree.ScanReverse
+		}
+		results, err := scan(storage.Uint32Key(query.Start), storage.Uint32Key(query.End), query.Limit)
+		if err != nil {
+			return "", fmt.Errorf("scan failed: %w", err)
+		}
+		return sql.FormatRows(results), nil
+
 	default:
 		return "", fmt.Errorf("unsupported query type: %s", query.Type)
 	}
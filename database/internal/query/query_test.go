@@ -24,6 +24,18 @@ func TestParseQuery(t *testing.T) {
 		{"INVALID", 0, "", true},
 		{"SELECT", 0, "", true},
 		{"INSERT 100", 0, "", true},
+		{"DELETE 100", 100, "", false},
+		{"DELETE FROM kv WHERE key = 42;", 42, "", false},
+		{"DELETE", 0, "", true},
+		{"UPDATE 100 hokage", 100, "hokage", false},
+		{"UPDATE kv SET value = 'hokage' WHERE key = 7;", 7, "hokage", false},
+		{"INSERT INTO kv VALUES (8, 'kage') ON CONFLICT DO UPDATE;", 8, "kage", false},
+		{"UPDATE 100", 0, "", true},
+		{"SCAN 10 20", 0, "", false},
+		{"SCAN 10 20 5", 0, "", false},
+		{"SELECT * FROM kv WHERE key BETWEEN 10 AND 20;", 0, "", false},
+		{"SCAN 10", 0, "", true},
+		{"SCAN 10 20 x", 0, "", true},
 	}
 
 	for _, tt := range tests {
@@ -55,6 +67,7 @@ func TestExecuteQueries(t *testing.T) {
 	dbFile := "test_execute.db"
 	walFile := "test_execute.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -130,6 +143,56 @@ func TestExecuteQueries(t *testing.T) {
 	if err == nil {
 		t.Error("SELECT 999: expected error, got none")
 	}
+
+	// Test UPDATE
+	query, _ = ParseQuery("UPDATE 200 rogue")
+	if result, err := Execute(tree, query); err != nil || result != "OK" {
+		t.Errorf("UPDATE 200: result=%s, err=%v", result, err)
+	}
+
+	query, _ = ParseQuery("SELECT 200")
+	if result, _ := Execute(tree, query); result != "rogue" {
+		t.Errorf("SELECT 200 after UPDATE: result=%s, expected rogue", result)
+	}
+
+	query, _ = ParseQuery("UPDATE 999 ghost")
+	if _, err := Execute(tree, query); err == nil {
+		t.Error("UPDATE 999: expected error, got none")
+	}
+
+	// Test SCAN
+	scanTests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SCAN 100 200 2", "100 | naruto\n150 | kakashi\n(2 rows)"},
+		{"SELECT * FROM kv WHERE key >= 150;", "150 | kakashi\n200 | rogue\n(2 rows)"},
+		{"SCAN 300 400", "(0 rows)"},
+		{"SELECT * FROM kv WHERE key <= 150 ORDER BY key DESC LIMIT 2;", "150 | kakashi\n100 | naruto\n(2 rows)"},
+	}
+
+	for _, tt := range scanTests {
+		query, err := ParseQuery(tt.sql)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q) failed: %v", tt.sql, err)
+		}
+		result, err := Execute(tree, query)
+		if err != nil || result != tt.expected {
+			t.Errorf("%s: result=%q, err=%v, expected %q", tt.sql, result, err, tt.expected)
+		}
+	}
+
+	// Test DELETE
+	query, _ = ParseQuery("DELETE 100")
+	result, err := Execute(tree, query)
+	if err != nil || result != "OK" {
+		t.Errorf("DELETE 100: result=%s, err=%v", result, err)
+	}
+
+	query, _ = ParseQuery("SELECT 100")
+	if _, err := Execute(tree, query); err == nil {
+		t.Error("SELECT 100 after DELETE: expected error, got none")
+	}
 }
 
 // TestSelect1000Operations tests 1000 SELECT operations with performance metrics
@@ -137,6 +200,7 @@ func TestSelect1000Operations(t *testing.T) {
 	dbFile := "test_select_1000.db"
 	walFile := "test_select_1000.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -160,7 +224,7 @@ func TestSelect1000Operations(t *testing.T) {
 		key := uint32(i)
 		value := fmt.Sprintf("value-%d", i)
 
-		if err := tree.Insert(key, value); err != nil {
+		if err := tree.Insert(storage.Uint32Key(key), value); err != nil {
 			t.Fatalf("Failed to insert key=%d: %v", key, err)
 		}
 	}
@@ -227,6 +291,7 @@ func BenchmarkSelectOperations(b *testing.B) {
 	dbFile := "bench_select.db"
 	walFile := "bench_select.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, _ := storage.NewFilePager(dbFile)
@@ -237,7 +302,7 @@ func BenchmarkSelectOperations(b *testing.B) {
 
 	// Prepare data
 	for i := 1; i <= 1000; i++ {
-		tree.Insert(uint32(i), fmt.Sprintf("value-%d", i))
+		tree.Insert(storage.Uint32Key(uint32(i)), fmt.Sprintf("value-%d", i))
 	}
 
 	b.ResetTimer()
@@ -256,6 +321,7 @@ func BenchmarkSelectWithSplits(b *testing.B) {
 	dbFile := "bench_select_splits.db"
 	walFile := "bench_select_splits.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, _ := storage.NewFilePager(dbFile)
@@ -267,7 +333,7 @@ func BenchmarkSelectWithSplits(b *testing.B) {
 	// Insert enough data to trigger multiple splits
 	numRecords := 5000
 	for i := 1; i <= numRecords; i++ {
-		tree.Insert(uint32(i), fmt.Sprintf("value-%d", i))
+		tree.Insert(storage.Uint32Key(uint32(i)), fmt.Sprintf("value-%d", i))
 	}
 
 	b.Logf("Tree prepared with %d records, root page: %d", numRecords, tree.GetRootPageID())
@@ -288,6 +354,7 @@ func BenchmarkInsertOperations(b *testing.B) {
 	dbFile := "bench_insert.db"
 	walFile := "bench_insert.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, _ := storage.NewFilePager(dbFile)
@@ -316,6 +383,7 @@ func TestQueryWithWALRecovery(t *testing.T) {
 	dbFile := "test_query_recovery.db"
 	walFile := "test_query_recovery.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	var rootPageID uint64
@@ -1,10 +1,15 @@
 package sql
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+	"github.com/spaghetti-lover/sharingan-db/internal/lock"
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 )
 
@@ -12,6 +17,7 @@ func TestSQLIntegration(t *testing.T) {
 	dbFile := "test_sql_integration.db"
 	walFile := "test_sql_integration.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	// Create database
@@ -79,12 +85,471 @@ func TestSQLIntegration(t *testing.T) {
 	} else {
 		t.Logf("✓ Correctly returned error: %v", err)
 	}
+
+	// Test DELETE statements
+	t.Log("\nTesting DELETE statements...")
+	result, err := ParseAndExecute("DELETE FROM kv WHERE key = 100;", tree)
+	if err != nil {
+		t.Errorf("DELETE failed: %v", err)
+	}
+	if result != "OK" {
+		t.Errorf("Expected 'OK', got '%s'", result)
+	}
+
+	if _, err := ParseAndExecute("SELECT * FROM kv WHERE key = 100;", tree); err == nil {
+		t.Error("Expected error selecting deleted key, got none")
+	}
+
+	if _, err := ParseAndExecute("DELETE FROM kv WHERE key = 100;", tree); err == nil {
+		t.Error("Expected error deleting missing key, got none")
+	} else {
+		t.Logf("✓ Correctly returned error: %v", err)
+	}
+}
+
+func TestSQLRangeSelect(t *testing.T) {
+	dbFile := "test_sql_range.db"
+	walFile := "test_sql_range.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := 10; i <= 50; i += 10 {
+		if err := tree.Insert(storage.Uint32Key(uint32(i)), fmt.Sprintf("ninja-%d", i)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT * FROM kv WHERE key BETWEEN 20 AND 40;", "20 | ninja-20\n30 | ninja-30\n40 | ninja-40\n(3 rows)"},
+		{"SELECT * FROM kv WHERE key >= 30 AND key < 50;", "30 | ninja-30\n40 | ninja-40\n(2 rows)"},
+		{"SELECT * FROM kv WHERE key > 10 LIMIT 2;", "20 | ninja-20\n30 | ninja-30\n(2 rows)"},
+		{"SELECT * FROM kv WHERE key < 10;", "(0 rows)"},
+		{"SELECT * FROM kv WHERE key BETWEEN 40 AND 20;", "(0 rows)"},
+		{"SELECT * FROM kv WHERE key >= 20 ORDER BY key DESC LIMIT 2;", "50 | ninja-50\n40 | ninja-40\n(2 rows)"},
+		{"SELECT * FROM kv WHERE key < 40 ORDER BY key DESC;", "30 | ninja-30\n20 | ninja-20\n10 | ninja-10\n(3 rows)"},
+	}
+
+	for _, tt := range tests {
+		result, err := ParseAndExecute(tt.sql, tree)
+		if err != nil {
+			t.Errorf("SELECT failed: %v\n  SQL: %s", err, tt.sql)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("SELECT mismatch:\n  SQL: %s\n  Expected: %q\n  Got: %q", tt.sql, tt.expected, result)
+		}
+	}
+}
+
+func TestSQLUpdateAndUpsert(t *testing.T) {
+	dbFile := "test_sql_update.db"
+	walFile := "test_sql_update.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	steps := []struct {
+		sql       string
+		expected  string
+		expectErr bool
+	}{
+		{"INSERT INTO kv VALUES (1, 'Naruto');", "OK", false},
+		{"INSERT INTO kv VALUES (1, 'Boruto');", "", true}, // Duplicate key
+		{"UPDATE kv SET value = 'Hokage' WHERE key = 1;", "OK", false},
+		{"SELECT * FROM kv WHERE key = 1;", "1 | Hokage", false},
+		{"UPDATE kv SET value = 'x' WHERE key = 2;", "", true}, // Missing key
+		{"INSERT INTO kv VALUES (2, 'Sasuke') ON CONFLICT DO UPDATE;", "OK", false},
+		{"INSERT INTO kv VALUES (2, 'Rogue') ON CONFLICT (key) DO UPDATE;", "OK", false},
+		{"SELECT * FROM kv WHERE key = 2;", "2 | Rogue", false},
+	}
+
+	for _, step := range steps {
+		result, err := ParseAndExecute(step.sql, tree)
+		if step.expectErr {
+			if err == nil {
+				t.Errorf("Expected error for SQL: %s", step.sql)
+			} else {
+				t.Logf("✓ Correctly rejected: %s\n  Error: %v", step.sql, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s failed: %v", step.sql, err)
+			continue
+		}
+		if result != step.expected {
+			t.Errorf("%s: got '%s', expected '%s'", step.sql, result, step.expected)
+		}
+	}
+}
+
+func TestSQLTransactions(t *testing.T) {
+	dbFile := "test_sql_tx.db"
+	walFile := "test_sql_tx.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// One session, statements spread over several calls like REPL lines
+	executor := NewExecutor(tree)
+	steps := []struct {
+		sql       string
+		expected  string
+		expectErr bool
+	}{
+		{"COMMIT;", "", true}, // No transaction
+		{"BEGIN;", "BEGIN", false},
+		{"BEGIN;", "", true}, // Already in progress
+		{"INSERT INTO kv VALUES (1, 'Naruto');", "OK", false},
+		{"INSERT INTO kv VALUES (2, 'Sasuke');", "OK", false},
+		{"SELECT * FROM kv WHERE key BETWEEN 1 AND 5;", "1 | Naruto\n2 | Sasuke\n(2 rows)", false},
+		{"COMMIT;", "COMMIT", false},
+		{"BEGIN; UPDATE kv SET value = 'Hokage' WHERE key = 1; DELETE FROM kv WHERE key = 2;", "BEGIN\nOK\nOK", false},
+		{"SELECT * FROM kv WHERE key = 1;", "1 | Hokage", false},
+		{"ROLLBACK;", "ROLLBACK", false},
+		{"SELECT * FROM kv WHERE key BETWEEN 1 AND 5;", "1 | Naruto\n2 | Sasuke\n(2 rows)", false},
+	}
+
+	for _, step := range steps {
+		result, err := executor.ExecuteSQL(step.sql)
+		if step.expectErr {
+			if err == nil {
+				t.Errorf("Expected error for SQL: %s", step.sql)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s failed: %v", step.sql, err)
+			continue
+		}
+		if result != step.expected {
+			t.Errorf("%s: got '%s', expected '%s'", step.sql, result, step.expected)
+		}
+	}
+
+	// Without a session an unfinished transaction is rolled back
+	if _, err := ParseAndExecute("BEGIN; INSERT INTO kv VALUES (3, 'Sakura');", tree); err == nil {
+		t.Error("Expected error for uncommitted transaction")
+	}
+	if _, found, _ := tree.Search(storage.Uint32Key(3)); found {
+		t.Error("Uncommitted insert was applied")
+	}
+
+	result, err := ParseAndExecute("BEGIN; INSERT INTO kv VALUES (3, 'Sakura'); COMMIT;", tree)
+	if err != nil || result != "BEGIN\nOK\nCOMMIT" {
+		t.Errorf("One-shot transaction: result=%q err=%v", result, err)
+	}
+	if value, found, _ := tree.Search(storage.Uint32Key(3)); !found || value != "Sakura" {
+		t.Errorf("Committed insert missing, got %q", value)
+	}
+
+	t.Logf("✓ SQL transactions commit and roll back")
+}
+
+func TestSQLTables(t *testing.T) {
+	dbFile := "test_sql_tables.db"
+	walFile := "test_sql_tables.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	executor := NewExecutor(tree)
+	steps := []struct {
+		sql       string
+		expected  string
+		expectErr bool
+	}{
+		{"INSERT INTO users VALUES (1, 'Naruto');", "", true}, // No such table
+		{"CREATE TABLE users;", "OK", false},
+		{"CREATE TABLE users;", "", true}, // Already exists
+		{"CREATE TABLE kv;", "", true},    // The default table
+		{"CREATE TABLE clans;", "OK", false},
+		{"INSERT INTO kv VALUES (1, 'kv-1');", "OK", false},
+		{"INSERT INTO users VALUES (1, 'Naruto');", "OK", false},
+		{"INSERT INTO clans VALUES (1, 'Uzumaki');", "OK", false},
+		{"SELECT * FROM users WHERE key = 1;", "1 | Naruto", false},
+		{"SELECT * FROM clans WHERE key BETWEEN 1 AND 5;", "1 | Uzumaki\n(1 rows)", false},
+		{"SELECT * FROM kv WHERE key = 1;", "1 | kv-1", false},
+		{"BEGIN; UPDATE users SET value = 'Hokage' WHERE key = 1; DELETE FROM clans WHERE key = 1; COMMIT;", "BEGIN\nOK\nOK\nCOMMIT", false},
+		{"SELECT * FROM users WHERE key = 1;", "1 | Hokage", false},
+		{"SELECT * FROM clans WHERE key = 1;", "", true}, // Deleted
+		{"BEGIN; DROP TABLE clans;", "", true},           // Not in a transaction
+		{"ROLLBACK;", "ROLLBACK", false},
+		{"DROP TABLE clans;", "OK", false},
+		{"SELECT * FROM clans WHERE key = 1;", "", true}, // Dropped
+		{"DROP TABLE clans;", "", true},
+		{"DROP TABLE kv;", "", true},
+		{"BEGIN; VACUUM;", "", true}, // Not in a transaction
+		{"ROLLBACK;", "ROLLBACK", false},
+	}
+
+	for _, step := range steps {
+		result, err := executor.ExecuteSQL(step.sql)
+		if step.expectErr {
+			if err == nil {
+				t.Errorf("Expected error for SQL: %s", step.sql)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s failed: %v", step.sql, err)
+			continue
+		}
+		if result != step.expected {
+			t.Errorf("%s: got '%s', expected '%s'", step.sql, result, step.expected)
+		}
+	}
+
+	if names := tree.Tables(); len(names) != 1 || names[0] != "users" {
+		t.Errorf("Tables() = %v, expected [users]", names)
+	}
+
+	// The dropped table left a hole for VACUUM to fill
+	if result, err := executor.ExecuteSQL("VACUUM;"); err != nil || !strings.HasPrefix(result, "OK, ") {
+		t.Errorf("VACUUM returned '%s' (err=%v)", result, err)
+	}
+	if result, err := executor.ExecuteSQL("SELECT * FROM users WHERE key = 1;"); err != nil || result != "1 | Hokage" {
+		t.Errorf("users after VACUUM: got '%s' (err=%v)", result, err)
+	}
+
+	// The same key in two tables takes two different locks
+	locks := lock.NewManager(50 * time.Millisecond)
+	alice := NewExecutorWithLocks(tree, locks)
+	bob := NewExecutorWithLocks(tree, locks)
+	if _, err := alice.ExecuteSQL("BEGIN; UPDATE users SET value = 'a' WHERE key = 1;"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := bob.ExecuteSQL("UPDATE kv SET value = 'b' WHERE key = 1;"); err != nil {
+		t.Errorf("Key 1 of kv blocked by key 1 of users: %v", err)
+	}
+	if _, err := alice.ExecuteSQL("COMMIT;"); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	t.Logf("✓ Statements routed to their tables")
+}
+
+func TestSQLIndexes(t *testing.T) {
+	dbFile := "test_sql_indexes.db"
+	walFile := "test_sql_indexes.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	executor := NewExecutor(tree)
+	steps := []struct {
+		sql       string
+		expected  string
+		expectErr bool
+	}{
+		{"INSERT INTO kv VALUES (1, 'Uzumaki'); INSERT INTO kv VALUES (2, 'Uchiha'); INSERT INTO kv VALUES (3, 'Uzumaki');", "OK\nOK\nOK", false},
+		{"SELECT * FROM kv WHERE value = 'Uzumaki';", "1 | Uzumaki\n3 | Uzumaki\n(2 rows)", false}, // Scan without an index
+		{"CREATE INDEX clan ON kv(value);", "OK", false},
+		{"CREATE INDEX clan ON kv(value);", "", true},      // Already exists
+		{"CREATE INDEX other ON kv(key);", "", true},       // Only value is indexed
+		{"CREATE INDEX other ON ghosts(value);", "", true}, // No such table
+		{"SELECT * FROM kv WHERE value = 'Uzumaki';", "1 | Uzumaki\n3 | Uzumaki\n(2 rows)", false},
+		{"SELECT * FROM kv WHERE value = 'Uzumaki' LIMIT 1;", "1 | Uzumaki\n(1 rows)", false},
+		{"UPDATE kv SET value = 'Hatake' WHERE key = 1;", "OK", false},
+		{"INSERT INTO kv VALUES (4, 'Uzumaki');", "OK", false},
+		{"DELETE FROM kv WHERE key = 3;", "OK", false},
+		{"SELECT * FROM kv WHERE value = 'Uzumaki';", "4 | Uzumaki\n(1 rows)", false},
+		{"SELECT * FROM kv WHERE value = 'Senju';", "(0 rows)", false},
+		{"BEGIN; INSERT INTO kv VALUES (5, 'Senju'); SELECT * FROM kv WHERE value = 'Senju';", "BEGIN\nOK\n5 | Senju\n(1 rows)", false},
+		{"CREATE INDEX later ON kv(value);", "", true}, // Not in a transaction
+		{"COMMIT;", "COMMIT", false},
+		{"SELECT * FROM kv WHERE value = 'Senju';", "5 | Senju\n(1 rows)", false},
+		{"SELECT * FROM kv WHERE key = 'Senju';", "", true},  // Key is a number
+		{"SELECT * FROM kv WHERE clan = 'Senju';", "", true}, // No such column
+		{"CREATE TABLE users (id INT PRIMARY KEY, name TEXT);", "OK", false},
+		{"CREATE INDEX by_name ON users(name);", "", true},       // Typed tables have no value column
+		{"SELECT * FROM users WHERE name = 'Naruto';", "", true}, // Not the primary key
+		{"DROP INDEX clan;", "OK", false},
+		{"DROP INDEX clan;", "", true},
+		{"SELECT * FROM kv WHERE value = 'Uzumaki';", "4 | Uzumaki\n(1 rows)", false},
+	}
+
+	for _, step := range steps {
+		result, err := executor.ExecuteSQL(step.sql)
+		if step.expectErr {
+			if err == nil {
+				t.Errorf("Expected error for SQL: %s", step.sql)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s failed: %v", step.sql, err)
+			continue
+		}
+		if result != step.expected {
+			t.Errorf("%s: got '%s', expected '%s'", step.sql, result, step.expected)
+		}
+	}
+
+	t.Logf("✓ Lookups by value through the index")
+}
+
+func TestSQLTypedTables(t *testing.T) {
+	dbFile := "test_sql_typed.db"
+	walFile := "test_sql_typed.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	executor := NewExecutor(tree)
+	steps := []struct {
+		sql       string
+		expected  string
+		expectErr bool
+	}{
+		{"CREATE TABLE users (id INT PRIMARY KEY, name TEXT NOT NULL, age INT, active BOOL);", "OK", false},
+		{"CREATE TABLE bad (id INT, name TEXT);", "", true},                // No primary key
+		{"CREATE TABLE bad (id TEXT PRIMARY KEY);", "", true},              // Primary key not INT
+		{"CREATE TABLE bad (id INT PRIMARY KEY, id TEXT);", "", true},      // Duplicate column
+		{"CREATE TABLE bad (id INT PRIMARY KEY, at TIMESTAMP);", "", true}, // Unknown type
+		{"INSERT INTO users VALUES (1, 'Naruto', 17, TRUE);", "OK", false},
+		{"INSERT INTO users (id, name) VALUES (2, 'Sasuke');", "OK", false}, // age, active NULL
+		{"INSERT INTO users VALUES (3, 'Sakura', -5, false);", "OK", false},
+		{"INSERT INTO users VALUES (4, 'Kakashi', 'old', TRUE);", "", true},  // TEXT into INT
+		{"INSERT INTO users VALUES (4, NULL, 30, TRUE);", "", true},          // NOT NULL
+		{"INSERT INTO users VALUES (-1, 'Orochimaru', 50, TRUE);", "", true}, // Key out of range
+		{"INSERT INTO users VALUES (4, 'Kakashi');", "", true},               // Too few values
+		{"INSERT INTO users (id, rank) VALUES (4, 'Jonin');", "", true},      // No such column
+		{"INSERT INTO users VALUES (1, 'Naruto', 17, TRUE);", "", true},      // Duplicate key
+		{"SELECT * FROM users WHERE id = 1;", "1 | Naruto | 17 | true", false},
+		{"SELECT name, active FROM users WHERE id = 2;", "Sasuke | NULL", false},
+		{"SELECT age, name FROM users WHERE id BETWEEN 1 AND 3 ORDER BY id DESC;", "-5 | Sakura\nNULL | Sasuke\n17 | Naruto\n(3 rows)", false},
+		{"SELECT rank FROM users WHERE id = 1;", "", true}, // No such column
+		{"SELECT * FROM users WHERE age = 17;", "", true},  // Not the primary key
+		{"SELECT * FROM users WHERE key = 1;", "", true},   // kv column name
+		{"UPDATE users SET age = 18, active = NULL WHERE id = 1;", "OK", false},
+		{"SELECT * FROM users WHERE id = 1;", "1 | Naruto | 18 | NULL", false},
+		{"UPDATE users SET age = TRUE WHERE id = 1;", "", true},  // BOOL into INT
+		{"UPDATE users SET name = NULL WHERE id = 1;", "", true}, // NOT NULL
+		{"UPDATE users SET id = 5 WHERE id = 1;", "", true},      // Primary key
+		{"UPDATE users SET age = 1 WHERE id = 9;", "", true},     // No such row
+		{"INSERT INTO users VALUES (2, 'Sasuke', 18, TRUE) ON CONFLICT (id) DO UPDATE;", "OK", false},
+		{"SELECT * FROM users WHERE id = 2;", "2 | Sasuke | 18 | true", false},
+		{"DELETE FROM users WHERE id = 3;", "OK", false},
+		{"SELECT id FROM users WHERE id >= 0;", "1\n2\n(2 rows)", false},
+		{"SELECT key FROM kv WHERE key = 1;", "", true}, // kv has no columns
+	}
+
+	for _, step := range steps {
+		result, err := executor.ExecuteSQL(step.sql)
+		if step.expectErr {
+			if err == nil {
+				t.Errorf("Expected error for SQL: %s", step.sql)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s failed: %v", step.sql, err)
+			continue
+		}
+		if result != step.expected {
+			t.Errorf("%s: got '%s', expected '%s'", step.sql, result, step.expected)
+		}
+	}
+
+	// Column names are stored with a one-byte length
+	if _, err := executor.ExecuteSQL("CREATE TABLE bad (id INT PRIMARY KEY, " + strings.Repeat("n", 256) + " TEXT);"); err == nil {
+		t.Errorf("Expected an error for a 256-byte column name")
+	}
+
+	// A key the schema cannot decode, written past SQL, fails the scan
+	users, _ := tree.Table("users")
+	if err := users.Insert([]byte("ab"), ""); err != nil {
+		t.Fatalf("Failed to insert raw key: %v", err)
+	}
+	if _, err := executor.ExecuteSQL("SELECT * FROM users WHERE id >= 0;"); err == nil {
+		t.Errorf("Expected an error for a row with a 2-byte key")
+	}
+
+	t.Logf("✓ Typed rows checked, stored and projected")
 }
 
 func TestSQLSyntaxErrors(t *testing.T) {
 	dbFile := "test_sql_errors.db"
 	walFile := "test_sql_errors.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, _ := storage.NewFilePager(dbFile)
@@ -101,6 +566,10 @@ func TestSQLSyntaxErrors(t *testing.T) {
 		"INVALID SQL;",                            // Invalid command
 		"SELECT * FROM kv",                        // Missing WHERE
 		"INSERT INTO kv VALUES ('key', 'value');", // Key not number
+		"INSERT INTO kv VALUES (100, 200);",       // Value not string
+		"INSERT INTO kv VALUES (100);",            // Missing value
+		"DELETE FROM kv WHERE id = 100;",          // Wrong column
+		"UPDATE kv SET key = 'x' WHERE key = 1;",  // Wrong column
 	}
 
 	for _, sql := range errorTests {
@@ -112,3 +581,88 @@ func TestSQLSyntaxErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestSQLSessionLocking(t *testing.T) {
+	dbFile := "test_sql_locking.db"
+	walFile := "test_sql_locking.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := bptree.NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	locks := lock.NewManager(time.Second)
+	alice := NewExecutorWithLocks(tree, locks)
+	bob := NewExecutorWithLocks(tree, locks)
+
+	if _, err := alice.ExecuteSQL("INSERT INTO kv VALUES (1, 'Naruto'); INSERT INTO kv VALUES (2, 'Sasuke');"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// A reader holds its shared lock until it commits, so a writer waits
+	if _, err := alice.ExecuteSQL("BEGIN; SELECT * FROM kv WHERE key = 1;"); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	write := make(chan error, 1)
+	go func() {
+		_, err := bob.ExecuteSQL("UPDATE kv SET value = 'Hokage' WHERE key = 1;")
+		write <- err
+	}()
+
+	select {
+	case err := <-write:
+		t.Fatalf("Write should wait for the reader, returned %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if _, err := alice.ExecuteSQL("COMMIT;"); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := <-write; err != nil {
+		t.Fatalf("Write failed after the reader committed: %v", err)
+	}
+
+	// Two transactions locking the same keys in opposite order deadlock,
+	// the younger one is rolled back and the older one goes on
+	alice.ExecuteSQL("BEGIN; UPDATE kv SET value = 'a' WHERE key = 1;")
+	bob.ExecuteSQL("BEGIN; UPDATE kv SET value = 'b' WHERE key = 2;")
+
+	go func() {
+		_, err := alice.ExecuteSQL("UPDATE kv SET value = 'a' WHERE key = 2;")
+		write <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = bob.ExecuteSQL("UPDATE kv SET value = 'b' WHERE key = 1;")
+	if !errors.Is(err, lock.ErrDeadlock) {
+		t.Fatalf("Expected deadlock, got %v", err)
+	}
+	if bob.InTransaction() {
+		t.Error("Deadlock victim should be rolled back")
+	}
+
+	if err := <-write; err != nil {
+		t.Fatalf("Surviving transaction failed: %v", err)
+	}
+	if _, err := alice.ExecuteSQL("COMMIT;"); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	result, err := bob.ExecuteSQL("SELECT * FROM kv WHERE key BETWEEN 1 AND 2;")
+	if err != nil || result != "1 | a\n2 | a\n(2 rows)" {
+		t.Errorf("After deadlock: result=%q err=%v", result, err)
+	}
+
+	t.Logf("✓ Sessions isolated by two-phase locking, deadlock victim rolled back")
+}
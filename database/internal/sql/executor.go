@@ -1,14 +1,43 @@
 package sql
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+	"github.com/spaghetti-lover/sharingan-db/internal/lock"
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 )
 
+// DefaultTable is the name of the tree the executor was created with,
+// other tables live in its catalog
+const DefaultTable = "kv"
+
 // Executor executes SQL statements against a B+ Tree
+// Between BEGIN and COMMIT/ROLLBACK statements run inside a transaction,
+// so an executor is a session and keeps that state across Execute calls
+//
+// Sessions that share a lock manager are isolated from each other by strict
+// two-phase locking: every key read or written is locked until the transaction
+// ends (or, in autocommit mode, until the statement ends)
 type Executor struct {
-	tree *bptree.BPTree
+	tree   *bptree.BPTree
+	tx     *bptree.Tx    // open transaction, nil in autocommit mode
+	locks  *lock.Manager // nil disables locking
+	lockTx uint64        // lock owner of the open transaction or statement
+}
+
+// store is what statements read and write: the tree (autocommit) or the open transaction
+type store interface {
+	Search(key []byte) (string, bool, error)
+	Insert(key []byte, value string) error
+	Upsert(key []byte, value string) error
+	Update(key []byte, value string) error
+	Delete(key []byte) (bool, error)
+	Scan(start, end []byte, limit int) ([]bptree.KeyValue, error)
+	ScanReverse(start, end []byte, limit int) ([]bptree.KeyValue, error)
+	SearchValue(value string, limit int) ([]bptree.KeyValue, error)
 }
 
 // NewExecutor creates a new SQL executor
@@ -16,26 +45,238 @@ func NewExecutor(tree *bptree.BPTree) *Executor {
 	return &Executor{tree: tree}
 }
 
+// NewExecutorWithLocks creates a SQL executor that locks the keys it touches
+// in locks, which is shared by all sessions on the same tree
+func NewExecutorWithLocks(tree *bptree.BPTree, locks *lock.Manager) *Executor {
+	return &Executor{tree: tree, locks: locks}
+}
+
 // Execute executes a SQL statement
+// A transaction chosen as deadlock victim is rolled back
 func (e *Executor) Execute(stmt Statement) (string, error) {
+	// Outside a transaction each statement holds its locks for itself
+	if e.locks != nil && e.tx == nil {
+		id := e.locks.Begin()
+		e.lockTx = id
+		defer e.locks.Release(id)
+	}
+
+	result, err := e.execute(stmt)
+	if err != nil && e.tx != nil && errors.Is(err, lock.ErrDeadlock) {
+		e.executeRollback()
+		err = fmt.Errorf("%w, transaction rolled back", err)
+	}
+
+	return result, err
+}
+
+// execute dispatches a statement to its executor
+func (e *Executor) execute(stmt Statement) (string, error) {
 	switch s := stmt.(type) {
 	case *SelectStatement:
 		return e.executeSelect(s)
 	case *InsertStatement:
 		return e.executeInsert(s)
+	case *UpdateStatement:
+		return e.executeUpdate(s)
+	case *DeleteStatement:
+		return e.executeDelete(s)
+	case *BeginStatement:
+		return e.executeBegin()
+	case *CommitStatement:
+		return e.executeCommit()
+	case *RollbackStatement:
+		return e.executeRollback()
+	case *CreateTableStatement:
+		return e.executeCreateTable(s)
+	case *DropTableStatement:
+		return e.executeDropTable(s)
+	case *CreateIndexStatement:
+		return e.executeCreateIndex(s)
+	case *DropIndexStatement:
+		return e.executeDropIndex(s)
+	case *VacuumStatement:
+		return e.executeVacuum()
 	default:
 		return "", fmt.Errorf("unsupported statement type: %T", stmt)
 	}
 }
 
+// ExecuteSQL parses and executes one or more statements separated by semicolons
+// Results are joined by newlines; execution stops at the first error
+func (e *Executor) ExecuteSQL(sql string) (string, error) {
+	// Tokenize
+	tokenizer := NewTokenizer(sql)
+	tokens, err := tokenizer.Tokenize()
+	if err != nil {
+		return "", fmt.Errorf("tokenizer error: %w", err)
+	}
+
+	// Parse
+	parser := NewParser(tokens)
+	statements, err := parser.ParseAll()
+	if err != nil {
+		return "", fmt.Errorf("parser error: %w", err)
+	}
+
+	// Execute
+	results := make([]string, 0, len(statements))
+	for _, stmt := range statements {
+		result, err := e.Execute(stmt)
+		if err != nil {
+			return strings.Join(results, "\n"), err
+		}
+		results = append(results, result)
+	}
+
+	return strings.Join(results, "\n"), nil
+}
+
+// InTransaction reports whether a transaction is open
+func (e *Executor) InTransaction() bool {
+	return e.tx != nil
+}
+
+// tableInfo is a table resolved from its name
+type tableInfo struct {
+	name   string
+	tree   *bptree.BPTree
+	schema *Schema // nil for key-value tables (kv and tables created without columns)
+}
+
+// table returns a table: the executor's tree for DefaultTable, a catalog table otherwise
+func (e *Executor) table(name string) (*tableInfo, error) {
+	if name == DefaultTable {
+		return &tableInfo{name: name, tree: e.tree}, nil
+	}
+
+	tree, ok := e.tree.Table(name)
+	if !ok {
+		return nil, fmt.Errorf("table '%s' not found", name)
+	}
+
+	t := &tableInfo{name: name, tree: tree}
+	if data := tree.Schema(); data != nil {
+		schema, err := DecodeSchema(data)
+		if err != nil {
+			return nil, fmt.Errorf("table '%s': %w", name, err)
+		}
+		t.schema = schema
+	}
+	return t, nil
+}
+
+// keyColumn returns the name of the column holding the tree key
+func (t *tableInfo) keyColumn() string {
+	if t.schema == nil {
+		return "key"
+	}
+	return t.schema.Columns[t.schema.PrimaryKey()].Name
+}
+
+// checkKeyColumn returns an error unless column names the key of the table,
+// the only column WHERE filters on
+func (t *tableInfo) checkKeyColumn(column string) error {
+	if strings.EqualFold(column, t.keyColumn()) {
+		return nil
+	}
+	if t.schema != nil && t.schema.Column(column) >= 0 {
+		return fmt.Errorf("WHERE supports only the primary key '%s' of table '%s'", t.keyColumn(), t.name)
+	}
+	return fmt.Errorf("column '%s' not found in table '%s'", column, t.name)
+}
+
+// store returns the open transaction on table, or table in autocommit mode,
+// behind the lock manager if there is one
+func (e *Executor) store(table *bptree.BPTree) store {
+	var s store = table
+	if e.tx != nil {
+		s = e.tx.In(table)
+	}
+
+	if e.locks != nil {
+		return &lockedStore{inner: s, locks: e.locks, tx: e.lockTx, table: table.TableID()}
+	}
+	return s
+}
+
+// releaseLocks ends two-phase locking of the transaction that just finished
+func (e *Executor) releaseLocks() {
+	if e.locks != nil {
+		e.locks.Release(e.lockTx)
+	}
+}
+
+// executeBegin opens a transaction
+func (e *Executor) executeBegin() (string, error) {
+	if e.tx != nil {
+		return "", fmt.Errorf("transaction %d already in progress", e.tx.ID())
+	}
+
+	e.tx = e.tree.Begin()
+	if e.locks != nil {
+		e.lockTx = e.locks.Begin()
+	}
+	return "BEGIN", nil
+}
+
+// executeCommit commits the open transaction
+func (e *Executor) executeCommit() (string, error) {
+	if e.tx == nil {
+		return "", fmt.Errorf("no transaction in progress")
+	}
+
+	tx := e.tx
+	e.tx = nil
+	defer e.releaseLocks()
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit failed: %w", err)
+	}
+
+	return "COMMIT", nil
+}
+
+// executeRollback discards the open transaction
+func (e *Executor) executeRollback() (string, error) {
+	if e.tx == nil {
+		return "", fmt.Errorf("no transaction in progress")
+	}
+
+	tx := e.tx
+	e.tx = nil
+	defer e.releaseLocks()
+	if err := tx.Rollback(); err != nil {
+		return "", fmt.Errorf("rollback failed: %w", err)
+	}
+
+	return "ROLLBACK", nil
+}
+
 // executeSelect executes a SELECT statement
 func (e *Executor) executeSelect(stmt *SelectStatement) (string, error) {
-	// For now, we only support the "kv" table
-	if stmt.Table != "kv" {
-		return "", fmt.Errorf("table '%s' not found (only 'kv' is supported)", stmt.Table)
+	table, err := e.table(stmt.Table)
+	if err != nil {
+		return "", err
+	}
+	if stmt.ByValue {
+		return e.executeValueSelect(table, stmt)
+	}
+	if err := table.checkKeyColumn(stmt.KeyColumn); err != nil {
+		return "", err
+	}
+
+	if table.schema != nil {
+		return e.executeTypedSelect(table, stmt)
+	}
+	if stmt.Columns != nil {
+		return "", fmt.Errorf("table '%s' has no columns, use SELECT *", table.name)
+	}
+
+	if stmt.IsRange {
+		return e.executeRangeSelect(table, stmt)
 	}
 
-	value, found, err := e.tree.Search(stmt.Key)
+	value, found, err := e.store(table.tree).Search(encodeKey(stmt.Key))
 	if err != nil {
 		return "", fmt.Errorf("search failed: %w", err)
 	}
@@ -48,37 +289,400 @@ func (e *Executor) executeSelect(stmt *SelectStatement) (string, error) {
 	return fmt.Sprintf("%d | %s", stmt.Key, value), nil
 }
 
+// executeRangeSelect scans the leaf chain for keys in [Start, End]
+func (e *Executor) executeRangeSelect(table *tableInfo, stmt *SelectStatement) (string, error) {
+	results, err := e.scan(table, stmt)
+	if err != nil {
+		return "", err
+	}
+
+	return FormatRows(results), nil
+}
+
+// executeValueSelect finds the rows of a key-value table holding a value,
+// through an index on the value column if the table has one
+func (e *Executor) executeValueSelect(table *tableInfo, stmt *SelectStatement) (string, error) {
+	if table.schema != nil || !strings.EqualFold(stmt.KeyColumn, "value") {
+		if strings.EqualFold(stmt.KeyColumn, table.keyColumn()) {
+			return "", fmt.Errorf("column '%s' must be compared with a number", stmt.KeyColumn)
+		}
+		if err := table.checkKeyColumn(stmt.KeyColumn); err != nil {
+			return "", err
+		}
+	}
+	if stmt.Columns != nil {
+		return "", fmt.Errorf("table '%s' has no columns, use SELECT *", table.name)
+	}
+
+	results, err := e.store(table.tree).SearchValue(stmt.Value, stmt.Limit)
+	if err != nil {
+		return "", fmt.Errorf("search failed: %w", err)
+	}
+
+	return FormatRows(results), nil
+}
+
+// scan returns the records of a range SELECT
+func (e *Executor) scan(table *tableInfo, stmt *SelectStatement) ([]bptree.KeyValue, error) {
+	scan := e.store(table.tree).Scan
+	if stmt.Descending {
+		scan = e.store(table.tree).ScanReverse
+	}
+
+	results, err := scan(encodeKey(stmt.Start), encodeKey(stmt.End), stmt.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("scan failed: %w", err)
+	}
+	return results, nil
+}
+
+// executeTypedSelect executes a SELECT on a table with columns, printing the
+// projected columns of each row separated by " | "
+func (e *Executor) executeTypedSelect(table *tableInfo, stmt *SelectStatement) (string, error) {
+	schema := table.schema
+
+	projection := make([]int, 0, len(schema.Columns))
+	if stmt.Columns == nil {
+		for i := range schema.Columns {
+			projection = append(projection, i)
+		}
+	}
+	for _, name := range stmt.Columns {
+		i := schema.Column(name)
+		if i < 0 {
+			return "", fmt.Errorf("column '%s' not found in table '%s'", name, table.name)
+		}
+		projection = append(projection, i)
+	}
+
+	if !stmt.IsRange {
+		value, found, err := e.store(table.tree).Search(encodeKey(stmt.Key))
+		if err != nil {
+			return "", fmt.Errorf("search failed: %w", err)
+		}
+		if !found {
+			return "", fmt.Errorf("key %d not found", stmt.Key)
+		}
+
+		row, err := schema.DecodeRow(stmt.Key, value)
+		if err != nil {
+			return "", err
+		}
+		return formatRow(row, projection), nil
+	}
+
+	results, err := e.scan(table, stmt)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, result := range results {
+		key, ok := storage.KeyToUint32(result.Key)
+		if !ok {
+			return "", fmt.Errorf("table %s has a %d-byte key, expected 4", stmt.Table, len(result.Key))
+		}
+		row, err := schema.DecodeRow(key, result.Value)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(formatRow(row, projection))
+		sb.WriteByte('\n')
+	}
+	fmt.Fprintf(&sb, "(%d rows)", len(results))
+	return sb.String(), nil
+}
+
+// formatRow formats the projected columns of a row separated by " | "
+func formatRow(row []Value, projection []int) string {
+	fields := make([]string, len(projection))
+	for i, column := range projection {
+		fields[i] = FormatValue(row[column])
+	}
+	return strings.Join(fields, " | ")
+}
+
+// encodeKey returns the record key of a row key, big-endian so records sort
+// in key order (see ParseKey)
+func encodeKey(key uint32) []byte {
+	return storage.Uint32Key(key)
+}
+
+// FormatRows formats key-value pairs as "key | value" lines followed by a row count
+// Numeric keys are printed as numbers, other keys quoted
+func FormatRows(rows []bptree.KeyValue) string {
+	var sb strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&sb, "%s | %s\n", bptree.FormatKey(row.Key), row.Value)
+	}
+	fmt.Fprintf(&sb, "(%d rows)", len(rows))
+	return sb.String()
+}
+
 // executeInsert executes an INSERT statement
 func (e *Executor) executeInsert(stmt *InsertStatement) (string, error) {
-	// For now, we only support the "kv" table
-	if stmt.Table != "kv" {
-		return "", fmt.Errorf("table '%s' not found (only 'kv' is supported)", stmt.Table)
+	table, err := e.table(stmt.Table)
+	if err != nil {
+		return "", err
+	}
+
+	key, value := stmt.Key, stmt.Value
+	if table.schema != nil {
+		if key, value, err = table.insertRow(stmt); err != nil {
+			return "", fmt.Errorf("insert failed: %w", err)
+		}
+	} else if _, _, ok := keyValuePair(stmt.Values); !ok || stmt.Columns != nil {
+		return "", fmt.Errorf("table '%s' stores key-value pairs: use VALUES (<key>, '<value>')", table.name)
+	}
+
+	if stmt.Upsert {
+		if err := e.store(table.tree).Upsert(encodeKey(key), value); err != nil {
+			return "", fmt.Errorf("insert failed: %w", err)
+		}
+		return "OK", nil
 	}
 
-	if err := e.tree.Insert(stmt.Key, stmt.Value); err != nil {
+	if err := e.store(table.tree).Insert(encodeKey(key), value); err != nil {
 		return "", fmt.Errorf("insert failed: %w", err)
 	}
 
 	return "OK", nil
 }
 
-// ParseAndExecute is a convenience function that parses and executes SQL
-func ParseAndExecute(sql string, tree *bptree.BPTree) (string, error) {
-	// Tokenize
-	tokenizer := NewTokenizer(sql)
-	tokens, err := tokenizer.Tokenize()
+// insertRow type checks the row of an INSERT into a table with columns and
+// returns its key and encoded value
+// Columns left out of the column list are NULL
+func (t *tableInfo) insertRow(stmt *InsertStatement) (uint32, string, error) {
+	schema := t.schema
+	row := make([]Value, len(schema.Columns))
+
+	if stmt.Columns == nil {
+		if len(stmt.Values) != len(schema.Columns) {
+			return 0, "", fmt.Errorf("table '%s' has %d columns but %d values were given",
+				t.name, len(schema.Columns), len(stmt.Values))
+		}
+		copy(row, stmt.Values)
+	} else {
+		assigned := make([]bool, len(schema.Columns))
+		for i, name := range stmt.Columns {
+			column := schema.Column(name)
+			if column < 0 {
+				return 0, "", fmt.Errorf("column '%s' not found in table '%s'", name, t.name)
+			}
+			if assigned[column] {
+				return 0, "", fmt.Errorf("column '%s' given twice", name)
+			}
+			assigned[column] = true
+			row[column] = stmt.Values[i]
+		}
+	}
+
+	for i := range row {
+		if err := schema.Check(i, row[i]); err != nil {
+			return 0, "", err
+		}
+	}
+
+	key := uint32(row[schema.PrimaryKey()].(int64))
+	return key, schema.EncodeRow(row), nil
+}
+
+// executeUpdate executes an UPDATE statement
+func (e *Executor) executeUpdate(stmt *UpdateStatement) (string, error) {
+	table, err := e.table(stmt.Table)
 	if err != nil {
-		return "", fmt.Errorf("tokenizer error: %w", err)
+		return "", err
+	}
+	if err := table.checkKeyColumn(stmt.KeyColumn); err != nil {
+		return "", err
 	}
 
-	// Parse
-	parser := NewParser(tokens)
-	stmt, err := parser.Parse()
+	value := stmt.Value
+	if table.schema != nil {
+		if value, err = e.updateRow(table, stmt); err != nil {
+			return "", fmt.Errorf("update failed: %w", err)
+		}
+	} else if _, ok := valueAssignment(stmt.Assignments); !ok {
+		return "", fmt.Errorf("table '%s' stores key-value pairs: use SET value = '<value>'", table.name)
+	}
+
+	if err := e.store(table.tree).Update(encodeKey(stmt.Key), value); err != nil {
+		return "", fmt.Errorf("update failed: %w", err)
+	}
+
+	return "OK", nil
+}
+
+// updateRow reads the row an UPDATE of a table with columns changes and
+// returns it encoded with the assignments applied and type checked
+func (e *Executor) updateRow(table *tableInfo, stmt *UpdateStatement) (string, error) {
+	schema := table.schema
+
+	value, found, err := e.store(table.tree).Search(encodeKey(stmt.Key))
 	if err != nil {
-		return "", fmt.Errorf("parser error: %w", err)
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("%w: %d", bptree.ErrKeyNotFound, stmt.Key)
 	}
 
-	// Execute
+	row, err := schema.DecodeRow(stmt.Key, value)
+	if err != nil {
+		return "", err
+	}
+
+	for _, assignment := range stmt.Assignments {
+		column := schema.Column(assignment.Column)
+		if column < 0 {
+			return "", fmt.Errorf("column '%s' not found in table '%s'", assignment.Column, table.name)
+		}
+		if schema.Columns[column].PrimaryKey {
+			return "", fmt.Errorf("primary key '%s' cannot be updated", assignment.Column)
+		}
+		if err := schema.Check(column, assignment.Value); err != nil {
+			return "", err
+		}
+		row[column] = assignment.Value
+	}
+
+	return schema.EncodeRow(row), nil
+}
+
+// executeDelete executes a DELETE statement
+func (e *Executor) executeDelete(stmt *DeleteStatement) (string, error) {
+	table, err := e.table(stmt.Table)
+	if err != nil {
+		return "", err
+	}
+	if err := table.checkKeyColumn(stmt.KeyColumn); err != nil {
+		return "", err
+	}
+
+	found, err := e.store(table.tree).Delete(encodeKey(stmt.Key))
+	if err != nil {
+		return "", fmt.Errorf("delete failed: %w", err)
+	}
+
+	if !found {
+		return "", fmt.Errorf("key %d not found", stmt.Key)
+	}
+
+	return "OK", nil
+}
+
+// executeCreateTable executes a CREATE TABLE statement
+// Tables are created outside transactions, the catalog is not transactional
+func (e *Executor) executeCreateTable(stmt *CreateTableStatement) (string, error) {
+	if e.tx != nil {
+		return "", fmt.Errorf("CREATE TABLE is not allowed inside a transaction")
+	}
+	if stmt.Table == DefaultTable {
+		return "", fmt.Errorf("table '%s' already exists", stmt.Table)
+	}
+
+	var schema []byte
+	if stmt.Columns != nil {
+		columns, err := newSchema(stmt.Columns)
+		if err != nil {
+			return "", fmt.Errorf("create table failed: %w", err)
+		}
+		schema = columns.Encode()
+	}
+
+	if _, err := e.tree.CreateTableWithSchema(stmt.Table, schema); err != nil {
+		return "", fmt.Errorf("create table failed: %w", err)
+	}
+
+	return "OK", nil
+}
+
+// executeDropTable executes a DROP TABLE statement
+func (e *Executor) executeDropTable(stmt *DropTableStatement) (string, error) {
+	if e.tx != nil {
+		return "", fmt.Errorf("DROP TABLE is not allowed inside a transaction")
+	}
+	if stmt.Table == DefaultTable {
+		return "", fmt.Errorf("table '%s' cannot be dropped", stmt.Table)
+	}
+
+	if err := e.tree.DropTable(stmt.Table); err != nil {
+		if errors.Is(err, bptree.ErrTableNotFound) {
+			return "", fmt.Errorf("table '%s' not found", stmt.Table)
+		}
+		return "", fmt.Errorf("drop table failed: %w", err)
+	}
+
+	return "OK", nil
+}
+
+// executeCreateIndex executes a CREATE INDEX statement
+// Only the value column of key-value tables can be indexed
+func (e *Executor) executeCreateIndex(stmt *CreateIndexStatement) (string, error) {
+	if e.tx != nil {
+		return "", fmt.Errorf("CREATE INDEX is not allowed inside a transaction")
+	}
+
+	table, err := e.table(stmt.Table)
+	if err != nil {
+		return "", err
+	}
+	if table.schema != nil || !strings.EqualFold(stmt.Column, "value") {
+		return "", fmt.Errorf("only the value column of key-value tables can be indexed")
+	}
+
+	if err := table.tree.CreateIndex(stmt.Index); err != nil {
+		if errors.Is(err, bptree.ErrIndexExists) {
+			return "", fmt.Errorf("index '%s' already exists", stmt.Index)
+		}
+		return "", fmt.Errorf("create index failed: %w", err)
+	}
+
+	return "OK", nil
+}
+
+// executeDropIndex executes a DROP INDEX statement
+func (e *Executor) executeDropIndex(stmt *DropIndexStatement) (string, error) {
+	if e.tx != nil {
+		return "", fmt.Errorf("DROP INDEX is not allowed inside a transaction")
+	}
+
+	if err := e.tree.DropIndex(stmt.Index); err != nil {
+		if errors.Is(err, bptree.ErrIndexNotFound) {
+			return "", fmt.Errorf("index '%s' not found", stmt.Index)
+		}
+		return "", fmt.Errorf("drop index failed: %w", err)
+	}
+
+	return "OK", nil
+}
+
+// executeVacuum executes a VACUUM statement, reporting the space reclaimed
+func (e *Executor) executeVacuum() (string, error) {
+	if e.tx != nil {
+		return "", fmt.Errorf("VACUUM is not allowed inside a transaction")
+	}
+
+	info, err := e.tree.Vacuum()
+	if err != nil {
+		return "", fmt.Errorf("vacuum failed: %w", err)
+	}
+
+	return fmt.Sprintf("OK, %d bytes reclaimed (%d pages)", info.BytesReclaimed, info.PagesBefore-info.PagesAfter), nil
+}
+
+// ParseAndExecute is a convenience function that parses and executes SQL
+// It has no session, so a transaction must be committed within the same call
+// (BEGIN; ...; COMMIT;) or it is rolled back
+func ParseAndExecute(sql string, tree *bptree.BPTree) (string, error) {
 	executor := NewExecutor(tree)
-	return executor.Execute(stmt)
+	result, err := executor.ExecuteSQL(sql)
+
+	if executor.InTransaction() {
+		executor.executeRollback()
+		if err == nil {
+			err = fmt.Errorf("transaction not committed, rolled back")
+		}
+	}
+
+	return result, err
 }
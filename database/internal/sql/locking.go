@@ -0,0 +1,100 @@
+package sql
+
+import (
+	"encoding/binary"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+	"github.com/spaghetti-lover/sharingan-db/internal/lock"
+)
+
+// lockedStore takes two-phase locks before it reads or writes a key of inner:
+// shared for reads, exclusive for writes. The locks are released by the
+// executor when the transaction (or autocommit statement) ends
+type lockedStore struct {
+	inner store
+	locks *lock.Manager
+	tx    uint64
+	table uint32 // catalog ID of the table, lock names are prefixed with it
+}
+
+// lockKey returns the lock name of key, so equal keys of different tables
+// do not conflict
+func (s *lockedStore) lockKey(key []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, s.table), key...)
+}
+
+func (s *lockedStore) Search(key []byte) (string, bool, error) {
+	if err := s.locks.Lock(s.tx, s.lockKey(key), lock.Shared); err != nil {
+		return "", false, err
+	}
+	return s.inner.Search(key)
+}
+
+func (s *lockedStore) Insert(key []byte, value string) error {
+	if err := s.locks.Lock(s.tx, s.lockKey(key), lock.Exclusive); err != nil {
+		return err
+	}
+	return s.inner.Insert(key, value)
+}
+
+func (s *lockedStore) Upsert(key []byte, value string) error {
+	if err := s.locks.Lock(s.tx, s.lockKey(key), lock.Exclusive); err != nil {
+		return err
+	}
+	return s.inner.Upsert(key, value)
+}
+
+func (s *lockedStore) Update(key []byte, value string) error {
+	if err := s.locks.Lock(s.tx, s.lockKey(key), lock.Exclusive); err != nil {
+		return err
+	}
+	return s.inner.Update(key, value)
+}
+
+func (s *lockedStore) Delete(key []byte) (bool, error) {
+	if err := s.locks.Lock(s.tx, s.lockKey(key), lock.Exclusive); err != nil {
+		return false, err
+	}
+	return s.inner.Delete(key)
+}
+
+func (s *lockedStore) Scan(start, end []byte, limit int) ([]bptree.KeyValue, error) {
+	return s.scan(s.inner.Scan, start, end, limit)
+}
+
+func (s *lockedStore) ScanReverse(start, end []byte, limit int) ([]bptree.KeyValue, error) {
+	return s.scan(s.inner.ScanReverse, start, end, limit)
+}
+
+func (s *lockedStore) SearchValue(value string, limit int) ([]bptree.KeyValue, error) {
+	return s.scan(func(_, _ []byte, limit int) ([]bptree.KeyValue, error) {
+		return s.inner.SearchValue(value, limit)
+	}, nil, nil, limit)
+}
+
+// scan locks every row the range returns, scanning again until all of them
+// were already locked so the rows returned cannot change under the lock holder
+// Keys inserted into the range later are not locked (no phantom protection)
+func (s *lockedStore) scan(scan func(start, end []byte, limit int) ([]bptree.KeyValue, error), start, end []byte, limit int) ([]bptree.KeyValue, error) {
+	for {
+		rows, err := scan(start, end, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		stable := true
+		for _, row := range rows {
+			if s.locks.Held(s.tx, s.lockKey(row.Key)) != 0 {
+				continue
+			}
+			stable = false
+			if err := s.locks.Lock(s.tx, s.lockKey(row.Key), lock.Shared); err != nil {
+				return nil, err
+			}
+		}
+
+		if stable {
+			return rows, nil
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package sql
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestParserSelect(t *testing.T) {
 	tests := []struct {
@@ -11,8 +14,10 @@ func TestParserSelect(t *testing.T) {
 		{"SELECT * FROM kv WHERE key = 100;", 100, false},
 		{"SELECT * FROM kv WHERE key = 50", 50, false},
 		{"SELECT * FROM users WHERE key = 10;", 10, false}, // Different table name
-		{"SELECT * FROM kv WHERE id = 100;", 0, true},      // Wrong column name
-		{"SELECT key FROM kv WHERE key = 100;", 0, true},   // Wrong SELECT syntax
+		{"SELECT * FROM kv WHERE id = 100;", 100, false},   // Column checked by the executor
+		{"SELECT name, age FROM users WHERE id = 7;", 7, false},
+		{"SELECT FROM kv WHERE key = 100;", 0, true},            // Missing columns
+		{"SELECT * FROM kv WHERE key = 1 AND id = 2;", 0, true}, // Two key columns
 	}
 
 	for _, tt := range tests {
@@ -59,10 +64,11 @@ func TestParserInsert(t *testing.T) {
 		{"INSERT INTO kv VALUES (100, 'Naruto');", 100, "Naruto", false},
 		{"INSERT INTO kv VALUES (50, 'Sasuke')", 50, "Sasuke", false},
 		{"INSERT INTO users VALUES (10, 'Admin');", 10, "Admin", false},
-		{"INSERT INTO kv (100, 'Test');", 0, "", true},           // Missing VALUES
-		{"INSERT INTO kv VALUES (100);", 0, "", true},            // Missing value
-		{"INSERT INTO kv VALUES (100, 200);", 0, "", true},       // Value not string
-		{"INSERT INTO kv VALUES ('key', 'value');", 0, "", true}, // Key not number
+		{"INSERT INTO kv (100, 'Test');", 0, "", true},         // Missing VALUES
+		{"INSERT INTO kv VALUES (100, 200);", 0, "", false},    // Not a key-value pair, checked by the executor
+		{"INSERT INTO kv VALUES (100, );", 0, "", true},        // Missing value
+		{"INSERT INTO kv VALUES 100, 'a';", 0, "", true},       // Missing parentheses
+		{"INSERT INTO kv (key) VALUES (1, 'a');", 0, "", true}, // Column count mismatch
 	}
 
 	for _, tt := range tests {
@@ -105,3 +111,288 @@ func TestParserInsert(t *testing.T) {
 		})
 	}
 }
+
+func TestParserDelete(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedKey uint32
+		expectError bool
+	}{
+		{"DELETE FROM kv WHERE key = 100;", 100, false},
+		{"DELETE FROM kv WHERE key = 7", 7, false},
+		{"DELETE kv WHERE key = 100;", 0, true},        // Missing FROM
+		{"DELETE FROM kv;", 0, true},                   // Missing WHERE
+		{"DELETE FROM kv WHERE id = 100;", 100, false}, // Column checked by the executor
+		{"DELETE FROM kv WHERE id > 100;", 0, true},    // Only equality
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokenizer := NewTokenizer(tt.input)
+			tokens, err := tokenizer.Tokenize()
+			if err != nil {
+				t.Fatalf("Tokenize failed: %v", err)
+			}
+
+			parser := NewParser(tokens)
+			stmt, err := parser.Parse()
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			deleteStmt, ok := stmt.(*DeleteStatement)
+			if !ok {
+				t.Fatalf("Expected DeleteStatement, got %T", stmt)
+			}
+
+			if deleteStmt.Key != tt.expectedKey {
+				t.Errorf("Key: got %d, expected %d", deleteStmt.Key, tt.expectedKey)
+			}
+		})
+	}
+}
+
+func TestParserUpdateAndUpsert(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedKey   uint32
+		expectedValue string
+		upsert        bool
+		expectError   bool
+	}{
+		{"UPDATE kv SET value = 'Hokage' WHERE key = 1;", 1, "Hokage", false, false},
+		{"INSERT INTO kv VALUES (1, 'Hokage') ON CONFLICT DO UPDATE;", 1, "Hokage", true, false},
+		{"INSERT INTO kv VALUES (2, 'Kage') ON CONFLICT (key) DO UPDATE", 2, "Kage", true, false},
+		{"UPDATE kv SET value = 'x';", 0, "", false, true},                   // Missing WHERE
+		{"UPDATE kv SET key = WHERE key = 1;", 0, "", false, true},           // Missing value
+		{"INSERT INTO kv VALUES (1, 'x') ON CONFLICT;", 0, "", false, true},  // Missing DO UPDATE
+		{"INSERT INTO kv VALUES (1, 'x') ON DO UPDATE;", 0, "", false, true}, // Missing CONFLICT
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokenizer := NewTokenizer(tt.input)
+			tokens, err := tokenizer.Tokenize()
+			if err != nil {
+				t.Fatalf("Tokenize failed: %v", err)
+			}
+
+			parser := NewParser(tokens)
+			stmt, err := parser.Parse()
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			switch s := stmt.(type) {
+			case *UpdateStatement:
+				if tt.upsert {
+					t.Fatalf("Expected InsertStatement, got %T", stmt)
+				}
+				if s.Key != tt.expectedKey || s.Value != tt.expectedValue {
+					t.Errorf("Got (%d, %s), expected (%d, %s)", s.Key, s.Value, tt.expectedKey, tt.expectedValue)
+				}
+			case *InsertStatement:
+				if !s.Upsert {
+					t.Errorf("Expected Upsert to be set")
+				}
+				if s.Key != tt.expectedKey || s.Value != tt.expectedValue {
+					t.Errorf("Got (%d, %s), expected (%d, %s)", s.Key, s.Value, tt.expectedKey, tt.expectedValue)
+				}
+			default:
+				t.Fatalf("Unexpected statement %T", stmt)
+			}
+		})
+	}
+}
+
+func TestParserSelectRange(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStart uint32
+		expectedEnd   uint32
+		expectedLimit int
+		expectedDesc  bool
+		expectError   bool
+	}{
+		{"SELECT * FROM kv WHERE key BETWEEN 10 AND 20;", 10, 20, 0, false, false},
+		{"SELECT * FROM kv WHERE key >= 10 AND key < 20", 10, 19, 0, false, false},
+		{"SELECT * FROM kv WHERE key > 10 AND key <= 20", 11, 20, 0, false, false},
+		{"SELECT * FROM kv WHERE key >= 100 LIMIT 5;", 100, 4294967295, 5, false, false},
+		{"SELECT * FROM kv WHERE key < 50", 0, 49, 0, false, false},
+		{"SELECT * FROM kv WHERE key BETWEEN 1 AND 100 AND key > 90", 91, 100, 0, false, false},
+		{"SELECT * FROM kv WHERE key < 0", 1, 0, 0, false, false}, // Empty range
+		{"SELECT * FROM kv WHERE key < 100 ORDER BY key DESC LIMIT 10;", 0, 99, 10, true, false},
+		{"SELECT * FROM kv WHERE key >= 5 ORDER BY key ASC", 5, 4294967295, 0, false, false},
+		{"SELECT * FROM kv WHERE key >= 5 ORDER BY value DESC", 0, 0, 0, false, true}, // Only key ordering
+		{"SELECT * FROM kv WHERE key >= 5 ORDER key DESC", 0, 0, 0, false, true},      // Missing BY
+		{"SELECT * FROM kv WHERE key BETWEEN 10;", 0, 0, 0, false, true},              // Missing AND
+		{"SELECT * FROM kv WHERE key >= 10 AND id < 20;", 0, 0, 0, false, true},       // Wrong column name
+		{"SELECT * FROM kv WHERE key >= 10 LIMIT 0;", 0, 0, 0, false, true},           // Invalid limit
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokenizer := NewTokenizer(tt.input)
+			tokens, err := tokenizer.Tokenize()
+			if err != nil {
+				t.Fatalf("Tokenize failed: %v", err)
+			}
+
+			parser := NewParser(tokens)
+			stmt, err := parser.Parse()
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			selectStmt, ok := stmt.(*SelectStatement)
+			if !ok {
+				t.Fatalf("Expected SelectStatement, got %T", stmt)
+			}
+
+			if !selectStmt.IsRange {
+				t.Fatalf("Expected range query")
+			}
+
+			if selectStmt.Start != tt.expectedStart || selectStmt.End != tt.expectedEnd {
+				t.Errorf("Range: got [%d, %d], expected [%d, %d]",
+					selectStmt.Start, selectStmt.End, tt.expectedStart, tt.expectedEnd)
+			}
+
+			if selectStmt.Limit != tt.expectedLimit {
+				t.Errorf("Limit: got %d, expected %d", selectStmt.Limit, tt.expectedLimit)
+			}
+
+			if selectStmt.Descending != tt.expectedDesc {
+				t.Errorf("Descending: got %v, expected %v", selectStmt.Descending, tt.expectedDesc)
+			}
+		})
+	}
+}
+
+func TestParserTransactions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedTypes []string
+		expectError   bool
+	}{
+		{"BEGIN;", []string{"BEGIN"}, false},
+		{"begin transaction", []string{"BEGIN"}, false},
+		{"COMMIT;", []string{"COMMIT"}, false},
+		{"ROLLBACK;", []string{"ROLLBACK"}, false},
+		{"ABORT", []string{"ROLLBACK"}, false},
+		{"BEGIN; INSERT INTO kv VALUES (1, 'a'); DELETE FROM kv WHERE key = 2; COMMIT;",
+			[]string{"BEGIN", "INSERT", "DELETE", "COMMIT"}, false},
+		{"BEGIN;; COMMIT", []string{"BEGIN", "COMMIT"}, false},
+		{"BEGIN COMMIT", nil, true}, // Missing semicolon between statements
+		{";", nil, true},            // Empty statement
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokenizer := NewTokenizer(tt.input)
+			tokens, err := tokenizer.Tokenize()
+			if err != nil {
+				t.Fatalf("Tokenize failed: %v", err)
+			}
+
+			parser := NewParser(tokens)
+			statements, err := parser.ParseAll()
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			if len(statements) != len(tt.expectedTypes) {
+				t.Fatalf("Got %d statements, expected %d", len(statements), len(tt.expectedTypes))
+			}
+			for i, stmt := range statements {
+				if stmt.Type() != tt.expectedTypes[i] {
+					t.Errorf("Statement %d: got %s, expected %s", i, stmt.Type(), tt.expectedTypes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParserTables(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    Statement
+		expectError bool
+	}{
+		{"CREATE TABLE users;", &CreateTableStatement{Table: "users"}, false},
+		{"create table orders", &CreateTableStatement{Table: "orders"}, false},
+		{"DROP TABLE users;", &DropTableStatement{Table: "users"}, false},
+		{"CREATE users;", nil, true},  // Missing TABLE
+		{"DROP TABLE 42;", nil, true}, // Name not an identifier
+		{"CREATE INDEX idx ON kv(value);", &CreateIndexStatement{Index: "idx", Table: "kv", Column: "value"}, false},
+		{"create index idx on users (value)", &CreateIndexStatement{Index: "idx", Table: "users", Column: "value"}, false},
+		{"DROP INDEX idx;", &DropIndexStatement{Index: "idx"}, false},
+		{"CREATE INDEX idx kv(value);", nil, true},   // Missing ON
+		{"CREATE INDEX idx ON kv value;", nil, true}, // Missing parentheses
+		{"CREATE INDEX ON kv(value);", nil, true},    // Missing index name
+		{"SELECT * FROM kv WHERE value = 'Naruto' LIMIT 2;", &SelectStatement{Table: "kv", KeyColumn: "value", ByValue: true, Value: "Naruto", Limit: 2}, false},
+		{"SELECT * FROM kv WHERE value = 'a' ORDER BY key;", nil, true}, // Ordered by key only
+		{"VACUUM;", &VacuumStatement{}, false},
+		{"vacuum", &VacuumStatement{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			tokenizer := NewTokenizer(tt.input)
+			tokens, err := tokenizer.Tokenize()
+			if err != nil {
+				t.Fatalf("Tokenize failed: %v", err)
+			}
+
+			parser := NewParser(tokens)
+			stmt, err := parser.Parse()
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error, got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(stmt, tt.expected) {
+				t.Errorf("Got %#v, expected %#v", stmt, tt.expected)
+			}
+		})
+	}
+}
@@ -28,6 +28,15 @@ func TestTokenizer(t *testing.T) {
 				TokenRightParen, TokenSemicolon, TokenEOF,
 			},
 		},
+		{
+			name:  "Range predicates",
+			input: "WHERE key >= 10 AND key<20",
+			expected: []TokenType{
+				TokenKeyword, TokenIdentifier, TokenOperator, TokenNumber,
+				TokenKeyword, TokenIdentifier, TokenOperator, TokenNumber,
+				TokenEOF,
+			},
+		},
 	}
 
 	for _, tt := range tests {
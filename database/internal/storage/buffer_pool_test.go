@@ -1,13 +1,16 @@
 package storage
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
 func TestBufferPoolBasic(t *testing.T) {
 	dbFile := "test_buffer_pool.db"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
 
 	pager, err := NewFilePager(dbFile)
 	if err != nil {
@@ -29,7 +32,7 @@ func TestBufferPoolBasic(t *testing.T) {
 		pageIDs[i] = pageID
 
 		// Write unique data
-		data := make([]byte, PageSize)
+		data := make([]byte, DefaultPageSize)
 		data[0] = byte(i)
 		if err := bp.WritePage(pageID, data); err != nil {
 			t.Fatalf("Failed to write page %d: %v", pageID, err)
@@ -73,6 +76,7 @@ func TestBufferPoolBasic(t *testing.T) {
 func TestBufferPoolEviction(t *testing.T) {
 	dbFile := "test_buffer_eviction.db"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
 
 	pager, err := NewFilePager(dbFile)
 	if err != nil {
@@ -93,7 +97,7 @@ func TestBufferPoolEviction(t *testing.T) {
 		}
 		pageIDs[i] = pageID
 
-		data := make([]byte, PageSize)
+		data := make([]byte, DefaultPageSize)
 		data[0] = byte(i * 10)
 		if err := bp.WritePage(pageID, data); err != nil {
 			t.Fatalf("Failed to write page: %v", err)
@@ -169,6 +173,7 @@ func TestBufferPoolEviction(t *testing.T) {
 func TestBufferPoolHitRate(t *testing.T) {
 	dbFile := "test_buffer_hitrate.db"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
 
 	pager, err := NewFilePager(dbFile)
 	if err != nil {
@@ -188,7 +193,7 @@ func TestBufferPoolHitRate(t *testing.T) {
 		}
 		pageIDs[i] = pageID
 
-		data := make([]byte, PageSize)
+		data := make([]byte, DefaultPageSize)
 		data[0] = byte(i)
 		if err := bp.WritePage(pageID, data); err != nil {
 			t.Fatalf("Failed to write page: %v", err)
@@ -231,6 +236,7 @@ func TestBufferPoolHitRate(t *testing.T) {
 func TestBufferPoolFlush(t *testing.T) {
 	dbFile := "test_buffer_flush.db"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
 
 	pager, err := NewFilePager(dbFile)
 	if err != nil {
@@ -249,7 +255,7 @@ func TestBufferPoolFlush(t *testing.T) {
 			t.Fatalf("Failed to allocate page: %v", err)
 		}
 
-		data := make([]byte, PageSize)
+		data := make([]byte, DefaultPageSize)
 		data[0] = 0xAB
 		if err := bp.WritePage(pageID, data); err != nil {
 			t.Fatalf("Failed to write page: %v", err)
@@ -276,9 +282,238 @@ func TestBufferPoolFlush(t *testing.T) {
 	}
 }
 
+func TestBufferPoolFlushJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	pager, err := NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+
+	const pages = 20
+	ids := make([]uint64, pages)
+	for i := range ids {
+		if ids[i], err = pager.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+	}
+	if err := pager.WriteSuperblock(pager.Superblock()); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+
+	// One flush overwrites every page: their images go to the journal together
+	bp := NewBufferPool(pager, pages)
+	for _, id := range ids {
+		data := make([]byte, DefaultPageSize)
+		data[0] = 0xCD
+		if err := bp.WritePage(id, data); err != nil {
+			t.Fatalf("Failed to write page %d: %v", id, err)
+		}
+	}
+	if err := bp.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	info, err := os.Stat(path + JournalSuffix)
+	if err != nil {
+		t.Fatalf("No journal after the flush: %v", err)
+	}
+	if size := int64(journalHeaderSize + pages*(8+DefaultPageSize+4)); info.Size() != size {
+		t.Errorf("Journal has %d bytes, expected %d for %d pages", info.Size(), size, pages)
+	}
+
+	// Crash before the next superblock write: the pages are rolled back
+	pager.Close()
+	pager, err = NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+
+	for _, id := range ids {
+		data, err := pager.ReadPage(id)
+		if err != nil {
+			t.Fatalf("Failed to read page %d: %v", id, err)
+		}
+		if data[0] != 0 {
+			t.Fatalf("Page %d: data[0]=%#x after rollback, expected 0", id, data[0])
+		}
+	}
+
+	t.Logf("✓ Flush journaled %d pages in one batch and rolled them back", pages)
+}
+
+func TestBufferPoolPinning(t *testing.T) {
+	dbFile := "test_buffer_pin.db"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bp := NewBufferPool(pager, 3)
+
+	pageIDs := make([]uint64, 6)
+	for i := range pageIDs {
+		if pageIDs[i], err = bp.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		data := make([]byte, DefaultPageSize)
+		data[0] = byte(i)
+		if err := bp.WritePage(pageIDs[i], data); err != nil {
+			t.Fatalf("Failed to write page: %v", err)
+		}
+	}
+
+	// A pinned page survives eviction and is changed in place
+	frame, err := bp.FetchPage(pageIDs[0])
+	if err != nil {
+		t.Fatalf("Failed to fetch page: %v", err)
+	}
+	for _, id := range pageIDs[1:] {
+		if _, err := bp.ReadPage(id); err != nil {
+			t.Fatalf("Failed to read page %d: %v", id, err)
+		}
+	}
+	frame[0] = 0xEE
+	if err := bp.UnpinPage(pageIDs[0], true); err != nil {
+		t.Fatalf("Failed to unpin page: %v", err)
+	}
+	hits := bp.GetStats().Hits
+	if data, err := bp.ReadPage(pageIDs[0]); err != nil || data[0] != 0xEE {
+		t.Fatalf("Pinned page reads %#x (err=%v), expected 0xEE", data[0], err)
+	}
+	if bp.GetStats().Hits != hits+1 {
+		t.Error("Pinned page was evicted")
+	}
+	t.Log("✓ Pinned page stays cached")
+
+	// With every page pinned the pool grows, then shrinks back once unpinned
+	for _, id := range pageIDs[:4] {
+		if _, err := bp.FetchPage(id); err != nil {
+			t.Fatalf("Failed to fetch page %d: %v", id, err)
+		}
+	}
+	stats := bp.GetStats()
+	if stats.Size != 4 || stats.PinnedPages != 4 {
+		t.Errorf("All pinned: %s, expected 4 pinned pages", stats)
+	}
+	if err := bp.FreePage(pageIDs[1]); !errors.Is(err, ErrPagePinned) {
+		t.Errorf("Expected ErrPagePinned freeing a pinned page, got %v", err)
+	}
+	for _, id := range pageIDs[:4] {
+		if err := bp.UnpinPage(id, false); err != nil {
+			t.Fatalf("Failed to unpin page %d: %v", id, err)
+		}
+	}
+	if err := bp.UnpinPage(pageIDs[0], false); !errors.Is(err, ErrPageNotPinned) {
+		t.Errorf("Expected ErrPageNotPinned, got %v", err)
+	}
+	if _, err := bp.ReadPage(pageIDs[5]); err != nil {
+		t.Fatalf("Failed to read page: %v", err)
+	}
+	if stats := bp.GetStats(); stats.Size != 3 || stats.PinnedPages != 0 {
+		t.Errorf("After unpinning: %s, expected 3 cached pages", stats)
+	}
+	t.Logf("✓ %s", bp.GetStats())
+
+	if err := bp.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if data, err := pager.ReadPage(pageIDs[0]); err != nil || data[0] != 0xEE {
+		t.Errorf("Page changed in place reads %#x on disk (err=%v)", data[0], err)
+	}
+}
+
+// testLog is a WriteAheadLog whose LastLSN is set by the test
+type testLog struct {
+	last    uint64
+	durable uint64
+}
+
+func (l *testLog) LastLSN() uint64 { return l.last }
+
+func (l *testLog) SyncTo(lsn uint64) error {
+	l.durable = max(l.durable, lsn)
+	return nil
+}
+
+// logCheckPager records how far the log was durable when each page was written
+type logCheckPager struct {
+	Pager
+	log     *testLog
+	durable map[uint64]uint64
+}
+
+func (p *logCheckPager) WritePage(id uint64, data []byte) error {
+	p.durable[id] = p.log.durable
+	return p.Pager.WritePage(id, data)
+}
+
+func TestBufferPoolWriteAheadRule(t *testing.T) {
+	dbFile := "test_buffer_wal.db"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
+
+	filePager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer filePager.Close()
+
+	log := &testLog{}
+	pager := &logCheckPager{Pager: filePager, log: log, durable: make(map[uint64]uint64)}
+	bp := NewBufferPool(pager, 2)
+	bp.SetWAL(log)
+
+	pageIDs := make([]uint64, 4)
+	for i := range pageIDs {
+		if pageIDs[i], err = bp.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+	}
+
+	// Each page is changed after the record with its LSN is logged
+	write := func(id, lsn uint64) {
+		log.last = lsn
+		if err := bp.WritePage(id, make([]byte, DefaultPageSize)); err != nil {
+			t.Fatalf("Failed to write page: %v", err)
+		}
+	}
+	write(pageIDs[0], 10)
+	write(pageIDs[1], 20)
+	write(pageIDs[2], 30) // evicts pageIDs[0]
+	if durable, ok := pager.durable[pageIDs[0]]; !ok || durable < 10 {
+		t.Fatalf("Page with LSN 10 evicted with the log durable to %d", durable)
+	}
+
+	// A page pinned and changed in place takes the LSN of the unpin
+	if _, err := bp.FetchPage(pageIDs[3]); err != nil {
+		t.Fatalf("Failed to fetch page: %v", err)
+	}
+	log.last = 40
+	if err := bp.UnpinPage(pageIDs[3], true); err != nil {
+		t.Fatalf("Failed to unpin page: %v", err)
+	}
+
+	if err := bp.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	for i, id := range pageIDs {
+		if lsn := uint64(i+1) * 10; pager.durable[id] < lsn {
+			t.Errorf("Page with LSN %d written with the log durable to %d", lsn, pager.durable[id])
+		}
+	}
+	t.Logf("✓ Pages written after the log, durable to LSN %d", log.durable)
+}
+
 func TestBufferPoolSequentialAccess(t *testing.T) {
 	dbFile := "test_buffer_sequential.db"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
 
 	pager, err := NewFilePager(dbFile)
 	if err != nil {
@@ -296,7 +531,7 @@ func TestBufferPoolSequentialAccess(t *testing.T) {
 		pageID, _ := bp.AllocatePage()
 		pageIDs[i] = pageID
 
-		data := make([]byte, PageSize)
+		data := make([]byte, DefaultPageSize)
 		data[0] = byte(i * 5)
 		bp.WritePage(pageID, data)
 	}
@@ -329,6 +564,7 @@ func TestBufferPoolSequentialAccess(t *testing.T) {
 func BenchmarkBufferPoolRead(b *testing.B) {
 	dbFile := "bench_buffer_read.db"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
 
 	pager, _ := NewFilePager(dbFile)
 	defer pager.Close()
@@ -342,7 +578,7 @@ func BenchmarkBufferPoolRead(b *testing.B) {
 		pageID, _ := bp.AllocatePage()
 		pageIDs[i] = pageID
 
-		data := make([]byte, PageSize)
+		data := make([]byte, DefaultPageSize)
 		data[0] = byte(i)
 		bp.WritePage(pageID, data)
 	}
@@ -1,28 +1,65 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
-)
-
-const (
-	FreeListPageID = 0
+	"slices"
+	"sync"
+	"sync/atomic"
 )
 
 // FilePager implement Pager interface using file system
+// It is safe for concurrent use; pages are read and written with ReadAt/WriteAt
+//
+// Page 0 holds the superblock (see superblock.go), a new file keeps its free
+// list in page 1. The page size is chosen when the file is created and
+// recorded in the superblock. Pages written since the last superblock write
+// are rolled back if the file is opened after a crash (see journal.go)
 type FilePager struct {
 	file     *os.File
+	journal  *journal
+	pageSize int        // fixed once the file is opened
+	mu       sync.Mutex // guards the fields below
 	numPages uint64
 	freeList *FreeList
+	super    Superblock
+
+	// freeListPages holds what each page of the free-list chain last had
+	// written, so saving the list skips the pages it did not change
+	freeListPages map[uint64][]byte
+
+	snapshot atomic.Pointer[PageSnapshot] // open page snapshot, nil if none
 }
 
 // NewFilePager create nerw or open database file
+// A new file uses DefaultPageSize
+// Returns ErrUnsupportedFormat if the file was written by a newer format version
 func NewFilePager(path string) (*FilePager, error) {
+	return NewFilePagerWithPageSize(path, DefaultPageSize)
+}
+
+// NewFilePagerWithPageSize create new or open database file
+// pageSize (see ValidPageSize) applies to a new file only, an existing file
+// keeps the page size it was created with
+func NewFilePagerWithPageSize(path string, pageSize int) (*FilePager, error) {
+	if !ValidPageSize(pageSize) {
+		return nil, fmt.Errorf("invalid page size %d: must be a power of two from %d to %d", pageSize, MinPageSize, MaxPageSize)
+	}
+
 	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 
+	// Back to the last superblock write if writes after it were cut short
+	journal := newJournal(path)
+	if err := journal.rollback(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
 	// Take file size to calculate number of page
 	stat, err := file.Stat()
 	if err != nil {
@@ -30,55 +67,125 @@ func NewFilePager(path string) (*FilePager, error) {
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	numPages := uint64(stat.Size()) / PageSize
-
 	pager := &FilePager{
-		file:     file,
-		numPages: numPages,
-		freeList: NewFreeList(),
+		file:          file,
+		journal:       journal,
+		pageSize:      pageSize,
+		freeList:      NewFreeList(),
+		freeListPages: make(map[uint64][]byte),
 	}
 
-	if numPages == 0 {
-		if err := pager.initializeFreeList(); err != nil {
-			file.Close()
-			return nil, err
-		}
+	if stat.Size() == 0 {
+		err = pager.initialize()
 	} else {
-		if err := pager.loadFreeList(); err != nil {
-			file.Close()
-			return nil, err
-		}
+		err = pager.load(stat.Size())
+	}
+	if err == nil {
+		err = journal.commit(pager.super.Sequence, pager.numPages)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
 	return pager, nil
 }
 
-// initializeFreeList creat new free list page (page 0)
-func (p *FilePager) initializeFreeList() error {
-	page := p.freeList.SerializeToPage()
+// initialize lays out a new file: the superblock in page 0, the free list in page 1
+func (p *FilePager) initialize() error {
+	// Allocate pages 0 and 1 without using AllocatePage (avoid recursion)
+	emptyPages := make([]byte, 2*p.pageSize)
+	if _, err := p.file.WriteAt(emptyPages, 0); err != nil {
+		return fmt.Errorf("failed to initialize file: %w", err)
+	}
+	p.numPages = 2
 
-	// Allocate page 0 without using AllocatePage (avoid recursion)
-	emptyPage := make([]byte, PageSize)
-	if _, err := p.file.WriteAt(emptyPage, 0); err != nil {
+	p.super = Superblock{Version: FormatVersion, PageSize: uint32(p.pageSize), FreeListPage: 1}
+	if err := p.saveFreeList(); err != nil {
 		return fmt.Errorf("failed to initialize free list: %w", err)
 	}
+	return p.writeSuperblock(p.super)
+}
 
-	p.numPages = 1
+// load reads the superblock and the free list of an existing file
+// The page size comes from the superblock, files written before it use 4 KB pages
+func (p *FilePager) load(fileSize int64) error {
+	header := make([]byte, superblockHeader)
+	if _, err := p.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("failed to read superblock: %w", err)
+	}
 
-	// Write free list data
-	return p.WritePageStruct(FreeListPageID, page)
-}
+	super, err := readSuperblock(header)
+	if errors.Is(err, errNoSuperblock) {
+		p.pageSize = DefaultPageSize
+		p.numPages = uint64(fileSize) / uint64(p.pageSize)
 
-// loadFreeList read free list from disk
-func (p *FilePager) loadFreeList() error {
-	page, err := p.ReadPageStruct(FreeListPageID)
+		data, err := p.ReadPage(SuperblockPageID)
+		if err != nil {
+			return fmt.Errorf("failed to read page 0: %w", err)
+		}
+		return p.upgrade(data)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read free list: %w", err)
+		return err
 	}
+	p.super = super
+	p.pageSize = int(super.PageSize)
+	p.numPages = uint64(fileSize) / uint64(p.pageSize)
+
+	return p.loadFreeList()
+}
 
+// upgrade moves the free list of a file written before the superblock out of
+// page 0 and writes the superblock there
+// The tree fields stay unset, the tree moves them from its metadata file
+func (p *FilePager) upgrade(data []byte) error {
+	page, err := DeserializePage(data)
+	if err != nil {
+		return err
+	}
 	freeList, err := DeserializeFreeList(page)
 	if err != nil {
-		return fmt.Errorf("failed to deserialize free list: %w", err)
+		return fmt.Errorf("%w: page 0 is neither a superblock nor a free list", ErrUnsupportedFormat)
+	}
+	p.freeList = freeList
+
+	// Page 0 still has the old free list until the superblock is written, a
+	// crash in between only leaks the new page
+	p.super = Superblock{Version: FormatVersion, PageSize: uint32(p.pageSize), FreeListPage: p.numPages}
+	p.numPages++
+	if err := p.saveFreeList(); err != nil {
+		return fmt.Errorf("failed to move free list: %w", err)
+	}
+	return p.writeSuperblock(p.super)
+}
+
+// loadFreeList read free list from disk, following its chain of pages
+func (p *FilePager) loadFreeList() error {
+	freeList := NewFreeList()
+	pageID := p.super.FreeListPage
+	for {
+		data, err := p.ReadPage(pageID)
+		if err != nil {
+			return fmt.Errorf("failed to read free list: %w", err)
+		}
+		page, err := DeserializePage(data)
+		if err != nil {
+			return fmt.Errorf("failed to read free list: %w", err)
+		}
+		if err := freeList.readPage(page); err != nil {
+			return fmt.Errorf("failed to deserialize free list page %d: %w", pageID, err)
+		}
+		p.freeListPages[pageID] = data
+
+		pageID = uint64(page.Header.NextPage)
+		if pageID == 0 {
+			break
+		}
+		if len(freeList.trunks) >= int(p.numPages) {
+			return fmt.Errorf("free list chain loops at page %d", pageID)
+		}
+		freeList.trunks = append(freeList.trunks, pageID)
 	}
 
 	p.freeList = freeList
@@ -86,15 +193,133 @@ func (p *FilePager) loadFreeList() error {
 }
 
 // saveFreeList write free list to disk
+// The chain is written back to front, so no page points to a trunk before
+// the trunk holds its part of the list
 func (p *FilePager) saveFreeList() error {
-	page := p.freeList.SerializeToPage()
-	return p.WritePageStruct(FreeListPageID, page)
+	pages := p.freeList.SerializeToPages(p.pageSize)
+	ids := append([]uint64{p.super.FreeListPage}, p.freeList.Trunks()...)
+
+	written := make(map[uint64][]byte, len(pages))
+	for i := len(pages) - 1; i >= 0; i-- {
+		data := pages[i].Serialize()
+		if !bytes.Equal(p.freeListPages[ids[i]], data) {
+			if err := p.WritePage(ids[i], data); err != nil {
+				return err
+			}
+		}
+		written[ids[i]] = data
+	}
+
+	p.freeListPages = written
+	return nil
+}
+
+// ClearFreeList empties the free list, trunk pages included, for VACUUM:
+// every page the tree does not reach is then either reused by it or cut off
+// with the end of the file
+func (p *FilePager) ClearFreeList() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.freeList = NewFreeList()
+	return p.saveFreeList()
+}
+
+// Truncate cuts the file to numPages pages and keeps the free list, which
+// must be empty, in freeListPage from then on
+// VACUUM calls it once every live page is below numPages
+func (p *FilePager) Truncate(numPages, freeListPage uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.freeList.IsEmpty() || len(p.freeList.trunks) > 0 {
+		return fmt.Errorf("cannot truncate with %d pages in the free list", p.freeList.Size())
+	}
+	if numPages > p.numPages {
+		return fmt.Errorf("cannot truncate %d pages to %d", p.numPages, numPages)
+	}
+	if freeListPage == SuperblockPageID || freeListPage >= numPages {
+		return fmt.Errorf("free list page %d out of bounds", freeListPage)
+	}
+	if p.snapshot.Load() != nil {
+		return fmt.Errorf("cannot truncate: %w", ErrSnapshotOpen)
+	}
+
+	// The superblock only names the new free-list page once it is written
+	if freeListPage != p.super.FreeListPage {
+		data := p.freeList.SerializeToPages(p.pageSize)[0].Serialize()
+		if err := p.WritePage(freeListPage, data); err != nil {
+			return fmt.Errorf("failed to move free list: %w", err)
+		}
+		p.freeListPages = map[uint64][]byte{freeListPage: data}
+	}
+
+	// Commit first: a rollback could not bring back the pages cut off
+	super := p.super
+	super.FreeListPage = freeListPage
+	if err := p.writeSuperblock(super); err != nil {
+		return err
+	}
+
+	if err := p.file.Truncate(int64(numPages) * int64(p.pageSize)); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	p.numPages = numPages
+	p.journal.truncate(numPages)
+	return nil
+}
+
+// Superblock returns the superblock of the file
+func (p *FilePager) Superblock() Superblock {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.super
+}
+
+// WriteSuperblock durably stores the tree fields of sb (root, order,
+// checkpoint LSN and catalog page) in the superblock
+// It commits the pages written since the last superblock write, which a
+// crash no longer rolls back
+func (p *FilePager) WriteSuperblock(sb Superblock) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	super := p.super
+	super.RootPage = sb.RootPage
+	super.Order = sb.Order
+	super.CheckpointLSN = sb.CheckpointLSN
+	super.CatalogPage = sb.CatalogPage
+	return p.writeSuperblock(super)
+}
+
+// writeSuperblock writes sb over the older copy of the superblock
+// Callers hold mu (or own the pager while opening it)
+func (p *FilePager) writeSuperblock(sb Superblock) error {
+	sb.Sequence = p.super.Sequence + 1
+	if err := p.preserve(SuperblockPageID); err != nil {
+		return err
+	}
+	if _, err := p.file.WriteAt(sb.encode(), superblockOffset(sb.Sequence)); err != nil {
+		return fmt.Errorf("failed to write superblock: %w", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync superblock: %w", err)
+	}
+
+	p.super = sb
+	return p.journal.commit(sb.Sequence, p.numPages)
 }
 
 // FreePage mark page is free and add into free list
 func (p *FilePager) FreePage(pageID uint64) error {
-	if pageID == FreeListPageID {
-		return fmt.Errorf("cannot free the free list page")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pageID == SuperblockPageID || pageID == p.super.FreeListPage || slices.Contains(p.freeList.Trunks(), pageID) {
+		return fmt.Errorf("cannot free page %d: it holds the superblock or the free list", pageID)
 	}
 
 	if pageID >= p.numPages {
@@ -110,16 +335,22 @@ func (p *FilePager) FreePage(pageID uint64) error {
 
 // FreeListSize trả về số lượng free pages
 func (p *FilePager) FreeListSize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.freeList.Size()
 }
 
 func (p *FilePager) ReadPage(id uint64) ([]byte, error) {
-	if id >= p.numPages {
+	if id >= p.NumPages() {
 		return nil, fmt.Errorf("page %d out of bounds", id)
 	}
+	return p.readFile(id)
+}
 
-	buf := make([]byte, PageSize)
-	offset := int64(id * PageSize)
+// readFile reads page id from the file, with no bounds check
+func (p *FilePager) readFile(id uint64) ([]byte, error) {
+	buf := make([]byte, p.pageSize)
+	offset := int64(id) * int64(p.pageSize)
 
 	_, err := p.file.ReadAt(buf, offset)
 	if err != nil {
@@ -130,11 +361,18 @@ func (p *FilePager) ReadPage(id uint64) ([]byte, error) {
 }
 
 func (p *FilePager) WritePage(id uint64, data []byte) error {
-	if len(data) != PageSize {
-		return fmt.Errorf("invalid page size: %d, expected %d", len(data), PageSize)
+	if len(data) != p.pageSize {
+		return fmt.Errorf("invalid page size: %d, expected %d", len(data), p.pageSize)
+	}
+
+	if err := p.preserve(id); err != nil {
+		return err
+	}
+	if err := p.journal.save(p, id); err != nil {
+		return err
 	}
 
-	offset := int64(id * PageSize)
+	offset := int64(id) * int64(p.pageSize)
 
 	_, err := p.file.WriteAt(data, offset)
 	if err != nil {
@@ -144,11 +382,55 @@ func (p *FilePager) WritePage(id uint64, data []byte) error {
 	return p.file.Sync()
 }
 
+// WritePages writes several pages with one journal fsync and one file fsync
+// instead of one each per page (see journal.go)
+func (p *FilePager) WritePages(pages map[uint64][]byte) error {
+	ids := make([]uint64, 0, len(pages))
+	for id, data := range pages {
+		if len(data) != p.pageSize {
+			return fmt.Errorf("invalid page size: %d, expected %d", len(data), p.pageSize)
+		}
+		if err := p.preserve(id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := p.journal.save(p, ids...); err != nil {
+		return err
+	}
+
+	for id, data := range pages {
+		if _, err := p.file.WriteAt(data, int64(id)*int64(p.pageSize)); err != nil {
+			return fmt.Errorf("failed to write page %d: %w", id, err)
+		}
+	}
+
+	return p.file.Sync()
+}
+
 func (p *FilePager) AllocatePage() (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Reuse a freed page if there is one
+	if pageID, ok := p.freeList.Pop(); ok {
+		if err := p.saveFreeList(); err != nil {
+			p.freeList.Push(pageID) // rollback
+			return 0, err
+		}
+
+		emptyPage := make([]byte, p.pageSize)
+		if err := p.WritePage(pageID, emptyPage); err != nil {
+			return 0, err
+		}
+
+		return pageID, nil
+	}
+
 	pageID := p.numPages
 	p.numPages++
 
-	emptyPage := make([]byte, PageSize)
+	emptyPage := make([]byte, p.pageSize)
 	if err := p.WritePage(pageID, emptyPage); err != nil {
 		p.numPages-- // rollback
 		return 0, err
@@ -157,7 +439,45 @@ func (p *FilePager) AllocatePage() (uint64, error) {
 	return pageID, nil
 }
 
+// Snapshot opens a snapshot of the pages as they are in the file now (see
+// PageSnapshot). The caller writes cached pages first and keeps writes out
+// until it returns. A file has at most one open snapshot
+func (p *FilePager) Snapshot() (*PageSnapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &PageSnapshot{
+		pager:    p,
+		numPages: p.numPages,
+		read:     make([]bool, p.numPages),
+		saved:    make(map[uint64][]byte),
+	}
+	if !p.snapshot.CompareAndSwap(nil, s) {
+		return nil, ErrSnapshotOpen
+	}
+	return s, nil
+}
+
+// preserve lets the open snapshot, if any, save page id before it is overwritten
+func (p *FilePager) preserve(id uint64) error {
+	if s := p.snapshot.Load(); s != nil {
+		return s.preserve(id)
+	}
+	return nil
+}
+
+// Flush fsyncs the database file
+// WritePage already syncs, so this only matters for callers batching writes
+func (p *FilePager) Flush() error {
+	return p.file.Sync()
+}
+
 func (p *FilePager) Close() error {
+	// The journal stays on disk: whatever it covers was not committed
+	if err := p.journal.close(); err != nil {
+		return fmt.Errorf("failed to close journal: %w", err)
+	}
+
 	if p.file != nil {
 		return p.file.Close()
 	}
@@ -188,7 +508,7 @@ func (p *FilePager) AllocatePageWithType(pageType PageType) (uint64, *Page, erro
 		return 0, nil, err
 	}
 
-	page := NewPage(pageType)
+	page := NewPage(pageType, p.pageSize)
 	if err := p.WritePageStruct(pageID, page); err != nil {
 		return 0, nil, err
 	}
@@ -196,6 +516,13 @@ func (p *FilePager) AllocatePageWithType(pageType PageType) (uint64, *Page, erro
 	return pageID, page, nil
 }
 
+// PageSize returns the page size of the file
+func (p *FilePager) PageSize() int {
+	return p.pageSize
+}
+
 func (p *FilePager) NumPages() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.numPages
 }
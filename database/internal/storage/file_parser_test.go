@@ -5,18 +5,19 @@ import (
 )
 
 func TestPageSerialization(t *testing.T) {
-	page := NewPage(PageTypeLeaf)
+	page := NewPage(PageTypeLeaf, DefaultPageSize)
 	page.Header.NumKeys = 10
 	page.Header.NextPage = 42
 	page.Header.Parent = 5
+	page.Header.PrevPage = 7
 
 	copy(page.Data, []byte("test data"))
 
 	// Serialize
 	serialized := page.Serialize()
 
-	if len(serialized) != PageSize {
-		t.Errorf("Serialized page size = %d, expected %d", len(serialized), PageSize)
+	if len(serialized) != DefaultPageSize {
+		t.Errorf("Serialized page size = %d, expected %d", len(serialized), DefaultPageSize)
 	}
 
 	// Deserialize
@@ -38,6 +39,9 @@ func TestPageSerialization(t *testing.T) {
 	if deserialized.Header.Parent != 5 {
 		t.Errorf("Parent = %d, expected 5", deserialized.Header.Parent)
 	}
+	if deserialized.Header.PrevPage != 7 {
+		t.Errorf("PrevPage = %d, expected 7", deserialized.Header.PrevPage)
+	}
 
 	// Verify data
 	if string(deserialized.Data[:9]) != "test data" {
@@ -46,17 +50,17 @@ func TestPageSerialization(t *testing.T) {
 }
 
 func TestPageTypes(t *testing.T) {
-	leaf := NewPage(PageTypeLeaf)
+	leaf := NewPage(PageTypeLeaf, DefaultPageSize)
 	if !leaf.IsLeaf() {
 		t.Error("IsLeaf() should return true")
 	}
 
-	internal := NewPage(PageTypeInternal)
+	internal := NewPage(PageTypeInternal, DefaultPageSize)
 	if !internal.IsInternal() {
 		t.Error("IsInternal() should return true")
 	}
 
-	free := NewPage(PageTypeFree)
+	free := NewPage(PageTypeFree, DefaultPageSize)
 	if !free.IsFree() {
 		t.Error("IsFree() should return true")
 	}
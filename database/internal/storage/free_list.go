@@ -6,8 +6,16 @@ import (
 )
 
 // FreeList manage deleted page for reused purpose
+//
+// The list is stored in a chain of pages linked by Header.NextPage: the
+// free-list page named by the superblock, then as many trunk pages as the IDs
+// need. Trunk pages are free pages borrowed from the list itself, so a long
+// list takes no extra space; they go back to it once the list shrinks
+//
+// Page layout (after the page header): [count: 4 bytes][page ID: 8 bytes]...
 type FreeList struct {
 	freePageIDs []uint64
+	trunks      []uint64 // pages after the first one holding the list, in chain order
 }
 
 // NewFreeList create new free list
@@ -43,30 +51,75 @@ func (fl *FreeList) Size() int {
 	return len(fl.freePageIDs)
 }
 
-// SerializeToPage change FreeList into Page to write to disk
-func (fl *FreeList) SerializeToPage() *Page {
-	page := NewPage(PageTypeFree)
+// Trunks returns the pages after the free-list page holding the list
+// They are not free for reuse while the list needs them
+func (fl *FreeList) Trunks() []uint64 {
+	return fl.trunks
+}
 
-	binary.LittleEndian.PutUint32(page.Data[0:4], uint32(len(fl.freePageIDs)))
+// balance borrows or returns trunk pages until the chain has as many pages
+// as the IDs need at perPage IDs per page
+func (fl *FreeList) balance(perPage int) {
+	for len(fl.freePageIDs) > (len(fl.trunks)+1)*perPage {
+		last := len(fl.freePageIDs) - 1
+		fl.trunks = append(fl.trunks, fl.freePageIDs[last])
+		fl.freePageIDs = fl.freePageIDs[:last]
+	}
 
-	offset := 4
-	for _, pageID := range fl.freePageIDs {
-		binary.LittleEndian.PutUint64(page.Data[offset:offset+8], pageID)
-		offset += 8
+	for len(fl.trunks) > 0 && len(fl.freePageIDs)+1 <= len(fl.trunks)*perPage {
+		last := len(fl.trunks) - 1
+		fl.freePageIDs = append(fl.freePageIDs, fl.trunks[last])
+		fl.trunks = fl.trunks[:last]
+	}
+}
+
+// SerializeToPages change FreeList into the chain of pages of pageSize bytes
+// to write to disk, borrowing or returning trunk pages first
+// pages[0] goes to the free-list page, pages[i] to Trunks()[i-1]
+func (fl *FreeList) SerializeToPages(pageSize int) []*Page {
+	perPage := MaxFreePageIDs(pageSize)
+	fl.balance(perPage)
+
+	pages := make([]*Page, len(fl.trunks)+1)
+	for i := range pages {
+		page := NewPage(PageTypeFree, pageSize)
+		if i < len(fl.trunks) {
+			page.Header.NextPage = uint32(fl.trunks[i])
+		}
+
+		ids := fl.freePageIDs[min(i*perPage, len(fl.freePageIDs)):min((i+1)*perPage, len(fl.freePageIDs))]
+		binary.LittleEndian.PutUint32(page.Data[0:4], uint32(len(ids)))
+		offset := 4
+		for _, pageID := range ids {
+			binary.LittleEndian.PutUint64(page.Data[offset:offset+8], pageID)
+			offset += 8
+		}
+		pages[i] = page
 	}
 
-	return page
+	return pages
 }
 
-// DeserializeFreeList read FreeList from Page
+// DeserializeFreeList read FreeList from a single Page
+// The pager reads lists spanning trunk pages by following the chain
 func DeserializeFreeList(page *Page) (*FreeList, error) {
-	if page.Header.PageType != PageTypeFree {
-		return nil, fmt.Errorf("invalid page type: %v, expected Free", page.Header.PageType)
+	fl := NewFreeList()
+	if err := fl.readPage(page); err != nil {
+		return nil, err
 	}
+	return fl, nil
+}
 
-	fl := NewFreeList()
+// readPage appends the IDs held by one page of the chain
+func (fl *FreeList) readPage(page *Page) error {
+	if page.Header.PageType != PageTypeFree {
+		return fmt.Errorf("invalid page type: %v, expected Free", page.Header.PageType)
+	}
 
 	count := binary.LittleEndian.Uint32(page.Data[0:4])
+	if int(count) > MaxFreePageIDs(page.Size()) {
+		return fmt.Errorf("free list page holds %d IDs, at most %d fit", count, MaxFreePageIDs(page.Size()))
+	}
 
 	offset := 4
 	for i := uint32(0); i < count; i++ {
@@ -75,10 +128,11 @@ func DeserializeFreeList(page *Page) (*FreeList, error) {
 		offset += 8
 	}
 
-	return fl, nil
+	return nil
 }
 
 // MaxFreePageIDs calculate max number of page IDs that can be store in one page
-func MaxFreePageIDs() int {
-	return (PageSize - PageHeaderSize - 4) / 8
+// of pageSize bytes
+func MaxFreePageIDs(pageSize int) int {
+	return (pageSize - PageHeaderSize - 4) / 8
 }
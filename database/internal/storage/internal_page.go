@@ -1,35 +1,58 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 )
 
-// InternalPage represents a B+ Tree internal node
-// Layout: [leftmost_ptr: 8 bytes][key1: 4 bytes][ptr1: 8 bytes][key2: 4 bytes][ptr2: 8 bytes]...
+// InternalPage represents a B+ Tree internal node with variable-length keys
+// Layout: [leftmost_ptr: 8 bytes][slot1: 2 bytes][slot2: 2 bytes]... free ...[entries]
+// Each slot is the offset of an entry [keySize: 2 bytes][key][ptr: 8 bytes];
+// entries are packed at the end of the page, slots are kept in key order
 // Structure: P0 | K1 P1 | K2 P2 | K3 P3 | ...
 // Where P0 is for keys < K1, P1 for [K1, K2), P2 for [K2, K3), etc.
 type InternalPage struct {
 	page *Page
+	cmp  Comparator
 }
 
 // InternalEntry represents a key-pointer pair
 type InternalEntry struct {
-	Key    uint32
+	Key    []byte
 	PageID uint64
 }
 
-// NewInternalPage creates a new internal page
+const (
+	leftmostPointerSize = 8
+	internalSlotSize    = 2
+	internalEntryHeader = 2 + 8 // keySize + ptr
+)
+
+// InternalEntrySize returns the bytes an entry with key takes, slot included
+func InternalEntrySize(key []byte) int {
+	return internalSlotSize + internalEntryHeader + len(key)
+}
+
+// MaxInternalEntrySize is the size of an entry with the largest allowed key
+const MaxInternalEntrySize = internalSlotSize + internalEntryHeader + MaxKeySize
+
+// NewInternalPage creates a new internal page ordering keys bytewise
 func NewInternalPage(page *Page) *InternalPage {
+	return NewInternalPageWithComparator(page, CompareBytes)
+}
+
+// NewInternalPageWithComparator creates a new internal page ordering keys with cmp
+func NewInternalPageWithComparator(page *Page, cmp Comparator) *InternalPage {
 	if page.Header.PageType != PageTypeInternal {
 		panic("page must be of type Internal")
 	}
-	return &InternalPage{page: page}
+	return &InternalPage{page: page, cmp: cmp}
 }
 
 // GetLeftmostPointer returns the leftmost child pointer (P0)
 func (ip *InternalPage) GetLeftmostPointer() (uint64, error) {
-	if len(ip.page.Data) < 8 {
+	if len(ip.page.Data) < leftmostPointerSize {
 		return 0, fmt.Errorf("insufficient data for leftmost pointer")
 	}
 	ptr := binary.LittleEndian.Uint64(ip.page.Data[0:8])
@@ -38,7 +61,7 @@ func (ip *InternalPage) GetLeftmostPointer() (uint64, error) {
 
 // SetLeftmostPointer sets the leftmost child pointer
 func (ip *InternalPage) SetLeftmostPointer(pageID uint64) error {
-	if len(ip.page.Data) < 8 {
+	if len(ip.page.Data) < leftmostPointerSize {
 		return fmt.Errorf("insufficient data for leftmost pointer")
 	}
 	binary.LittleEndian.PutUint64(ip.page.Data[0:8], pageID)
@@ -48,132 +71,225 @@ func (ip *InternalPage) SetLeftmostPointer(pageID uint64) error {
 // GetKeyPointer returns the key and pointer at index (0-based)
 // index 0 returns key[0] and pointer[1]
 // index i returns key[i] and pointer[i+1]
-func (ip *InternalPage) GetKeyPointer(index int) (uint32, uint64, error) {
+func (ip *InternalPage) GetKeyPointer(index int) ([]byte, uint64, error) {
 	if index < 0 || index >= int(ip.page.Header.NumKeys) {
-		return 0, 0, fmt.Errorf("index %d out of bounds", index)
+		return nil, 0, fmt.Errorf("index %d out of bounds", index)
 	}
 
-	// Offset: leftmost_ptr(8) + index * (key(4) + ptr(8))
-	offset := 8 + index*12
+	slot := leftmostPointerSize + index*internalSlotSize
+	offset := int(binary.LittleEndian.Uint16(ip.page.Data[slot : slot+2]))
+	if offset+internalEntryHeader > len(ip.page.Data) {
+		return nil, 0, fmt.Errorf("insufficient data at offset %d", offset)
+	}
 
-	if offset+12 > len(ip.page.Data) {
-		return 0, 0, fmt.Errorf("insufficient data at offset %d", offset)
+	keySize := int(binary.LittleEndian.Uint16(ip.page.Data[offset : offset+2]))
+	end := offset + 2 + keySize
+	if end+8 > len(ip.page.Data) {
+		return nil, 0, fmt.Errorf("insufficient data at offset %d", offset)
 	}
 
-	key := binary.LittleEndian.Uint32(ip.page.Data[offset : offset+4])
-	ptr := binary.LittleEndian.Uint64(ip.page.Data[offset+4 : offset+12])
+	key := bytes.Clone(ip.page.Data[offset+2 : end])
+	ptr := binary.LittleEndian.Uint64(ip.page.Data[end : end+8])
 
 	return key, ptr, nil
 }
 
+// Entries returns every key-pointer pair in key order
+func (ip *InternalPage) Entries() ([]InternalEntry, error) {
+	entries := make([]InternalEntry, 0, ip.page.Header.NumKeys)
+	for i := 0; i < int(ip.page.Header.NumKeys); i++ {
+		key, ptr, err := ip.GetKeyPointer(i)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, InternalEntry{Key: key, PageID: ptr})
+	}
+	return entries, nil
+}
+
+// setEntries rewrites the page with entries, keeping the leftmost pointer
+// Returns an error (leaving the page untouched) if they do not fit
+func (ip *InternalPage) setEntries(entries []InternalEntry) error {
+	needed := 0
+	for _, entry := range entries {
+		if len(entry.Key) > MaxKeySize {
+			return fmt.Errorf("key of %d bytes exceeds %d", len(entry.Key), MaxKeySize)
+		}
+		needed += InternalEntrySize(entry.Key)
+	}
+	if needed > ip.Capacity() {
+		return fmt.Errorf("internal page full: need %d bytes, have %d", needed, ip.Capacity())
+	}
+
+	offset := len(ip.page.Data)
+	for i, entry := range entries {
+		offset -= internalEntryHeader + len(entry.Key)
+		binary.LittleEndian.PutUint16(ip.page.Data[offset:offset+2], uint16(len(entry.Key)))
+		copy(ip.page.Data[offset+2:], entry.Key)
+		end := offset + 2 + len(entry.Key)
+		binary.LittleEndian.PutUint64(ip.page.Data[end:end+8], entry.PageID)
+
+		slot := leftmostPointerSize + i*internalSlotSize
+		binary.LittleEndian.PutUint16(ip.page.Data[slot:slot+2], uint16(offset))
+	}
+
+	ip.page.Header.NumKeys = uint16(len(entries))
+	return nil
+}
+
 // SetKeyPointer sets key and pointer at index
-func (ip *InternalPage) SetKeyPointer(index int, key uint32, pageID uint64) error {
-	// Allow setting at NumKeys position for insertion
+// index NumKeys appends an entry
+func (ip *InternalPage) SetKeyPointer(index int, key []byte, pageID uint64) error {
 	if index < 0 || index > int(ip.page.Header.NumKeys) {
 		return fmt.Errorf("index %d out of bounds", index)
 	}
 
-	offset := 8 + index*12
-
-	if offset+12 > len(ip.page.Data) {
-		return fmt.Errorf("insufficient data at offset %d", offset)
+	entries, err := ip.Entries()
+	if err != nil {
+		return err
 	}
 
-	binary.LittleEndian.PutUint32(ip.page.Data[offset:offset+4], key)
-	binary.LittleEndian.PutUint64(ip.page.Data[offset+4:offset+12], pageID)
+	entry := InternalEntry{Key: key, PageID: pageID}
+	if index == len(entries) {
+		entries = append(entries, entry)
+	} else {
+		entries[index] = entry
+	}
 
-	return nil
+	return ip.setEntries(entries)
 }
 
 // InsertEntry inserts a key-pointer pair at the correct position
-func (ip *InternalPage) InsertEntry(key uint32, pageID uint64) error {
-	// Check space (12 bytes per entry = 4 bytes key + 8 bytes pointer)
-	maxEntries := (len(ip.page.Data) - 8) / 12 // -8 for leftmost ptr
-	if int(ip.page.Header.NumKeys) >= maxEntries {
+// Returns an error if the page has no room for it
+func (ip *InternalPage) InsertEntry(key []byte, pageID uint64) error {
+	if !ip.Fits(key) {
 		return fmt.Errorf("internal page full")
 	}
 
+	entries, err := ip.Entries()
+	if err != nil {
+		return err
+	}
+
 	// Find insert position (keep keys sorted)
 	insertPos := ip.findInsertPosition(key)
+	entries = append(entries[:insertPos], append([]InternalEntry{{Key: key, PageID: pageID}}, entries[insertPos:]...)...)
+
+	return ip.setEntries(entries)
+}
 
-	// Shift entries to make room
-	for i := int(ip.page.Header.NumKeys) - 1; i >= insertPos; i-- {
-		k, p, _ := ip.GetKeyPointer(i)
-		ip.SetKeyPointer(i+1, k, p)
+// RemoveEntry removes key[index] and pointer[index+1], shifting later entries left
+func (ip *InternalPage) RemoveEntry(index int) error {
+	if index < 0 || index >= int(ip.page.Header.NumKeys) {
+		return fmt.Errorf("index %d out of bounds", index)
 	}
 
-	// Insert new entry
-	ip.SetKeyPointer(insertPos, key, pageID)
-	ip.page.Header.NumKeys++
+	entries, err := ip.Entries()
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return ip.setEntries(append(entries[:index], entries[index+1:]...))
 }
 
-// findInsertPosition finds where to insert key to maintain sorted order
-func (ip *InternalPage) findInsertPosition(key uint32) int {
-	for i := 0; i < int(ip.page.Header.NumKeys); i++ {
-		k, _, err := ip.GetKeyPointer(i)
-		if err != nil {
-			break
-		}
-		if key < k {
-			return i
-		}
+// SetKey replaces the key at index, keeping its pointer
+// Returns an error if a longer key does not fit
+func (ip *InternalPage) SetKey(index int, key []byte) error {
+	_, ptr, err := ip.GetKeyPointer(index)
+	if err != nil {
+		return err
 	}
-	return int(ip.page.Header.NumKeys)
+	return ip.SetKeyPointer(index, key, ptr)
 }
 
-// SearchChild finds the child page ID for a given key
-// Logic: For keys [K1, K2, K3] and pointers [P0, P1, P2, P3]
-// - key < K1 → P0
-// - K1 <= key < K2 → P1
-// - K2 <= key < K3 → P2
-// - key >= K3 → P3
-func (ip *InternalPage) SearchChild(key uint32) (uint64, error) {
-	// If no keys, return leftmost pointer
-	if ip.page.Header.NumKeys == 0 {
+// GetChild returns the child pointer at index
+// index 0 returns the leftmost pointer, index i returns pointer[i]
+func (ip *InternalPage) GetChild(index int) (uint64, error) {
+	if index == 0 {
 		return ip.GetLeftmostPointer()
 	}
+	_, ptr, err := ip.GetKeyPointer(index - 1)
+	return ptr, err
+}
 
-	// Search through keys to find the correct child
-	for i := 0; i < int(ip.page.Header.NumKeys); i++ {
-		k, ptr, err := ip.GetKeyPointer(i)
+// SetChild replaces the child pointer at index, keeping its key
+// index 0 sets the leftmost pointer, index i sets pointer[i]
+func (ip *InternalPage) SetChild(index int, pageID uint64) error {
+	if index == 0 {
+		return ip.SetLeftmostPointer(pageID)
+	}
+	key, _, err := ip.GetKeyPointer(index - 1)
+	if err != nil {
+		return err
+	}
+	return ip.SetKeyPointer(index-1, key, pageID)
+}
+
+// FindChildIndex returns the index of a child pointer, or -1 if not present
+func (ip *InternalPage) FindChildIndex(pageID uint64) int {
+	for i := 0; i <= int(ip.page.Header.NumKeys); i++ {
+		ptr, err := ip.GetChild(i)
 		if err != nil {
-			return 0, err
+			return -1
 		}
-
-		// If search key < current key, return appropriate pointer
-		if key < k {
-			if i == 0 {
-				// key < K1, return P0 (leftmost)
-				return ip.GetLeftmostPointer()
-			}
-			// This case shouldn't happen because we already processed previous keys
-			// But for safety, return leftmost
-			return ip.GetLeftmostPointer()
+		if ptr == pageID {
+			return i
 		}
+	}
+	return -1
+}
 
-		// If this is the last key
-		if i == int(ip.page.Header.NumKeys)-1 {
-			// key >= last key, return last pointer
-			return ptr, nil
-		}
+// findInsertPosition finds where to insert key to maintain sorted order
+func (ip *InternalPage) findInsertPosition(key []byte) int {
+	return ip.ChildIndex(key)
+}
 
-		// Check next key to determine range
-		nextKey, _, err := ip.GetKeyPointer(i + 1)
+// ChildIndex returns the index of the child whose range holds key,
+// i.e. the number of separators <= key
+func (ip *InternalPage) ChildIndex(key []byte) int {
+	left, right := 0, int(ip.page.Header.NumKeys)
+	for left < right {
+		mid := (left + right) / 2
+		separator, _, err := ip.GetKeyPointer(mid)
 		if err != nil {
-			// If error reading next key, return current pointer
-			return ptr, nil
+			return left
+		}
+		if ip.cmp(separator, key) <= 0 {
+			left = mid + 1
+		} else {
+			right = mid
 		}
+	}
+	return left
+}
 
-		// If key is in range [Ki, Ki+1), return Pi
-		if key >= k && key < nextKey {
-			return ptr, nil
+// ChildIndexBefore returns the index of the child holding the keys just
+// below key, i.e. the number of separators < key
+func (ip *InternalPage) ChildIndexBefore(key []byte) int {
+	left, right := 0, int(ip.page.Header.NumKeys)
+	for left < right {
+		mid := (left + right) / 2
+		separator, _, err := ip.GetKeyPointer(mid)
+		if err != nil {
+			return left
+		}
+		if ip.cmp(separator, key) < 0 {
+			left = mid + 1
+		} else {
+			right = mid
 		}
 	}
+	return left
+}
 
-	// Fallback: should not reach here, but return leftmost for safety
-	return ip.GetLeftmostPointer()
+// SearchChild finds the child page ID for a given key
+// Logic: For keys [K1, K2, K3] and pointers [P0, P1, P2, P3]
+// - key < K1 → P0
+// - K1 <= key < K2 → P1
+// - K2 <= key < K3 → P2
+// - key >= K3 → P3
+func (ip *InternalPage) SearchChild(key []byte) (uint64, error) {
+	return ip.GetChild(ip.ChildIndex(key))
 }
 
 // NumKeys returns number of keys
@@ -181,7 +297,36 @@ func (ip *InternalPage) NumKeys() int {
 	return int(ip.page.Header.NumKeys)
 }
 
+// Capacity returns bytes available for slots and entries in an empty page
+func (ip *InternalPage) Capacity() int {
+	return len(ip.page.Data) - leftmostPointerSize
+}
+
+// UsedSpace returns bytes used by slots and entries
+func (ip *InternalPage) UsedSpace() int {
+	used := 0
+	for i := 0; i < int(ip.page.Header.NumKeys); i++ {
+		key, _, err := ip.GetKeyPointer(i)
+		if err != nil {
+			break
+		}
+		used += InternalEntrySize(key)
+	}
+	return used
+}
+
+// AvailableSpace returns free space in bytes
+func (ip *InternalPage) AvailableSpace() int {
+	return ip.Capacity() - ip.UsedSpace()
+}
+
+// Fits reports whether an entry with key can be inserted without splitting
+func (ip *InternalPage) Fits(key []byte) bool {
+	return ip.AvailableSpace() >= InternalEntrySize(key)
+}
+
 // String returns string representation
 func (ip *InternalPage) String() string {
-	return fmt.Sprintf("InternalPage{NumKeys: %d}", ip.page.Header.NumKeys)
+	return fmt.Sprintf("InternalPage{NumKeys: %d, AvailableSpace: %d bytes}",
+		ip.page.Header.NumKeys, ip.AvailableSpace())
 }
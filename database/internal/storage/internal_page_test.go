@@ -5,7 +5,7 @@ import (
 )
 
 func TestInternalPageInsertAndSearch(t *testing.T) {
-	page := NewPage(PageTypeInternal)
+	page := NewPage(PageTypeInternal, DefaultPageSize)
 	internalPage := NewInternalPage(page)
 
 	// Set leftmost pointer
@@ -24,7 +24,7 @@ func TestInternalPageInsertAndSearch(t *testing.T) {
 	}
 
 	for _, entry := range entries {
-		if err := internalPage.InsertEntry(entry.key, entry.pageID); err != nil {
+		if err := internalPage.InsertEntry(Uint32Key(entry.key), entry.pageID); err != nil {
 			t.Fatalf("Failed to insert entry: %v", err)
 		}
 	}
@@ -47,7 +47,7 @@ func TestInternalPageInsertAndSearch(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		pageID, err := internalPage.SearchChild(tc.key)
+		pageID, err := internalPage.SearchChild(Uint32Key(tc.key))
 		if err != nil {
 			t.Errorf("SearchChild(%d) failed: %v", tc.key, err)
 		}
@@ -56,3 +56,120 @@ func TestInternalPageInsertAndSearch(t *testing.T) {
 		}
 	}
 }
+
+func TestInternalPageRemoveEntry(t *testing.T) {
+	page := NewPage(PageTypeInternal, DefaultPageSize)
+	internalPage := NewInternalPage(page)
+
+	internalPage.SetLeftmostPointer(100)
+	internalPage.InsertEntry(Uint32Key(50), 101)
+	internalPage.InsertEntry(Uint32Key(100), 102)
+	internalPage.InsertEntry(Uint32Key(150), 103)
+
+	if idx := internalPage.FindChildIndex(102); idx != 2 {
+		t.Errorf("FindChildIndex(102) = %d, expected 2", idx)
+	}
+
+	// Remove key 100 and its right pointer
+	if err := internalPage.RemoveEntry(1); err != nil {
+		t.Fatalf("RemoveEntry failed: %v", err)
+	}
+
+	if internalPage.NumKeys() != 2 {
+		t.Errorf("NumKeys = %d, expected 2", internalPage.NumKeys())
+	}
+	if idx := internalPage.FindChildIndex(102); idx != -1 {
+		t.Errorf("FindChildIndex(102) = %d after remove, expected -1", idx)
+	}
+
+	key, ptr, _ := internalPage.GetKeyPointer(1)
+	if k, _ := KeyToUint32(key); k != 150 || ptr != 103 {
+		t.Errorf("Entry 1 = (%d, %d), expected (150, 103)", k, ptr)
+	}
+
+	internalPage.SetKey(0, Uint32Key(60))
+	if child, _ := internalPage.SearchChild(Uint32Key(55)); child != 100 {
+		t.Errorf("SearchChild(55) = %d, expected 100", child)
+	}
+}
+
+func TestInternalPageVariableKeys(t *testing.T) {
+	page := NewPage(PageTypeInternal, DefaultPageSize)
+	internalPage := NewInternalPage(page)
+	internalPage.SetLeftmostPointer(100)
+
+	// Keys of different lengths sort bytewise
+	for i, key := range []string{"user:10", "order", "user:2", "user:100"} {
+		if err := internalPage.InsertEntry([]byte(key), uint64(101+i)); err != nil {
+			t.Fatalf("Failed to insert %q: %v", key, err)
+		}
+	}
+
+	expected := []string{"order", "user:10", "user:100", "user:2"}
+	entries, err := internalPage.Entries()
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	for i, entry := range entries {
+		if string(entry.Key) != expected[i] {
+			t.Errorf("Entry %d = %q, expected %q", i, entry.Key, expected[i])
+		}
+	}
+
+	searches := map[string]uint64{
+		"a":        100, // < "order"
+		"order":    102,
+		"user:1":   102, // between "order" and "user:10"
+		"user:101": 104,
+		"user:3":   103,
+	}
+	for key, expectedPageID := range searches {
+		if child, _ := internalPage.SearchChild([]byte(key)); child != expectedPageID {
+			t.Errorf("SearchChild(%q) = %d, expected %d", key, child, expectedPageID)
+		}
+	}
+
+	// Space is accounted in bytes and the page rejects entries once full
+	used := 0
+	for _, key := range expected {
+		used += InternalEntrySize([]byte(key))
+	}
+	if internalPage.UsedSpace() != used {
+		t.Errorf("UsedSpace = %d, expected %d", internalPage.UsedSpace(), used)
+	}
+
+	big := make([]byte, MaxKeySize)
+	inserted := 0
+	for {
+		big[0] = byte(inserted)
+		if err := internalPage.InsertEntry(big, 200); err != nil {
+			break
+		}
+		inserted++
+	}
+	if inserted == 0 || internalPage.Fits(big) {
+		t.Errorf("Inserted %d max-size keys, page still fits one: %v", inserted, internalPage.Fits(big))
+	}
+
+	t.Logf("✓ Variable-length separators stored in %d bytes", internalPage.UsedSpace())
+}
+
+func TestShortestSeparator(t *testing.T) {
+	tests := []struct {
+		left, right, expected string
+	}{
+		{"apple", "banana", "b"},
+		{"user:1", "user:2", "user:2"},
+		{"user:19", "user:2", "user:2"},
+		{"abc", "abd", "abd"},
+		{"ab", "abc", "abc"},
+		{"alpha", "beta", "b"},
+	}
+
+	for _, tt := range tests {
+		got := ShortestSeparator([]byte(tt.left), []byte(tt.right))
+		if string(got) != tt.expected {
+			t.Errorf("ShortestSeparator(%q, %q) = %q, expected %q", tt.left, tt.right, got, tt.expected)
+		}
+	}
+}
@@ -6,16 +6,23 @@ import (
 )
 
 // LeafPage represents a B+ Tree leaf node with slot-based layout
+// Records are kept sorted by key in comparator order
 type LeafPage struct {
 	page *Page
+	cmp  Comparator
 }
 
-// NewLeafPage creates a new leaf page
+// NewLeafPage creates a new leaf page ordering keys bytewise
 func NewLeafPage(page *Page) *LeafPage {
+	return NewLeafPageWithComparator(page, CompareBytes)
+}
+
+// NewLeafPageWithComparator creates a new leaf page ordering keys with cmp
+func NewLeafPageWithComparator(page *Page, cmp Comparator) *LeafPage {
 	if page.Header.PageType != PageTypeLeaf {
 		panic("page must be of type Leaf")
 	}
-	return &LeafPage{page: page}
+	return &LeafPage{page: page, cmp: cmp}
 }
 
 // SlotOffset returns the offset of a record in the data area
@@ -103,11 +110,6 @@ func (lp *LeafPage) InsertRecord(record *Record) error {
 
 // findInsertPosition finds where to insert record to maintain sorted order
 func (lp *LeafPage) findInsertPosition(record *Record) int {
-	key, err := record.GetKeyAsUint32()
-	if err != nil {
-		return int(lp.page.Header.NumKeys) // Insert at end if key is not uint32
-	}
-
 	// Binary search
 	left, right := 0, int(lp.page.Header.NumKeys)
 	for left < right {
@@ -117,12 +119,7 @@ func (lp *LeafPage) findInsertPosition(record *Record) int {
 			return int(lp.page.Header.NumKeys)
 		}
 
-		midKey, err := midRecord.GetKeyAsUint32()
-		if err != nil {
-			return int(lp.page.Header.NumKeys)
-		}
-
-		if midKey < key {
+		if lp.cmp(midRecord.Key, record.Key) < 0 {
 			left = mid + 1
 		} else {
 			right = mid
@@ -145,31 +142,134 @@ func (lp *LeafPage) GetRecord(index int) (*Record, error) {
 
 // SearchRecord searches for a record by key (binary search)
 // Returns (record, found)
-func (lp *LeafPage) SearchRecord(key uint32) (*Record, bool) {
+func (lp *LeafPage) SearchRecord(key []byte) (*Record, bool) {
+	index, found := lp.findRecordIndex(key)
+	if !found {
+		return nil, false
+	}
+
+	record, err := lp.GetRecord(index)
+	if err != nil {
+		return nil, false
+	}
+
+	return record, true
+}
+
+// findRecordIndex returns the slot index of a key (binary search)
+// Returns (index, found)
+func (lp *LeafPage) findRecordIndex(key []byte) (int, bool) {
 	left, right := 0, int(lp.page.Header.NumKeys)
 
 	for left < right {
 		mid := (left + right) / 2
 		record, err := lp.GetRecord(mid)
 		if err != nil {
-			return nil, false
-		}
-
-		recordKey, err := record.GetKeyAsUint32()
-		if err != nil {
-			return nil, false
+			return 0, false
 		}
 
-		if recordKey == key {
-			return record, true
-		} else if recordKey < key {
+		order := lp.cmp(record.Key, key)
+		if order == 0 {
+			return mid, true
+		} else if order < 0 {
 			left = mid + 1
 		} else {
 			right = mid
 		}
 	}
 
-	return nil, false
+	return 0, false
+}
+
+// DeleteRecord removes the record with the given key and compacts the page
+// Returns true if the key was found
+func (lp *LeafPage) DeleteRecord(key []byte) bool {
+	index, found := lp.findRecordIndex(key)
+	if !found {
+		return false
+	}
+
+	records, err := lp.GetAllRecords()
+	if err != nil {
+		return false
+	}
+	records = append(records[:index], records[index+1:]...)
+
+	// Rebuild page so the record area stays contiguous
+	lp.Clear()
+	for _, record := range records {
+		// Cannot fail: remaining records fitted before the delete
+		lp.InsertRecord(record)
+	}
+
+	return true
+}
+
+// UpdateRecord replaces the value of the record with the given key
+// Returns (found, error); an error means the page cannot hold the new value
+func (lp *LeafPage) UpdateRecord(key, value []byte) (bool, error) {
+	return lp.ReplaceRecord(NewRecord(key, value))
+}
+
+// ReplaceRecord replaces the record with the same key as record
+// The record is rewritten in place when it fits in its old space, otherwise it is
+// relocated into free space, compacting the page first if needed
+// Returns (found, error); an error means the page cannot hold the new record
+func (lp *LeafPage) ReplaceRecord(record *Record) (bool, error) {
+	index, found := lp.findRecordIndex(record.Key)
+	if !found {
+		return false, nil
+	}
+
+	oldRecord, err := lp.GetRecord(index)
+	if err != nil {
+		return true, err
+	}
+
+	recordSize := record.Size()
+
+	// In-place rewrite (leftover bytes are reclaimed on the next compaction)
+	if recordSize <= oldRecord.Size() {
+		offset := int(lp.getSlotOffset(index))
+		copy(lp.page.Data[offset:offset+recordSize], record.Serialize())
+		return true, nil
+	}
+
+	// Relocate into free space and point the slot at the new copy
+	if lp.AvailableSpace() >= recordSize {
+		offset := lp.freeSpaceEnd() - recordSize
+		copy(lp.page.Data[offset:offset+recordSize], record.Serialize())
+		lp.setSlotOffset(index, uint16(offset))
+		return true, nil
+	}
+
+	// Compact the page and retry
+	records, err := lp.GetAllRecords()
+	if err != nil {
+		return true, err
+	}
+	records[index] = record
+
+	needed := 0
+	for _, r := range records {
+		needed += r.Size() + 2 // +2 for slot
+	}
+	if needed > lp.Capacity() {
+		return true, fmt.Errorf("leaf page full: need %d bytes, have %d", needed, lp.Capacity())
+	}
+
+	lp.Clear()
+	for _, r := range records {
+		lp.InsertRecord(r)
+	}
+
+	return true, nil
+}
+
+// Clear removes all records from the page
+func (lp *LeafPage) Clear() {
+	lp.page.Header.NumKeys = 0
+	binary.LittleEndian.PutUint16(lp.page.Data[0:2], 0)
 }
 
 // GetAllRecords returns all records in sorted order
@@ -185,6 +285,16 @@ func (lp *LeafPage) GetAllRecords() ([]*Record, error) {
 	return records, nil
 }
 
+// UsedSpace returns bytes used by slots and records
+func (lp *LeafPage) UsedSpace() int {
+	return lp.Capacity() - lp.AvailableSpace()
+}
+
+// Capacity returns bytes available for slots and records in an empty page
+func (lp *LeafPage) Capacity() int {
+	return len(lp.page.Data) - 2 // -2 for numSlots
+}
+
 // IsFull checks if page is full (less than threshold free space)
 func (lp *LeafPage) IsFull(threshold int) bool {
 	return lp.AvailableSpace() < threshold
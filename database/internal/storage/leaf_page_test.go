@@ -5,7 +5,7 @@ import (
 )
 
 func TestLeafPageInsertAndSearch(t *testing.T) {
-	page := NewPage(PageTypeLeaf)
+	page := NewPage(PageTypeLeaf, DefaultPageSize)
 	leafPage := NewLeafPage(page)
 
 	// Insert records
@@ -42,7 +42,7 @@ func TestLeafPageInsertAndSearch(t *testing.T) {
 	}
 
 	// Test search
-	record, found := leafPage.SearchRecord(100)
+	record, found := leafPage.SearchRecord(Uint32Key(100))
 	if !found {
 		t.Error("Record with key 100 not found")
 	}
@@ -51,14 +51,14 @@ func TestLeafPageInsertAndSearch(t *testing.T) {
 	}
 
 	// Test search not found
-	_, found = leafPage.SearchRecord(999)
+	_, found = leafPage.SearchRecord(Uint32Key(999))
 	if found {
 		t.Error("Should not find record with key 999")
 	}
 }
 
 func TestLeafPageFull(t *testing.T) {
-	page := NewPage(PageTypeLeaf)
+	page := NewPage(PageTypeLeaf, DefaultPageSize)
 	leafPage := NewLeafPage(page)
 
 	// Insert until full
@@ -79,3 +79,87 @@ func TestLeafPageFull(t *testing.T) {
 		t.Errorf("Page should fit at least 10 records, only fit %d", i)
 	}
 }
+
+func TestLeafPageDelete(t *testing.T) {
+	page := NewPage(PageTypeLeaf, DefaultPageSize)
+	leafPage := NewLeafPage(page)
+
+	for _, key := range []uint32{10, 20, 30, 40} {
+		if err := leafPage.InsertRecord(NewRecordFromInts(key, "value")); err != nil {
+			t.Fatalf("Failed to insert record: %v", err)
+		}
+	}
+
+	usedBefore := leafPage.UsedSpace()
+
+	if !leafPage.DeleteRecord(Uint32Key(20)) {
+		t.Fatal("DeleteRecord(Uint32Key(20)) should find the key")
+	}
+	if leafPage.DeleteRecord(Uint32Key(20)) {
+		t.Error("DeleteRecord(Uint32Key(20)) twice should not find the key")
+	}
+
+	if leafPage.NumRecords() != 3 {
+		t.Errorf("NumRecords = %d, expected 3", leafPage.NumRecords())
+	}
+
+	// Space of the deleted record and its slot is reclaimed
+	recordSize := NewRecordFromInts(20, "value").Size()
+	if leafPage.UsedSpace() != usedBefore-recordSize-2 {
+		t.Errorf("UsedSpace = %d, expected %d", leafPage.UsedSpace(), usedBefore-recordSize-2)
+	}
+
+	if _, found := leafPage.SearchRecord(Uint32Key(20)); found {
+		t.Error("Deleted key 20 still found")
+	}
+	for _, key := range []uint32{10, 30, 40} {
+		if _, found := leafPage.SearchRecord(Uint32Key(key)); !found {
+			t.Errorf("Key %d not found after delete", key)
+		}
+	}
+}
+
+func TestLeafPageUpdate(t *testing.T) {
+	page := NewPage(PageTypeLeaf, DefaultPageSize)
+	leafPage := NewLeafPage(page)
+
+	for _, key := range []uint32{10, 20, 30} {
+		if err := leafPage.InsertRecord(NewRecordFromInts(key, "medium")); err != nil {
+			t.Fatalf("Failed to insert record: %v", err)
+		}
+	}
+
+	// Shorter value is rewritten in place
+	found, err := leafPage.UpdateRecord(Uint32Key(20), []byte("tiny"))
+	if !found || err != nil {
+		t.Fatalf("UpdateRecord(20) = (%v, %v)", found, err)
+	}
+
+	// Longer value is relocated
+	found, err = leafPage.UpdateRecord(Uint32Key(10), []byte("a much longer value"))
+	if !found || err != nil {
+		t.Fatalf("UpdateRecord(10) = (%v, %v)", found, err)
+	}
+
+	found, _ = leafPage.UpdateRecord(Uint32Key(99), []byte("missing"))
+	if found {
+		t.Error("UpdateRecord(99) should not find the key")
+	}
+
+	expected := map[uint32]string{10: "a much longer value", 20: "tiny", 30: "medium"}
+	for key, value := range expected {
+		record, found := leafPage.SearchRecord(Uint32Key(key))
+		if !found {
+			t.Fatalf("Key %d not found", key)
+		}
+		if record.GetValueAsString() != value {
+			t.Errorf("Key %d: value = %s, expected %s", key, record.GetValueAsString(), value)
+		}
+	}
+
+	// Value larger than the page is rejected
+	huge := make([]byte, DefaultPageSize)
+	if _, err := leafPage.UpdateRecord(Uint32Key(30), huge); err == nil {
+		t.Error("Expected error for value larger than page")
+	}
+}
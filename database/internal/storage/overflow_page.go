@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Overflow chains
+//
+// A value larger than MaxInlineValueSize(pageSize) is not kept in its leaf record but
+// in a chain of overflow pages linked by Header.NextPage (0 ends the chain).
+// The record stores an overflow reference instead of the value and flags it
+// in the high bit of its ValueSize (see Record)
+//
+// Overflow page layout (after the page header): [chunkSize: 2 bytes][chunk]
+// Reference layout: [valueSize: 4 bytes][first page: 8 bytes]
+
+// OverflowRefSize is the size of an overflow reference
+const OverflowRefSize = 12
+
+// MaxInlineValueSize returns the largest value kept in a leaf record of a
+// file with pages of pageSize bytes, so a leaf always holds a few records
+func MaxInlineValueSize(pageSize int) int {
+	return pageSize / 4
+}
+
+// OverflowChunkSize returns the part of a value one overflow page holds
+func OverflowChunkSize(pageSize int) int {
+	return pageSize - PageHeaderSize - 2
+}
+
+// OverflowPage is one page of an overflow chain
+type OverflowPage struct {
+	page *Page
+}
+
+// NewOverflowPage wraps a page of type Overflow
+func NewOverflowPage(page *Page) *OverflowPage {
+	if page.Header.PageType != PageTypeOverflow {
+		panic("page must be of type Overflow")
+	}
+	return &OverflowPage{page: page}
+}
+
+// Chunk returns the part of the value held by this page
+func (op *OverflowPage) Chunk() ([]byte, error) {
+	size := int(binary.LittleEndian.Uint16(op.page.Data[0:2]))
+	if size > len(op.page.Data)-2 {
+		return nil, fmt.Errorf("overflow chunk of %d bytes exceeds %d", size, len(op.page.Data)-2)
+	}
+	return op.page.Data[2 : 2+size], nil
+}
+
+// SetChunk stores as much of data as fits and returns the bytes stored
+func (op *OverflowPage) SetChunk(data []byte) int {
+	n := copy(op.page.Data[2:], data)
+	binary.LittleEndian.PutUint16(op.page.Data[0:2], uint16(n))
+	return n
+}
+
+// Next returns the next page of the chain, 0 for the last one
+func (op *OverflowPage) Next() uint64 {
+	return uint64(op.page.Header.NextPage)
+}
+
+// SetNext links the page to the next page of the chain
+func (op *OverflowPage) SetNext(pageID uint64) {
+	op.page.Header.NextPage = uint32(pageID)
+}
+
+// EncodeOverflowRef returns the reference to a value of size bytes whose
+// chain starts at firstPage
+func EncodeOverflowRef(size int, firstPage uint64) []byte {
+	ref := make([]byte, OverflowRefSize)
+	binary.LittleEndian.PutUint32(ref[0:4], uint32(size))
+	binary.LittleEndian.PutUint64(ref[4:12], firstPage)
+	return ref
+}
+
+// DecodeOverflowRef returns the value size and first page of a reference
+func DecodeOverflowRef(ref []byte) (int, uint64, error) {
+	if len(ref) != OverflowRefSize {
+		return 0, 0, fmt.Errorf("overflow reference of %d bytes, expected %d", len(ref), OverflowRefSize)
+	}
+	return int(binary.LittleEndian.Uint32(ref[0:4])), binary.LittleEndian.Uint64(ref[4:12]), nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrSnapshotOpen is returned when a file already has an open page snapshot,
+// or is truncated while it has one
+var ErrSnapshotOpen = errors.New("page snapshot already open")
+
+// PageSnapshot is a frozen view of the pages of a file while writes go on
+//
+// The pager keeps it copy-on-write: before a page the snapshot has not read
+// yet is overwritten (the superblock included) its old image is saved in
+// memory, so ReadPage returns the page as it was when the snapshot was
+// taken. Pages allocated past the end of the file are not in the snapshot.
+// Each page can be read once; memory grows with the pages overwritten
+// before they are read, so the snapshot is best read front to back at once
+type PageSnapshot struct {
+	pager    *FilePager
+	numPages uint64
+
+	mu    sync.Mutex
+	read  []bool            // pages already returned by ReadPage
+	saved map[uint64][]byte // old images of pages overwritten before they were read
+}
+
+// NumPages returns the number of pages of the file when the snapshot was taken
+func (s *PageSnapshot) NumPages() uint64 {
+	return s.numPages
+}
+
+// PageSize returns the page size of the file
+func (s *PageSnapshot) PageSize() int {
+	return s.pager.pageSize
+}
+
+// ReadPage returns page id as it was when the snapshot was taken
+func (s *PageSnapshot) ReadPage(id uint64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id >= s.numPages {
+		return nil, fmt.Errorf("page %d out of bounds", id)
+	}
+	if s.read[id] {
+		return nil, fmt.Errorf("page %d already read", id)
+	}
+
+	data, ok := s.saved[id]
+	if !ok {
+		// Writers save the page before overwriting it, which waits for mu
+		var err error
+		if data, err = s.pager.readFile(id); err != nil {
+			return nil, err
+		}
+	}
+
+	s.read[id] = true
+	delete(s.saved, id)
+	return data, nil
+}
+
+// Close releases the snapshot, writes stop saving old images
+func (s *PageSnapshot) Close() {
+	s.pager.snapshot.CompareAndSwap(s, nil)
+
+	s.mu.Lock()
+	s.saved = nil
+	s.mu.Unlock()
+}
+
+// preserve saves the current image of page id unless the snapshot does not
+// need it, called before the page is overwritten
+func (s *PageSnapshot) preserve(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id >= s.numPages || s.read[id] || s.saved == nil {
+		return nil
+	}
+	if _, ok := s.saved[id]; ok {
+		return nil
+	}
+
+	data, err := s.pager.readFile(id)
+	if err != nil {
+		return fmt.Errorf("failed to save page %d for snapshot: %w", id, err)
+	}
+	s.saved[id] = data
+	return nil
+}
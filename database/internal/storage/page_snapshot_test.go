@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPageSnapshot(t *testing.T) {
+	dbFile := "test_page_snapshot.db"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + JournalSuffix)
+
+	pager, err := NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	page := func(b byte) []byte {
+		data := make([]byte, DefaultPageSize)
+		data[0] = b
+		return data
+	}
+
+	ids := make([]uint64, 3)
+	for i := range ids {
+		if ids[i], err = pager.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		if err := pager.WritePage(ids[i], page(byte(i+1))); err != nil {
+			t.Fatalf("Failed to write page: %v", err)
+		}
+	}
+	if err := pager.WriteSuperblock(Superblock{RootPage: ids[0], Order: 100, CheckpointLSN: 1}); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+
+	// Cached pages reach the file before the snapshot is taken
+	bp := NewBufferPool(pager, 10)
+	if err := bp.WritePage(ids[2], page(3)); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+	if err := pager.WritePage(ids[2], page(0xFF)); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+	snap, err := bp.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	if _, err := pager.Snapshot(); !errors.Is(err, ErrSnapshotOpen) {
+		t.Errorf("Expected ErrSnapshotOpen, got %v", err)
+	}
+	if err := pager.Truncate(pager.NumPages(), 1); !errors.Is(err, ErrSnapshotOpen) {
+		t.Errorf("Expected ErrSnapshotOpen from Truncate, got %v", err)
+	}
+
+	// Writes after the snapshot do not change what it reads
+	if err := pager.WritePage(ids[0], page(0xAA)); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+	if err := pager.WritePage(ids[0], page(0xBB)); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+	if err := pager.WriteSuperblock(Superblock{RootPage: ids[1], Order: 100, CheckpointLSN: 2}); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+	if _, err := pager.AllocatePage(); err != nil {
+		t.Fatalf("Failed to allocate page: %v", err)
+	}
+	if snap.NumPages() != pager.NumPages()-1 {
+		t.Errorf("Snapshot has %d pages, file %d", snap.NumPages(), pager.NumPages())
+	}
+
+	for i, id := range ids {
+		data, err := snap.ReadPage(id)
+		if err != nil {
+			t.Fatalf("Failed to read page %d: %v", id, err)
+		}
+		if data[0] != byte(i+1) {
+			t.Errorf("Page %d reads %#x, expected %#x", id, data[0], i+1)
+		}
+	}
+	data, err := snap.ReadPage(SuperblockPageID)
+	if err != nil {
+		t.Fatalf("Failed to read superblock: %v", err)
+	}
+	if super, err := readSuperblock(data); err != nil || super.RootPage != ids[0] || super.CheckpointLSN != 1 {
+		t.Errorf("Snapshot superblock %+v (err=%v)", super, err)
+	}
+	if _, err := snap.ReadPage(ids[0]); err == nil {
+		t.Error("Expected error reading a page twice")
+	}
+
+	// Pages read already are no longer saved
+	if err := pager.WritePage(ids[1], page(0xCC)); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+	if len(snap.saved) != 0 {
+		t.Errorf("Snapshot keeps %d saved pages", len(snap.saved))
+	}
+	t.Log("✓ Snapshot reads pages as of when it was taken")
+
+	snap.Close()
+	if snap, err = pager.Snapshot(); err != nil {
+		t.Fatalf("Failed to open snapshot after close: %v", err)
+	}
+	snap.Close()
+}
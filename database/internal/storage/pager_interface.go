@@ -8,8 +8,42 @@ type Pager interface {
 	WritePage(id uint64, data []byte) error
 	// AllocatePage allocate a new page and return ID
 	AllocatePage() (uint64, error)
+	// FreePage return a page to the free list for reuse
+	FreePage(id uint64) error
+	// NumPages returns the number of pages in the file
+	NumPages() uint64
+	// ClearFreeList empties the free list, for VACUUM
+	ClearFreeList() error
+	// Truncate cuts the file to numPages pages once VACUUM moved every live
+	// page below, keeping the empty free list in freeListPage
+	Truncate(numPages, freeListPage uint64) error
+	// Flush persists all written pages to disk
+	Flush() error
+	// Snapshot writes cached pages and opens a snapshot of the pages of the
+	// file that later writes do not change (see PageSnapshot)
+	Snapshot() (*PageSnapshot, error)
+	// Superblock returns the superblock of the file
+	Superblock() Superblock
+	// WriteSuperblock durably stores the tree fields of sb in the superblock
+	WriteSuperblock(sb Superblock) error
 	// Close closes database file
 	Close() error
+
+	// PageSize returns the size of every page of the file in bytes
+	PageSize() int
 }
 
-const PageSize = 4096
\ No newline at end of file
+const (
+	// DefaultPageSize is the page size of new files unless one is chosen
+	DefaultPageSize = 4096
+	// MinPageSize and MaxPageSize bound the page sizes a file can use, slot
+	// offsets in leaf and internal pages are 16 bits
+	MinPageSize = 4096
+	MaxPageSize = 32768
+)
+
+// ValidPageSize reports whether size is a supported page size: a power of
+// two from MinPageSize to MaxPageSize (4, 8, 16 or 32 KB)
+func ValidPageSize(size int) bool {
+	return size >= MinPageSize && size <= MaxPageSize && size&(size-1) == 0
+}
\ No newline at end of file
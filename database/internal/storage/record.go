@@ -7,11 +7,37 @@ import (
 
 // Record stand for a KV record
 // Format: [KeySize: 4 bytes][Key: variable][ValueSize: 4 bytes][Value: variable]
+//
+// A record written while snapshots are open also keeps older versions. Its
+// KeySize has the versioned bit set and the value is followed by
+// [CommitTS: 8 bytes][Flags: 1 byte][NumVersions: 2 bytes] and the older
+// versions, newest first, each [CommitTS: 8][Flags: 1][ValueSize: 4][Value]
+//
+// A ValueSize with the overflow bit set holds an overflow reference instead
+// of the value (see overflow_page.go)
 type Record struct {
-	Key   []byte
-	Value []byte
+	Key      []byte
+	Value    []byte
+	Overflow bool      // Value is an overflow reference
+	CommitTS uint64    // commit timestamp of Value, 0 = visible to every snapshot
+	Deleted  bool      // the newest version is a delete (tombstone)
+	History  []Version // older versions, newest first
 }
 
+// Version is one committed value of a record
+type Version struct {
+	CommitTS uint64
+	Deleted  bool
+	Value    []byte
+	Overflow bool // Value is an overflow reference
+}
+
+const (
+	versionedFlag = uint32(1) << 31 // set in KeySize of versioned records
+	overflowFlag  = uint32(1) << 31 // set in ValueSize of overflow references
+	deletedFlag   = byte(1)         // version is a tombstone
+)
+
 func NewRecord(key, value []byte) *Record {
 	return &Record{
 		Key:   key,
@@ -19,18 +45,73 @@ func NewRecord(key, value []byte) *Record {
 	}
 }
 
+// NewRecordFromInts creates a record whose key is a number (see Uint32Key)
 func NewRecordFromInts(key uint32, value string) *Record {
-	keyBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(keyBytes, key)
 	return &Record{
-		Key:   keyBytes,
+		Key:   Uint32Key(key),
 		Value: []byte(value),
 	}
 }
 
+// NewVersionedRecord builds a record from its versions, newest first
+func NewVersionedRecord(key []byte, versions []Version) *Record {
+	record := &Record{
+		Key:      key,
+		Value:    versions[0].Value,
+		Overflow: versions[0].Overflow,
+		CommitTS: versions[0].CommitTS,
+		Deleted:  versions[0].Deleted,
+	}
+	if len(versions) > 1 {
+		record.History = versions[1:]
+	}
+	return record
+}
+
+// Versions returns every version of the record, newest first
+func (r *Record) Versions() []Version {
+	versions := make([]Version, 0, 1+len(r.History))
+	versions = append(versions, Version{CommitTS: r.CommitTS, Deleted: r.Deleted, Value: r.Value, Overflow: r.Overflow})
+	return append(versions, r.History...)
+}
+
+// Versioned reports whether the record carries a timestamp, a tombstone or
+// older versions, i.e. is stored in the versioned format
+func (r *Record) Versioned() bool {
+	return r.CommitTS != 0 || r.Deleted || len(r.History) > 0
+}
+
+// VisibleAt returns the value of the newest version committed at or before ts
+// Returns false if the key did not exist or was deleted as of ts
+func (r *Record) VisibleAt(ts uint64) ([]byte, bool) {
+	version, ok := r.VersionAt(ts)
+	return version.Value, ok
+}
+
+// VersionAt returns the newest version committed at or before ts
+// Returns false if the key did not exist or was deleted as of ts
+func (r *Record) VersionAt(ts uint64) (Version, bool) {
+	for _, version := range r.Versions() {
+		if version.CommitTS <= ts {
+			return version, !version.Deleted
+		}
+	}
+	return Version{}, false
+}
+
 func (r *Record) Size() int {
 	// 4 bytes keySize + key + 4 bytes valueSize + value
-	return 4 + len(r.Key) + 4 + len(r.Value)
+	size := 4 + len(r.Key) + 4 + len(r.Value)
+	if !r.Versioned() {
+		return size
+	}
+
+	// commitTS + flags + numVersions, then each older version
+	size += 8 + 1 + 2
+	for _, version := range r.History {
+		size += 8 + 1 + 4 + len(version.Value)
+	}
+	return size
 }
 
 func (r *Record) Serialize() []byte {
@@ -40,7 +121,11 @@ func (r *Record) Serialize() []byte {
 	offset := 0
 
 	// Write key size
-	binary.LittleEndian.PutUint32(buf[offset:offset+4], uint32(len(r.Key)))
+	keySize := uint32(len(r.Key))
+	if r.Versioned() {
+		keySize |= versionedFlag
+	}
+	binary.LittleEndian.PutUint32(buf[offset:offset+4], keySize)
 	offset += 4
 
 	// Write key
@@ -48,15 +133,51 @@ func (r *Record) Serialize() []byte {
 	offset += len(r.Key)
 
 	// Write value size
-	binary.LittleEndian.PutUint32(buf[offset:offset+4], uint32(len(r.Value)))
+	binary.LittleEndian.PutUint32(buf[offset:offset+4], valueSize(r.Value, r.Overflow))
 	offset += 4
 
 	// Write value
 	copy(buf[offset:offset+len(r.Value)], r.Value)
+	offset += len(r.Value)
+
+	if !r.Versioned() {
+		return buf
+	}
+
+	// Write version chain
+	binary.LittleEndian.PutUint64(buf[offset:offset+8], r.CommitTS)
+	buf[offset+8] = versionFlags(r.Deleted)
+	binary.LittleEndian.PutUint16(buf[offset+9:offset+11], uint16(len(r.History)))
+	offset += 11
+
+	for _, version := range r.History {
+		binary.LittleEndian.PutUint64(buf[offset:offset+8], version.CommitTS)
+		buf[offset+8] = versionFlags(version.Deleted)
+		binary.LittleEndian.PutUint32(buf[offset+9:offset+13], valueSize(version.Value, version.Overflow))
+		offset += 13
+		copy(buf[offset:offset+len(version.Value)], version.Value)
+		offset += len(version.Value)
+	}
 
 	return buf
 }
 
+// valueSize returns the ValueSize field of a value, flagging overflow references
+func valueSize(value []byte, overflow bool) uint32 {
+	size := uint32(len(value))
+	if overflow {
+		size |= overflowFlag
+	}
+	return size
+}
+
+func versionFlags(deleted bool) byte {
+	if deleted {
+		return deletedFlag
+	}
+	return 0
+}
+
 func DeserializeRecord(data []byte) (*Record, int, error) {
 	if len(data) < 8 {
 		return nil, 0, fmt.Errorf("insufficient data for record header")
@@ -68,6 +189,9 @@ func DeserializeRecord(data []byte) (*Record, int, error) {
 	keySize := binary.LittleEndian.Uint32(data[offset : offset+4])
 	offset += 4
 
+	versioned := keySize&versionedFlag != 0
+	keySize &^= versionedFlag
+
 	if offset+int(keySize) > len(data) {
 		return nil, 0, fmt.Errorf("insufficient data for key")
 	}
@@ -85,6 +209,9 @@ func DeserializeRecord(data []byte) (*Record, int, error) {
 	valueSize := binary.LittleEndian.Uint32(data[offset : offset+4])
 	offset += 4
 
+	overflow := valueSize&overflowFlag != 0
+	valueSize &^= overflowFlag
+
 	if offset+int(valueSize) > len(data) {
 		return nil, 0, fmt.Errorf("insufficient data for value")
 	}
@@ -94,17 +221,56 @@ func DeserializeRecord(data []byte) (*Record, int, error) {
 	copy(value, data[offset:offset+int(valueSize)])
 	offset += int(valueSize)
 
-	return &Record{
-		Key:   key,
-		Value: value,
-	}, offset, nil
+	record := &Record{
+		Key:      key,
+		Value:    value,
+		Overflow: overflow,
+	}
+	if !versioned {
+		return record, offset, nil
+	}
+
+	// Read version chain
+	if offset+11 > len(data) {
+		return nil, 0, fmt.Errorf("insufficient data for version header")
+	}
+	record.CommitTS = binary.LittleEndian.Uint64(data[offset : offset+8])
+	record.Deleted = data[offset+8]&deletedFlag != 0
+	numVersions := int(binary.LittleEndian.Uint16(data[offset+9 : offset+11]))
+	offset += 11
+
+	for i := 0; i < numVersions; i++ {
+		if offset+13 > len(data) {
+			return nil, 0, fmt.Errorf("insufficient data for version %d", i)
+		}
+		version := Version{
+			CommitTS: binary.LittleEndian.Uint64(data[offset : offset+8]),
+			Deleted:  data[offset+8]&deletedFlag != 0,
+		}
+		size := binary.LittleEndian.Uint32(data[offset+9 : offset+13])
+		version.Overflow = size&overflowFlag != 0
+		size &^= overflowFlag
+		offset += 13
+
+		if offset+int(size) > len(data) {
+			return nil, 0, fmt.Errorf("insufficient data for version %d value", i)
+		}
+		version.Value = make([]byte, size)
+		copy(version.Value, data[offset:offset+int(size)])
+		offset += int(size)
+
+		record.History = append(record.History, version)
+	}
+
+	return record, offset, nil
 }
 
 func (r *Record) GetKeyAsUint32() (uint32, error) {
-	if len(r.Key) != 4 {
+	key, ok := KeyToUint32(r.Key)
+	if !ok {
 		return 0, fmt.Errorf("key is not 4 bytes")
 	}
-	return binary.LittleEndian.Uint32(r.Key), nil
+	return key, nil
 }
 
 func (r *Record) GetValueAsString() string {
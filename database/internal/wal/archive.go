@@ -0,0 +1,227 @@
+package wal
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// WAL archiving
+//
+// A checkpoint drops the WAL entries its pages cover. With archiving on they
+// are first written to a segment file in the archive directory, path +
+// ArchiveSuffix, in the WAL file format and named after the first and last
+// LSN it holds. The segments are the log of the database from the first
+// archived entry on: with a backup taken after it they can bring the
+// database to any later point (see bptree.RestoreToPoint)
+//
+// A crash between archiving and truncating the WAL archives the same entries
+// again, so segments may overlap; readers skip entries they already have
+
+// ArchiveSuffix is appended to the WAL path to name its archive directory
+const ArchiveSuffix = ".archive"
+
+// Segment is an archived file of WAL entries
+type Segment struct {
+	Path     string
+	FirstLSN uint64
+	LastLSN  uint64
+}
+
+// EnableArchive creates the archive directory, entries dropped from then on
+// are archived. The WAL finds the directory again when it is opened
+func (w *WAL) EnableArchive() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dir := w.path + ArchiveSuffix
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create WAL archive: %w", err)
+	}
+	w.archive = dir
+	return nil
+}
+
+// ArchiveDir returns the archive directory, "" if archiving is off
+func (w *WAL) ArchiveDir() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.archive
+}
+
+// archiveBefore writes the entries with LSN <= lsn to a new segment if
+// archiving is on, caller must hold w.mu
+func (w *WAL) archiveBefore(lsn uint64) error {
+	if w.archive == "" || lsn < w.firstLSN {
+		return nil
+	}
+
+	entries, err := w.readAllLocked()
+	if err != nil {
+		return err
+	}
+	end := 0
+	for end < len(entries) && entries[end].LSN <= lsn {
+		end++
+	}
+	if end == 0 {
+		return nil
+	}
+
+	if err := WriteSegment(w.archive, entries[:end]); err != nil {
+		return fmt.Errorf("failed to archive WAL: %w", err)
+	}
+	return nil
+}
+
+// WriteSegment writes entries, in LSN order, to a new segment of the archive
+// directory dir. The file only appears once it is complete
+func WriteSegment(dir string, entries []*Entry) error {
+	path := filepath.Join(dir, segmentName(entries[0].LSN, entries[len(entries)-1].LSN))
+	if err := WriteFile(path+".tmp", entries); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// segmentName returns the file name of the segment holding first to last
+func segmentName(first, last uint64) string {
+	return fmt.Sprintf("%020d-%020d.wal", first, last)
+}
+
+// ListSegments returns the segments of an archive directory in LSN order
+// Other files in the directory are ignored
+func ListSegments(dir string) ([]Segment, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL archive: %w", err)
+	}
+
+	segments := make([]Segment, 0, len(files))
+	for _, file := range files {
+		var first, last uint64
+		if file.IsDir() {
+			continue
+		}
+		if _, err := fmt.Sscanf(file.Name(), "%d-%d.wal", &first, &last); err != nil {
+			continue
+		}
+		if file.Name() != segmentName(first, last) || first > last {
+			continue
+		}
+		segments = append(segments, Segment{Path: filepath.Join(dir, file.Name()), FirstLSN: first, LastLSN: last})
+	}
+
+	slices.SortFunc(segments, func(a, b Segment) int {
+		return cmp.Or(cmp.Compare(a.FirstLSN, b.FirstLSN), cmp.Compare(a.LastLSN, b.LastLSN))
+	})
+	return segments, nil
+}
+
+// ReadFile reads the entries of a WAL file or segment without opening it for
+// writing, stopping at the first torn or corrupt record like NewWAL
+func ReadFile(path string) ([]*Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	header := make([]byte, FileHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+	if err := checkFileHeader(header); err == errLegacyFormat {
+		return nil, fmt.Errorf("%s: %w", path, ErrUnsupportedVersion)
+	} else if err != nil {
+		return nil, err
+	}
+
+	entries, _, err := scanRecords(file, info.Size()-FileHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// ReadArchive returns the archived entries with LSN > after in LSN order,
+// and the number of segments read
+// Entries in more than one segment are returned once; a missing range is
+// not an error, the caller checks the LSNs follow each other
+func ReadArchive(dir string, after uint64) ([]*Entry, int, error) {
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]*Entry, 0)
+	last, read := after, 0
+	for _, segment := range segments {
+		if segment.LastLSN <= last {
+			continue
+		}
+		segmentEntries, err := ReadFile(segment.Path)
+		if err != nil {
+			return nil, read, err
+		}
+		read++
+
+		for _, entry := range segmentEntries {
+			if entry.LSN > last {
+				entries = append(entries, entry)
+				last = entry.LSN
+			}
+		}
+	}
+
+	return entries, read, nil
+}
+
+// TrimArchive drops the archived entries with LSN > lsn: segments after lsn
+// are removed, one holding lsn is cut after it
+// Used when a restore makes the entries after lsn a history the database
+// no longer has. Returns the number of segments removed or cut
+func TrimArchive(dir string, lsn uint64) (int, error) {
+	segments, err := ListSegments(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	trimmed := 0
+	for _, segment := range segments {
+		if segment.LastLSN <= lsn {
+			continue
+		}
+
+		if segment.FirstLSN <= lsn {
+			entries, err := ReadFile(segment.Path)
+			if err != nil {
+				return trimmed, err
+			}
+			end := 0
+			for end < len(entries) && entries[end].LSN <= lsn {
+				end++
+			}
+			if end > 0 {
+				if err := WriteSegment(dir, entries[:end]); err != nil {
+					return trimmed, fmt.Errorf("failed to cut segment: %w", err)
+				}
+			}
+		}
+
+		if err := os.Remove(segment.Path); err != nil {
+			return trimmed, fmt.Errorf("failed to remove segment: %w", err)
+		}
+		trimmed++
+	}
+
+	return trimmed, nil
+}
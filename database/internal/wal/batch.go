@@ -0,0 +1,91 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// BatchOp is a single write inside an OpBatch record
+type BatchOp struct {
+	OpType OpType // OpInsert, OpUpdate or OpDelete
+	Key    []byte
+	Value  string
+}
+
+// batchOpHeaderSize is [opType 1][keySize 2][valueSize 4] per packed write
+const batchOpHeaderSize = 7
+
+// NewBatchEntry packs writes into one OpBatch entry, so they are framed,
+// checksummed and fsynced as a single record
+//
+//	Value: [count 4] then per write [opType 1][keySize 2][key][valueSize 4][value]
+func NewBatchEntry(ops []BatchOp) *Entry {
+	size := 4
+	for _, op := range ops {
+		size += batchOpHeaderSize + len(op.Key) + len(op.Value)
+	}
+
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(ops)))
+
+	offset := 4
+	for _, op := range ops {
+		data[offset] = byte(op.OpType)
+		binary.LittleEndian.PutUint16(data[offset+1:offset+3], uint16(len(op.Key)))
+		offset += 3
+		copy(data[offset:], op.Key)
+		offset += len(op.Key)
+		binary.LittleEndian.PutUint32(data[offset:offset+4], uint32(len(op.Value)))
+		copy(data[offset+4:], op.Value)
+		offset += 4 + len(op.Value)
+	}
+
+	return &Entry{
+		OpType: OpBatch,
+		Value:  string(data),
+	}
+}
+
+// BatchOps unpacks the writes of an OpBatch entry
+func (e *Entry) BatchOps() ([]BatchOp, error) {
+	if e.OpType != OpBatch {
+		return nil, fmt.Errorf("entry is not a batch: op %d", e.OpType)
+	}
+
+	data := []byte(e.Value)
+	if len(data) < 4 {
+		return nil, fmt.Errorf("batch record too short: %d bytes", len(data))
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[0:4]))
+	ops := make([]BatchOp, 0, min(count, len(data)/batchOpHeaderSize))
+
+	offset := 4
+	for i := 0; i < count; i++ {
+		if offset+batchOpHeaderSize > len(data) {
+			return nil, fmt.Errorf("batch record truncated at write %d", i)
+		}
+
+		keySize := int(binary.LittleEndian.Uint16(data[offset+1 : offset+3]))
+		keyEnd := offset + 3 + keySize
+		if keyEnd+4 > len(data) {
+			return nil, fmt.Errorf("batch record truncated at write %d", i)
+		}
+
+		valueSize := int(binary.LittleEndian.Uint32(data[keyEnd : keyEnd+4]))
+		end := keyEnd + 4 + valueSize
+		if end > len(data) {
+			return nil, fmt.Errorf("batch record truncated at write %d", i)
+		}
+
+		ops = append(ops, BatchOp{
+			OpType: OpType(data[offset]),
+			Key:    bytes.Clone(data[offset+3 : keyEnd]),
+			Value:  string(data[keyEnd+4 : end]),
+		})
+		offset = end
+	}
+
+	return ops, nil
+}
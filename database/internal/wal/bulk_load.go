@@ -0,0 +1,12 @@
+package wal
+
+// NewBulkLoadEntry returns the marker of a bulk load
+//
+// A bulk load writes its pages directly and logs this single record instead
+// of one per row; its LSN is the commit timestamp of the loaded rows. The
+// loader checkpoints before and after it, so replay never rebuilds a load
+// from the log: a marker only notes that the pages of its table changed
+// outside of it (Key and Value unused)
+func NewBulkLoadEntry() *Entry {
+	return &Entry{OpType: OpBulkLoad}
+}
@@ -1,11 +1,12 @@
 package wal
 
 import (
-	"encoding/binary"
+	"bufio"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
 )
 
 // OpType represents the type of operation
@@ -15,73 +16,232 @@ const (
 	OpInsert OpType = 0x01
 	OpDelete OpType = 0x02
 	OpUpdate OpType = 0x03
+
+	// Transaction control records (Key and Value unused)
+	OpBegin  OpType = 0x04
+	OpCommit OpType = 0x05
+	OpAbort  OpType = 0x06
+
+	// OpBatch packs several writes into one record (see batch.go)
+	OpBatch OpType = 0x07
+
+	// OpBulkLoad marks pages written outside the log (see NewBulkLoadEntry)
+	OpBulkLoad OpType = 0x08
+
+	// OpCatalog records a catalog change (see NewCatalogEntry)
+	OpCatalog OpType = 0x09
 )
 
 // Entry represents a single WAL entry
 type Entry struct {
 	OpType OpType
-	Key    uint32
+	TxID   uint64 // Transaction the entry belongs to, 0 for auto-committed writes
+	Table  uint32 // Table (B+ tree) written to, 0 for the default table
+	Key    []byte
 	Value  string
+	LSN    uint64 // Log sequence number, assigned by Append
+	Time   int64  // Wall clock time of the append in Unix nanoseconds, assigned by Append (0 if upgraded from a legacy WAL)
 }
 
 // WAL represents a Write-Ahead Log
+//
+// Every appended entry gets a log sequence number (LSN). LSNs grow by one
+// per entry and keep growing across truncations, so a checkpoint can record
+// "everything up to LSN n is on disk" and drop exactly those entries
+//
+// Records are checksummed (see format.go). Opening a WAL keeps every record
+// up to the first torn or corrupt one and truncates the rest of the file
+//
+// Appends use group commit: callers enqueue entries, a single flusher
+// goroutine writes the queued batch with one fsync and then releases every
+// caller of that batch (see group_commit.go). SyncNormal and SyncOff trade
+// that fsync for a bounded or OS-defined loss window (see sync_mode.go)
 type WAL struct {
-	file  *os.File
-	mu    sync.Mutex
-	path  string
-	syncs int // Counter for fsync operations
+	file      *os.File
+	mu        sync.Mutex
+	path      string
+	syncs     int    // Counter for fsync operations
+	firstLSN  uint64 // LSN of the first entry in the file
+	nextLSN   uint64 // LSN assigned to the next appended entry
+	discarded int64  // Bytes of torn/corrupt tail dropped when opening
+	size      int64  // Bytes of records in the file, kept by the append path
+	lastTime  int64  // Time of the last appended entry
+	archive   string // Directory keeping dropped entries, "" if not archiving
+
+	requests    chan *appendRequest // Appends waiting for the flusher
+	flusherDone chan struct{}       // Closed when the flusher exits
+	closeMu     sync.RWMutex        // Held for reading while enqueueing, for writing by Close
+	closed      bool
+	commitDelay time.Duration
+	groupCommit groupCommitCounters
+
+	syncMode     SyncMode
+	syncInterval time.Duration // SyncNormal fsync interval
+	syncTicker   *time.Ticker  // Drives SyncNormal fsyncs in the flusher
+	lastSync     time.Time
+	unsynced     bool   // Records were written since the last fsync
+	writtenLSN   uint64 // LSN of the last record written to the file
+	durableLSN   uint64 // Records up to this LSN are fsynced or covered by a checkpoint
 }
 
-// NewWAL creates a new WAL file
+// NewWAL creates a new WAL file or opens an existing one
+// A torn or corrupt tail left by a crash is truncated (see DiscardedBytes)
+// Entries are archived if path + ArchiveSuffix is a directory (see archive.go)
 func NewWAL(path string) (*WAL, error) {
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open WAL file: %w", err)
 	}
 
-	return &WAL{
-		file:  file,
-		path:  path,
-		syncs: 0,
-	}, nil
+	w := &WAL{
+		file:         file,
+		path:         path,
+		syncs:        0,
+		firstLSN:     1,
+		nextLSN:      1,
+		requests:     make(chan *appendRequest, MaxBatchSize),
+		flusherDone:  make(chan struct{}),
+		syncMode:     SyncFull,
+		syncInterval: DefaultSyncInterval,
+		lastSync:     time.Now(),
+	}
+	if info, err := os.Stat(path + ArchiveSuffix); err == nil && info.IsDir() {
+		w.archive = path + ArchiveSuffix
+	}
+
+	if err := w.recover(); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	// Records that survived reopening are on disk
+	w.writtenLSN = w.nextLSN - 1
+	w.durableLSN = w.writtenLSN
+	if err := w.statSize(); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	w.syncTicker = time.NewTicker(w.syncInterval)
+	go w.flusher()
+
+	return w, nil
 }
 
-// Append writes an entry to the WAL
-func (w *WAL) Append(entry *Entry) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
+// recover validates the file header and drops everything after the last valid record
+func (w *WAL) recover() error {
+	info, err := w.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat WAL: %w", err)
+	}
+	size := info.Size()
+
+	// Empty file, or crash while writing the header
+	if size < FileHeaderSize {
+		w.discarded = size
+		return w.writeFileHeader()
+	}
 
-	// Serialize entry
-	data := w.serializeEntry(entry)
+	header := make([]byte, FileHeaderSize)
+	if _, err := w.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("failed to read WAL header: %w", err)
+	}
 
-	// Write to file
-	if _, err := w.file.Write(data); err != nil {
-		return fmt.Errorf("failed to write WAL entry: %w", err)
+	if err := checkFileHeader(header); err == errLegacyFormat {
+		return w.upgradeLegacy(size)
+	} else if err != nil {
+		return err
 	}
 
-	// Flush to disk (fsync)
-	if err := w.file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync WAL: %w", err)
+	if _, err := w.file.Seek(FileHeaderSize, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek WAL: %w", err)
+	}
+
+	entries, valid, err := scanRecords(w.file, size-FileHeaderSize)
+	if err != nil {
+		return err
+	}
+
+	if end := FileHeaderSize + valid; end < size {
+		if err := w.file.Truncate(end); err != nil {
+			return fmt.Errorf("failed to truncate torn WAL tail: %w", err)
+		}
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync WAL: %w", err)
+		}
+		w.discarded = size - end
+	}
+
+	if len(entries) > 0 {
+		w.firstLSN = entries[0].LSN
+		w.nextLSN = entries[len(entries)-1].LSN + 1
+		w.lastTime = entries[len(entries)-1].Time
 	}
 
-	w.syncs++
 	return nil
 }
 
-// serializeEntry converts an entry to bytes
-func (w *WAL) serializeEntry(entry *Entry) []byte {
-	valueBytes := []byte(entry.Value)
-	valueSize := uint32(len(valueBytes))
+// writeFileHeader resets the file to just the header
+func (w *WAL) writeFileHeader() error {
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+	if _, err := w.file.Write(encodeFileHeader()); err != nil {
+		return fmt.Errorf("failed to write WAL header: %w", err)
+	}
+	return w.file.Sync()
+}
 
-	// Total size: 1 (opType) + 4 (key) + 4 (valueSize) + len(value)
-	data := make([]byte, 9+valueSize)
+// upgradeLegacy rewrites a WAL from before checksummed records in the current format
+func (w *WAL) upgradeLegacy(size int64) error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek WAL: %w", err)
+	}
 
-	data[0] = byte(entry.OpType)
-	binary.LittleEndian.PutUint32(data[1:5], entry.Key)
-	binary.LittleEndian.PutUint32(data[5:9], valueSize)
-	copy(data[9:], valueBytes)
+	entries, err := scanLegacyEntries(w.file, size)
+	if err != nil {
+		return err
+	}
 
-	return data
+	if err := w.rewrite(entries); err != nil {
+		return fmt.Errorf("failed to upgrade legacy WAL: %w", err)
+	}
+
+	w.nextLSN = uint64(len(entries)) + 1
+	return nil
+}
+
+// Append writes an entry to the WAL and assigns its LSN
+// Returns once the entry is written (and fsynced in SyncFull); concurrent
+// appends share one write and fsync
+func (w *WAL) Append(entry *Entry) error {
+	return w.append(entry, true)
+}
+
+// AppendWithoutSync writes an entry without waiting for an fsync
+// Used for records that only matter once a later synced record (a
+// transaction's COMMIT) is durable, since that fsync covers them too
+func (w *WAL) AppendWithoutSync(entry *Entry) error {
+	return w.append(entry, false)
+}
+
+// append hands an entry to the flusher and waits until it is written
+func (w *WAL) append(entry *Entry, sync bool) error {
+	req := &appendRequest{
+		entry:    entry,
+		sync:     sync,
+		enqueued: time.Now(),
+		done:     make(chan error, 1),
+	}
+
+	w.closeMu.RLock()
+	if w.closed {
+		w.closeMu.RUnlock()
+		return ErrClosed
+	}
+	w.requests <- req
+	w.closeMu.RUnlock()
+
+	return <-req.done
 }
 
 // ReadAll reads all entries from the WAL
@@ -89,83 +249,257 @@ func (w *WAL) ReadAll() ([]*Entry, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// Seek to beginning
-	if _, err := w.file.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to seek WAL: %w", err)
-	}
+	return w.readAllLocked()
+}
 
-	entries := make([]*Entry, 0)
+// readAllLocked reads all entries, caller must hold w.mu
+func (w *WAL) readAllLocked() ([]*Entry, error) {
+	info, err := w.file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat WAL: %w", err)
+	}
 
-	for {
-		entry, err := w.readEntry()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read WAL entry: %w", err)
-		}
+	// Seek past the file header
+	if _, err := w.file.Seek(FileHeaderSize, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek WAL: %w", err)
+	}
 
-		entries = append(entries, entry)
+	entries, _, err := scanRecords(w.file, info.Size()-FileHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL entry: %w", err)
 	}
 
 	// Seek back to end for future appends
-	if _, err := w.file.Seek(0, 2); err != nil {
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
 		return nil, fmt.Errorf("failed to seek to end: %w", err)
 	}
 
 	return entries, nil
 }
 
-// readEntry reads a single entry from the current file position
-func (w *WAL) readEntry() (*Entry, error) {
-	// Read header (9 bytes: 1 opType + 4 key + 4 valueSize)
-	header := make([]byte, 9)
-	if _, err := io.ReadFull(w.file, header); err != nil {
-		return nil, err
+// CopyTo writes a WAL file holding the entries up to lastLSN to dst, for a
+// backup. Returns the number of bytes written
+func (w *WAL) CopyTo(dst io.Writer, lastLSN uint64) (int64, error) {
+	w.mu.Lock()
+	entries, err := w.readAllLocked()
+	w.mu.Unlock()
+	if err != nil {
+		return 0, err
 	}
 
-	opType := OpType(header[0])
-	key := binary.LittleEndian.Uint32(header[1:5])
-	valueSize := binary.LittleEndian.Uint32(header[5:9])
+	written, err := dst.Write(encodeFileHeader())
+	if err != nil {
+		return int64(written), fmt.Errorf("failed to copy WAL: %w", err)
+	}
+	total := int64(written)
 
-	// Read value
-	valueBytes := make([]byte, valueSize)
-	if _, err := io.ReadFull(w.file, valueBytes); err != nil {
-		return nil, fmt.Errorf("failed to read value: %w", err)
+	for _, entry := range entries {
+		if entry.LSN > lastLSN {
+			break
+		}
+		written, err := dst.Write(encodeRecord(entry))
+		total += int64(written)
+		if err != nil {
+			return total, fmt.Errorf("failed to copy WAL: %w", err)
+		}
 	}
 
-	return &Entry{
-		OpType: opType,
-		Key:    key,
-		Value:  string(valueBytes),
-	}, nil
+	return total, nil
 }
 
-// Truncate clears the WAL file
+// Truncate clears the WAL file, archiving its entries first if archiving is on
 func (w *WAL) Truncate() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	if err := w.file.Truncate(0); err != nil {
+	if err := w.archiveBefore(w.nextLSN - 1); err != nil {
+		return err
+	}
+	if err := w.truncateFile(); err != nil {
+		return err
+	}
+
+	w.syncs = 0
+	return nil
+}
+
+// truncateFile drops all records (keeping the file header), LSNs continue from where they were
+func (w *WAL) truncateFile() error {
+	if err := w.file.Truncate(FileHeaderSize); err != nil {
 		return fmt.Errorf("failed to truncate WAL: %w", err)
 	}
 
-	if _, err := w.file.Seek(0, 0); err != nil {
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
 		return fmt.Errorf("failed to seek after truncate: %w", err)
 	}
 
-	w.syncs = 0
+	w.firstLSN = w.nextLSN
+	w.size = 0
+	// Dropped records are covered by the checkpoint, pages need no fsync of them
+	w.durableLSN = w.writtenLSN
 	return nil
 }
 
-// Close closes the WAL file
+// TruncateBefore drops all entries with LSN <= lsn (they are covered by a checkpoint)
+// Newer entries are kept by rotating them into a fresh file, dropped ones
+// are archived first if archiving is on
+// Returns the number of bytes removed from the WAL
+func (w *WAL) TruncateBefore(lsn uint64) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat WAL: %w", err)
+	}
+	sizeBefore := info.Size()
+
+	if err := w.archiveBefore(lsn); err != nil {
+		return 0, err
+	}
+
+	// Fast path: checkpoint covers the whole log
+	if lsn >= w.nextLSN-1 {
+		if err := w.truncateFile(); err != nil {
+			return 0, err
+		}
+		return sizeBefore - FileHeaderSize, nil
+	}
+
+	entries, err := w.readAllLocked()
+	if err != nil {
+		return 0, err
+	}
+
+	kept := make([]*Entry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.LSN > lsn {
+			kept = append(kept, entry)
+		}
+	}
+
+	if err := w.rewrite(kept); err != nil {
+		return 0, err
+	}
+
+	info, err = w.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat WAL: %w", err)
+	}
+
+	return sizeBefore - info.Size(), nil
+}
+
+// rewrite writes entries (with their LSNs) to a new file and atomically
+// replaces the current WAL with it
+func (w *WAL) rewrite(entries []*Entry) error {
+	tmpPath := w.path + ".tmp"
+	if err := WriteFile(tmpPath, entries); err != nil {
+		return fmt.Errorf("failed to rotate WAL: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		return fmt.Errorf("failed to replace WAL: %w", err)
+	}
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen WAL: %w", err)
+	}
+
+	w.file.Close()
+	w.file = file
+	if err := w.statSize(); err != nil {
+		return err
+	}
+
+	w.firstLSN = w.nextLSN
+	if len(entries) > 0 {
+		w.firstLSN = entries[0].LSN
+	}
+	// WriteFile synced the kept records, the others are checkpointed
+	w.unsynced = false
+	w.durableLSN = w.writtenLSN
+	return nil
+}
+
+// WriteFile writes a WAL file holding entries (with their LSNs) to path and syncs it
+// It can be opened with NewWAL, which continues after the last entry
+func WriteFile(path string, entries []*Entry) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+
+	writer := bufio.NewWriter(file)
+	writer.Write(encodeFileHeader())
+	for _, entry := range entries {
+		writer.Write(encodeRecord(entry))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// AdvanceLSN makes sure new entries are numbered after lsn
+// Used on startup with the LSN of the last checkpoint, since an empty
+// WAL has no records to continue the sequence from
+func (w *WAL) AdvanceLSN(lsn uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.nextLSN > lsn {
+		return
+	}
+
+	if w.firstLSN == w.nextLSN {
+		w.firstLSN = lsn + 1
+	}
+	w.nextLSN = lsn + 1
+	w.writtenLSN = lsn
+	w.durableLSN = max(w.durableLSN, lsn)
+}
+
+// LastLSN returns the LSN of the last appended entry (0 if none)
+func (w *WAL) LastLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.nextLSN - 1
+}
+
+// DiscardedBytes returns how many bytes of torn or corrupt tail were
+// truncated when the WAL was opened
+func (w *WAL) DiscardedBytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.discarded
+}
+
+// Close waits for pending appends, stops the flusher and closes the WAL file
 func (w *WAL) Close() error {
+	w.closeMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.requests)
+	}
+	w.closeMu.Unlock()
+	<-w.flusherDone
+
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	if err := w.file.Sync(); err != nil {
 		return fmt.Errorf("failed to sync before close: %w", err)
 	}
+	w.unsynced = false
+	w.durableLSN = w.writtenLSN
 
 	if err := w.file.Close(); err != nil {
 		return fmt.Errorf("failed to close WAL: %w", err)
@@ -181,26 +515,31 @@ func (w *WAL) GetSyncCount() int {
 	return w.syncs
 }
 
-// Size returns the current size of the WAL file
-func (w *WAL) Size() (int64, error) {
+// Size returns the number of bytes of log records (excluding the file header)
+// It is kept as records are written, with no stat of the file
+func (w *WAL) Size() int64 {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	return w.size
+}
 
+// statSize sets size from the file, caller must hold w.mu (or own the WAL while opening it)
+func (w *WAL) statSize() error {
 	info, err := w.file.Stat()
 	if err != nil {
-		return 0, err
+		return fmt.Errorf("failed to stat WAL: %w", err)
 	}
-
-	return info.Size(), nil
+	w.size = info.Size() - FileHeaderSize
+	return nil
 }
 
-// Exists checks if WAL file exists and has content
+// Exists checks if WAL file exists and has records
 func Exists(path string) bool {
 	info, err := os.Stat(path)
 	if err != nil {
 		return false
 	}
-	return info.Size() > 0
+	return info.Size() > FileHeaderSize
 }
 
 // Path returns the WAL file path
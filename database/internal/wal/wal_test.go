@@ -1,10 +1,24 @@
 package wal
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 )
 
+// numKey encodes a number as a big-endian key, like storage.Uint32Key
+func numKey(n uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, n)
+	return key
+}
+
 func TestWALBasicOperations(t *testing.T) {
 	walPath := "test_basic.wal"
 	defer os.Remove(walPath)
@@ -18,9 +32,9 @@ func TestWALBasicOperations(t *testing.T) {
 
 	// Append entries
 	entries := []*Entry{
-		{OpType: OpInsert, Key: 100, Value: "naruto"},
-		{OpType: OpInsert, Key: 200, Value: "sasuke"},
-		{OpType: OpInsert, Key: 50, Value: "sakura"},
+		{OpType: OpInsert, Key: numKey(100), Value: "naruto"},
+		{OpType: OpInsert, Key: numKey(200), Value: "sasuke"},
+		{OpType: OpInsert, Key: numKey(50), Value: "sakura"},
 	}
 
 	for _, entry := range entries {
@@ -47,8 +61,8 @@ func TestWALBasicOperations(t *testing.T) {
 		if entry.OpType != entries[i].OpType {
 			t.Errorf("Entry %d: OpType=%d, expected %d", i, entry.OpType, entries[i].OpType)
 		}
-		if entry.Key != entries[i].Key {
-			t.Errorf("Entry %d: Key=%d, expected %d", i, entry.Key, entries[i].Key)
+		if !bytes.Equal(entry.Key, entries[i].Key) {
+			t.Errorf("Entry %d: Key=%x, expected %x", i, entry.Key, entries[i].Key)
 		}
 		if entry.Value != entries[i].Value {
 			t.Errorf("Entry %d: Value=%s, expected %s", i, entry.Value, entries[i].Value)
@@ -70,9 +84,9 @@ func TestWALPersistence(t *testing.T) {
 		}
 
 		entries := []*Entry{
-			{OpType: OpInsert, Key: 1, Value: "one"},
-			{OpType: OpInsert, Key: 2, Value: "two"},
-			{OpType: OpInsert, Key: 3, Value: "three"},
+			{OpType: OpInsert, Key: numKey(1), Value: "one"},
+			{OpType: OpInsert, Key: numKey(2), Value: "two"},
+			{OpType: OpInsert, Key: numKey(3), Value: "three"},
 		}
 
 		for _, entry := range entries {
@@ -128,7 +142,7 @@ func TestWALTruncate(t *testing.T) {
 	for i := 1; i <= 5; i++ {
 		entry := &Entry{
 			OpType: OpInsert,
-			Key:    uint32(i),
+			Key:    numKey(uint32(i)),
 			Value:  "value",
 		}
 		if err := w.Append(entry); err != nil {
@@ -136,7 +150,7 @@ func TestWALTruncate(t *testing.T) {
 		}
 	}
 
-	size1, _ := w.Size()
+	size1 := w.Size()
 	t.Logf("WAL size after 5 entries: %d bytes", size1)
 
 	// Truncate
@@ -144,7 +158,7 @@ func TestWALTruncate(t *testing.T) {
 		t.Fatalf("Failed to truncate: %v", err)
 	}
 
-	size2, _ := w.Size()
+	size2 := w.Size()
 	if size2 != 0 {
 		t.Errorf("WAL size after truncate: %d, expected 0", size2)
 	}
@@ -171,7 +185,7 @@ func BenchmarkWALAppend(b *testing.B) {
 
 	entry := &Entry{
 		OpType: OpInsert,
-		Key:    100,
+		Key:    numKey(100),
 		Value:  "benchmark-value",
 	}
 
@@ -183,3 +197,864 @@ func BenchmarkWALAppend(b *testing.B) {
 
 	b.Logf("Performed %d fsync operations", w.GetSyncCount())
 }
+
+func BenchmarkWALAppendParallel(b *testing.B) {
+	walPath := "bench_append_parallel.wal"
+	defer os.Remove(walPath)
+
+	w, _ := NewWAL(walPath)
+	defer w.Close()
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.Append(&Entry{OpType: OpInsert, Key: numKey(100), Value: "benchmark-value"})
+		}
+	})
+
+	b.Logf("Performed %d fsync operations (%s)", w.GetSyncCount(), w.GetGroupCommitStats())
+}
+
+func TestWALGroupCommit(t *testing.T) {
+	walPath := "test_group_commit.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	w.SetCommitDelay(2 * time.Millisecond)
+
+	const writers = 32
+	const perWriter = 50
+	total := writers * perWriter
+
+	var wg sync.WaitGroup
+	errs := make(chan error, total)
+	lsns := make(chan uint64, total)
+
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				entry := &Entry{
+					OpType: OpInsert,
+					Key:    numKey(uint32(g*perWriter + i)),
+					Value:  fmt.Sprintf("value_%d_%d", g, i),
+				}
+				if err := w.Append(entry); err != nil {
+					errs <- err
+					return
+				}
+				lsns <- entry.LSN
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	close(lsns)
+
+	for err := range errs {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// Every caller got its own LSN, and together they are 1..total
+	seen := make(map[uint64]bool)
+	for lsn := range lsns {
+		if lsn < 1 || lsn > uint64(total) || seen[lsn] {
+			t.Fatalf("Unexpected or duplicate LSN %d", lsn)
+		}
+		seen[lsn] = true
+	}
+	if len(seen) != total {
+		t.Fatalf("Expected %d LSNs, got %d", total, len(seen))
+	}
+
+	stats := w.GetGroupCommitStats()
+	if stats.Entries != total {
+		t.Errorf("Expected %d committed entries, got %d", total, stats.Entries)
+	}
+	if stats.Batches != w.GetSyncCount() {
+		t.Errorf("Expected one fsync per batch, got %d batches and %d syncs", stats.Batches, w.GetSyncCount())
+	}
+	if stats.Batches >= total || stats.MaxBatchSize < 2 {
+		t.Errorf("Expected appends to share fsyncs, got %s", stats)
+	}
+	if stats.MaxBatchSize > MaxBatchSize {
+		t.Errorf("Batch of %d exceeds MaxBatchSize %d", stats.MaxBatchSize, MaxBatchSize)
+	}
+
+	t.Logf("✓ %d appends committed with %d fsyncs (%s)", total, w.GetSyncCount(), stats)
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close WAL: %v", err)
+	}
+
+	if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(1), Value: "late"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+
+	// Everything acknowledged is in the file, in LSN order
+	w2, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w2.Close()
+
+	entries, err := w2.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read WAL: %v", err)
+	}
+	if len(entries) != total {
+		t.Fatalf("Expected %d entries after reopen, got %d", total, len(entries))
+	}
+	for i, entry := range entries {
+		if entry.LSN != uint64(i+1) {
+			t.Fatalf("Entry %d has LSN %d", i, entry.LSN)
+		}
+	}
+
+	t.Logf("✓ All %d entries durable after reopen", total)
+}
+
+func TestWALTruncateBefore(t *testing.T) {
+	walPath := "test_truncate_before.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	for i := 1; i <= 10; i++ {
+		entry := &Entry{OpType: OpInsert, Key: numKey(uint32(i)), Value: "value"}
+		if err := w.Append(entry); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+		if entry.LSN != uint64(i) {
+			t.Errorf("Entry %d: LSN=%d, expected %d", i, entry.LSN, i)
+		}
+	}
+
+	// Checkpoint covers entries 1..6, entries 7..10 must survive
+	freed, err := w.TruncateBefore(6)
+	if err != nil {
+		t.Fatalf("TruncateBefore failed: %v", err)
+	}
+	if freed <= 0 {
+		t.Errorf("TruncateBefore freed %d bytes, expected > 0", freed)
+	}
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Found %d entries after TruncateBefore, expected 4", len(entries))
+	}
+	for i, entry := range entries {
+		if !bytes.Equal(entry.Key, numKey(uint32(7+i))) || entry.LSN != uint64(7+i) {
+			t.Errorf("Entry %d: key=%x LSN=%d, expected %d", i, entry.Key, entry.LSN, 7+i)
+		}
+	}
+
+	// LSNs keep growing after rotation
+	entry := &Entry{OpType: OpDelete, Key: numKey(1)}
+	if err := w.Append(entry); err != nil {
+		t.Fatalf("Failed to append after rotation: %v", err)
+	}
+	if entry.LSN != 11 || w.LastLSN() != 11 {
+		t.Errorf("LSN after rotation: entry=%d last=%d, expected 11", entry.LSN, w.LastLSN())
+	}
+
+	// Covering everything empties the file
+	if _, err := w.TruncateBefore(w.LastLSN()); err != nil {
+		t.Fatalf("TruncateBefore failed: %v", err)
+	}
+	if size := w.Size(); size != 0 {
+		t.Errorf("WAL size after full truncate: %d, expected 0", size)
+	}
+
+	// Reopen: existing entries are numbered after the checkpoint LSN
+	if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(42), Value: "x"}); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	w.Close()
+
+	w2, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w2.Close()
+
+	w2.AdvanceLSN(11)
+	entries, _ = w2.ReadAll()
+	if len(entries) != 1 || entries[0].LSN != 12 || w2.LastLSN() != 12 {
+		t.Errorf("After reopen: %d entries, last LSN %d, expected one entry with LSN 12", len(entries), w2.LastLSN())
+	}
+}
+
+func TestWALTornTailRecovery(t *testing.T) {
+	walPath := "test_torn_tail.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(uint32(i)), Value: "value"}); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	w.Close()
+
+	info, _ := os.Stat(walPath)
+	fullSize := info.Size()
+
+	// Cut the last record in half
+	recordSize := int64(recordHeaderSize + entryHeaderSize + 4 + len("value"))
+	if err := os.Truncate(walPath, fullSize-recordSize/2); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen torn WAL: %v", err)
+	}
+	defer w.Close()
+
+	if discarded := w.DiscardedBytes(); discarded != recordSize-recordSize/2 {
+		t.Errorf("Discarded %d bytes, expected %d", discarded, recordSize-recordSize/2)
+	}
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Found %d entries, expected 4", len(entries))
+	}
+
+	// Garbage is gone from the file, appends continue after the last valid record
+	info, _ = os.Stat(walPath)
+	if info.Size() != fullSize-recordSize {
+		t.Errorf("File size %d after recovery, expected %d", info.Size(), fullSize-recordSize)
+	}
+
+	entry := &Entry{OpType: OpInsert, Key: numKey(5), Value: "again"}
+	if err := w.Append(entry); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if entry.LSN != 5 {
+		t.Errorf("LSN after recovery=%d, expected 5", entry.LSN)
+	}
+
+	entries, _ = w.ReadAll()
+	if len(entries) != 5 || entries[4].Value != "again" {
+		t.Errorf("Expected 5 entries ending with the new one, got %d", len(entries))
+	}
+}
+
+func TestWALChecksumMismatch(t *testing.T) {
+	walPath := "test_checksum.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(uint32(i)), Value: "value"}); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	w.Close()
+
+	// Flip a byte in the value of the third record
+	recordSize := int64(recordHeaderSize + entryHeaderSize + 4 + len("value"))
+	f, err := os.OpenFile(walPath, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	f.WriteAt([]byte{'X'}, FileHeaderSize+2*recordSize+recordSize-1)
+	f.Close()
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen corrupt WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries, _ := w.ReadAll()
+	if len(entries) != 2 {
+		t.Errorf("Found %d entries, expected 2 (stop at corrupt record)", len(entries))
+	}
+	if discarded := w.DiscardedBytes(); discarded != 3*recordSize {
+		t.Errorf("Discarded %d bytes, expected %d", discarded, 3*recordSize)
+	}
+}
+
+func TestWALFileHeader(t *testing.T) {
+	walPath := "test_header.wal"
+	defer os.Remove(walPath)
+
+	// Legacy WAL: bare entries without file header or checksums
+	legacy := make([]byte, 0)
+	for i := 1; i <= 3; i++ {
+		legacy = append(legacy, encodeLegacyEntry(OpInsert, uint32(i), "old")...)
+	}
+	if err := os.WriteFile(walPath, legacy, 0644); err != nil {
+		t.Fatalf("Failed to write legacy WAL: %v", err)
+	}
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy WAL: %v", err)
+	}
+	entries, _ := w.ReadAll()
+	if len(entries) != 3 || !bytes.Equal(entries[2].Key, numKey(3)) || entries[2].LSN != 3 {
+		t.Errorf("Legacy upgrade: %d entries", len(entries))
+	}
+	if w.LastLSN() != 3 {
+		t.Errorf("Legacy upgrade: last LSN=%d, expected 3", w.LastLSN())
+	}
+
+	// New records carry their transaction ID and table
+	if err := w.Append(&Entry{OpType: OpBegin, TxID: 7, Table: 2}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	data, _ := os.ReadFile(walPath)
+	if string(data[0:4]) != walMagic || binary.LittleEndian.Uint16(data[4:6]) != walVersion {
+		t.Errorf("Legacy WAL was not rewritten with a version %d file header", walVersion)
+	}
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen upgraded WAL: %v", err)
+	}
+	entries, _ = w.ReadAll()
+	if len(entries) != 4 || entries[3].OpType != OpBegin || entries[3].TxID != 7 || entries[3].Table != 2 || entries[3].LSN != 4 {
+		t.Errorf("Unexpected entries after upgrade: %d", len(entries))
+	}
+	w.Close()
+
+	// Unknown version is rejected rather than misread
+	data, _ = os.ReadFile(walPath)
+	data[4] = 99
+	os.WriteFile(walPath, data, 0644)
+	if _, err := NewWAL(walPath); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	t.Logf("✓ Legacy WAL upgraded to version %d", walVersion)
+}
+
+// encodeLegacyEntry encodes an entry in the legacy layout (4-byte
+// little-endian key, no file header or checksum)
+func encodeLegacyEntry(opType OpType, key uint32, value string) []byte {
+	data := make([]byte, legacyEntryHeaderSize+len(value))
+	data[0] = byte(opType)
+	binary.LittleEndian.PutUint32(data[1:5], key)
+	binary.LittleEndian.PutUint32(data[5:9], uint32(len(value)))
+	copy(data[legacyEntryHeaderSize:], value)
+	return data
+}
+
+func TestWALTables(t *testing.T) {
+	walPath := "test_wal_tables.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(1), Value: "default"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if err := w.Append(&Entry{OpType: OpUpdate, Table: 7, Key: numKey(2), Value: "table"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries, _ := w.ReadAll()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Table != 0 || entries[0].Value != "default" {
+		t.Errorf("Default table entry read as %+v", entries[0])
+	}
+	if entries[1].Table != 7 || !bytes.Equal(entries[1].Key, numKey(2)) {
+		t.Errorf("Table lost: %+v", entries[1])
+	}
+
+	t.Logf("✓ Entries keep their table")
+}
+
+func TestWALSyncModes(t *testing.T) {
+	walPath := "test_sync_modes.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	defer w.Close()
+
+	if w.SyncMode() != SyncFull || w.LossWindow() != 0 {
+		t.Fatalf("Expected FULL with no loss window by default, got %s", w.Durability())
+	}
+
+	// OFF: nothing is fsynced until asked
+	if err := w.SetSyncMode(SyncOff, 0); err != nil {
+		t.Fatalf("Failed to set sync mode: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(uint32(i)), Value: "off"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if w.GetSyncCount() != 0 {
+		t.Errorf("Expected no fsyncs in OFF mode, got %d", w.GetSyncCount())
+	}
+	if w.LossWindow() != UnboundedLossWindow {
+		t.Errorf("Expected unbounded loss window in OFF mode, got %v", w.LossWindow())
+	}
+
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if w.GetSyncCount() != 1 {
+		t.Errorf("Expected explicit Sync to fsync once, got %d", w.GetSyncCount())
+	}
+	t.Logf("✓ %s", w.Durability())
+
+	// NORMAL: the flusher fsyncs pending records on the interval
+	if err := w.SetSyncMode(SyncNormal, 20*time.Millisecond); err != nil {
+		t.Fatalf("Failed to set sync mode: %v", err)
+	}
+	if w.LossWindow() != 20*time.Millisecond {
+		t.Errorf("Expected 20ms loss window in NORMAL mode, got %v", w.LossWindow())
+	}
+
+	before := w.GetSyncCount()
+	for i := 0; i < 100; i++ {
+		if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(uint32(i)), Value: "normal"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for w.GetSyncCount() == before && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	synced := w.GetSyncCount() - before
+	if synced == 0 || synced >= 100 {
+		t.Errorf("Expected a few interval fsyncs for 100 appends, got %d", synced)
+	}
+	t.Logf("✓ %s: 100 appends, %d fsyncs", w.Durability(), synced)
+
+	if err := w.SetSyncMode(SyncMode(7), 0); err == nil {
+		t.Error("Expected error for invalid sync mode")
+	}
+}
+
+func TestWALSyncTo(t *testing.T) {
+	walPath := "test_sync_to.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	if err := w.SetSyncMode(SyncOff, 0); err != nil {
+		t.Fatalf("Failed to set sync mode: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(uint32(i)), Value: "off"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if w.DurableLSN() != 0 {
+		t.Errorf("Expected nothing durable in OFF mode, got LSN %d", w.DurableLSN())
+	}
+
+	// Even in OFF mode a page writer gets the records it depends on synced
+	if err := w.SyncTo(5); err != nil {
+		t.Fatalf("SyncTo failed: %v", err)
+	}
+	if w.DurableLSN() != 10 || w.GetSyncCount() != 1 {
+		t.Errorf("After SyncTo(5): durable LSN %d, %d fsyncs, expected 10 and 1", w.DurableLSN(), w.GetSyncCount())
+	}
+	if err := w.SyncTo(10); err != nil || w.GetSyncCount() != 1 {
+		t.Errorf("SyncTo of durable records fsynced again (err=%v)", err)
+	}
+	if err := w.SyncTo(11); err == nil {
+		t.Error("Expected error syncing past the last record")
+	}
+	t.Logf("✓ SyncTo fsyncs only records not yet durable")
+
+	// A checkpoint covers the records it drops
+	if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(10), Value: "off"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, err := w.TruncateBefore(11); err != nil {
+		t.Fatalf("TruncateBefore failed: %v", err)
+	}
+	if w.DurableLSN() != 11 {
+		t.Errorf("After truncation: durable LSN %d, expected 11", w.DurableLSN())
+	}
+	w.Close()
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+	w.AdvanceLSN(11)
+	if w.DurableLSN() != 11 {
+		t.Errorf("After reopening: durable LSN %d, expected 11", w.DurableLSN())
+	}
+}
+
+func TestWALSyncFailure(t *testing.T) {
+	walPath := "test_sync_failure.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+
+	if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(1), Value: "kept"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	size := w.Size()
+
+	// An fsync error fails the append and takes its record back out
+	fsync = func(*os.File) error { return errors.New("injected fsync failure") }
+	entry := &Entry{OpType: OpInsert, Key: numKey(2), Value: "lost"}
+	err = w.Append(entry)
+	fsync = (*os.File).Sync
+	if err == nil {
+		t.Fatal("Expected the append to fail")
+	}
+	if entry.LSN != 0 || w.LastLSN() != 1 || w.Size() != size {
+		t.Errorf("After the failed sync: entry LSN %d, last LSN %d, size %d, expected 0, 1 and %d",
+			entry.LSN, w.LastLSN(), w.Size(), size)
+	}
+	t.Logf("✓ Failed append removed: %v", err)
+
+	// The next record takes the free LSN, once in the file
+	if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(3), Value: "next"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries, err := w.ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read entries: %v", err)
+	}
+	if len(entries) != 2 || entries[0].LSN != 1 || entries[1].LSN != 2 || entries[1].Value != "next" {
+		t.Fatalf("Expected LSNs 1 and 2 with the last record \"next\", got %+v", entries)
+	}
+	t.Logf("✓ No LSN written twice after the failed sync")
+}
+
+func TestParseSyncMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected SyncMode
+		wantErr  bool
+	}{
+		{"full", SyncFull, false},
+		{"NORMAL", SyncNormal, false},
+		{" Off ", SyncOff, false},
+		{"fast", SyncFull, true},
+	}
+
+	for _, tt := range tests {
+		mode, err := ParseSyncMode(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSyncMode(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if mode != tt.expected {
+			t.Errorf("ParseSyncMode(%q) = %v, want %v", tt.input, mode, tt.expected)
+		}
+	}
+}
+
+func TestWALBatchEntry(t *testing.T) {
+	walPath := "test_batch_entry.wal"
+	defer os.Remove(walPath)
+
+	ops := []BatchOp{
+		{OpType: OpUpdate, Key: numKey(1), Value: "one"},
+		{OpType: OpDelete, Key: numKey(2)},
+		{OpType: OpUpdate, Key: numKey(3), Value: ""},
+		{OpType: OpUpdate, Key: []byte("user:42"), Value: "variable-length key"},
+	}
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	if err := w.Append(NewBatchEntry(ops)); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries, _ := w.ReadAll()
+	if len(entries) != 1 || entries[0].OpType != OpBatch {
+		t.Fatalf("Expected one batch record, got %d entries", len(entries))
+	}
+
+	decoded, err := entries[0].BatchOps()
+	if err != nil {
+		t.Fatalf("BatchOps failed: %v", err)
+	}
+	if len(decoded) != len(ops) {
+		t.Fatalf("Decoded %d writes, expected %d", len(decoded), len(ops))
+	}
+	for i := range ops {
+		if decoded[i].OpType != ops[i].OpType || !bytes.Equal(decoded[i].Key, ops[i].Key) || decoded[i].Value != ops[i].Value {
+			t.Errorf("Write %d: got %+v, expected %+v", i, decoded[i], ops[i])
+		}
+	}
+
+	// A short value is rejected rather than misread
+	broken := *entries[0]
+	broken.Value = broken.Value[:len(broken.Value)-4]
+	if _, err := broken.BatchOps(); err == nil {
+		t.Error("Expected error for truncated batch")
+	}
+
+	t.Logf("✓ Batch of %d writes round-trips through one record", len(ops))
+}
+
+func TestWALBulkLoadEntry(t *testing.T) {
+	walPath := "test_bulk_load_entry.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	entry := NewBulkLoadEntry()
+	entry.Table = 3
+	if err := w.Append(entry); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries, _ := w.ReadAll()
+	if len(entries) != 1 || entries[0].OpType != OpBulkLoad || entries[0].Table != 3 || entries[0].LSN != entry.LSN {
+		t.Fatalf("Expected one bulk load marker for table 3, got %+v", entries)
+	}
+
+	t.Log("✓ Bulk load marker round-trips")
+}
+
+func TestWALCatalogEntry(t *testing.T) {
+	walPath := "test_catalog_entry.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	changes := []CatalogChange{
+		{Op: CatalogCreateTable, Name: "users", Schema: []byte("id INT, name TEXT")},
+		{Op: CatalogCreateIndex, Name: "by_name", Indexed: 1},
+		{Op: CatalogDropTable, Name: "users"},
+	}
+	for i, change := range changes {
+		entry := NewCatalogEntry(change)
+		entry.Table = uint32(i + 1)
+		if err := w.Append(entry); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	// A record too short or with an unknown change is rejected
+	if err := w.Append(&Entry{OpType: OpCatalog, Value: "CREATE TABLE old"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	w.Close()
+
+	w, err = NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	defer w.Close()
+
+	entries, _ := w.ReadAll()
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(entries))
+	}
+	for i, want := range changes {
+		change, err := entries[i].CatalogChange()
+		if err != nil {
+			t.Fatalf("Entry %d: %v", i, err)
+		}
+		if change.Op != want.Op || change.Name != want.Name || change.Indexed != want.Indexed ||
+			string(change.Schema) != string(want.Schema) || entries[i].Table != uint32(i+1) {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, want, change)
+		}
+	}
+	if _, err := entries[3].CatalogChange(); err == nil {
+		t.Errorf("Expected an error for a record without a change")
+	}
+	if got := changes[1].String(); got != "CREATE INDEX by_name" {
+		t.Errorf("Expected CREATE INDEX by_name, got %q", got)
+	}
+
+	t.Log("✓ Catalog changes round-trip")
+}
+
+func TestWALEntryTime(t *testing.T) {
+	walPath := "test_wal_time.wal"
+	defer os.Remove(walPath)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	before := time.Now().UnixNano()
+	for i := 1; i <= 3; i++ {
+		if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(uint32(i)), Value: "value"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	w.Close()
+
+	entries, err := ReadFile(walPath)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("ReadFile returned %d entries (err=%v)", len(entries), err)
+	}
+	for i, entry := range entries {
+		if entry.Time < before || entry.Time > time.Now().UnixNano() {
+			t.Errorf("Entry %d has time %d", i, entry.Time)
+		}
+		if i > 0 && entry.Time < entries[i-1].Time {
+			t.Errorf("Entry %d is older than the one before it", i)
+		}
+	}
+
+	t.Log("✓ Entries keep the time they were appended")
+}
+
+func TestWALArchive(t *testing.T) {
+	walPath := "test_wal_archive.wal"
+	archive := walPath + ArchiveSuffix
+	defer os.Remove(walPath)
+	defer os.RemoveAll(archive)
+
+	w, err := NewWAL(walPath)
+	if err != nil {
+		t.Fatalf("Failed to create WAL: %v", err)
+	}
+	appendN := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := w.Append(&Entry{OpType: OpInsert, Key: numKey(uint32(i)), Value: "value"}); err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+		}
+	}
+
+	// Not archived before archiving is on
+	appendN(5)
+	if _, err := w.TruncateBefore(5); err != nil {
+		t.Fatalf("TruncateBefore failed: %v", err)
+	}
+	if err := w.EnableArchive(); err != nil {
+		t.Fatalf("Failed to enable archive: %v", err)
+	}
+
+	appendN(10)
+	if _, err := w.TruncateBefore(12); err != nil {
+		t.Fatalf("TruncateBefore failed: %v", err)
+	}
+	w.Close()
+
+	// Archiving stays on when the WAL is reopened
+	if w, err = NewWAL(walPath); err != nil {
+		t.Fatalf("Failed to reopen WAL: %v", err)
+	}
+	if w.ArchiveDir() != archive {
+		t.Fatalf("Archive dir is %q after reopening", w.ArchiveDir())
+	}
+	appendN(5)
+	if err := w.Truncate(); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	w.Close()
+
+	segments, err := ListSegments(archive)
+	if err != nil || len(segments) != 2 {
+		t.Fatalf("Archive has %d segments (err=%v), expected 2", len(segments), err)
+	}
+	if segments[0].FirstLSN != 6 || segments[0].LastLSN != 12 || segments[1].FirstLSN != 13 || segments[1].LastLSN != 20 {
+		t.Errorf("Segments %+v", segments)
+	}
+
+	// Overlapping segments (a crash before truncating) are read once
+	if err := WriteSegment(archive, []*Entry{{OpType: OpInsert, Key: numKey(1), LSN: 11}, {OpType: OpInsert, Key: numKey(1), LSN: 12}}); err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	os.WriteFile(filepath.Join(archive, "notes.txt"), []byte("not a segment"), 0644)
+
+	entries, read, err := ReadArchive(archive, 8)
+	if err != nil {
+		t.Fatalf("ReadArchive failed: %v", err)
+	}
+	if len(entries) != 12 || entries[0].LSN != 9 || entries[11].LSN != 20 || read != 2 {
+		t.Fatalf("ReadArchive returned %d entries from LSN %d from %d segments", len(entries), entries[0].LSN, read)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].LSN != entries[i-1].LSN+1 {
+			t.Fatalf("LSN %d follows %d", entries[i].LSN, entries[i-1].LSN)
+		}
+	}
+	t.Logf("✓ %d archived entries read back in order", len(entries))
+
+	// Trimming cuts the segment holding the LSN and removes later ones
+	if trimmed, err := TrimArchive(archive, 9); err != nil || trimmed != 3 {
+		t.Fatalf("TrimArchive trimmed %d segments (err=%v), expected 3", trimmed, err)
+	}
+	segments, _ = ListSegments(archive)
+	if len(segments) != 1 || segments[0].FirstLSN != 6 || segments[0].LastLSN != 9 {
+		t.Fatalf("Segments after trim %+v", segments)
+	}
+	t.Log("✓ Archive trimmed after LSN 9")
+}
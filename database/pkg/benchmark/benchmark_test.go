@@ -1,6 +1,7 @@
 package benchmark
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"testing"
@@ -15,6 +16,7 @@ func Benchmark100kInserts(b *testing.B) {
 	dbFile := "bench_100k_inserts.db"
 	walFile := "bench_100k_inserts.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -38,7 +40,7 @@ func Benchmark100kInserts(b *testing.B) {
 	for i := 0; i < 100000; i++ {
 		key := uint32(i)
 		value := fmt.Sprintf("value-%d", i)
-		if err := tree.Insert(key, value); err != nil {
+		if err := tree.Insert(storage.Uint32Key(key), value); err != nil {
 			b.Fatalf("Insert failed: %v", err)
 		}
 	}
@@ -70,11 +72,60 @@ func Benchmark100kInserts(b *testing.B) {
 	b.Logf("   Evictions: %d", stats.Evictions)
 }
 
+// Benchmark100kBulkLoad loads the rows of Benchmark100kInserts with BulkLoad
+func Benchmark100kBulkLoad(b *testing.B) {
+	dbFile := "bench_100k_bulk_load.db"
+	walFile := "bench_100k_bulk_load.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		b.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bufferPool := storage.NewBufferPool(pager, 128)
+	defer bufferPool.Close()
+
+	tree, err := bptree.NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		b.Fatalf("Failed to create tree: %v", err)
+	}
+	defer tree.Close()
+
+	rows := make([]bptree.KeyValue, 0, 100000)
+	for i := 0; i < 100000; i++ {
+		rows = append(rows, bptree.KeyValue{Key: storage.Uint32Key(uint32(i)), Value: fmt.Sprintf("value-%d", i)})
+	}
+
+	b.ResetTimer()
+	syncs := tree.GetWALSyncCount()
+
+	info, err := tree.BulkLoad(bptree.SliceSource(rows), bptree.BulkLoadOptions{})
+	if err != nil {
+		b.Fatalf("Bulk load failed: %v", err)
+	}
+
+	b.StopTimer()
+
+	dbInfo, _ := os.Stat(dbFile)
+
+	b.Logf("\n📊 100k Bulk Load Benchmark Results:")
+	b.Logf("   Duration: %v", info.Duration)
+	b.Logf("   Throughput: %.2f rows/sec", float64(info.Records)/info.Duration.Seconds())
+	b.Logf("   WAL syncs: %d", tree.GetWALSyncCount()-syncs)
+	b.Logf("   Pages: %d (%d leaves, height %d)", info.Pages, info.Leaves, info.Height)
+	b.Logf("   Database: %.2f MB", float64(dbInfo.Size())/(1024*1024))
+}
+
 // Benchmark100kReads measures read throughput after 100k inserts
 func Benchmark100kReads(b *testing.B) {
 	dbFile := "bench_100k_reads.db"
 	walFile := "bench_100k_reads.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -97,7 +148,7 @@ func Benchmark100kReads(b *testing.B) {
 	for i := 0; i < 100000; i++ {
 		key := uint32(i)
 		value := fmt.Sprintf("value-%d", i)
-		tree.Insert(key, value)
+		tree.Insert(storage.Uint32Key(key), value)
 	}
 
 	b.ResetTimer()
@@ -106,7 +157,7 @@ func Benchmark100kReads(b *testing.B) {
 	// Benchmark reads
 	for i := 0; i < 100000; i++ {
 		key := uint32(i)
-		_, found, err := tree.Search(key)
+		_, found, err := tree.Search(storage.Uint32Key(key))
 		if err != nil {
 			b.Fatalf("Search failed: %v", err)
 		}
@@ -136,6 +187,7 @@ func BenchmarkMixedWorkload(b *testing.B) {
 	dbFile := "bench_mixed.db"
 	walFile := "bench_mixed.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -155,7 +207,7 @@ func BenchmarkMixedWorkload(b *testing.B) {
 
 	// Prepare initial data
 	for i := 0; i < 10000; i++ {
-		tree.Insert(uint32(i), fmt.Sprintf("value-%d", i))
+		tree.Insert(storage.Uint32Key(uint32(i)), fmt.Sprintf("value-%d", i))
 	}
 
 	b.ResetTimer()
@@ -170,12 +222,12 @@ func BenchmarkMixedWorkload(b *testing.B) {
 		if i%10 < 7 {
 			// Read operation
 			key := uint32(i % 10000)
-			tree.Search(key)
+			tree.Search(storage.Uint32Key(key))
 			reads++
 		} else {
 			// Write operation
 			key := uint32(10000 + i)
-			tree.Insert(key, fmt.Sprintf("new-value-%d", i))
+			tree.Insert(storage.Uint32Key(key), fmt.Sprintf("new-value-%d", i))
 			writes++
 		}
 	}
@@ -199,6 +251,7 @@ func BenchmarkInOrderTraversal(b *testing.B) {
 	dbFile := "bench_traversal.db"
 	walFile := "bench_traversal.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -221,7 +274,7 @@ func BenchmarkInOrderTraversal(b *testing.B) {
 	for i := 0; i < 100000; i++ {
 		key := uint32(i)
 		value := fmt.Sprintf("value-%d", i)
-		tree.Insert(key, value)
+		tree.Insert(storage.Uint32Key(key), value)
 	}
 
 	b.ResetTimer()
@@ -242,8 +295,8 @@ func BenchmarkInOrderTraversal(b *testing.B) {
 
 	// Check sorted order
 	for i := 1; i < len(keys); i++ {
-		if keys[i] <= keys[i-1] {
-			b.Fatalf("Keys not in order at index %d: %d <= %d", i, keys[i], keys[i-1])
+		if bytes.Compare(keys[i], keys[i-1]) <= 0 {
+			b.Fatalf("Keys not in order at index %d: %x <= %x", i, keys[i], keys[i-1])
 		}
 	}
 
@@ -259,6 +312,7 @@ func BenchmarkRandomInserts(b *testing.B) {
 	dbFile := "bench_random_inserts.db"
 	walFile := "bench_random_inserts.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -293,7 +347,7 @@ func BenchmarkRandomInserts(b *testing.B) {
 
 	for _, key := range keys {
 		value := fmt.Sprintf("value-%d", key)
-		if err := tree.Insert(key, value); err != nil {
+		if err := tree.Insert(storage.Uint32Key(key), value); err != nil {
 			b.Fatalf("Insert failed: %v", err)
 		}
 	}
@@ -314,6 +368,7 @@ func Test100kCorrectnessWithTraversal(t *testing.T) {
 	dbFile := "test_100k_correctness.db"
 	walFile := "test_100k_correctness.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -337,7 +392,7 @@ func Test100kCorrectnessWithTraversal(t *testing.T) {
 	for i := 0; i < 100000; i++ {
 		key := uint32(i)
 		value := fmt.Sprintf("value-%d", i)
-		if err := tree.Insert(key, value); err != nil {
+		if err := tree.Insert(storage.Uint32Key(key), value); err != nil {
 			t.Fatalf("Insert failed at key %d: %v", key, err)
 		}
 
@@ -358,7 +413,7 @@ func Test100kCorrectnessWithTraversal(t *testing.T) {
 		key := uint32(i)
 		expectedValue := fmt.Sprintf("value-%d", i)
 
-		value, found, err := tree.Search(key)
+		value, found, err := tree.Search(storage.Uint32Key(key))
 		if err != nil {
 			t.Fatalf("Search failed at key %d: %v", key, err)
 		}
@@ -397,17 +452,17 @@ func Test100kCorrectnessWithTraversal(t *testing.T) {
 
 	// Check sorted order
 	for i := 1; i < len(keys); i++ {
-		if keys[i] <= keys[i-1] {
-			t.Fatalf("Keys not sorted at index %d: %d <= %d", i, keys[i], keys[i-1])
+		if bytes.Compare(keys[i], keys[i-1]) <= 0 {
+			t.Fatalf("Keys not sorted at index %d: %x <= %x", i, keys[i], keys[i-1])
 		}
 	}
 
 	// Check range
-	if keys[0] != 0 {
-		t.Fatalf("Min key: expected 0, got %d", keys[0])
+	if !bytes.Equal(keys[0], storage.Uint32Key(0)) {
+		t.Fatalf("Min key: expected 0, got %x", keys[0])
 	}
-	if keys[len(keys)-1] != 99999 {
-		t.Fatalf("Max key: expected 99999, got %d", keys[len(keys)-1])
+	if !bytes.Equal(keys[len(keys)-1], storage.Uint32Key(99999)) {
+		t.Fatalf("Max key: expected 99999, got %x", keys[len(keys)-1])
 	}
 
 	t.Log("✓ All keys in correct sorted order")
@@ -435,6 +490,7 @@ func BenchmarkBufferPoolSizes(b *testing.B) {
 			dbFile := fmt.Sprintf("bench_buffer_%d.db", size)
 			walFile := fmt.Sprintf("bench_buffer_%d.wal", size)
 			defer os.Remove(dbFile)
+			defer os.Remove(dbFile + storage.JournalSuffix)
 			defer os.Remove(walFile)
 
 			pager, _ := storage.NewFilePager(dbFile)
@@ -448,14 +504,14 @@ func BenchmarkBufferPoolSizes(b *testing.B) {
 
 			// Insert 10k keys
 			for i := 0; i < 10000; i++ {
-				tree.Insert(uint32(i), fmt.Sprintf("value-%d", i))
+				tree.Insert(storage.Uint32Key(uint32(i)), fmt.Sprintf("value-%d", i))
 			}
 
 			b.ResetTimer()
 
 			// Benchmark reads
 			for i := 0; i < 10000; i++ {
-				tree.Search(uint32(i % 10000))
+				tree.Search(storage.Uint32Key(uint32(i % 10000)))
 			}
 
 			b.StopTimer()
@@ -465,3 +521,51 @@ func BenchmarkBufferPoolSizes(b *testing.B) {
 		})
 	}
 }
+
+// BenchmarkPageSizes compares full scans over files with different page sizes
+func BenchmarkPageSizes(b *testing.B) {
+	sizes := []int{4096, 8192, 16384, 32768}
+
+	for _, size := range sizes {
+		b.Run(fmt.Sprintf("Page%dKB", size/1024), func(b *testing.B) {
+			dbFile := fmt.Sprintf("bench_page_%d.db", size)
+			walFile := fmt.Sprintf("bench_page_%d.wal", size)
+			defer os.Remove(dbFile)
+			defer os.Remove(dbFile + storage.JournalSuffix)
+			defer os.Remove(walFile)
+
+			pager, err := storage.NewFilePagerWithPageSize(dbFile, size)
+			if err != nil {
+				b.Fatalf("Failed to create pager: %v", err)
+			}
+			defer pager.Close()
+
+			// The same amount of cache memory for every page size
+			bufferPool := storage.NewBufferPool(pager, 128*4096/size)
+			defer bufferPool.Close()
+
+			tree, _ := bptree.NewBPTree(bufferPool, 100, walFile)
+			defer tree.Close()
+
+			// Insert 10k keys
+			for i := 0; i < 10000; i++ {
+				tree.Insert(storage.Uint32Key(uint32(i)), fmt.Sprintf("value-%d", i))
+			}
+
+			b.ResetTimer()
+
+			// Benchmark full scans
+			for i := 0; i < b.N; i++ {
+				rows, err := tree.Scan(nil, nil, 0)
+				if err != nil || len(rows) != 10000 {
+					b.Fatalf("Scan returned %d rows (err=%v), expected 10000", len(rows), err)
+				}
+			}
+
+			b.StopTimer()
+
+			stats := bufferPool.GetStats()
+			b.Logf("Page size %d: %d pages, hit rate %.2f%%", size, pager.NumPages(), stats.HitRate*100)
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package bptree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Online backup
+//
+// Backup copies the database file and the WAL while reads and writes go on.
+// With writes stopped for a moment it writes the cached pages to the file,
+// opens a page snapshot of it (see storage.PageSnapshot) and copies the WAL
+// entries up to the last LSN into memory; then writes resume and the pages
+// are streamed from the snapshot, which keeps the image of every page
+// overwritten before it is copied. The copy is the file as of that LSN:
+// opening a restored file replays the copied entries after its checkpoint
+// like after a crash, and drops those of transactions not committed by then
+//
+// Stream layout, integers little endian:
+//   - header: [magic "SGBK" 4][version 4][page size 4][pages 8][WAL bytes 8][LSN 8][CRC32 4]
+//   - every page of the file, each followed by its CRC32
+//   - the WAL file, followed by its CRC32
+//
+// Restore checks every checksum into temporary files and only then renames
+// them over the database and WAL files
+
+// ErrCorruptBackup is returned when restoring a backup that fails its checks
+var ErrCorruptBackup = errors.New("corrupt backup")
+
+const (
+	backupMagic      = "SGBK"
+	backupVersion    = 1
+	backupHeaderSize = 40
+)
+
+// BackupInfo describes a backup
+type BackupInfo struct {
+	Pages    uint64        // pages of the database file copied
+	PageSize int           // page size of the database file
+	WALBytes int64         // bytes of WAL copied
+	LSN      uint64        // last WAL entry in the backup
+	Bytes    int64         // size of the backup stream
+	Duration time.Duration // time spent
+}
+
+// Backup writes a consistent copy of the database file and its WAL to w
+// Reads and writes continue while the pages are copied, DDL, bulk loads and
+// VACUUM wait until it is done. It can be called on any table of the file
+func (tree *BPTree) Backup(w io.Writer) (BackupInfo, error) {
+	main := tree.main
+	start := time.Now()
+
+	main.ddlMu.Lock()
+	defer main.ddlMu.Unlock()
+
+	snap, walCopy, lsn, err := main.openBackup()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer snap.Close()
+
+	info := BackupInfo{
+		Pages:    snap.NumPages(),
+		PageSize: snap.PageSize(),
+		WALBytes: int64(walCopy.Len()),
+		LSN:      lsn,
+	}
+
+	out := bufio.NewWriter(w)
+	written, err := writeBackup(out, snap, walCopy.Bytes(), info)
+	info.Bytes = written
+	if err != nil {
+		return info, err
+	}
+	if err := out.Flush(); err != nil {
+		return info, fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	info.Duration = time.Since(start)
+	return info, nil
+}
+
+// openBackup stops writes, opens a snapshot of the pages and copies the WAL
+// entries they do not cover yet
+// The caller holds ddlMu and closes the snapshot
+func (tree *BPTree) openBackup() (*storage.PageSnapshot, *bytes.Buffer, uint64, error) {
+	// No checkpoint changes the superblock or drops WAL entries in between
+	tree.checkpointMu.Lock()
+	defer tree.checkpointMu.Unlock()
+	tree.writeLatch.Lock()
+	defer tree.writeLatch.Unlock()
+
+	// The superblock only names the roots of the last checkpoint, take one so
+	// it matches the pages of the snapshot
+	if _, err := tree.checkpoint(time.Now(), tree.wal.LastLSN(), tree.oldestActiveTxLSN()); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to checkpoint: %w", err)
+	}
+
+	snap, err := tree.pager.Snapshot()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to snapshot pages: %w", err)
+	}
+
+	lsn := tree.wal.LastLSN()
+	walCopy := new(bytes.Buffer)
+	if _, err := tree.wal.CopyTo(walCopy, lsn); err != nil {
+		snap.Close()
+		return nil, nil, 0, err
+	}
+
+	return snap, walCopy, lsn, nil
+}
+
+// writeBackup writes the backup stream, returns the number of bytes written
+func writeBackup(w io.Writer, snap *storage.PageSnapshot, walData []byte, info BackupInfo) (int64, error) {
+	var written int64
+	write := func(data []byte) error {
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+		return nil
+	}
+	sum := make([]byte, 4)
+	writeSum := func(data []byte) error {
+		binary.LittleEndian.PutUint32(sum, crc32.ChecksumIEEE(data))
+		return write(sum)
+	}
+
+	if err := write(encodeBackupHeader(info)); err != nil {
+		return written, err
+	}
+
+	for id := uint64(0); id < info.Pages; id++ {
+		page, err := snap.ReadPage(id)
+		if err != nil {
+			return written, fmt.Errorf("failed to read page %d: %w", id, err)
+		}
+		if err := write(page); err != nil {
+			return written, err
+		}
+		if err := writeSum(page); err != nil {
+			return written, err
+		}
+	}
+
+	if err := write(walData); err != nil {
+		return written, err
+	}
+	return written, writeSum(walData)
+}
+
+// encodeBackupHeader returns the header of a backup stream
+func encodeBackupHeader(info BackupInfo) []byte {
+	buf := make([]byte, backupHeaderSize)
+	copy(buf[0:4], backupMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], backupVersion)
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(info.PageSize))
+	binary.LittleEndian.PutUint64(buf[12:20], info.Pages)
+	binary.LittleEndian.PutUint64(buf[20:28], uint64(info.WALBytes))
+	binary.LittleEndian.PutUint64(buf[28:36], info.LSN)
+	binary.LittleEndian.PutUint32(buf[36:40], crc32.ChecksumIEEE(buf[:36]))
+	return buf
+}
+
+// decodeBackupHeader checks the header of a backup stream
+func decodeBackupHeader(buf []byte) (BackupInfo, error) {
+	if string(buf[0:4]) != backupMagic {
+		return BackupInfo{}, fmt.Errorf("%w: not a backup", ErrCorruptBackup)
+	}
+	if crc32.ChecksumIEEE(buf[:36]) != binary.LittleEndian.Uint32(buf[36:40]) {
+		return BackupInfo{}, fmt.Errorf("%w: header checksum mismatch", ErrCorruptBackup)
+	}
+	if version := binary.LittleEndian.Uint32(buf[4:8]); version != backupVersion {
+		return BackupInfo{}, fmt.Errorf("unsupported backup version %d", version)
+	}
+
+	info := BackupInfo{
+		PageSize: int(binary.LittleEndian.Uint32(buf[8:12])),
+		Pages:    binary.LittleEndian.Uint64(buf[12:20]),
+		WALBytes: int64(binary.LittleEndian.Uint64(buf[20:28])),
+		LSN:      binary.LittleEndian.Uint64(buf[28:36]),
+	}
+	if !storage.ValidPageSize(info.PageSize) || info.Pages < 2 || info.WALBytes < wal.FileHeaderSize {
+		return BackupInfo{}, fmt.Errorf("%w: invalid header", ErrCorruptBackup)
+	}
+	return info, nil
+}
+
+// Restore replaces the database file dbPath and its WAL walPath with the
+// backup read from r, which must be closed
+// Nothing is replaced unless every checksum of the backup matches and the
+// restored files open; returns ErrCorruptBackup otherwise
+func Restore(r io.Reader, dbPath, walPath string) (BackupInfo, error) {
+	start := time.Now()
+	tmpDB, tmpWAL := dbPath+".restore", walPath+".restore"
+
+	info, err := restoreFiles(bufio.NewReader(r), tmpDB, tmpWAL)
+	if err == nil {
+		err = checkRestored(tmpDB, tmpWAL, info)
+	}
+	if err != nil {
+		os.Remove(tmpDB)
+		os.Remove(tmpWAL)
+		return BackupInfo{}, err
+	}
+
+	if err := replaceFiles(tmpDB, tmpWAL, dbPath, walPath); err != nil {
+		return BackupInfo{}, err
+	}
+
+	info.Duration = time.Since(start)
+	return info, nil
+}
+
+// replaceFiles renames restored files over the database and WAL files
+func replaceFiles(tmpDB, tmpWAL, dbPath, walPath string) error {
+	// The two renames are not atomic together: after a crash between them
+	// the files do not match, and the restore has to be run again
+	if err := os.Rename(tmpWAL, walPath); err != nil {
+		os.Remove(tmpDB)
+		os.Remove(tmpWAL)
+		return fmt.Errorf("failed to replace WAL: %w", err)
+	}
+	// A journal of the old file must not be rolled back into the restored one
+	if err := os.Remove(dbPath + storage.JournalSuffix); err != nil && !os.IsNotExist(err) {
+		os.Remove(tmpDB)
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	if err := os.Rename(tmpDB, dbPath); err != nil {
+		os.Remove(tmpDB)
+		return fmt.Errorf("failed to replace database file: %w", err)
+	}
+	return nil
+}
+
+// restoreFiles writes the pages and the WAL of a backup to dbPath and
+// walPath, checking every checksum
+func restoreFiles(r io.Reader, dbPath, walPath string) (BackupInfo, error) {
+	header := make([]byte, backupHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return BackupInfo{}, fmt.Errorf("%w: failed to read header: %v", ErrCorruptBackup, err)
+	}
+	info, err := decodeBackupHeader(header)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	info.Bytes = backupHeaderSize
+
+	sum := make([]byte, 4)
+	readChecked := func(data []byte, what string) error {
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("%w: failed to read %s: %v", ErrCorruptBackup, what, err)
+		}
+		if _, err := io.ReadFull(r, sum); err != nil {
+			return fmt.Errorf("%w: failed to read checksum of %s: %v", ErrCorruptBackup, what, err)
+		}
+		if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(sum) {
+			return fmt.Errorf("%w: checksum mismatch in %s", ErrCorruptBackup, what)
+		}
+		info.Bytes += int64(len(data) + len(sum))
+		return nil
+	}
+
+	dbFile, err := os.OpenFile(dbPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to create database file: %w", err)
+	}
+	defer dbFile.Close()
+	out := bufio.NewWriter(dbFile)
+
+	page := make([]byte, info.PageSize)
+	for id := uint64(0); id < info.Pages; id++ {
+		if err := readChecked(page, fmt.Sprintf("page %d", id)); err != nil {
+			return BackupInfo{}, err
+		}
+		if _, err := out.Write(page); err != nil {
+			return BackupInfo{}, fmt.Errorf("failed to write database file: %w", err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to write database file: %w", err)
+	}
+	if err := dbFile.Sync(); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to sync database file: %w", err)
+	}
+
+	walData := make([]byte, info.WALBytes)
+	if err := readChecked(walData, "WAL"); err != nil {
+		return BackupInfo{}, err
+	}
+	if n, _ := r.Read(sum); n != 0 {
+		return BackupInfo{}, fmt.Errorf("%w: data after the end of the backup", ErrCorruptBackup)
+	}
+
+	walFile, err := os.OpenFile(walPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to create WAL: %w", err)
+	}
+	defer walFile.Close()
+	if _, err := walFile.Write(walData); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to write WAL: %w", err)
+	}
+	if err := walFile.Sync(); err != nil {
+		return BackupInfo{}, fmt.Errorf("failed to sync WAL: %w", err)
+	}
+
+	return info, nil
+}
+
+// checkRestored opens the restored files the way the database will
+func checkRestored(dbPath, walPath string, info BackupInfo) error {
+	pager, err := storage.NewFilePager(dbPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+	}
+	pageSize, super := pager.PageSize(), pager.Superblock()
+	pager.Close()
+	if pageSize != info.PageSize || super.RootPage == 0 || super.RootPage >= info.Pages {
+		return fmt.Errorf("%w: superblock does not match the backup", ErrCorruptBackup)
+	}
+
+	walFile, err := wal.NewWAL(walPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+	}
+	discarded := walFile.DiscardedBytes()
+	lsn := walFile.LastLSN()
+	walFile.Close()
+	if discarded != 0 || (lsn != 0 && lsn != info.LSN) {
+		return fmt.Errorf("%w: WAL does not match the backup", ErrCorruptBackup)
+	}
+	return nil
+}
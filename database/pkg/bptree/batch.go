@@ -0,0 +1,99 @@
+package bptree
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// WriteBatch collects puts and deletes that Write logs as one WAL record
+// (one fsync) and applies together
+//
+// Unlike a Tx a batch is blind: it does not read the tree, so Put always
+// upserts and deleting a missing key is a no-op
+type WriteBatch struct {
+	ops []wal.BatchOp
+}
+
+// NewWriteBatch creates an empty batch
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{ops: make([]wal.BatchOp, 0)}
+}
+
+// Put inserts a key-value pair, replacing the value if the key exists
+// The key is copied, so the caller may reuse it
+func (b *WriteBatch) Put(key []byte, value string) {
+	b.ops = append(b.ops, wal.BatchOp{OpType: wal.OpUpdate, Key: bytes.Clone(key), Value: value})
+}
+
+// Delete removes a key
+func (b *WriteBatch) Delete(key []byte) {
+	b.ops = append(b.ops, wal.BatchOp{OpType: wal.OpDelete, Key: bytes.Clone(key)})
+}
+
+// Len returns the number of writes in the batch
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch so it can be reused
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// Write logs the batch as a single WAL record and applies its writes in order
+// After a crash replay applies either all of them or none
+func (tree *BPTree) Write(batch *WriteBatch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	for _, op := range batch.ops {
+		if err := checkKey(op.Key); err != nil {
+			return err
+		}
+	}
+
+	if err := tree.writeBatch(batch.ops); err != nil {
+		return err
+	}
+
+	tree.maybeCheckpoint()
+	return nil
+}
+
+// writeBatch logs and applies a batch with no other write in flight,
+// so its writes cannot interleave with single-key writes to the same keys
+func (tree *BPTree) writeBatch(ops []wal.BatchOp) error {
+	tree.writeLatch.Lock()
+	defer tree.writeLatch.Unlock()
+
+	for _, op := range ops {
+		if op.OpType != wal.OpDelete {
+			if err := tree.checkIndexedKey(op.Key); err != nil {
+				return err
+			}
+		}
+	}
+
+	entry := wal.NewBatchEntry(ops)
+	entry.Table = tree.id
+	if err := tree.wal.Append(entry); err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+
+	return tree.applyBatch(ops, entry.LSN)
+}
+
+// applyBatch applies the writes of a batch without logging them again,
+// all stamped with the batch's commit timestamp ts
+func (tree *BPTree) applyBatch(ops []wal.BatchOp, ts uint64) error {
+	for i, op := range ops {
+		entry := &wal.Entry{OpType: op.OpType, Table: tree.id, Key: op.Key, Value: op.Value}
+		if err := tree.applyEntry(entry, ts); err != nil {
+			return fmt.Errorf("failed to apply batch write %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -1,25 +1,124 @@
 package bptree
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
 	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 	"github.com/spaghetti-lover/sharingan-db/internal/wal"
 )
 
 // BPTree represents a B+ Tree index
+// It is safe for concurrent use (see latch.go)
+//
+// Every table of a file is a BPTree. The tree opened with NewBPTree or
+// LoadBPTree is the default table, the others come from its catalog (see
+// catalog.go) and share its pager, WAL, snapshots and transactions
 type BPTree struct {
-	pager    storage.Pager
-	rootPage uint64
-	order    int // Maximum number of keys per node
-	wal      *wal.WAL
+	*sharedState
+
+	id           uint32    // catalog ID, 0 for the default table
+	schema       []byte    // catalog schema, nil if the table has none
+	indexed      *BPTree   // table whose values this tree indexes, nil if it is not an index
+	valueIndexes []*BPTree // indexes on the values, changed with writeLatch held exclusively
+	pager        storage.Pager
+	rootPage     uint64             // read under rootLatch, written under rootLatch and metaMu
+	order        int                // Maximum number of keys per node
+	cmp          storage.Comparator // key order, fixed for the life of the file
+	bytewise     bool               // cmp is the default order, so separators can be shortened
+
+	rootLatch sync.RWMutex // parent latch of the root page
 }
 
-// NewBPTree creates a new B+ Tree
+// sharedState is the state of the file, shared by all of its tables
+type sharedState struct {
+	wal     *wal.WAL
+	latches latchTable    // one latch per page
+	freed   atomic.Uint64 // pages freed or moved so far (see latch.go)
+	pins    pagePinner    // the pager if it pins pages (see latch.go), nil otherwise
+
+	// writeLatch keeps the WAL order of conflicting writes equal to the order
+	// they reach the pages. Single-key writes hold it shared from logging until
+	// applied (the leaf latch orders them), batches and commits exclusively
+	writeLatch sync.RWMutex
+
+	// Open snapshots, changed only with writeLatch held exclusively so they
+	// stay the same for the length of a write (see mvcc.go)
+	snapshots map[uint64]int // snapshot timestamp -> open handles
+	garbage   atomic.Bool    // records may hold versions no snapshot needs
+
+	metaMu        sync.Mutex // serializes superblock writes
+	checkpointLSN uint64     // WAL entries up to this LSN are on disk, written under metaMu
+
+	checkpointMu     sync.Mutex // serializes checkpoints, guards the fields below
+	checkpointPolicy CheckpointPolicy
+	lastCheckpoint   time.Time
+	checkpoints      int
+	checkpointErr    error // last automatic checkpoint failure, nil after a success
+
+	txMu      sync.Mutex        // guards nextTxID and activeTxs
+	nextTxID  uint64            // last transaction ID handed out by Begin
+	activeTxs map[uint64]uint64 // open transactions that logged writes -> BEGIN LSN
+
+	main *BPTree // the default table, whose root is in the superblock
+
+	ddlMu       sync.Mutex   // serializes CreateTable and DropTable
+	catalogMu   sync.RWMutex // guards the fields below
+	catalogPage uint64       // page holding the catalog, 0 until the first table
+	nextTableID uint32       // last table ID handed out by CreateTable
+	tables      map[string]*BPTree
+	indexes     map[string]*BPTree
+	tablesByID  map[uint32]*BPTree // tables and indexes
+}
+
+// newSharedState returns the state of a file stored by pager whose WAL is
+// walFile. A buffer pool pager is made to keep its pages behind the WAL
+func newSharedState(walFile *wal.WAL, pager storage.Pager) *sharedState {
+	state := &sharedState{
+		wal:              walFile,
+		checkpointPolicy: DefaultCheckpointPolicy(),
+		lastCheckpoint:   time.Now(),
+		activeTxs:        make(map[uint64]uint64),
+		snapshots:        make(map[uint64]int),
+		tables:           make(map[string]*BPTree),
+		indexes:          make(map[string]*BPTree),
+		tablesByID:       make(map[uint32]*BPTree),
+	}
+
+	if pins, ok := pager.(pagePinner); ok {
+		pins.SetWAL(walFile)
+		state.pins = pins
+	}
+	return state
+}
+
+// ErrKeyTooLarge is returned when a key is longer than storage.MaxKeySize
+var ErrKeyTooLarge = errors.New("key too large")
+
+// ErrNoMetadata is returned when loading a tree from a file that has none
+var ErrNoMetadata = errors.New("no tree metadata in database file")
+
+// ErrInvalidRoot is returned when loading a tree whose root is not a leaf or
+// internal page
+var ErrInvalidRoot = errors.New("invalid root page")
+
+// NewBPTree creates a new B+ Tree ordering keys bytewise
 func NewBPTree(pager storage.Pager, order int, walPath string) (*BPTree, error) {
+	return NewBPTreeWithComparator(pager, order, walPath, nil)
+}
+
+// NewBPTreeWithComparator creates a new B+ Tree ordering keys with cmp, or
+// bytewise if cmp is nil
+// The comparator is not stored, the tree must always be loaded with the same one
+func NewBPTreeWithComparator(pager storage.Pager, order int, walPath string, cmp storage.Comparator) (*BPTree, error) {
 	rootPageID, rootPage, err := allocatePageWithType(pager, storage.PageTypeLeaf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to allocate root page: %w", err)
@@ -37,14 +136,24 @@ func NewBPTree(pager storage.Pager, order int, walPath string) (*BPTree, error)
 
 	// Create tree instance FIRST
 	tree := &BPTree{
-		pager:    pager,
-		rootPage: rootPageID,
-		order:    order,
-		wal:      walFile,
+		sharedState: newSharedState(walFile, pager),
+		pager:       pager,
+		rootPage:    rootPageID,
+		order:       order,
+		cmp:         cmp,
+		bytewise:    cmp == nil,
 	}
+	if tree.bytewise {
+		tree.cmp = storage.CompareBytes
+	}
+	tree.main = tree
 
-	// Save metadata for recovery
-	if err := tree.SaveMetadata(walPath + ".meta"); err != nil {
+	// Save metadata for recovery, once the root it names is on disk
+	if err := pager.Flush(); err != nil {
+		walFile.Close()
+		return nil, fmt.Errorf("failed to flush root page: %w", err)
+	}
+	if err := tree.SaveMetadata(); err != nil {
 		walFile.Close() // Clean up WAL if metadata save fails
 		return nil, fmt.Errorf("failed to save metadata: %w", err)
 	}
@@ -52,19 +161,49 @@ func NewBPTree(pager storage.Pager, order int, walPath string) (*BPTree, error)
 	return tree, nil
 }
 
-// LoadBPTree loads an existing B+ Tree from disk
+// LoadBPTree loads an existing B+ Tree from disk, ordering keys bytewise
 func LoadBPTree(pager storage.Pager, rootPageID uint64, order int, walPath string) (*BPTree, error) {
+	return LoadBPTreeWithComparator(pager, rootPageID, order, walPath, nil)
+}
+
+// LoadBPTreeWithComparator loads an existing B+ Tree whose keys are ordered by
+// cmp, or bytewise if cmp is nil
+func LoadBPTreeWithComparator(pager storage.Pager, rootPageID uint64, order int, walPath string, cmp storage.Comparator) (*BPTree, error) {
 	// Open WAL
 	walFile, err := wal.NewWAL(walPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open WAL: %w", err)
 	}
 
+	// Entries in the WAL follow the last checkpoint, a file whose metadata was
+	// never saved has no checkpoint (LSN 0) and no tables
+	super := pager.Superblock()
+	checkpointLSN, catalogPage := super.CheckpointLSN, super.CatalogPage
+	walFile.AdvanceLSN(checkpointLSN)
+
 	tree := &BPTree{
-		pager:    pager,
-		rootPage: rootPageID,
-		order:    order,
-		wal:      walFile,
+		sharedState: newSharedState(walFile, pager),
+		pager:       pager,
+		rootPage:    rootPageID,
+		order:       order,
+		cmp:         cmp,
+		bytewise:    cmp == nil,
+	}
+	if tree.bytewise {
+		tree.cmp = storage.CompareBytes
+	}
+	tree.main = tree
+	tree.checkpointLSN = checkpointLSN
+	tree.catalogPage = catalogPage
+
+	// Replay routes entries to their tables, so open them first
+	if err := tree.loadCatalog(); err != nil {
+		walFile.Close()
+		return nil, fmt.Errorf("failed to load catalog: %w", err)
+	}
+	if err := tree.checkRoots(); err != nil {
+		walFile.Close()
+		return nil, err
 	}
 
 	// Replay WAL entries
@@ -77,46 +216,66 @@ func LoadBPTree(pager storage.Pager, rootPageID uint64, order int, walPath strin
 }
 
 // Insert inserts a key-value pair into the B+ Tree
-func (tree *BPTree) Insert(key uint32, value string) error {
-	walEntry := &wal.Entry{
-		OpType: wal.OpInsert,
-		Key:    key,
-		Value:  value,
+// Returns ErrKeyExists if the key is already present (use Upsert to replace)
+func (tree *BPTree) Insert(key []byte, value string) error {
+	if err := checkKey(key); err != nil {
+		return err
 	}
 
-	if err := tree.wal.Append(walEntry); err != nil {
-		return fmt.Errorf("failed to write WAL: %w", err)
-	}
+	err := tree.writeKey(key, tree.versionSafe(key, value, false), func(path *writePath, leafPageID uint64, leafPage *storage.Page) error {
+		// Check before logging so a rejected insert never reaches the WAL
+		if record, found := tree.leafPage(leafPage).SearchRecord(key); found && !record.Deleted {
+			return fmt.Errorf("%w: %s", ErrKeyExists, FormatKey(key))
+		}
+		if err := tree.checkIndexedKey(key); err != nil {
+			return err
+		}
+
+		walEntry := &wal.Entry{
+			OpType: wal.OpInsert,
+			Table:  tree.id,
+			Key:    key,
+			Value:  value,
+		}
 
-	record := storage.NewRecordFromInts(key, value)
+		if err := tree.wal.Append(walEntry); err != nil {
+			return fmt.Errorf("failed to write WAL: %w", err)
+		}
 
-	// Load root page
-	rootPage, err := readPageStruct(tree.pager, tree.rootPage)
+		_, err := tree.writeVersion(path, leafPageID, leafPage, key, value, false, walEntry.LSN)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to load root page: %w", err)
+		return err
 	}
 
-	// If root is leaf, handle insertion (possibly splitting)
-	if rootPage.IsLeaf() {
-		newChildKey, newChildPageID, err := tree.insertIntoLeafWithSplit(tree.rootPage, rootPage, record)
-		if err != nil {
-			return err
-		}
+	tree.maybeCheckpoint()
+	return nil
+}
 
-		// If split occurred, create new root
-		if newChildPageID != 0 {
-			return tree.createNewRoot(tree.rootPage, newChildKey, newChildPageID)
-		}
+// writeKey runs a single-key write on the exclusively latched leaf for key
+// write logs the change and applies it while the leaf is latched, so writes
+// to the same key reach the WAL in the order they reach the page
+func (tree *BPTree) writeKey(key []byte, safe func(*storage.Page) bool,
+	write func(path *writePath, leafPageID uint64, leafPage *storage.Page) error) error {
+	tree.writeLatch.RLock()
+	defer tree.writeLatch.RUnlock()
 
-		return nil
+	path, leafPageID, leafPage, err := tree.descendExclusive(key, safe)
+	if err != nil {
+		return fmt.Errorf("failed to find leaf page: %w", err)
 	}
+	defer path.release()
 
-	// Root is internal, navigate and insert
-	return tree.insertNonLeafRoot(key, record)
+	return write(path, leafPageID, leafPage)
 }
 
 // replayWAL replays all WAL entries to restore state
 func (tree *BPTree) replayWAL() error {
+	if discarded := tree.wal.DiscardedBytes(); discarded > 0 {
+		fmt.Printf("⚠️  Discarded %d bytes of torn WAL tail\n", discarded)
+	}
+
 	entries, err := tree.wal.ReadAll()
 	if err != nil {
 		return fmt.Errorf("failed to read WAL: %w", err)
@@ -128,52 +287,85 @@ func (tree *BPTree) replayWAL() error {
 
 	fmt.Printf("🔄 Replaying %d WAL entries...\n", len(entries))
 
-	for i, entry := range entries {
-		switch entry.OpType {
-		case wal.OpInsert:
-			// Apply insert directly to tree (without writing to WAL again)
-			record := storage.NewRecordFromInts(entry.Key, entry.Value)
-			if err := tree.insertWithoutWAL(record); err != nil {
-				return fmt.Errorf("failed to replay insert at entry %d: %w", i, err)
+	// Writes of each transaction wait here for its COMMIT record
+	pending := make(map[uint64][]*wal.Entry)
+
+	for _, entry := range entries {
+		tree.nextTxID = max(tree.nextTxID, entry.TxID)
+
+		switch {
+		case entry.OpType == wal.OpBegin:
+			pending[entry.TxID] = make([]*wal.Entry, 0)
+
+		case entry.OpType == wal.OpAbort:
+			delete(pending, entry.TxID)
+
+		case entry.OpType == wal.OpBulkLoad:
+			// The load checkpoints before and after its marker: the table
+			// is either still empty or loaded (see bulk_load.go)
+			continue
+
+		case entry.OpType == wal.OpCatalog:
+			// Changes up to the checkpoint are in its catalog
+			if entry.LSN <= tree.checkpointLSN {
+				continue
+			}
+			if err := tree.replayCatalogChange(entry); err != nil {
+				return fmt.Errorf("failed to replay catalog change at LSN %d: %w", entry.LSN, err)
 			}
-		default:
-			return fmt.Errorf("unsupported WAL operation: %d", entry.OpType)
-		}
-	}
 
-	fmt.Printf("✓ WAL replay complete\n")
+		case entry.OpType == wal.OpCommit:
+			writes := pending[entry.TxID]
+			delete(pending, entry.TxID)
 
-	// Clear WAL after successful replay
-	return tree.wal.Truncate()
-}
+			// A transaction is applied at its commit, so its COMMIT LSN
+			// tells whether the checkpoint already has it
+			if entry.LSN <= tree.checkpointLSN {
+				continue
+			}
+			for _, write := range writes {
+				if err := tree.applyEntry(write, entry.LSN); err != nil {
+					return fmt.Errorf("failed to replay entry at LSN %d: %w", write.LSN, err)
+				}
+			}
 
-// insertWithoutWAL inserts without writing to WAL (used during replay)
-func (tree *BPTree) insertWithoutWAL(record *storage.Record) error {
-	key, _ := record.GetKeyAsUint32()
+		case entry.TxID != 0:
+			pending[entry.TxID] = append(pending[entry.TxID], entry)
 
-	rootPage, err := readPageStruct(tree.pager, tree.rootPage)
-	if err != nil {
-		return fmt.Errorf("failed to load root page: %w", err)
+		default:
+			// Already on disk (crash between checkpoint and WAL truncation)
+			if entry.LSN <= tree.checkpointLSN {
+				continue
+			}
+			// Apply directly to tree (without writing to WAL again)
+			if err := tree.applyEntry(entry, entry.LSN); err != nil {
+				return fmt.Errorf("failed to replay entry at LSN %d: %w", entry.LSN, err)
+			}
+		}
 	}
 
-	if rootPage.IsLeaf() {
-		newChildKey, newChildPageID, err := tree.insertIntoLeafWithSplit(tree.rootPage, rootPage, record)
-		if err != nil {
-			return err
-		}
+	if len(pending) > 0 {
+		fmt.Printf("⚠️  Discarded %d uncommitted transactions\n", len(pending))
+	}
 
-		if newChildPageID != 0 {
-			return tree.createNewRoot(tree.rootPage, newChildKey, newChildPageID)
-		}
+	fmt.Printf("✓ WAL replay complete\n")
 
-		return nil
+	// Replayed changes may only live in the buffer pool, so flush
+	// them before clearing the WAL
+	if _, err := tree.Checkpoint(); err != nil {
+		return fmt.Errorf("failed to checkpoint after replay: %w", err)
 	}
 
-	return tree.insertNonLeafRoot(key, record)
+	return nil
 }
 
 // Close closes the B+ Tree and WAL
+// Closing a table other than the default one does nothing, the file is
+// closed with the default table
 func (tree *BPTree) Close() error {
+	if tree.id != 0 {
+		return nil
+	}
 	if tree.wal != nil {
 		if err := tree.wal.Close(); err != nil {
 			return fmt.Errorf("failed to close WAL: %w", err)
@@ -190,117 +382,151 @@ func (tree *BPTree) GetWALSyncCount() int {
 	return tree.wal.GetSyncCount()
 }
 
-// insertNonLeafRoot handles insertion when root is internal
-func (tree *BPTree) insertNonLeafRoot(key uint32, record *storage.Record) error {
-	// Find leaf page
-	leafPageID, err := tree.findLeafPage(key)
-	if err != nil {
-		return fmt.Errorf("failed to find leaf page: %w", err)
+// SetWALSyncMode changes when WAL appends are fsynced (see wal.SyncMode)
+func (tree *BPTree) SetWALSyncMode(mode wal.SyncMode, interval time.Duration) error {
+	if tree.wal == nil {
+		return fmt.Errorf("tree has no WAL")
 	}
+	return tree.wal.SetSyncMode(mode, interval)
+}
 
-	leafPage, err := readPageStruct(tree.pager, leafPageID)
-	if err != nil {
-		return fmt.Errorf("failed to load leaf page: %w", err)
+// GetWALDurability describes the WAL sync mode and its worst-case loss window
+func (tree *BPTree) GetWALDurability() string {
+	if tree.wal == nil {
+		return "no WAL"
 	}
+	return tree.wal.Durability()
+}
 
-	// Insert into leaf (possibly splitting)
-	newChildKey, newChildPageID, err := tree.insertIntoLeafWithSplit(leafPageID, leafPage, record)
-	if err != nil {
-		return err
+// EnableWALArchive keeps the WAL entries checkpoints drop in the archive
+// directory of the WAL, which stays on when the file is reopened (see wal/archive.go)
+func (tree *BPTree) EnableWALArchive() error {
+	if tree.wal == nil {
+		return fmt.Errorf("tree has no WAL")
 	}
+	return tree.wal.EnableArchive()
+}
 
-	// If split occurred, insert promoted key into parent
-	if newChildPageID != 0 {
-		return tree.insertIntoParent(leafPageID, newChildKey, newChildPageID)
+// GetWALArchiveDir returns the WAL archive directory, "" if archiving is off
+func (tree *BPTree) GetWALArchiveDir() string {
+	if tree.wal == nil {
+		return ""
 	}
+	return tree.wal.ArchiveDir()
+}
 
-	return nil
+// GetWALGroupCommitStats returns batch size and latency stats of WAL group commit
+func (tree *BPTree) GetWALGroupCommitStats() wal.GroupCommitStats {
+	if tree.wal == nil {
+		return wal.GroupCommitStats{}
+	}
+	return tree.wal.GetGroupCommitStats()
 }
 
 // insertIntoLeafWithSplit inserts record into leaf, splitting if necessary
 // Returns (promotedKey, newPageID, error)
-// If no split: returns (0, 0, nil)
-func (tree *BPTree) insertIntoLeafWithSplit(pageID uint64, page *storage.Page, record *storage.Record) (uint32, uint64, error) {
-	leaf := storage.NewLeafPage(page)
+// If no split: returns (nil, 0, nil)
+func (tree *BPTree) insertIntoLeafWithSplit(path *writePath, pageID uint64, page *storage.Page, record *storage.Record) ([]byte, uint64, error) {
+	leaf := tree.leafPage(page)
 
 	// Try simple insert
 	err := leaf.InsertRecord(record)
 	if err == nil {
 		// Success without split
-		return 0, 0, writePageStruct(tree.pager, pageID, page)
+		return nil, 0, writePageStruct(tree.pager, pageID, page)
 	}
 
 	// Page is full, need to split
-	return tree.splitLeaf(pageID, page, record)
+	return tree.splitLeaf(path, pageID, page, record)
 }
 
 // splitLeaf splits a full leaf page
 // Returns (promotedKey, newPageID, error)
-func (tree *BPTree) splitLeaf(oldPageID uint64, oldPage *storage.Page, newRecord *storage.Record) (uint32, uint64, error) {
-	oldLeaf := storage.NewLeafPage(oldPage)
+func (tree *BPTree) splitLeaf(path *writePath, oldPageID uint64, oldPage *storage.Page, newRecord *storage.Record) ([]byte, uint64, error) {
+	oldLeaf := tree.leafPage(oldPage)
 
 	// Get all existing records + new record
 	allRecords, err := oldLeaf.GetAllRecords()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get records: %w", err)
+		return nil, 0, fmt.Errorf("failed to get records: %w", err)
 	}
 	allRecords = append(allRecords, newRecord)
 
 	// Sort records by key
-	sortRecordsByKey(allRecords)
+	tree.sortRecordsByKey(allRecords)
 
-	// Find split point (middle)
-	splitIndex := len(allRecords) / 2
+	// Find split point (middle by bytes, so both halves fit)
+	splitIndex := leafSplitIndex(allRecords)
 
 	// Create new right leaf
 	newPageID, newPage, err := allocatePageWithType(tree.pager, storage.PageTypeLeaf)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to allocate new leaf: %w", err)
+		return nil, 0, fmt.Errorf("failed to allocate new leaf: %w", err)
 	}
-	newLeaf := storage.NewLeafPage(newPage)
+	path.lock(newPageID)
+	newLeaf := tree.leafPage(newPage)
 
 	// Clear old leaf and re-insert left half
 	oldPage.Header.NumKeys = 0
 	for i := 0; i < splitIndex; i++ {
 		if err := oldLeaf.InsertRecord(allRecords[i]); err != nil {
-			return 0, 0, fmt.Errorf("failed to insert into old leaf: %w", err)
+			return nil, 0, fmt.Errorf("failed to insert into old leaf: %w", err)
 		}
 	}
 
 	// Insert right half into new leaf
 	for i := splitIndex; i < len(allRecords); i++ {
 		if err := newLeaf.InsertRecord(allRecords[i]); err != nil {
-			return 0, 0, fmt.Errorf("failed to insert into new leaf: %w", err)
+			return nil, 0, fmt.Errorf("failed to insert into new leaf: %w", err)
 		}
 	}
 
-	// Update leaf chain: newLeaf.next = oldLeaf.next, oldLeaf.next = newLeaf
+	// Update leaf chain: oldLeaf <-> newLeaf <-> oldLeaf.next
+	nextPageID := uint64(oldPage.Header.NextPage)
 	newPage.Header.NextPage = oldPage.Header.NextPage
+	newPage.Header.PrevPage = uint32(oldPageID)
 	oldPage.Header.NextPage = uint32(newPageID)
+	if nextPageID != 0 {
+		if err := tree.setPrevLeaf(path, nextPageID, newPageID); err != nil {
+			return nil, 0, err
+		}
+	}
 
 	// Copy parent pointer
 	newPage.Header.Parent = oldPage.Header.Parent
 
-	// Get promoted key (first key of right leaf)
-	promotedKey, err := allRecords[splitIndex].GetKeyAsUint32()
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get promoted key: %w", err)
-	}
+	// Promoted key: the shortest key between the two halves, which is at
+	// most the first key of the right leaf
+	promotedKey := tree.separator(allRecords[splitIndex-1].Key, allRecords[splitIndex].Key)
 
 	// Write both pages
 	if err := writePageStruct(tree.pager, oldPageID, oldPage); err != nil {
-		return 0, 0, err
+		return nil, 0, err
 	}
 	if err := writePageStruct(tree.pager, newPageID, newPage); err != nil {
-		return 0, 0, err
+		return nil, 0, err
 	}
 
 	return promotedKey, newPageID, nil
 }
 
+// setPrevLeaf updates the previous-leaf pointer of a leaf
+// The leaf is right of the leaves the write holds, so it is latched left to right
+func (tree *BPTree) setPrevLeaf(path *writePath, pageID uint64, prevID uint64) error {
+	path.lock(pageID)
+
+	page, err := readPageStruct(tree.pager, pageID)
+	if err != nil {
+		return fmt.Errorf("failed to load page %d: %w", pageID, err)
+	}
+	page.Header.PrevPage = uint32(prevID)
+	return writePageStruct(tree.pager, pageID, page)
+}
+
 // insertIntoParent inserts promoted key into parent internal node
 // Handles recursive splitting up the tree
-func (tree *BPTree) insertIntoParent(leftChildID uint64, key uint32, rightChildID uint64) error {
+// The parent is already latched: the child split, so it was not safe
+func (tree *BPTree) insertIntoParent(path *writePath, leftChildID uint64, key []byte, rightChildID uint64) error {
 	// Load left child to get parent pointer
 	leftChild, err := readPageStruct(tree.pager, leftChildID)
 	if err != nil {
@@ -309,7 +535,7 @@ func (tree *BPTree) insertIntoParent(leftChildID uint64, key uint32, rightChildI
 
 	// If no parent, create new root
 	if leftChild.Header.Parent == 0 {
-		return tree.createNewRoot(leftChildID, key, rightChildID)
+		return tree.createNewRoot(path, leftChildID, key, rightChildID)
 	}
 
 	// Load parent
@@ -319,7 +545,7 @@ func (tree *BPTree) insertIntoParent(leftChildID uint64, key uint32, rightChildI
 		return fmt.Errorf("failed to load parent: %w", err)
 	}
 
-	parent := storage.NewInternalPage(parentPage)
+	parent := tree.internalPage(parentPage)
 
 	// Try to insert into parent
 	err = parent.InsertEntry(key, rightChildID)
@@ -338,53 +564,34 @@ func (tree *BPTree) insertIntoParent(leftChildID uint64, key uint32, rightChildI
 	}
 
 	// Parent is full, need to split
-	return tree.splitInternal(parentID, parentPage, key, rightChildID)
+	return tree.splitInternal(path, parentID, parentPage, key, rightChildID)
 }
 
 // splitInternal splits a full internal page
-func (tree *BPTree) splitInternal(oldPageID uint64, oldPage *storage.Page, newKey uint32, newChildID uint64) error {
-	oldInternal := storage.NewInternalPage(oldPage)
+func (tree *BPTree) splitInternal(path *writePath, oldPageID uint64, oldPage *storage.Page, newKey []byte, newChildID uint64) error {
+	oldInternal := tree.internalPage(oldPage)
 
 	// Collect all entries (keys + pointers)
-	type entry struct {
-		key    uint32
-		pageID uint64
-	}
-
-	entries := make([]entry, 0, oldPage.Header.NumKeys+1)
-
-	// Get existing entries
-	for i := 0; i < int(oldPage.Header.NumKeys); i++ {
-		k, p, err := oldInternal.GetKeyPointer(i)
-		if err != nil {
-			return fmt.Errorf("failed to get entry %d: %w", i, err)
-		}
-		entries = append(entries, entry{key: k, pageID: p})
+	entries, err := oldInternal.Entries()
+	if err != nil {
+		return fmt.Errorf("failed to get entries: %w", err)
 	}
 
 	// Insert new entry in sorted position
-	inserted := false
-	for i, e := range entries {
-		if newKey < e.key {
-			entries = append(entries[:i], append([]entry{{newKey, newChildID}}, entries[i:]...)...)
-			inserted = true
-			break
-		}
-	}
-	if !inserted {
-		entries = append(entries, entry{newKey, newChildID})
-	}
+	insertPos := oldInternal.ChildIndex(newKey)
+	entries = append(entries[:insertPos], append([]storage.InternalEntry{{Key: newKey, PageID: newChildID}}, entries[insertPos:]...)...)
 
-	// Find middle point
-	middleIndex := len(entries) / 2
-	middleKey := entries[middleIndex].key
+	// Find middle point (by bytes, so both halves fit)
+	middleIndex := internalSplitIndex(entries)
+	middleKey := entries[middleIndex].Key
 
 	// Create new right internal page
 	newPageID, newPage, err := allocatePageWithType(tree.pager, storage.PageTypeInternal)
 	if err != nil {
 		return fmt.Errorf("failed to allocate new root: %w", err)
 	}
-	newInternal := storage.NewInternalPage(newPage)
+	path.lock(newPageID)
+	newInternal := tree.internalPage(newPage)
 
 	// Get leftmost pointer (before all keys)
 	leftmostPtr, err := oldInternal.GetLeftmostPointer()
@@ -397,17 +604,17 @@ func (tree *BPTree) splitInternal(oldPageID uint64, oldPage *storage.Page, newKe
 	oldInternal.SetLeftmostPointer(leftmostPtr)
 
 	for i := 0; i < middleIndex; i++ {
-		if err := oldInternal.InsertEntry(entries[i].key, entries[i].pageID); err != nil {
+		if err := oldInternal.InsertEntry(entries[i].Key, entries[i].PageID); err != nil {
 			return fmt.Errorf("failed to insert into old internal: %w", err)
 		}
 	}
 
 	// Build new page (right half)
 	// Leftmost pointer of new page is the pointer associated with middle key
-	newInternal.SetLeftmostPointer(entries[middleIndex].pageID)
+	newInternal.SetLeftmostPointer(entries[middleIndex].PageID)
 
 	for i := middleIndex + 1; i < len(entries); i++ {
-		if err := newInternal.InsertEntry(entries[i].key, entries[i].pageID); err != nil {
+		if err := newInternal.InsertEntry(entries[i].Key, entries[i].PageID); err != nil {
 			return fmt.Errorf("failed to insert into new internal: %w", err)
 		}
 	}
@@ -416,19 +623,10 @@ func (tree *BPTree) splitInternal(oldPageID uint64, oldPage *storage.Page, newKe
 	newPage.Header.Parent = oldPage.Header.Parent
 
 	// Update parent pointers of children in new page
-	// Update leftmost pointer's child
-	child, err := readPageStruct(tree.pager, entries[middleIndex].pageID)
-	if err == nil {
-		child.Header.Parent = uint32(newPageID)
-		writePageStruct(tree.pager, entries[middleIndex].pageID, child)
-	}
-
-	// Update other children
-	for i := middleIndex + 1; i < len(entries); i++ {
-		child, err := readPageStruct(tree.pager, entries[i].pageID)
-		if err == nil {
-			child.Header.Parent = uint32(newPageID)
-			writePageStruct(tree.pager, entries[i].pageID, child)
+	// (leftmost pointer's child first, then the others)
+	for i := middleIndex; i < len(entries); i++ {
+		if err := tree.setParent(path, entries[i].PageID, newPageID); err != nil {
+			return err
 		}
 	}
 
@@ -442,18 +640,20 @@ func (tree *BPTree) splitInternal(oldPageID uint64, oldPage *storage.Page, newKe
 
 	// Recursively insert promoted key into parent
 	// Note: middle key is PUSHED UP (not copied like in leaf split)
-	return tree.insertIntoParent(oldPageID, middleKey, newPageID)
+	return tree.insertIntoParent(path, oldPageID, middleKey, newPageID)
 }
 
 // createNewRoot creates a new root when current root splits
-func (tree *BPTree) createNewRoot(leftChildID uint64, key uint32, rightChildID uint64) error {
+// The old root was not safe, so the write still holds rootLatch
+func (tree *BPTree) createNewRoot(path *writePath, leftChildID uint64, key []byte, rightChildID uint64) error {
 	// Allocate new root (internal node)
 	newRootID, newRootPage, err := allocatePageWithType(tree.pager, storage.PageTypeInternal)
 	if err != nil {
 		return fmt.Errorf("failed to allocate new root: %w", err)
 	}
+	path.lock(newRootID)
 
-	newRoot := storage.NewInternalPage(newRootPage)
+	newRoot := tree.internalPage(newRootPage)
 
 	// Set leftmost pointer to left child
 	if err := newRoot.SetLeftmostPointer(leftChildID); err != nil {
@@ -490,123 +690,108 @@ func (tree *BPTree) createNewRoot(leftChildID uint64, key uint32, rightChildID u
 	}
 
 	// Update tree's root pointer
-	tree.rootPage = newRootID
+	return tree.setRoot(newRootID)
+}
 
-	// Update metadata file with new root
-	if tree.wal != nil {
-		metaPath := tree.wal.Path() + ".meta"
-		if err := tree.SaveMetadata(metaPath); err != nil {
-			fmt.Printf("Warning: failed to update metadata after root change: %v\n", err)
+// setRoot updates the root pointer (and the catalog page for tables other
+// than the default one)
+// The superblock keeps the root of the last checkpoint: the new root reaches
+// it only once checkpoint has flushed the pages below it, so after a crash
+// the superblock never names a root that is not on disk
+// The caller holds rootLatch exclusively
+func (tree *BPTree) setRoot(pageID uint64) error {
+	tree.metaMu.Lock()
+	tree.rootPage = pageID
+	tree.metaMu.Unlock()
+
+	if tree.id != 0 {
+		if err := tree.saveCatalog(); err != nil {
+			return fmt.Errorf("failed to update catalog after root change: %w", err)
 		}
 	}
+	return nil
+}
 
+// checkRoots verifies that the root of every table is a leaf or internal
+// page, so a file whose roots were saved before their pages fails to load
+// instead of replaying into a free page
+func (tree *BPTree) checkRoots() error {
+	for _, table := range tree.allTables() {
+		page, err := readPageStruct(tree.pager, table.rootPage)
+		if err != nil {
+			return fmt.Errorf("%w: failed to read root page %d: %v", ErrInvalidRoot, table.rootPage, err)
+		}
+		if !page.IsLeaf() && !page.IsInternal() {
+			return fmt.Errorf("%w: root page %d of table %d is a %s page", ErrInvalidRoot, table.rootPage, table.id, page.Header.PageType)
+		}
+	}
 	return nil
 }
 
 // Search searches for a key in the B+ Tree
-func (tree *BPTree) Search(key uint32) (string, bool, error) {
-	leafPageID, err := tree.findLeafPage(key)
+func (tree *BPTree) Search(key []byte) (string, bool, error) {
+	_, leafPage, _, latch, err := tree.descendLatched(childIndexFor(key))
 	if err != nil {
 		return "", false, fmt.Errorf("failed to find leaf page: %w", err)
 	}
+	defer latch.RUnlock()
 
-	leafPage, err := readPageStruct(tree.pager, leafPageID)
-	if err != nil {
-		return "", false, fmt.Errorf("failed to load leaf page: %w", err)
-	}
-
-	leaf := storage.NewLeafPage(leafPage)
+	leaf := tree.leafPage(leafPage)
 	record, found := leaf.SearchRecord(key)
-	if !found {
+	if !found || record.Deleted {
 		return "", false, nil
 	}
 
-	return record.GetValueAsString(), true, nil
+	value, err := tree.newestValue(record)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
 }
 
 // findLeafPage navigates from root to leaf
-func (tree *BPTree) findLeafPage(key uint32) (uint64, error) {
-	currentPageID := tree.rootPage
-
-	for {
-		page, err := readPageStruct(tree.pager, currentPageID)
-		if err != nil {
-			return 0, fmt.Errorf("failed to read page %d: %w", currentPageID, err)
-		}
-
-		if page.IsLeaf() {
-			return currentPageID, nil
-		}
-
-		internalPage := storage.NewInternalPage(page)
-		childPageID, err := internalPage.SearchChild(key)
-		if err != nil {
-			return 0, fmt.Errorf("failed to search child in page %d: %w", currentPageID, err)
-		}
-
-		currentPageID = childPageID
-	}
+// Returns the leaf ID and a copy of the leaf
+func (tree *BPTree) findLeafPage(key []byte) (uint64, *storage.Page, error) {
+	pageID, page, _, err := tree.descendShared(childIndexFor(key))
+	return pageID, page, err
 }
 
 // InOrderTraversal returns all keys in sorted order
-func (tree *BPTree) InOrderTraversal() ([]uint32, error) {
-	keys := make([]uint32, 0)
+func (tree *BPTree) InOrderTraversal() ([][]byte, error) {
+	keys := make([][]byte, 0)
 
-	leftmostLeafID, err := tree.findLeftmostLeaf()
-	if err != nil {
-		return nil, err
+	cursor := tree.NewCursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		keys = append(keys, cursor.Key())
 	}
 
-	currentPageID := leftmostLeafID
-	for currentPageID != 0 {
-		page, err := readPageStruct(tree.pager, currentPageID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read page %d: %w", currentPageID, err)
-		}
-
-		leaf := storage.NewLeafPage(page)
-		records, err := leaf.GetAllRecords()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get records from page %d: %w", currentPageID, err)
-		}
-
-		for _, record := range records {
-			key, _ := record.GetKeyAsUint32()
-			keys = append(keys, key)
-		}
-
-		currentPageID = uint64(page.Header.NextPage)
+	if err := cursor.Err(); err != nil {
+		return nil, err
 	}
 
 	return keys, nil
 }
 
 // findLeftmostLeaf finds leftmost leaf
-func (tree *BPTree) findLeftmostLeaf() (uint64, error) {
-	currentPageID := tree.rootPage
-
-	for {
-		page, err := readPageStruct(tree.pager, currentPageID)
-		if err != nil {
-			return 0, err
-		}
-
-		if page.IsLeaf() {
-			return currentPageID, nil
-		}
-
-		internalPage := storage.NewInternalPage(page)
-		leftmostPtr, err := internalPage.GetLeftmostPointer()
-		if err != nil {
-			return 0, err
-		}
+func (tree *BPTree) findLeftmostLeaf() (uint64, *storage.Page, error) {
+	pageID, page, _, err := tree.descendShared(func(*storage.InternalPage) int {
+		return 0
+	})
+	return pageID, page, err
+}
 
-		currentPageID = leftmostPtr
-	}
+// findRightmostLeaf finds rightmost leaf
+func (tree *BPTree) findRightmostLeaf() (uint64, *storage.Page, error) {
+	pageID, page, _, err := tree.descendShared(func(internalPage *storage.InternalPage) int {
+		return internalPage.NumKeys()
+	})
+	return pageID, page, err
 }
 
 // GetRootPageID returns root page ID
 func (tree *BPTree) GetRootPageID() uint64 {
+	tree.rootLatch.RLock()
+	defer tree.rootLatch.RUnlock()
 	return tree.rootPage
 }
 
@@ -615,6 +800,48 @@ func (tree *BPTree) GetOrder() int {
 	return tree.order
 }
 
+// Compare orders two keys the way the tree does
+func (tree *BPTree) Compare(a, b []byte) int {
+	return tree.cmp(a, b)
+}
+
+// leafPage wraps a leaf page ordered by the tree's comparator
+func (tree *BPTree) leafPage(page *storage.Page) *storage.LeafPage {
+	return storage.NewLeafPageWithComparator(page, tree.cmp)
+}
+
+// internalPage wraps an internal page ordered by the tree's comparator
+func (tree *BPTree) internalPage(page *storage.Page) *storage.InternalPage {
+	return storage.NewInternalPageWithComparator(page, tree.cmp)
+}
+
+// separator returns the key promoted between left and right, left < key <= right
+// Only the default order is shortened (see storage.ShortestSeparator), a
+// custom comparator gets right unchanged, since it may not accept a prefix
+func (tree *BPTree) separator(left, right []byte) []byte {
+	if !tree.bytewise {
+		return bytes.Clone(right)
+	}
+	return storage.ShortestSeparator(left, right)
+}
+
+// checkKey rejects keys that do not fit in a page
+func checkKey(key []byte) error {
+	if len(key) > storage.MaxKeySize {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrKeyTooLarge, len(key), storage.MaxKeySize)
+	}
+	return nil
+}
+
+// FormatKey renders a key for messages: numeric keys (see storage.Uint32Key)
+// as numbers, others quoted
+func FormatKey(key []byte) string {
+	if n, ok := storage.KeyToUint32(key); ok {
+		return strconv.FormatUint(uint64(n), 10)
+	}
+	return strconv.Quote(string(key))
+}
+
 func readPageStruct(pager storage.Pager, pageID uint64) (*storage.Page, error) {
 	data, err := pager.ReadPage(pageID)
 	if err != nil {
@@ -634,7 +861,7 @@ func allocatePageWithType(pager storage.Pager, pageType storage.PageType) (uint6
 		return 0, nil, err
 	}
 
-	page := storage.NewPage(pageType)
+	page := storage.NewPage(pageType, pager.PageSize())
 	if err := writePageStruct(pager, pageID, page); err != nil {
 		return 0, nil, err
 	}
@@ -642,56 +869,120 @@ func allocatePageWithType(pager storage.Pager, pageType storage.PageType) (uint6
 	return pageID, page, nil
 }
 
-// sortRecordsByKey sorts records by key (ascending)
-func sortRecordsByKey(records []*storage.Record) {
-	// TODO: Simple bubble sort (good enough for small arrays). Need to change this shit in the future
-	n := len(records)
-	for i := 0; i < n-1; i++ {
-		for j := 0; j < n-i-1; j++ {
-			key1, _ := records[j].GetKeyAsUint32()
-			key2, _ := records[j+1].GetKeyAsUint32()
-			if key1 > key2 {
-				records[j], records[j+1] = records[j+1], records[j]
+// leafSplitIndex returns the index splitting sorted records into two halves of
+// roughly equal byte size, keeping at least one record on each side
+func leafSplitIndex(records []*storage.Record) int {
+	total := 0
+	for _, record := range records {
+		total += record.Size() + 2 // +2 for slot
+	}
+
+	used := 0
+	for i, record := range records {
+		used += record.Size() + 2
+		if used*2 > total {
+			if i == 0 {
+				return 1
 			}
+			return i
 		}
 	}
+
+	return len(records) - 1
 }
 
-// SaveMetadata saves tree metadata to a file
-func (tree *BPTree) SaveMetadata(path string) error {
-	file, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %w", err)
+// internalSplitIndex returns the index of the entry pushed up when splitting
+// sorted entries, leaving two halves of roughly equal byte size with at
+// least one entry each
+func internalSplitIndex(entries []storage.InternalEntry) int {
+	total := 0
+	for _, entry := range entries {
+		total += storage.InternalEntrySize(entry.Key)
+	}
+
+	used := 0
+	for i, entry := range entries {
+		used += storage.InternalEntrySize(entry.Key)
+		if used*2 > total {
+			return min(max(i, 1), len(entries)-2)
+		}
 	}
-	defer file.Close()
 
-	// Write: rootPageID (8 bytes) + order (4 bytes)
-	data := make([]byte, 12)
-	binary.LittleEndian.PutUint64(data[0:8], tree.rootPage)
-	binary.LittleEndian.PutUint32(data[8:12], uint32(tree.order))
+	return len(entries) / 2
+}
 
-	if _, err := file.Write(data); err != nil {
-		return fmt.Errorf("failed to write metadata: %w", err)
+// sortRecordsByKey sorts records by key (ascending)
+func (tree *BPTree) sortRecordsByKey(records []*storage.Record) {
+	slices.SortFunc(records, func(a, b *storage.Record) int {
+		return tree.cmp(a.Key, b.Key)
+	})
+}
+
+// SaveMetadata stores the root and order of the default table, the
+// checkpoint LSN and the catalog page in the superblock of the file
+// The pages they name must be flushed first (see checkpoint)
+func (tree *BPTree) SaveMetadata() error {
+	tree.catalogMu.RLock()
+	catalogPage := tree.catalogPage
+	tree.catalogMu.RUnlock()
+
+	tree.metaMu.Lock()
+	defer tree.metaMu.Unlock()
+
+	return tree.pager.WriteSuperblock(storage.Superblock{
+		RootPage:      tree.main.rootPage,
+		Order:         uint32(tree.main.order),
+		CheckpointLSN: tree.checkpointLSN,
+		CatalogPage:   catalogPage,
+	})
+}
+
+// LoadMetadata reads the root page and order of the default table from the
+// superblock of the file
+// Files written before the superblock keep them in walPath + ".meta", which
+// is moved into the superblock
+func LoadMetadata(pager storage.Pager, walPath string) (rootPageID uint64, order int, err error) {
+	super := pager.Superblock()
+	if super.RootPage == 0 {
+		if super, err = upgradeMetadata(pager, walPath+".meta"); err != nil {
+			return 0, 0, err
+		}
 	}
 
-	return file.Sync() // Ensure metadata is flushed to disk
+	return super.RootPage, int(super.Order), nil
 }
 
-// LoadMetadata loads tree metadata from a file
-func LoadMetadata(path string) (rootPageID uint64, order int, err error) {
-	file, err := os.Open(path)
+// upgradeMetadata moves a metadata file into the superblock and removes it
+// Layout: [root 8][order 4][checkpoint LSN 8][catalog page 8], files written
+// before checkpoints or the catalog end earlier (LSN 0, no tables)
+func upgradeMetadata(pager storage.Pager, path string) (storage.Superblock, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return storage.Superblock{}, ErrNoMetadata
+	}
 	if err != nil {
-		return 0, 0, err
+		return storage.Superblock{}, fmt.Errorf("failed to read metadata: %w", err)
 	}
-	defer file.Close()
-
-	data := make([]byte, 12)
-	if _, err := io.ReadFull(file, data); err != nil {
-		return 0, 0, fmt.Errorf("failed to read metadata: %w", err)
+	if len(data) < 12 {
+		return storage.Superblock{}, fmt.Errorf("failed to read metadata: %d bytes", len(data))
 	}
 
-	rootPageID = binary.LittleEndian.Uint64(data[0:8])
-	order = int(binary.LittleEndian.Uint32(data[8:12]))
+	super := storage.Superblock{
+		RootPage: binary.LittleEndian.Uint64(data[0:8]),
+		Order:    binary.LittleEndian.Uint32(data[8:12]),
+	}
+	if len(data) >= 20 {
+		super.CheckpointLSN = binary.LittleEndian.Uint64(data[12:20])
+	}
+	if len(data) >= 28 {
+		super.CatalogPage = binary.LittleEndian.Uint64(data[20:28])
+	}
 
-	return rootPageID, order, nil
+	if err := pager.WriteSuperblock(super); err != nil {
+		return storage.Superblock{}, err
+	}
+	if err := os.Remove(path); err != nil {
+		return storage.Superblock{}, fmt.Errorf("failed to remove metadata file: %w", err)
+	}
+	return super, nil
 }
@@ -8,11 +8,23 @@ import (
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
 )
 
+// k encodes a numeric test key
+func k(key uint32) []byte {
+	return storage.Uint32Key(key)
+}
+
+// num decodes a key made by k
+func num(key []byte) uint32 {
+	n, _ := storage.KeyToUint32(key)
+	return n
+}
+
 func TestBPTreeInsertAndSearch(t *testing.T) {
 	// Create temporary database file
 	dbFile := "test_bptree.db"
 	walFile := "test_bptree.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -41,14 +53,14 @@ func TestBPTreeInsertAndSearch(t *testing.T) {
 	}
 
 	for _, td := range testData {
-		if err := tree.Insert(td.key, td.value); err != nil {
+		if err := tree.Insert(k(td.key), td.value); err != nil {
 			t.Fatalf("Failed to insert key=%d: %v", td.key, err)
 		}
 	}
 
 	// Test search - found
 	for _, td := range testData {
-		value, found, err := tree.Search(td.key)
+		value, found, err := tree.Search(k(td.key))
 		if err != nil {
 			t.Fatalf("Search failed for key=%d: %v", td.key, err)
 		}
@@ -61,7 +73,7 @@ func TestBPTreeInsertAndSearch(t *testing.T) {
 	}
 
 	// Test search - not found
-	_, found, err := tree.Search(999)
+	_, found, err := tree.Search(k(999))
 	if err != nil {
 		t.Fatalf("Search failed: %v", err)
 	}
@@ -74,6 +86,7 @@ func TestBPTreeInOrderTraversal(t *testing.T) {
 	dbFile := "test_traversal.db"
 	walFile := "test_traversal.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -91,7 +104,7 @@ func TestBPTreeInOrderTraversal(t *testing.T) {
 	// Insert keys in random order
 	keys := []uint32{100, 50, 200, 75, 150, 25, 300}
 	for _, key := range keys {
-		if err := tree.Insert(key, "value"); err != nil {
+		if err := tree.Insert(k(key), "value"); err != nil {
 			t.Fatalf("Failed to insert key=%d: %v", key, err)
 		}
 	}
@@ -109,7 +122,7 @@ func TestBPTreeInOrderTraversal(t *testing.T) {
 	}
 
 	for i, key := range allKeys {
-		if key != expectedKeys[i] {
+		if num(key) != expectedKeys[i] {
 			t.Errorf("Key[%d] = %d, expected %d", i, key, expectedKeys[i])
 		}
 	}
@@ -119,6 +132,7 @@ func TestBPTreePersistence(t *testing.T) {
 	dbFile := "test_persistence.db"
 	walFile := "test_persistence.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	var rootPageID uint64
@@ -139,7 +153,7 @@ func TestBPTreePersistence(t *testing.T) {
 
 		// Insert data
 		for i := uint32(1); i <= 10; i++ {
-			if err := tree.Insert(i, "value"); err != nil {
+			if err := tree.Insert(k(i), "value"); err != nil {
 				t.Fatalf("Failed to insert: %v", err)
 			}
 		}
@@ -164,7 +178,7 @@ func TestBPTreePersistence(t *testing.T) {
 
 		// Verify all keys
 		for i := uint32(1); i <= 10; i++ {
-			_, found, err := tree2.Search(i)
+			_, found, err := tree2.Search(k(i))
 			if err != nil {
 				t.Fatalf("Search failed: %v", err)
 			}
@@ -180,6 +194,7 @@ func TestBPTreeSearchPath(t *testing.T) {
 	dbFile := "test_search_path.db"
 	walFile := "test_search_path.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -238,7 +253,7 @@ func TestBPTreeSearchPath(t *testing.T) {
 	root.SetLeftmostPointer(leaf1ID)
 
 	// Insert key 100 pointing to leaf2
-	root.InsertEntry(100, leaf2ID)
+	root.InsertEntry(k(100), leaf2ID)
 
 	if err := writePageStruct(pager, rootID, rootPage); err != nil {
 		t.Fatalf("Failed to write root: %v", err)
@@ -268,7 +283,7 @@ func TestBPTreeSearchPath(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		value, found, err := tree.Search(tc.key)
+		value, found, err := tree.Search(k(tc.key))
 		if err != nil {
 			t.Errorf("Search(%d) failed: %v", tc.key, err)
 			continue
@@ -295,7 +310,7 @@ func TestBPTreeSearchPath(t *testing.T) {
 	}
 
 	for i, key := range keys {
-		if key != expectedKeys[i] {
+		if num(key) != expectedKeys[i] {
 			t.Errorf("Key[%d] = %d, expected %d", i, key, expectedKeys[i])
 		}
 	}
@@ -308,6 +323,7 @@ func TestBPTreeDeepTree(t *testing.T) {
 	dbFile := "test_deep_tree.db"
 	walFile := "test_deep_tree.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -371,27 +387,27 @@ func TestBPTreeDeepTree(t *testing.T) {
 	internal1ID, internal1Page, _ := allocatePageWithType(pager, storage.PageTypeInternal)
 	internal1 := storage.NewInternalPage(internal1Page)
 	internal1.SetLeftmostPointer(leafPageIDs[0]) // L1
-	internal1.InsertEntry(50, leafPageIDs[1])    // 50 → L2
+	internal1.InsertEntry(k(50), leafPageIDs[1]) // 50 → L2
 	writePageStruct(pager, internal1ID, internal1Page)
 
 	internal2ID, internal2Page, _ := allocatePageWithType(pager, storage.PageTypeInternal)
 	internal2 := storage.NewInternalPage(internal2Page)
-	internal2.SetLeftmostPointer(leafPageIDs[2]) // L3
-	internal2.InsertEntry(150, leafPageIDs[3])   // 150 → L4
+	internal2.SetLeftmostPointer(leafPageIDs[2])  // L3
+	internal2.InsertEntry(k(150), leafPageIDs[3]) // 150 → L4
 	writePageStruct(pager, internal2ID, internal2Page)
 
 	internal3ID, internal3Page, _ := allocatePageWithType(pager, storage.PageTypeInternal)
 	internal3 := storage.NewInternalPage(internal3Page)
-	internal3.SetLeftmostPointer(leafPageIDs[4]) // L5
-	internal3.InsertEntry(250, leafPageIDs[5])   // 250 → L6
+	internal3.SetLeftmostPointer(leafPageIDs[4])  // L5
+	internal3.InsertEntry(k(250), leafPageIDs[5]) // 250 → L6
 	writePageStruct(pager, internal3ID, internal3Page)
 
 	// Create root (level 1)
 	rootID, rootPage, _ := allocatePageWithType(pager, storage.PageTypeInternal)
 	root := storage.NewInternalPage(rootPage)
-	root.SetLeftmostPointer(internal1ID) // < 100 → Internal1
-	root.InsertEntry(100, internal2ID)   // [100, 200) → Internal2
-	root.InsertEntry(200, internal3ID)   // >= 200 → Internal3
+	root.SetLeftmostPointer(internal1ID)  // < 100 → Internal1
+	root.InsertEntry(k(100), internal2ID) // [100, 200) → Internal2
+	root.InsertEntry(k(200), internal3ID) // >= 200 → Internal3
 	writePageStruct(pager, rootID, rootPage)
 
 	// Create tree
@@ -419,7 +435,7 @@ func TestBPTreeDeepTree(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		value, found, err := tree.Search(tc.key)
+		value, found, err := tree.Search(k(tc.key))
 		if err != nil {
 			t.Errorf("Search(%d) failed: %v", tc.key, err)
 			continue
@@ -446,7 +462,7 @@ func TestBPTreeDeepTree(t *testing.T) {
 	}
 
 	for i, key := range keys {
-		if key != expectedKeys[i] {
+		if num(key) != expectedKeys[i] {
 			t.Errorf("Key[%d] = %d, expected %d", i, key, expectedKeys[i])
 		}
 	}
@@ -459,6 +475,7 @@ func TestBPTreeLeafSplit(t *testing.T) {
 	dbFile := "test_leaf_split.db"
 	walFile := "test_leaf_split.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -481,7 +498,7 @@ func TestBPTreeLeafSplit(t *testing.T) {
 	for i := 1; i <= numRecords; i++ {
 		key := uint32(i * 10) // Use non-sequential keys
 		value := fmt.Sprintf("value-%d", i)
-		if err := tree.Insert(key, value); err != nil {
+		if err := tree.Insert(k(key), value); err != nil {
 			t.Fatalf("Failed to insert key=%d: %v", key, err)
 		}
 	}
@@ -493,7 +510,7 @@ func TestBPTreeLeafSplit(t *testing.T) {
 		key := uint32(i * 10)
 		expectedValue := fmt.Sprintf("value-%d", i)
 
-		value, found, err := tree.Search(key)
+		value, found, err := tree.Search(k(key))
 		if err != nil {
 			t.Fatalf("Search failed for key=%d: %v", key, err)
 		}
@@ -517,8 +534,8 @@ func TestBPTreeLeafSplit(t *testing.T) {
 
 	// Verify keys are sorted
 	for i := 1; i < len(keys); i++ {
-		if keys[i] <= keys[i-1] {
-			t.Errorf("Keys not sorted: keys[%d]=%d, keys[%d]=%d", i-1, keys[i-1], i, keys[i])
+		if num(keys[i]) <= num(keys[i-1]) {
+			t.Errorf("Keys not sorted: keys[%d]=%d, keys[%d]=%d", i-1, num(keys[i-1]), i, num(keys[i]))
 		}
 	}
 
@@ -530,6 +547,7 @@ func TestBPTreeInternalSplit(t *testing.T) {
 	dbFile := "test_internal_split.db"
 	walFile := "test_internal_split.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -551,7 +569,7 @@ func TestBPTreeInternalSplit(t *testing.T) {
 	for i := 1; i <= numRecords; i++ {
 		key := uint32(i)
 		value := fmt.Sprintf("value-%d", i)
-		if err := tree.Insert(key, value); err != nil {
+		if err := tree.Insert(k(key), value); err != nil {
 			t.Fatalf("Failed to insert key=%d: %v", key, err)
 		}
 	}
@@ -563,7 +581,7 @@ func TestBPTreeInternalSplit(t *testing.T) {
 		key := uint32(i)
 		expectedValue := fmt.Sprintf("value-%d", i)
 
-		value, found, err := tree.Search(key)
+		value, found, err := tree.Search(k(key))
 		if err != nil {
 			t.Fatalf("Search failed for key=%d: %v", key, err)
 		}
@@ -587,8 +605,8 @@ func TestBPTreeInternalSplit(t *testing.T) {
 
 	// Verify keys are sorted
 	for i := 1; i < len(keys); i++ {
-		if keys[i] <= keys[i-1] {
-			t.Errorf("Keys not sorted: keys[%d]=%d, keys[%d]=%d", i-1, keys[i-1], i, keys[i])
+		if num(keys[i]) <= num(keys[i-1]) {
+			t.Errorf("Keys not sorted: keys[%d]=%d, keys[%d]=%d", i-1, num(keys[i-1]), i, num(keys[i]))
 		}
 	}
 
@@ -600,6 +618,7 @@ func TestBPTreeWithWAL(t *testing.T) {
 	dbFile := "test_wal.db"
 	walFile := "test_wal.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	pager, err := storage.NewFilePager(dbFile)
@@ -625,7 +644,7 @@ func TestBPTreeWithWAL(t *testing.T) {
 	}
 
 	for _, td := range testData {
-		if err := tree.Insert(td.key, td.value); err != nil {
+		if err := tree.Insert(k(td.key), td.value); err != nil {
 			t.Fatalf("Failed to insert key=%d: %v", td.key, err)
 		}
 	}
@@ -635,7 +654,7 @@ func TestBPTreeWithWAL(t *testing.T) {
 
 	// Verify data
 	for _, td := range testData {
-		value, found, err := tree.Search(td.key)
+		value, found, err := tree.Search(k(td.key))
 		if err != nil {
 			t.Fatalf("Search failed: %v", err)
 		}
@@ -654,6 +673,7 @@ func TestWALRecovery(t *testing.T) {
 	dbFile := "test_recovery.db"
 	walFile := "test_recovery.wal"
 	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
 	defer os.Remove(walFile)
 
 	var rootPageID uint64
@@ -674,7 +694,7 @@ func TestWALRecovery(t *testing.T) {
 
 		// Insert data
 		for i := 1; i <= 10; i++ {
-			if err := tree.Insert(uint32(i), fmt.Sprintf("value-%d", i)); err != nil {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
 				t.Fatalf("Failed to insert: %v", err)
 			}
 		}
@@ -702,7 +722,7 @@ func TestWALRecovery(t *testing.T) {
 
 		// Verify all data recovered
 		for i := 1; i <= 10; i++ {
-			value, found, err := tree2.Search(uint32(i))
+			value, found, err := tree2.Search(k(uint32(i)))
 			if err != nil {
 				t.Fatalf("Search failed: %v", err)
 			}
@@ -719,3 +739,78 @@ func TestWALRecovery(t *testing.T) {
 		t.Log("✓ All data recovered successfully")
 	}
 }
+
+func TestBPTreePageSize(t *testing.T) {
+	dbFile := "test_page_size.db"
+	walFile := "test_page_size.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pages := make(map[int]uint64)
+	for _, size := range []int{storage.DefaultPageSize, 16384, storage.MaxPageSize} {
+		os.Remove(dbFile)
+		os.Remove(walFile)
+
+		{
+			pager, err := storage.NewFilePagerWithPageSize(dbFile, size)
+			if err != nil {
+				t.Fatalf("Failed to create pager: %v", err)
+			}
+
+			tree, err := NewBPTree(pager, 100, walFile)
+			if err != nil {
+				t.Fatalf("Failed to create B+ Tree: %v", err)
+			}
+
+			for i := uint32(1); i <= 2000; i++ {
+				if err := tree.Insert(k(i), fmt.Sprintf("value-%d", i)); err != nil {
+					t.Fatalf("Failed to insert key %d: %v", i, err)
+				}
+			}
+			// Inline or spread over overflow pages depending on the page size
+			if err := tree.Insert(k(5000), largeValue(5000, 6000)); err != nil {
+				t.Fatalf("Failed to insert large value: %v", err)
+			}
+
+			if _, err := tree.Checkpoint(); err != nil {
+				t.Fatalf("Failed to checkpoint: %v", err)
+			}
+			pages[size] = pager.NumPages()
+			tree.Close()
+			pager.Close()
+		}
+
+		// The file keeps its page size, whatever the pager defaults to
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		if pager.PageSize() != size {
+			t.Errorf("Reopened with %d byte pages, expected %d", pager.PageSize(), size)
+		}
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+		tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+
+		rows, err := tree.Scan(nil, nil, 0)
+		if err != nil || len(rows) != 2001 {
+			t.Fatalf("Scan with %d byte pages returned %d rows (err=%v), expected 2001", size, len(rows), err)
+		}
+		if value, _, err := tree.Search(k(5000)); err != nil || value != largeValue(5000, 6000) {
+			t.Errorf("Large value with %d byte pages read back with %d bytes (err=%v)", size, len(value), err)
+		}
+		tree.Close()
+		pager.Close()
+	}
+
+	if pages[storage.MaxPageSize] >= pages[storage.DefaultPageSize] {
+		t.Errorf("%d byte pages used %d pages, %d byte pages %d", storage.MaxPageSize, pages[storage.MaxPageSize], storage.DefaultPageSize, pages[storage.DefaultPageSize])
+	}
+	t.Log("✓ Trees read back with 4, 16 and 32 KB pages")
+}
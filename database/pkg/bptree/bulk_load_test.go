@@ -0,0 +1,246 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// sortedRows returns rows 1..n with the values Insert tests use
+func sortedRows(n int) []KeyValue {
+	rows := make([]KeyValue, 0, n)
+	for i := 1; i <= n; i++ {
+		rows = append(rows, KeyValue{Key: k(uint32(i)), Value: fmt.Sprintf("value-%d", i)})
+	}
+	return rows
+}
+
+// checkParents verifies that every page below pageID points to its parent
+// and that no internal page has a single child
+func checkParents(t *testing.T, tree *BPTree, pageID, parentID uint64) {
+	t.Helper()
+
+	page, err := readPageStruct(tree.pager, pageID)
+	if err != nil {
+		t.Fatalf("Failed to read page %d: %v", pageID, err)
+	}
+	if uint64(page.Header.Parent) != parentID {
+		t.Fatalf("Page %d: Parent=%d, expected %d", pageID, page.Header.Parent, parentID)
+	}
+	if !page.IsInternal() {
+		return
+	}
+
+	internal := tree.internalPage(page)
+	if internal.NumKeys() == 0 {
+		t.Fatalf("Internal page %d has a single child", pageID)
+	}
+	for i := 0; i <= internal.NumKeys(); i++ {
+		childID, err := internal.GetChild(i)
+		if err != nil {
+			t.Fatalf("Failed to get child %d of page %d: %v", i, pageID, err)
+		}
+		checkParents(t, tree, childID, pageID)
+	}
+}
+
+func TestBPTreeBulkLoad(t *testing.T) {
+	dbFile := "test_bulk_load.db"
+	walFile := "test_bulk_load.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	const n = 100000
+
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+		bufferPool := storage.NewBufferPool(pager, 128)
+
+		tree, err := NewBPTree(bufferPool, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		syncs := tree.GetWALSyncCount()
+		info, err := tree.BulkLoad(SliceSource(sortedRows(n)), BulkLoadOptions{})
+		if err != nil {
+			t.Fatalf("Failed to bulk load: %v", err)
+		}
+		if info.Records != n || info.Height < 3 {
+			t.Fatalf("Loaded %d records in %d levels", info.Records, info.Height)
+		}
+		if logged := tree.GetWALSyncCount() - syncs; logged > 3 {
+			t.Errorf("Bulk load synced the WAL %d times", logged)
+		}
+		t.Logf("✓ Loaded %d records into %d leaves (%d pages, height %d) in %v",
+			info.Records, info.Leaves, info.Pages, info.Height, info.Duration)
+
+		checkParents(t, tree, tree.rootPage, 0)
+		checkLeafChain(t, tree)
+
+		keys, err := tree.InOrderTraversal()
+		if err != nil || len(keys) != n {
+			t.Fatalf("Traversal returned %d keys (err=%v)", len(keys), err)
+		}
+		for i, key := range keys {
+			if num(key) != uint32(i+1) {
+				t.Fatalf("Key %d is %d", i, num(key))
+			}
+		}
+		for _, i := range []uint32{1, 2, 777, 50000, n} {
+			if value, found, err := tree.Search(k(i)); err != nil || !found || value != fmt.Sprintf("value-%d", i) {
+				t.Fatalf("Key %d read back as %q (found=%v, err=%v)", i, value, found, err)
+			}
+		}
+
+		// Pages keep their free space for later writes, which split and
+		// merge the loaded pages like any other
+		for i := uint32(n + 1); i <= n+2000; i++ {
+			if err := tree.Insert(k(i), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert after load: %v", err)
+			}
+		}
+		for i := uint32(1); i <= 5000; i++ {
+			if _, err := tree.Delete(k(i)); err != nil {
+				t.Fatalf("Failed to delete after load: %v", err)
+			}
+		}
+		if err := tree.Upsert(k(60000), "changed"); err != nil {
+			t.Fatalf("Failed to upsert after load: %v", err)
+		}
+		checkParents(t, tree, tree.rootPage, 0)
+		checkLeafChain(t, tree)
+
+		// A loaded table is not empty
+		if _, err := tree.BulkLoad(SliceSource(sortedRows(1)), BulkLoadOptions{}); !errors.Is(err, ErrTableNotEmpty) {
+			t.Errorf("Expected ErrTableNotEmpty, got %v", err)
+		}
+
+		tree.Close()
+		bufferPool.Close()
+	}
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+	rootPageID, order, err := LoadMetadata(pager, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	defer tree.Close()
+
+	rows, err := tree.Scan(nil, nil, 0)
+	if err != nil || len(rows) != n-3000 {
+		t.Fatalf("Scan after reopen returned %d rows (err=%v), expected %d", len(rows), err, n-3000)
+	}
+	if value, _, _ := tree.Search(k(60000)); value != "changed" {
+		t.Errorf("Key 60000 read back as %q", value)
+	}
+	if _, found, _ := tree.Search(k(5000)); found {
+		t.Error("Deleted key 5000 is back")
+	}
+	t.Log("✓ Loaded table reopened")
+}
+
+func TestBPTreeBulkLoadTable(t *testing.T) {
+	dbFile := "test_bulk_load_table.db"
+	walFile := "test_bulk_load_table.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// The fill factor sets how many leaves the same rows take
+	leaves := make(map[float64]int)
+	for _, fill := range []float64{0.5, 1} {
+		table, err := tree.CreateTable(fmt.Sprintf("fill_%v", fill))
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		info, err := table.BulkLoad(SliceSource(sortedRows(20000)), BulkLoadOptions{FillFactor: fill})
+		if err != nil {
+			t.Fatalf("Failed to bulk load with fill %v: %v", fill, err)
+		}
+		checkParents(t, table, table.rootPage, 0)
+		leaves[fill] = info.Leaves
+	}
+	if leaves[0.5] < leaves[1]*19/10 {
+		t.Errorf("Fill 0.5 took %d leaves, fill 1 took %d", leaves[0.5], leaves[1])
+	}
+	t.Logf("✓ 20000 rows take %d leaves at fill 0.5 and %d at fill 1", leaves[0.5], leaves[1])
+
+	if _, err := tree.BulkLoad(SliceSource(nil), BulkLoadOptions{FillFactor: 1.5}); err == nil {
+		t.Error("Expected error for fill factor 1.5")
+	}
+
+	// Unsorted input is refused and leaves the table empty and usable
+	users, err := tree.CreateTable("users")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := users.CreateIndex("users_value"); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	unsorted := sortedRows(5000)
+	unsorted[4000], unsorted[4001] = unsorted[4001], unsorted[4000]
+	if _, err := users.BulkLoad(SliceSource(unsorted), BulkLoadOptions{}); !errors.Is(err, ErrUnsorted) {
+		t.Fatalf("Expected ErrUnsorted, got %v", err)
+	}
+	if rows, err := users.Scan(nil, nil, 0); err != nil || len(rows) != 0 {
+		t.Fatalf("Failed load left %d rows (err=%v)", len(rows), err)
+	}
+
+	// Large values go to overflow chains, indexes and snapshots follow the load
+	snap := users.Snapshot()
+	defer snap.Release()
+	rows := sortedRows(300)
+	for i := range rows {
+		if i%10 == 0 {
+			rows[i].Value = largeValue(i+1, 10000)
+		}
+	}
+	if _, err := users.BulkLoad(SliceSource(rows), BulkLoadOptions{}); err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+
+	if value, found, err := users.Search(k(11)); err != nil || !found || value != largeValue(11, 10000) {
+		t.Errorf("Large value read back with %d bytes (found=%v, err=%v)", len(value), found, err)
+	}
+	if matches, err := users.SearchValue("value-250", 0); err != nil || len(matches) != 1 || num(matches[0].Key) != 250 {
+		t.Errorf("Index lookup returned %v (err=%v)", matches, err)
+	}
+	if _, found, _ := snap.Search(k(2)); found {
+		t.Error("Snapshot taken before the load sees its rows")
+	}
+	if err := users.Upsert(k(2), "after"); err != nil {
+		t.Fatalf("Failed to upsert after load: %v", err)
+	}
+	if matches, _ := users.SearchValue("after", 0); len(matches) != 1 {
+		t.Errorf("Index has %d entries for the new value", len(matches))
+	}
+	t.Log("✓ Bulk load into a catalog table with an index")
+}
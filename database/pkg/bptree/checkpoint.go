@@ -0,0 +1,163 @@
+package bptree
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultCheckpointWALSize triggers a checkpoint once the WAL reaches 4 MB
+	DefaultCheckpointWALSize = 4 * 1024 * 1024
+	// DefaultCheckpointInterval triggers a checkpoint once a minute under write load
+	DefaultCheckpointInterval = time.Minute
+)
+
+// CheckpointPolicy controls when writes trigger an automatic checkpoint
+// A zero field disables that trigger
+type CheckpointPolicy struct {
+	WALSize  int64         // checkpoint when the WAL file reaches this many bytes
+	Interval time.Duration // checkpoint when this much time passed since the last one
+}
+
+// DefaultCheckpointPolicy returns the policy used by NewBPTree and LoadBPTree
+func DefaultCheckpointPolicy() CheckpointPolicy {
+	return CheckpointPolicy{
+		WALSize:  DefaultCheckpointWALSize,
+		Interval: DefaultCheckpointInterval,
+	}
+}
+
+// CheckpointInfo describes a completed checkpoint
+type CheckpointInfo struct {
+	LSN        uint64        // last WAL entry covered by the checkpoint
+	WALFreed   int64         // bytes removed from the WAL
+	Duration   time.Duration // time spent flushing and truncating
+	Checkpoint int           // number of checkpoints taken by this tree
+}
+
+// Checkpoint flushes dirty pages, records the roots and the checkpoint LSN
+// in the superblock and drops the covered WAL entries
+//
+// Writes wait while it runs: the pages it flushes and the roots it records
+// must be those of the same point in the WAL, or a split between the flush
+// and the superblock write would leave a root whose page is not on disk
+func (tree *BPTree) Checkpoint() (CheckpointInfo, error) {
+	tree.checkpointMu.Lock()
+	defer tree.checkpointMu.Unlock()
+
+	start := time.Now()
+
+	// With no write in flight every entry up to lsn is in the pages, except
+	// those of open transactions (their writes are applied at commit)
+	tree.writeLatch.Lock()
+	defer tree.writeLatch.Unlock()
+	lsn := tree.wal.LastLSN()
+	oldestTxLSN := tree.oldestActiveTxLSN()
+
+	return tree.checkpoint(start, lsn, oldestTxLSN)
+}
+
+// checkpoint makes the pages cover every entry up to lsn, given the BEGIN
+// LSN of the oldest open transaction (0 if none)
+// The caller holds checkpointMu and writeLatch exclusively
+func (tree *BPTree) checkpoint(start time.Time, lsn, oldestTxLSN uint64) (CheckpointInfo, error) {
+	// 1. The log must be durable before the pages it describes (SyncNormal/SyncOff
+	// may still hold records in the page cache), then push the pages to disk
+	if err := tree.wal.Sync(); err != nil {
+		return CheckpointInfo{}, fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	if err := tree.pager.Flush(); err != nil {
+		return CheckpointInfo{}, fmt.Errorf("failed to flush pages: %w", err)
+	}
+
+	// 2. Record the checkpoint before dropping the log
+	tree.metaMu.Lock()
+	prevLSN := tree.checkpointLSN
+	tree.checkpointLSN = lsn
+	tree.metaMu.Unlock()
+	if err := tree.SaveMetadata(); err != nil {
+		tree.metaMu.Lock()
+		tree.checkpointLSN = prevLSN
+		tree.metaMu.Unlock()
+		return CheckpointInfo{}, fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	// 3. Covered entries are no longer needed for recovery, except those of
+	// open transactions (their writes are not in the pages yet)
+	truncateLSN := lsn
+	if oldestTxLSN != 0 {
+		truncateLSN = min(truncateLSN, oldestTxLSN-1)
+	}
+
+	freed, err := tree.wal.TruncateBefore(truncateLSN)
+	if err != nil {
+		return CheckpointInfo{}, fmt.Errorf("failed to truncate WAL: %w", err)
+	}
+
+	tree.lastCheckpoint = time.Now()
+	tree.checkpoints++
+	tree.checkpointErr = nil
+
+	return CheckpointInfo{
+		LSN:        lsn,
+		WALFreed:   freed,
+		Duration:   time.Since(start),
+		Checkpoint: tree.checkpoints,
+	}, nil
+}
+
+// SetCheckpointPolicy changes the automatic checkpoint thresholds
+func (tree *BPTree) SetCheckpointPolicy(policy CheckpointPolicy) {
+	tree.checkpointMu.Lock()
+	defer tree.checkpointMu.Unlock()
+	tree.checkpointPolicy = policy
+}
+
+// GetCheckpointLSN returns the LSN of the last checkpoint
+func (tree *BPTree) GetCheckpointLSN() uint64 {
+	tree.metaMu.Lock()
+	defer tree.metaMu.Unlock()
+	return tree.checkpointLSN
+}
+
+// GetCheckpointCount returns the number of checkpoints taken by this tree
+func (tree *BPTree) GetCheckpointCount() int {
+	tree.checkpointMu.Lock()
+	defer tree.checkpointMu.Unlock()
+	return tree.checkpoints
+}
+
+// CheckpointError returns why the last automatic checkpoint failed, nil
+// once a checkpoint succeeds
+// The write that triggered it is committed all the same, its entries stay
+// in the WAL until a checkpoint covers them
+func (tree *BPTree) CheckpointError() error {
+	tree.checkpointMu.Lock()
+	defer tree.checkpointMu.Unlock()
+	return tree.checkpointErr
+}
+
+// maybeCheckpoint runs a checkpoint when the WAL size or time threshold is reached
+// Called after every logged write, once the write is committed: a failure
+// is kept for CheckpointError, not returned to the writer
+func (tree *BPTree) maybeCheckpoint() {
+	tree.checkpointMu.Lock()
+	policy := tree.checkpointPolicy
+	sinceLast := time.Since(tree.lastCheckpoint)
+	tree.checkpointMu.Unlock()
+
+	due := policy.Interval > 0 && sinceLast >= policy.Interval
+	if !due && policy.WALSize > 0 {
+		due = tree.wal.Size() >= policy.WALSize
+	}
+
+	if !due {
+		return
+	}
+
+	if _, err := tree.Checkpoint(); err != nil {
+		tree.checkpointMu.Lock()
+		tree.checkpointErr = err
+		tree.checkpointMu.Unlock()
+	}
+}
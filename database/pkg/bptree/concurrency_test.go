@@ -0,0 +1,236 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Run with -race: concurrent writers split and merge pages under readers,
+// scans, batches and checkpoints
+func TestBPTreeConcurrentAccess(t *testing.T) {
+	dir := t.TempDir()
+	walFile := filepath.Join(dir, "test.wal")
+
+	pager, err := storage.NewFilePager(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	// Small pool so pages are evicted and reloaded, latched ones stay pinned
+	bufferPool := storage.NewBufferPool(pager, 32)
+	defer bufferPool.Close()
+
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+	tree.SetWALSyncMode(wal.SyncOff, 0)
+	tree.SetCheckpointPolicy(CheckpointPolicy{WALSize: 256 * 1024})
+
+	const (
+		writers = 8
+		readers = 4
+		opsEach = 400
+		keySpan = 2000 // keys per writer
+	)
+
+	// Long values fill leaves quickly, so writes split and merge often
+	valueFor := func(key uint32, version int) string {
+		return fmt.Sprintf("%d:%d:%s", key, version, strings.Repeat("x", 60))
+	}
+
+	// Each writer owns the keys congruent to its ID, so the final contents are known
+	expected := make([]map[uint32]string, writers)
+	var writersDone sync.WaitGroup
+	errs := make(chan error, writers+readers+2)
+
+	for w := 0; w < writers; w++ {
+		expected[w] = make(map[uint32]string)
+		writersDone.Add(1)
+
+		go func(w int) {
+			defer writersDone.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+			mine := expected[w]
+
+			for i := 0; i < opsEach; i++ {
+				key := uint32(rng.Intn(keySpan)*writers + w)
+				value := valueFor(key, i)
+
+				switch op := rng.Intn(10); {
+				case op < 5:
+					if err := tree.Upsert(k(key), value); err != nil {
+						errs <- fmt.Errorf("upsert %d: %w", key, err)
+						return
+					}
+					mine[key] = value
+				case op < 7:
+					_, exists := mine[key]
+					err := tree.Insert(k(key), value)
+					if exists != (err != nil) {
+						errs <- fmt.Errorf("insert %d: exists=%v err=%v", key, exists, err)
+						return
+					}
+					if !exists {
+						mine[key] = value
+					}
+				case op < 9:
+					_, exists := mine[key]
+					found, err := tree.Delete(k(key))
+					if err != nil || found != exists {
+						errs <- fmt.Errorf("delete %d: found=%v expected=%v err=%v", key, found, exists, err)
+						return
+					}
+					delete(mine, key)
+				default:
+					// A batch of this writer's keys, applied with every other write excluded
+					batch := NewWriteBatch()
+					for j := 0; j < 10; j++ {
+						batchKey := uint32(rng.Intn(keySpan)*writers + w)
+						batch.Put(k(batchKey), valueFor(batchKey, i))
+						mine[batchKey] = valueFor(batchKey, i)
+					}
+					if err := tree.Write(batch); err != nil {
+						errs <- fmt.Errorf("batch: %w", err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+
+	// Readers check every row they see belongs to its key and scans stay sorted
+	stop := make(chan struct{})
+	var readersDone sync.WaitGroup
+	checkRow := func(key uint32, value string) error {
+		if !strings.HasPrefix(value, fmt.Sprintf("%d:", key)) {
+			return fmt.Errorf("key %d has value %q", key, value)
+		}
+		return nil
+	}
+
+	for r := 0; r < readers; r++ {
+		readersDone.Add(1)
+
+		go func(r int) {
+			defer readersDone.Done()
+			rng := rand.New(rand.NewSource(int64(100 + r)))
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				key := uint32(rng.Intn(keySpan * writers))
+				if value, found, err := tree.Search(k(key)); err != nil {
+					errs <- fmt.Errorf("search %d: %w", key, err)
+					return
+				} else if found {
+					if err := checkRow(key, value); err != nil {
+						errs <- err
+						return
+					}
+				}
+
+				scan := tree.Scan
+				if r%2 == 1 {
+					scan = tree.ScanReverse
+				}
+				rows, err := scan(k(key), k(key+500), 0)
+				if err != nil {
+					errs <- fmt.Errorf("scan from %d: %w", key, err)
+					return
+				}
+				for i, row := range rows {
+					if num(row.Key) < key || num(row.Key) > key+500 {
+						errs <- fmt.Errorf("scan from %d returned key %d", key, num(row.Key))
+						return
+					}
+					if i > 0 && (num(row.Key) > num(rows[i-1].Key)) != (r%2 == 0) {
+						errs <- fmt.Errorf("scan from %d out of order: %d after %d", key, num(row.Key), num(rows[i-1].Key))
+						return
+					}
+					if err := checkRow(num(row.Key), row.Value); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(r)
+	}
+
+	// Manual checkpoints on top of the size-triggered ones
+	readersDone.Add(1)
+	go func() {
+		defer readersDone.Done()
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if _, err := tree.Checkpoint(); err != nil {
+				errs <- fmt.Errorf("checkpoint: %w", err)
+				return
+			}
+		}
+	}()
+
+	writersDone.Wait()
+	close(stop)
+	readersDone.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		return
+	}
+
+	// Final contents are exactly what the writers left behind
+	total := 0
+	for w := 0; w < writers; w++ {
+		for key, value := range expected[w] {
+			if got, found, _ := tree.Search(k(key)); !found || got != value {
+				t.Fatalf("Key %d: got %q found=%v, expected %q", key, got, found, value)
+			}
+		}
+		total += len(expected[w])
+	}
+
+	keys, err := tree.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("Traversal failed: %v", err)
+	}
+	if len(keys) != total {
+		t.Fatalf("Expected %d keys, got %d", total, len(keys))
+	}
+	for i := 1; i < len(keys); i++ {
+		if num(keys[i]) <= num(keys[i-1]) {
+			t.Fatalf("Keys out of order at %d: %d after %d", i, num(keys[i]), num(keys[i-1]))
+		}
+	}
+	checkLeafChain(t, tree)
+
+	if stats := bufferPool.GetStats(); stats.PinnedPages != 0 {
+		t.Errorf("Writes left %d pages pinned", stats.PinnedPages)
+	}
+
+	t.Logf("✓ %d writers, %d readers: %d keys consistent, %d checkpoints",
+		writers, readers, total, tree.GetCheckpointCount())
+}
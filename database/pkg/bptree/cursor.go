@@ -0,0 +1,410 @@
+package bptree
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// KeyValue is a single key-value pair returned by range scans
+type KeyValue struct {
+	Key   []byte
+	Value string
+}
+
+// Cursor iterates over records in key order, leaf by leaf
+// A reverse cursor iterates in descending key order
+//
+// Seek and First reach a leaf from the root, later leaves are reached along
+// the leaf chain: NextPage forward, PrevPage backwards. Each leaf is copied
+// under its latch, which is released between calls, so the cursor remembers
+// the last key it read (edge) and takes only the keys past it. Writes
+// running alongside show up leaf by leaf; a snapshot cursor
+// (Snapshot.NewCursor) sees each record as of the snapshot instead, so no
+// write shows up at all
+//
+// Following a link from a leaf read earlier is only safe if that page is
+// still the leaf it was: the cursor latches it again and checks that no page
+// of the file was freed since (see latch.go). Splits and redistributions
+// only move keys between neighbours along the chain, so the keys past the
+// edge are in that leaf or the ones after it in iteration order. The neighbour is latched before the leaf is released, but
+// only tried: writers wait for leaves while holding others, so a cursor
+// waiting could deadlock with them. If it is busy, or a page was freed, the
+// cursor descends from the root again, to the edge or, when that leaf has
+// nothing past it, past the separator keys around it
+//
+// Usage:
+//
+//	cursor := tree.NewCursor()
+//	for ok := cursor.Seek([]byte("user:")); ok; ok = cursor.Next() {
+//		fmt.Println(cursor.Key(), cursor.Value())
+//	}
+//	if err := cursor.Err(); err != nil { ... }
+type Cursor struct {
+	tree     *BPTree
+	snapshot *Snapshot         // read as of this snapshot, nil reads the latest versions
+	reverse  bool              // iterate in descending key order
+	pageID   uint64            // current leaf page (0 = exhausted)
+	freed    uint64            // tree.freed when the leaf was read
+	bounds   fence             // key range of the leaf when it was reached by descent
+	bounded  bool              // the leaf was reached by descent, bounds is set
+	edge     []byte            // last key read in iteration order, nil before the first
+	pastEdge bool              // edge itself was read, false for the key of Seek
+	records  []*storage.Record // visible records of current leaf past the edge
+	index    int               // position in records
+	err      error
+}
+
+// NewCursor creates an unpositioned cursor (call Seek or First before use)
+func (tree *BPTree) NewCursor() *Cursor {
+	return &Cursor{tree: tree}
+}
+
+// NewReverseCursor creates an unpositioned cursor that iterates in descending order
+// First moves to the largest key, Seek to the last key <= key, Next to smaller keys
+func (tree *BPTree) NewReverseCursor() *Cursor {
+	return &Cursor{tree: tree, reverse: true}
+}
+
+// First positions the cursor at the smallest key (largest for reverse cursors)
+// Returns true if the cursor points to a record
+func (c *Cursor) First() bool {
+	c.err = nil
+	c.edge, c.pastEdge = nil, false
+
+	next := func(*storage.InternalPage) int { return 0 }
+	if c.reverse {
+		next = func(internal *storage.InternalPage) int { return internal.NumKeys() }
+	}
+	if err := c.descend(next); err != nil {
+		return c.fail(err)
+	}
+
+	return c.skipEmptyLeaves()
+}
+
+// Seek positions the cursor at the first key >= key
+// (the last key <= key for reverse cursors)
+// Returns true if the cursor points to a record
+func (c *Cursor) Seek(key []byte) bool {
+	c.err = nil
+	c.edge, c.pastEdge = key, false
+
+	if err := c.descend(childIndexFor(key)); err != nil {
+		return c.fail(err)
+	}
+
+	return c.skipEmptyLeaves()
+}
+
+// Next advances the cursor to the next key (previous key for reverse cursors)
+// Returns true if the cursor points to a record
+func (c *Cursor) Next() bool {
+	if !c.Valid() {
+		return false
+	}
+
+	if c.reverse {
+		c.index--
+	} else {
+		c.index++
+	}
+	return c.skipEmptyLeaves()
+}
+
+// Valid reports whether the cursor points to a record
+func (c *Cursor) Valid() bool {
+	return c.err == nil && c.pageID != 0 && c.index >= 0 && c.index < len(c.records)
+}
+
+// Key returns the key at the cursor position
+func (c *Cursor) Key() []byte {
+	if !c.Valid() {
+		return nil
+	}
+	return c.records[c.index].Key
+}
+
+// Value returns the value at the cursor position
+func (c *Cursor) Value() string {
+	if !c.Valid() {
+		return ""
+	}
+	return c.records[c.index].GetValueAsString()
+}
+
+// Err returns the error that stopped iteration, if any
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// skipEmptyLeaves moves on to the neighbouring leaf (the one below for
+// reverse cursors) until the cursor points to a record or the keys run out
+func (c *Cursor) skipEmptyLeaves() bool {
+	for c.index < 0 || c.index >= len(c.records) {
+		if c.pageID == 0 {
+			return c.exhaust()
+		}
+
+		followed, err := c.follow()
+		if err == nil && !followed {
+			err = c.redescend()
+		}
+		if err != nil {
+			return c.fail(err)
+		}
+	}
+
+	return true
+}
+
+// follow moves to the keys past the edge along the leaf chain, starting
+// with those added to the current leaf since it was read
+// Returns false if the link cannot be followed safely
+func (c *Cursor) follow() (bool, error) {
+	if c.snapshot != nil && c.snapshot.released {
+		return false, ErrSnapshotReleased
+	}
+
+	latch := c.tree.latches.get(c.pageID)
+	latch.RLock()
+	defer latch.RUnlock()
+
+	if c.tree.freed.Load() != c.freed {
+		return false, nil
+	}
+	page, err := readPageStruct(c.tree.pager, c.pageID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read leaf page %d: %w", c.pageID, err)
+	}
+	if !page.IsLeaf() {
+		return false, nil
+	}
+	if loaded, err := c.load(c.pageID, page, false); loaded || err != nil {
+		return loaded, err
+	}
+
+	// Writers wait for leaves while holding others, a cursor waiting here
+	// could close a cycle with them: the neighbour is only tried
+	neighbourID := uint64(page.Header.NextPage)
+	if c.reverse {
+		neighbourID = uint64(page.Header.PrevPage)
+	}
+	if neighbourID == 0 {
+		c.exhaust()
+		return true, nil
+	}
+	neighbourLatch := c.tree.latches.get(neighbourID)
+	if !neighbourLatch.TryRLock() {
+		return false, nil
+	}
+	defer neighbourLatch.RUnlock()
+
+	neighbour, err := readPageStruct(c.tree.pager, neighbourID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read leaf page %d: %w", neighbourID, err)
+	}
+	backLink := uint64(neighbour.Header.PrevPage)
+	if c.reverse {
+		backLink = uint64(neighbour.Header.NextPage)
+	}
+	if !neighbour.IsLeaf() || backLink != c.pageID {
+		return false, nil
+	}
+	_, err = c.load(neighbourID, neighbour, true)
+	return true, err
+}
+
+// redescend reaches the keys past the edge from the root: the leaf holding
+// the edge, or the one past the separators around the last leaf reached by
+// descent if the cursor was there already
+func (c *Cursor) redescend() error {
+	if !c.bounded {
+		if c.edge == nil {
+			next := func(*storage.InternalPage) int { return 0 }
+			if c.reverse {
+				next = func(internal *storage.InternalPage) int { return internal.NumKeys() }
+			}
+			return c.descend(next)
+		}
+		return c.descend(childIndexFor(c.edge))
+	}
+
+	if c.reverse {
+		// low is the first key of this leaf's range, the leftmost range has none
+		if !c.bounds.hasLow {
+			c.exhaust()
+			return nil
+		}
+		return c.descend(childIndexBefore(c.bounds.low))
+	}
+	if !c.bounds.hasHigh {
+		c.exhaust()
+		return nil
+	}
+	return c.descend(childIndexFor(c.bounds.high))
+}
+
+// descend reads the leaf reached by descending with next into the cursor
+func (c *Cursor) descend(next func(*storage.InternalPage) int) error {
+	if c.snapshot != nil && c.snapshot.released {
+		return ErrSnapshotReleased
+	}
+
+	// Overflow chains are read before the leaf latch is released
+	pageID, page, bounds, latch, err := c.tree.descendLatched(next)
+	if err != nil {
+		return fmt.Errorf("failed to find leaf page: %w", err)
+	}
+	defer latch.RUnlock()
+
+	if _, err := c.load(pageID, page, true); err != nil {
+		return err
+	}
+	c.bounds, c.bounded = bounds, true
+	return nil
+}
+
+// load reads the records past the edge from a latched leaf into the cursor
+// and moves the edge to the last of them. Always moves to the leaf if move
+// is set, otherwise only if it has such records
+// Returns whether it had any
+func (c *Cursor) load(pageID uint64, page *storage.Page, move bool) (bool, error) {
+	records, err := c.tree.leafPage(page).GetAllRecords()
+	if err != nil {
+		return false, fmt.Errorf("failed to get records from page %d: %w", pageID, err)
+	}
+	records = slices.DeleteFunc(records, func(record *storage.Record) bool {
+		return !c.pastEdgeOf(record.Key)
+	})
+	if len(records) == 0 && !move {
+		return false, nil
+	}
+
+	if len(records) > 0 {
+		if c.reverse {
+			c.edge = records[0].Key
+		} else {
+			c.edge = records[len(records)-1].Key
+		}
+		c.pastEdge = true
+	}
+	found := len(records) > 0
+
+	if records, err = c.visible(records); err != nil {
+		return false, fmt.Errorf("failed to read records from page %d: %w", pageID, err)
+	}
+
+	c.pageID = pageID
+	c.freed = c.tree.freed.Load()
+	c.bounded = false
+	c.records = records
+	c.index = 0
+	if c.reverse {
+		c.index = len(records) - 1
+	}
+	return found, nil
+}
+
+// pastEdgeOf reports whether key comes after the edge in iteration order
+func (c *Cursor) pastEdgeOf(key []byte) bool {
+	if c.edge == nil {
+		return true
+	}
+	order := c.tree.cmp(key, c.edge)
+	if c.reverse {
+		order = -order
+	}
+	return order > 0 || (order == 0 && !c.pastEdge)
+}
+
+// visible keeps the records the cursor sees, each holding the value it sees
+func (c *Cursor) visible(records []*storage.Record) ([]*storage.Record, error) {
+	kept := records[:0]
+	for _, record := range records {
+		version, ok := record.Versions()[0], !record.Deleted
+		if c.snapshot != nil {
+			version, ok = record.VersionAt(c.snapshot.ts)
+		}
+		if !ok {
+			continue
+		}
+
+		value, err := c.tree.readValue(version)
+		if err != nil {
+			return nil, err
+		}
+		kept = append(kept, storage.NewRecord(record.Key, []byte(value)))
+	}
+	return kept, nil
+}
+
+// exhaust marks the end of iteration
+func (c *Cursor) exhaust() bool {
+	c.pageID = 0
+	c.records = nil
+	return false
+}
+
+// fail records an error and invalidates the cursor
+func (c *Cursor) fail(err error) bool {
+	c.err = err
+	c.pageID = 0
+	c.records = nil
+	return false
+}
+
+// Scan returns key-value pairs with start <= key <= end in ascending order
+// A nil start or end leaves that side of the range open
+// limit <= 0 means no limit
+func (tree *BPTree) Scan(start, end []byte, limit int) ([]KeyValue, error) {
+	return scanRange(tree.NewCursor(), start, end, limit)
+}
+
+// ScanReverse returns key-value pairs with start <= key <= end in descending order
+// A nil start or end leaves that side of the range open
+// limit <= 0 means no limit
+func (tree *BPTree) ScanReverse(start, end []byte, limit int) ([]KeyValue, error) {
+	return scanRange(tree.NewReverseCursor(), start, end, limit)
+}
+
+// scanRange collects the pairs with start <= key <= end in the cursor's order
+func scanRange(cursor *Cursor, start, end []byte, limit int) ([]KeyValue, error) {
+	cmp := cursor.tree.cmp
+
+	results := make([]KeyValue, 0)
+	if start != nil && end != nil && cmp(start, end) > 0 {
+		return results, nil
+	}
+
+	from, inRange := start, func(key []byte) bool { return end == nil || cmp(key, end) <= 0 }
+	if cursor.reverse {
+		from, inRange = end, func(key []byte) bool { return start == nil || cmp(key, start) >= 0 }
+	}
+
+	ok := false
+	if from == nil {
+		ok = cursor.First()
+	} else {
+		ok = cursor.Seek(from)
+	}
+
+	for ; ok; ok = cursor.Next() {
+		if !inRange(cursor.Key()) {
+			break
+		}
+
+		results = append(results, KeyValue{Key: cursor.Key(), Value: cursor.Value()})
+
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,297 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestCursorIteration(t *testing.T) {
+	dbFile := "test_cursor.db"
+	walFile := "test_cursor.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// Even keys 2..2000 span many leaves
+	for i := 1; i <= 1000; i++ {
+		key := uint32(i * 2)
+		if err := tree.Insert(k(key), fmt.Sprintf("value-%d", key)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", key, err)
+		}
+	}
+
+	// Full iteration from First
+	cursor := tree.NewCursor()
+	count := 0
+	prev := uint32(0)
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		if num(cursor.Key()) <= prev {
+			t.Fatalf("Keys not ascending: %d after %d", num(cursor.Key()), prev)
+		}
+		if cursor.Value() != fmt.Sprintf("value-%d", num(cursor.Key())) {
+			t.Errorf("Key=%d: value=%s", num(cursor.Key()), cursor.Value())
+		}
+		prev = num(cursor.Key())
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		t.Fatalf("Cursor error: %v", err)
+	}
+	if count != 1000 {
+		t.Errorf("Iterated %d keys, expected 1000", count)
+	}
+
+	// Seek to an existing key and to a gap
+	seekTests := []struct {
+		seek     uint32
+		expected uint32
+		valid    bool
+	}{
+		{0, 2, true},
+		{500, 500, true},
+		{501, 502, true},
+		{2000, 2000, true},
+		{2001, 0, false},
+	}
+	for _, tt := range seekTests {
+		ok := cursor.Seek(k(tt.seek))
+		if ok != tt.valid {
+			t.Errorf("Seek(%d): valid=%v, expected %v", tt.seek, ok, tt.valid)
+			continue
+		}
+		if ok && num(cursor.Key()) != tt.expected {
+			t.Errorf("Seek(%d): key=%d, expected %d", tt.seek, num(cursor.Key()), tt.expected)
+		}
+	}
+}
+
+func TestBPTreeScan(t *testing.T) {
+	dbFile := "test_scan.db"
+	walFile := "test_scan.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := 1; i <= 1000; i++ {
+		if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+
+	// Delete a block so the scan crosses emptied/merged leaves
+	for i := 300; i < 700; i++ {
+		if _, err := tree.Delete(k(uint32(i))); err != nil {
+			t.Fatalf("Failed to delete key=%d: %v", i, err)
+		}
+	}
+
+	scanTests := []struct {
+		start, end uint32
+		limit      int
+		expected   int
+		first      uint32
+	}{
+		{1, 1000, 0, 600, 1},
+		{250, 750, 0, 101, 250}, // 250..299 and 700..750
+		{250, 750, 10, 10, 250},
+		{300, 699, 0, 0, 0},
+		{990, 5000, 0, 11, 990},
+		{10, 5, 0, 0, 0}, // Empty range
+	}
+
+	for _, tt := range scanTests {
+		results, err := tree.Scan(k(tt.start), k(tt.end), tt.limit)
+		if err != nil {
+			t.Fatalf("Scan(%d, %d, %d) failed: %v", tt.start, tt.end, tt.limit, err)
+		}
+		if len(results) != tt.expected {
+			t.Errorf("Scan(%d, %d, %d): %d results, expected %d", tt.start, tt.end, tt.limit, len(results), tt.expected)
+			continue
+		}
+		if len(results) > 0 && num(results[0].Key) != tt.first {
+			t.Errorf("Scan(%d, %d, %d): first key %d, expected %d", tt.start, tt.end, tt.limit, num(results[0].Key), tt.first)
+		}
+		for _, kv := range results {
+			if num(kv.Key) < tt.start || num(kv.Key) > tt.end {
+				t.Errorf("Scan(%d, %d): key %d out of range", tt.start, tt.end, num(kv.Key))
+			}
+			if kv.Value != fmt.Sprintf("value-%d", num(kv.Key)) {
+				t.Errorf("Key=%d: value=%s", num(kv.Key), kv.Value)
+			}
+		}
+	}
+}
+
+// checkLeafChain verifies that NextPage and PrevPage link the leaves from the
+// leftmost to the rightmost in key order
+func checkLeafChain(t *testing.T, tree *BPTree) {
+	t.Helper()
+
+	pageID, _, err := tree.findLeftmostLeaf()
+	if err != nil {
+		t.Fatalf("Failed to find leftmost leaf: %v", err)
+	}
+
+	prevID := uint64(0)
+	var lastKey []byte
+	for pageID != 0 {
+		page, err := readPageStruct(tree.pager, pageID)
+		if err != nil {
+			t.Fatalf("Failed to read page %d: %v", pageID, err)
+		}
+		if page.Header.PageType != storage.PageTypeLeaf {
+			t.Fatalf("Page %d in the leaf chain is %v, not a leaf", pageID, page.Header.PageType)
+		}
+		if uint64(page.Header.PrevPage) != prevID {
+			t.Fatalf("Leaf %d: PrevPage=%d, expected %d", pageID, page.Header.PrevPage, prevID)
+		}
+		records, err := tree.leafPage(page).GetAllRecords()
+		if err != nil {
+			t.Fatalf("Failed to read leaf %d: %v", pageID, err)
+		}
+		for _, record := range records {
+			if lastKey != nil && tree.cmp(record.Key, lastKey) <= 0 {
+				t.Fatalf("Leaf %d: key %s after %s in the chain", pageID, FormatKey(record.Key), FormatKey(lastKey))
+			}
+			lastKey = record.Key
+		}
+		prevID = pageID
+		pageID = uint64(page.Header.NextPage)
+	}
+
+	rightmostID, _, err := tree.findRightmostLeaf()
+	if err != nil {
+		t.Fatalf("Failed to find rightmost leaf: %v", err)
+	}
+	if rightmostID != prevID {
+		t.Fatalf("Rightmost leaf=%d, chain ends at %d", rightmostID, prevID)
+	}
+}
+
+func TestReverseCursor(t *testing.T) {
+	dbFile := "test_reverse_cursor.db"
+	walFile := "test_reverse_cursor.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// Even keys 2..2000 span many leaves
+	for i := 1; i <= 1000; i++ {
+		key := uint32(i * 2)
+		if err := tree.Insert(k(key), fmt.Sprintf("value-%d", key)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", key, err)
+		}
+	}
+	checkLeafChain(t, tree)
+
+	// Full iteration from the largest key
+	cursor := tree.NewReverseCursor()
+	count := 0
+	expected := uint32(2000)
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		if num(cursor.Key()) != expected {
+			t.Fatalf("Reverse iteration: key=%d, expected %d", num(cursor.Key()), expected)
+		}
+		expected -= 2
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		t.Fatalf("Cursor error: %v", err)
+	}
+	if count != 1000 {
+		t.Errorf("Iterated %d keys, expected 1000", count)
+	}
+
+	seekTests := []struct {
+		seek     uint32
+		expected uint32
+		valid    bool
+	}{
+		{5000, 2000, true},
+		{500, 500, true},
+		{501, 500, true},
+		{2, 2, true},
+		{1, 0, false},
+	}
+	for _, tt := range seekTests {
+		ok := cursor.Seek(k(tt.seek))
+		if ok != tt.valid {
+			t.Errorf("Seek(%d): valid=%v, expected %v", tt.seek, ok, tt.valid)
+			continue
+		}
+		if ok && num(cursor.Key()) != tt.expected {
+			t.Errorf("Seek(%d): key=%d, expected %d", tt.seek, num(cursor.Key()), tt.expected)
+		}
+	}
+
+	// Deletes merge leaves; the backward chain must stay intact
+	for i := 400; i < 1600; i += 2 {
+		if _, err := tree.Delete(k(uint32(i))); err != nil {
+			t.Fatalf("Failed to delete key=%d: %v", i, err)
+		}
+	}
+	checkLeafChain(t, tree)
+
+	results, err := tree.ScanReverse(k(300), k(1700), 0)
+	if err != nil {
+		t.Fatalf("ScanReverse failed: %v", err)
+	}
+	if len(results) != 101 { // 1700..1600 and 398..300
+		t.Fatalf("ScanReverse returned %d results, expected 101", len(results))
+	}
+	if num(results[0].Key) != 1700 || num(results[len(results)-1].Key) != 300 {
+		t.Errorf("ScanReverse bounds: first=%d last=%d", num(results[0].Key), num(results[len(results)-1].Key))
+	}
+	for i := 1; i < len(results); i++ {
+		if num(results[i].Key) >= num(results[i-1].Key) {
+			t.Fatalf("ScanReverse not descending: %d after %d", num(results[i].Key), num(results[i-1].Key))
+		}
+	}
+
+	limited, err := tree.ScanReverse(k(0), k(5000), 3)
+	if err != nil {
+		t.Fatalf("ScanReverse failed: %v", err)
+	}
+	if len(limited) != 3 || num(limited[0].Key) != 2000 || num(limited[2].Key) != 1996 {
+		t.Errorf("ScanReverse with limit: %v", limited)
+	}
+}
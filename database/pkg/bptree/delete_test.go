@@ -0,0 +1,253 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeDelete(t *testing.T) {
+	dbFile := "test_delete.db"
+	walFile := "test_delete.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	numRecords := 1000
+	for i := 1; i <= numRecords; i++ {
+		if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+
+	// Delete all even keys
+	for i := 2; i <= numRecords; i += 2 {
+		found, err := tree.Delete(k(uint32(i)))
+		if err != nil {
+			t.Fatalf("Failed to delete key=%d: %v", i, err)
+		}
+		if !found {
+			t.Errorf("Delete(%d): key should exist", i)
+		}
+	}
+
+	// Deleting a missing key is not an error
+	found, err := tree.Delete(k(2))
+	if err != nil {
+		t.Fatalf("Delete of missing key failed: %v", err)
+	}
+	if found {
+		t.Error("Delete(2) should report key not found")
+	}
+
+	for i := 1; i <= numRecords; i++ {
+		value, found, err := tree.Search(k(uint32(i)))
+		if err != nil {
+			t.Fatalf("Search failed for key=%d: %v", i, err)
+		}
+		if i%2 == 0 && found {
+			t.Errorf("Key=%d found after delete", i)
+		}
+		if i%2 == 1 && (!found || value != fmt.Sprintf("value-%d", i)) {
+			t.Errorf("Key=%d: found=%v value=%s", i, found, value)
+		}
+	}
+
+	keys, err := tree.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("InOrderTraversal failed: %v", err)
+	}
+	if len(keys) != numRecords/2 {
+		t.Errorf("Traversal returned %d keys, expected %d", len(keys), numRecords/2)
+	}
+	for i := 1; i < len(keys); i++ {
+		if num(keys[i]) <= num(keys[i-1]) {
+			t.Errorf("Keys not sorted: keys[%d]=%d, keys[%d]=%d", i-1, num(keys[i-1]), i, num(keys[i]))
+		}
+	}
+
+	t.Logf("✓ Deleted %d keys, %d remain", numRecords/2, len(keys))
+}
+
+// TestBPTreeDeleteAllRebalance deletes every key from a 3-level tree,
+// exercising leaf/internal merges, borrows and root collapse
+func TestBPTreeDeleteAllRebalance(t *testing.T) {
+	dbFile := "test_delete_all.db"
+	walFile := "test_delete_all.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bufferPool := storage.NewBufferPool(pager, 256)
+
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	// Large values keep only a few records per leaf so internal nodes split
+	numRecords := 1500
+	bigValue := strings.Repeat("x", 900)
+	for i := 1; i <= numRecords; i++ {
+		if err := tree.Insert(k(uint32(i)), bigValue); err != nil {
+			t.Fatalf("Failed to insert key=%d: %v", i, err)
+		}
+	}
+
+	rootPage, err := readPageStruct(bufferPool, tree.GetRootPageID())
+	if err != nil {
+		t.Fatalf("Failed to read root: %v", err)
+	}
+	child, _ := storage.NewInternalPage(rootPage).GetLeftmostPointer()
+	childPage, _ := readPageStruct(bufferPool, child)
+	if !childPage.IsInternal() {
+		t.Fatalf("Expected a 3-level tree")
+	}
+	pagesBefore := pager.NumPages()
+
+	// Delete in random order
+	rng := rand.New(rand.NewSource(42))
+	order := rng.Perm(numRecords)
+	for n, i := range order {
+		key := uint32(i + 1)
+		found, err := tree.Delete(k(key))
+		if err != nil {
+			t.Fatalf("Failed to delete key=%d: %v", key, err)
+		}
+		if !found {
+			t.Fatalf("Delete(%d): key should exist", key)
+		}
+
+		// Spot-check structure periodically
+		if n%250 == 0 {
+			keys, err := tree.InOrderTraversal()
+			if err != nil {
+				t.Fatalf("InOrderTraversal failed: %v", err)
+			}
+			if len(keys) != numRecords-n-1 {
+				t.Fatalf("After %d deletes: traversal has %d keys, expected %d", n+1, len(keys), numRecords-n-1)
+			}
+			checkLeafChain(t, tree)
+		}
+	}
+
+	keys, err := tree.InOrderTraversal()
+	if err != nil {
+		t.Fatalf("InOrderTraversal failed: %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("Traversal returned %d keys, expected 0", len(keys))
+	}
+
+	rootPage, err = readPageStruct(bufferPool, tree.GetRootPageID())
+	if err != nil {
+		t.Fatalf("Failed to read root: %v", err)
+	}
+	if !rootPage.IsLeaf() {
+		t.Errorf("Root should collapse to a leaf, got %s", rootPage.Header.PageType)
+	}
+
+	if pager.FreeListSize() == 0 {
+		t.Error("Merged pages should be returned to the free list")
+	}
+	t.Logf("✓ Free list holds %d pages", pager.FreeListSize())
+
+	// Freed pages are reused before the file grows
+	for i := 1; i <= 100; i++ {
+		if err := tree.Insert(k(uint32(i)), bigValue); err != nil {
+			t.Fatalf("Failed to re-insert key=%d: %v", i, err)
+		}
+	}
+	if pager.NumPages() != pagesBefore {
+		t.Errorf("File grew from %d to %d pages despite free pages", pagesBefore, pager.NumPages())
+	}
+}
+
+func TestBPTreeDeleteWALRecovery(t *testing.T) {
+	dbFile := "test_delete_recovery.db"
+	walFile := "test_delete_recovery.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	// Phase 1: insert and delete, then crash
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		for i := 1; i <= 300; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+		for i := 1; i <= 300; i += 3 {
+			if _, err := tree.Delete(k(uint32(i))); err != nil {
+				t.Fatalf("Failed to delete: %v", err)
+			}
+		}
+
+		// Don't close properly - simulate crash
+		pager.Close()
+	}
+
+	// Phase 2: recover from WAL
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+
+		tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		defer tree.Close()
+
+		for i := 1; i <= 300; i++ {
+			_, found, err := tree.Search(k(uint32(i)))
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			deleted := (i-1)%3 == 0
+			if found == deleted {
+				t.Errorf("Key=%d: found=%v after recovery, deleted=%v", i, found, deleted)
+			}
+		}
+	}
+}
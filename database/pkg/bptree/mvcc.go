@@ -0,0 +1,400 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// Snapshots (MVCC)
+//
+// Commit timestamps are WAL LSNs: a write is stamped with the LSN of its
+// record, a batch with the batch LSN and a transaction with its COMMIT LSN.
+// A snapshot reads as of the last LSN when it was taken. It is taken with no
+// write in flight, so every write up to that LSN is applied and every later
+// one is stamped higher
+//
+// With no snapshot open records are stored unversioned, as before. While
+// snapshots are open a write keeps the versions they can still see in the
+// record (a delete leaves a tombstone) and drops the rest. Versions left
+// behind when snapshots are released are removed by CollectGarbage, which
+// runs on its own once the last snapshot is released
+
+// ErrSnapshotReleased is returned when a released snapshot is read
+var ErrSnapshotReleased = errors.New("snapshot already released")
+
+// Snapshot is a read-only view of the tree as of one timestamp
+// Writes committed after it was taken are invisible to it and are never
+// blocked by it. Release it when done so the versions it keeps can be collected
+//
+// A Snapshot is not safe for concurrent use
+type Snapshot struct {
+	tree     *BPTree
+	ts       uint64
+	released bool
+}
+
+// GCStats reports what a garbage collection pass removed
+type GCStats struct {
+	Versions int // versions no open snapshot could see
+	Records  int // records of deleted keys removed from their leaves
+}
+
+// Snapshot opens a snapshot of the tree as it is now
+func (tree *BPTree) Snapshot() *Snapshot {
+	tree.writeLatch.Lock()
+	defer tree.writeLatch.Unlock()
+
+	ts := tree.wal.LastLSN()
+	tree.snapshots[ts]++
+	return &Snapshot{tree: tree, ts: ts}
+}
+
+// Timestamp returns the commit timestamp the snapshot reads as of
+func (s *Snapshot) Timestamp() uint64 {
+	return s.ts
+}
+
+// Release closes the snapshot, collecting garbage if it was the last one open
+func (s *Snapshot) Release() error {
+	if s.released {
+		return nil
+	}
+	s.released = true
+
+	tree := s.tree
+	tree.writeLatch.Lock()
+	tree.snapshots[s.ts]--
+	if tree.snapshots[s.ts] == 0 {
+		delete(tree.snapshots, s.ts)
+	}
+	last := len(tree.snapshots) == 0
+	tree.writeLatch.Unlock()
+
+	if !last || !tree.garbage.Load() {
+		return nil
+	}
+
+	_, err := tree.CollectGarbage()
+	return err
+}
+
+// Search looks up a key as of the snapshot
+func (s *Snapshot) Search(key []byte) (string, bool, error) {
+	if s.released {
+		return "", false, ErrSnapshotReleased
+	}
+
+	_, leafPage, _, latch, err := s.tree.descendLatched(childIndexFor(key))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to find leaf page: %w", err)
+	}
+	defer latch.RUnlock()
+
+	record, found := s.tree.leafPage(leafPage).SearchRecord(key)
+	if !found {
+		return "", false, nil
+	}
+
+	version, visible := record.VersionAt(s.ts)
+	if !visible {
+		return "", false, nil
+	}
+
+	value, err := s.tree.readValue(version)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// NewCursor creates an unpositioned cursor reading as of the snapshot
+func (s *Snapshot) NewCursor() *Cursor {
+	return &Cursor{tree: s.tree, snapshot: s}
+}
+
+// NewReverseCursor creates an unpositioned descending cursor reading as of the snapshot
+func (s *Snapshot) NewReverseCursor() *Cursor {
+	return &Cursor{tree: s.tree, snapshot: s, reverse: true}
+}
+
+// Scan returns key-value pairs with start <= key <= end in ascending order,
+// as of the snapshot
+// A nil start or end leaves that side of the range open
+// limit <= 0 means no limit
+func (s *Snapshot) Scan(start, end []byte, limit int) ([]KeyValue, error) {
+	return scanRange(s.NewCursor(), start, end, limit)
+}
+
+// ScanReverse is Scan in descending order
+func (s *Snapshot) ScanReverse(start, end []byte, limit int) ([]KeyValue, error) {
+	return scanRange(s.NewReverseCursor(), start, end, limit)
+}
+
+// SnapshotCount returns the number of open snapshots
+func (tree *BPTree) SnapshotCount() int {
+	tree.writeLatch.RLock()
+	defer tree.writeLatch.RUnlock()
+
+	count := 0
+	for _, handles := range tree.snapshots {
+		count += handles
+	}
+	return count
+}
+
+// openSnapshots returns the timestamps of the open snapshots in ascending order
+// Callers hold writeLatch
+func (tree *BPTree) openSnapshots() []uint64 {
+	if len(tree.snapshots) == 0 {
+		return nil
+	}
+
+	timestamps := make([]uint64, 0, len(tree.snapshots))
+	for ts := range tree.snapshots {
+		timestamps = append(timestamps, ts)
+	}
+	slices.Sort(timestamps)
+	return timestamps
+}
+
+// newVersion returns the record of key after writing value (or a tombstone)
+// at ts on top of existing (nil if the key has no record), keeping the older
+// versions open snapshots can still see. overflow marks value as the
+// reference to an overflow chain (see storeValue)
+// Returns nil when no record is needed: a delete with no snapshot open
+func (tree *BPTree) newVersion(existing *storage.Record, key []byte, value string, overflow, deleted bool, ts uint64) *storage.Record {
+	record := storage.NewRecord(key, []byte(value))
+	record.Overflow = overflow
+	record.CommitTS = ts
+	record.Deleted = deleted
+	if existing != nil {
+		record.History = existing.Versions()
+	}
+
+	return pruneVersions(record, tree.openSnapshots())
+}
+
+// pruneVersions drops the versions of record no open snapshot can see,
+// given the snapshot timestamps in ascending order
+// Returns nil when none of them is needed
+func pruneVersions(record *storage.Record, snapshots []uint64) *storage.Record {
+	versions := record.Versions()
+
+	// The newest version is read by everything but older snapshots. An older
+	// version is read by the snapshots taken before the next one committed
+	kept := []storage.Version{versions[0]}
+	for i := 1; i < len(versions); i++ {
+		from, to := versions[i].CommitTS, versions[i-1].CommitTS
+		next, _ := slices.BinarySearch(snapshots, from)
+		if next < len(snapshots) && snapshots[next] < to {
+			kept = append(kept, versions[i])
+		}
+	}
+
+	// A tombstone with nothing older reads the same as no record
+	for len(kept) > 0 && kept[len(kept)-1].Deleted {
+		kept = kept[:len(kept)-1]
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+
+	// A single version every snapshot sees needs no timestamp
+	if len(kept) == 1 && (len(snapshots) == 0 || kept[0].CommitTS <= snapshots[0]) {
+		kept[0].CommitTS = 0
+	}
+
+	return storage.NewVersionedRecord(record.Key, kept)
+}
+
+// versionSafe accepts pages that take the new version of key without
+// splitting, or with no snapshot open, pages a delete leaves out of underflow
+// Runs under writeLatch, so the open snapshots cannot change during the write
+func (tree *BPTree) versionSafe(key []byte, value string, deleted bool) func(*storage.Page) bool {
+	return func(page *storage.Page) bool {
+		if deleted && len(tree.snapshots) == 0 {
+			return tree.deleteSafe(key)(page)
+		}
+
+		if page.IsLeaf() {
+			leaf := tree.leafPage(page)
+			existing, found := leaf.SearchRecord(key)
+			if !found {
+				existing = nil
+			}
+
+			// Sized with a timestamp newer than any snapshot, like the real one,
+			// and a large value as the reference it is stored as
+			stored, overflow := value, false
+			if !deleted && len(value) > tree.maxInlineValueSize() {
+				stored, overflow = string(make([]byte, storage.OverflowRefSize)), true
+			}
+			record := tree.newVersion(existing, key, stored, overflow, deleted, math.MaxUint64)
+			return record == nil || leaf.AvailableSpace() >= record.Size()+2 // +2 for slot
+		}
+
+		// A leaf split pushes up a separator no longer than the largest key
+		return tree.internalPage(page).AvailableSpace() >= storage.MaxInternalEntrySize
+	}
+}
+
+// writeVersion makes value (or a tombstone) the newest version of key, stamped
+// with ts, in its leaf latched along versionSafe
+// Returns true if the key existed and was not deleted
+func (tree *BPTree) writeVersion(path *writePath, leafPageID uint64, leafPage *storage.Page,
+	key []byte, value string, deleted bool, ts uint64) (bool, error) {
+	existing, found := tree.leafPage(leafPage).SearchRecord(key)
+	if !found {
+		existing = nil
+	}
+	existed := found && !existing.Deleted
+
+	// Deleting a key that is already gone is a no-op
+	if deleted && !existed {
+		return false, nil
+	}
+
+	// Only indexes need the old value, a large one is read from its chain
+	var oldValue string
+	if existed && len(tree.valueIndexes) > 0 {
+		var err error
+		if oldValue, err = tree.newestValue(existing); err != nil {
+			return existed, err
+		}
+	}
+
+	stored, overflow, err := tree.storeValue(value, deleted)
+	if err != nil {
+		return existed, err
+	}
+
+	record := tree.newVersion(existing, key, stored, overflow, deleted, ts)
+	if record == nil {
+		_, err = tree.deleteFromLeaf(path, leafPageID, leafPage, key)
+	} else {
+		if record.Versioned() {
+			tree.garbage.Store(true)
+		}
+		_, err = tree.upsertIntoLeaf(path, leafPageID, leafPage, record)
+	}
+	if err != nil {
+		if overflow {
+			tree.freeOverflow([]byte(stored))
+		}
+		return existed, err
+	}
+
+	// Versions the record no longer holds take their chains with them
+	if err := tree.freeChains(existing, record); err != nil {
+		return existed, err
+	}
+
+	// Index entries follow the write under the same timestamp (see index.go)
+	return existed, tree.updateIndexes(key, oldValue, existed, value, deleted, ts)
+}
+
+// applyVersion writes an already logged change (replay, batches and commits)
+// Returns true if the key existed and was not deleted
+func (tree *BPTree) applyVersion(key []byte, value string, deleted bool, ts uint64) (bool, error) {
+	path, leafPageID, leafPage, err := tree.descendExclusive(key, tree.versionSafe(key, value, deleted))
+	if err != nil {
+		return false, fmt.Errorf("failed to find leaf page: %w", err)
+	}
+	defer path.release()
+
+	return tree.writeVersion(path, leafPageID, leafPage, key, value, deleted, ts)
+}
+
+// CollectGarbage drops the versions no open snapshot can see and removes the
+// records of deleted keys, in every table of the file
+// Runs on its own when the last open snapshot is released
+func (tree *BPTree) CollectGarbage() (GCStats, error) {
+	var stats GCStats
+	tree.garbage.Store(false)
+
+	for _, table := range tree.allTables() {
+		if err := table.collectTable(&stats); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// collectTable collects the garbage of one table into stats
+func (tree *BPTree) collectTable(stats *GCStats) error {
+	// Walk the leaves by their key ranges, pruning each versioned key under
+	// its own write so writers are only held up one key at a time
+	next := func(*storage.InternalPage) int { return 0 } // leftmost leaf first
+	for {
+		pageID, page, bounds, err := tree.descendShared(next)
+		if err != nil {
+			return fmt.Errorf("failed to find leaf page: %w", err)
+		}
+
+		records, err := tree.leafPage(page).GetAllRecords()
+		if err != nil {
+			return fmt.Errorf("failed to get records from page %d: %w", pageID, err)
+		}
+
+		for _, record := range records {
+			if !record.Versioned() {
+				continue
+			}
+			if err := tree.collectKey(record.Key, stats); err != nil {
+				return fmt.Errorf("failed to collect key %s: %w", FormatKey(record.Key), err)
+			}
+		}
+
+		if !bounds.hasHigh {
+			return nil
+		}
+		next = childIndexFor(bounds.high)
+	}
+}
+
+// collectKey prunes the versions of one key
+func (tree *BPTree) collectKey(key []byte, stats *GCStats) error {
+	tree.writeLatch.RLock()
+	defer tree.writeLatch.RUnlock()
+
+	// Pruning only shrinks the record, removing it may merge the leaf
+	path, leafPageID, leafPage, err := tree.descendExclusive(key, tree.deleteSafe(key))
+	if err != nil {
+		return err
+	}
+	defer path.release()
+
+	record, found := tree.leafPage(leafPage).SearchRecord(key)
+	if !found || !record.Versioned() {
+		return nil
+	}
+
+	before := len(record.History) + 1
+	pruned := pruneVersions(record, tree.openSnapshots())
+	if pruned == nil {
+		stats.Versions += before
+		stats.Records++
+		if _, err := tree.deleteFromLeaf(path, leafPageID, leafPage, key); err != nil {
+			return err
+		}
+		return tree.freeChains(record, nil)
+	}
+
+	dropped := before - len(pruned.History) - 1
+	if pruned.Versioned() {
+		tree.garbage.Store(true) // still seen by an open snapshot
+		if dropped == 0 && pruned.CommitTS == record.CommitTS {
+			return nil
+		}
+	}
+	stats.Versions += dropped
+
+	if _, err := tree.upsertIntoLeaf(path, leafPageID, leafPage, pruned); err != nil {
+		return err
+	}
+	return tree.freeChains(record, pruned)
+}
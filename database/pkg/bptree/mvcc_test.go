@@ -0,0 +1,369 @@
+package bptree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// countVersioned returns the number of records still stored with versions
+func countVersioned(t *testing.T, tree *BPTree) int {
+	t.Helper()
+
+	count, key := 0, []byte{}
+	for {
+		_, page, bounds, err := tree.descendShared(childIndexFor(key))
+		if err != nil {
+			t.Fatalf("Descent failed: %v", err)
+		}
+		records, _ := storage.NewLeafPage(page).GetAllRecords()
+		for _, record := range records {
+			if record.Versioned() {
+				count++
+			}
+		}
+		if !bounds.hasHigh {
+			return count
+		}
+		key = bounds.high
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	dbFile := "test_snapshot.db"
+	walFile := "test_snapshot.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 4, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+	tree.SetWALSyncMode(wal.SyncOff, 0)
+
+	for i := uint32(1); i <= 200; i++ {
+		tree.Insert(k(i), fmt.Sprintf("v1-%d", i))
+	}
+	if n := countVersioned(t, tree); n != 0 {
+		t.Fatalf("%d records versioned with no snapshot open", n)
+	}
+
+	first := tree.Snapshot()
+
+	// Every kind of write after the snapshot
+	for i := uint32(1); i <= 200; i += 2 {
+		tree.Upsert(k(i), fmt.Sprintf("v2-%d", i))
+	}
+	for i := uint32(2); i <= 200; i += 4 {
+		tree.Delete(k(i))
+	}
+	tree.Insert(k(500), "new")
+	batch := NewWriteBatch()
+	batch.Put(k(4), "batch")
+	batch.Delete(k(8))
+	if err := tree.Write(batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	second := tree.Snapshot()
+
+	tx := tree.Begin()
+	tx.Upsert(k(1), "tx")
+	tx.Delete(k(3))
+	tx.Insert(k(2), "back")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// The first snapshot still sees the original 200 keys
+	rows, err := first.Scan(k(0), k(1000), 0)
+	if err != nil {
+		t.Fatalf("Snapshot scan failed: %v", err)
+	}
+	if len(rows) != 200 {
+		t.Fatalf("First snapshot sees %d keys, expected 200", len(rows))
+	}
+	for _, row := range rows {
+		if row.Value != fmt.Sprintf("v1-%d", num(row.Key)) {
+			t.Fatalf("First snapshot: key %d = %q", num(row.Key), row.Value)
+		}
+	}
+	reversed, _ := first.ScanReverse(k(0), k(1000), 0)
+	if len(reversed) != 200 || num(reversed[0].Key) != 200 || num(reversed[199].Key) != 1 {
+		t.Errorf("First snapshot reverse scan returned %d keys", len(reversed))
+	}
+
+	// The second snapshot sees everything up to the batch, not the transaction
+	checks := []struct {
+		key     uint32
+		value   string
+		visible bool
+	}{
+		{1, "v2-1", true},
+		{2, "", false},
+		{3, "v2-3", true},
+		{4, "batch", true},
+		{8, "", false},
+		{500, "new", true},
+	}
+	for _, c := range checks {
+		value, visible, err := second.Search(k(c.key))
+		if err != nil || visible != c.visible || value != c.value {
+			t.Errorf("Second snapshot key %d = (%q, %v, %v), expected (%q, %v)", c.key, value, visible, err, c.value, c.visible)
+		}
+	}
+
+	// The tree itself sees the latest writes
+	if value, _, _ := tree.Search(k(1)); value != "tx" {
+		t.Errorf("Latest key 1 = %q", value)
+	}
+	if _, found, _ := tree.Search(k(3)); found {
+		t.Error("Key 3 should be deleted")
+	}
+	if err := tree.Insert(k(6), "again"); err != nil {
+		t.Errorf("Insert of a key deleted under a snapshot failed: %v", err)
+	}
+	keys, _ := tree.InOrderTraversal()
+	if len(keys) != 151 {
+		t.Errorf("Latest state has %d keys", len(keys))
+	}
+
+	if n := countVersioned(t, tree); n == 0 {
+		t.Fatal("Expected versioned records while snapshots are open")
+	}
+
+	// Releasing the last snapshot collects every old version
+	first.Release()
+	if n := countVersioned(t, tree); n == 0 {
+		t.Error("Second snapshot still needs versions")
+	}
+	if err := second.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if n := countVersioned(t, tree); n != 0 {
+		t.Errorf("%d records still versioned after the last release", n)
+	}
+	if _, _, err := first.Search(k(1)); err != ErrSnapshotReleased {
+		t.Errorf("Search on released snapshot: %v", err)
+	}
+
+	after, _ := tree.InOrderTraversal()
+	if len(after) != len(keys) {
+		t.Errorf("Garbage collection changed the key count: %d -> %d", len(keys), len(after))
+	}
+	checkLeafChain(t, tree)
+
+	t.Logf("✓ Snapshots read as of their timestamp, %d keys after GC", len(after))
+}
+
+func TestSnapshotGarbageCollection(t *testing.T) {
+	dbFile := "test_snapshot_gc.db"
+	walFile := "test_snapshot_gc.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 4, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+	tree.SetWALSyncMode(wal.SyncOff, 0)
+
+	tree.Insert(k(1), "a")
+	snapshot := tree.Snapshot()
+
+	// Versions only the snapshot's one is kept, however many writes follow
+	for i := 0; i < 50; i++ {
+		tree.Upsert(k(1), fmt.Sprintf("b%d", i))
+	}
+	_, page, _ := tree.findLeafPage(k(1))
+	record, _ := storage.NewLeafPage(page).SearchRecord(k(1))
+	if len(record.History) != 1 || string(record.History[0].Value) != "a" {
+		t.Errorf("Expected one old version, got %d", len(record.History))
+	}
+
+	// Deleted keys stay as tombstones until the snapshot is gone
+	tree.Delete(k(1))
+	if value, found, _ := snapshot.Search(k(1)); !found || value != "a" {
+		t.Errorf("Snapshot lost key 1: %q %v", value, found)
+	}
+
+	// Manual collection keeps what the open snapshot needs
+	stats, err := tree.CollectGarbage()
+	if err != nil {
+		t.Fatalf("CollectGarbage failed: %v", err)
+	}
+	if stats.Records != 0 {
+		t.Errorf("Removed %d records still seen by a snapshot", stats.Records)
+	}
+
+	// Release runs the collection: the tombstone and its history go away
+	snapshot.Release()
+	_, page, _ = tree.findLeafPage(k(1))
+	if _, found := storage.NewLeafPage(page).SearchRecord(k(1)); found {
+		t.Error("Tombstone of key 1 survived the last release")
+	}
+	if tree.SnapshotCount() != 0 {
+		t.Errorf("%d snapshots still open", tree.SnapshotCount())
+	}
+
+	t.Logf("✓ Versions pruned on write and collected on release")
+}
+
+// Transfers between accounts run as batches while snapshot scans check the
+// total never changes, which plain scans cannot promise mid-batch
+func TestSnapshotConsistentScans(t *testing.T) {
+	dbFile := "test_snapshot_scan.db"
+	walFile := "test_snapshot_scan.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	bufferPool := storage.NewBufferPool(pager, 64)
+	defer bufferPool.Close()
+
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+	tree.SetWALSyncMode(wal.SyncOff, 0)
+
+	const (
+		writers  = 4
+		accounts = 100 // per writer
+		balance  = 1000
+		scans    = 100
+	)
+
+	batch := NewWriteBatch()
+	for key := uint32(0); key < writers*accounts; key++ {
+		batch.Put(k(key), strconv.Itoa(balance))
+	}
+	if err := tree.Write(batch); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	total := writers * accounts * balance
+
+	stop := make(chan struct{})
+	var writersDone sync.WaitGroup
+	errs := make(chan error, writers+1)
+
+	for w := 0; w < writers; w++ {
+		writersDone.Add(1)
+
+		go func(w int) {
+			defer writersDone.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+
+			// Each writer moves money between its own accounts only
+			balances := make(map[uint32]int)
+			for i := 0; i < accounts; i++ {
+				balances[uint32(i*writers+w)] = balance
+			}
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				from := uint32(rng.Intn(accounts)*writers + w)
+				to := uint32(rng.Intn(accounts)*writers + w)
+				amount := rng.Intn(50)
+				if from == to {
+					continue
+				}
+
+				balances[from] -= amount
+				balances[to] += amount
+
+				transfer := NewWriteBatch()
+				transfer.Put(k(from), strconv.Itoa(balances[from]))
+				transfer.Put(k(to), strconv.Itoa(balances[to]))
+				if err := tree.Write(transfer); err != nil {
+					errs <- fmt.Errorf("transfer: %w", err)
+					return
+				}
+				time.Sleep(50 * time.Microsecond)
+			}
+		}(w)
+	}
+
+	// Each snapshot is read in two halves with writes landing in between
+	half := uint32(writers * accounts / 2)
+	for i := 0; i < scans; i++ {
+		snapshot := tree.Snapshot()
+		rows, err := snapshot.Scan(k(0), k(half-1), 0)
+		if err != nil {
+			t.Fatalf("Snapshot scan failed: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+		rest, err := snapshot.Scan(k(half), k(2*half-1), 0)
+		if err != nil {
+			t.Fatalf("Snapshot scan failed: %v", err)
+		}
+		rows = append(rows, rest...)
+
+		sum := 0
+		for _, row := range rows {
+			amount, _ := strconv.Atoi(row.Value)
+			sum += amount
+		}
+		if len(rows) != writers*accounts || sum != total {
+			t.Errorf("Snapshot at %d saw %d accounts holding %d, expected %d holding %d",
+				snapshot.Timestamp(), len(rows), sum, writers*accounts, total)
+			break
+		}
+
+		if err := snapshot.Release(); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+	}
+
+	close(stop)
+	writersDone.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		return
+	}
+
+	if n := countVersioned(t, tree); n != 0 {
+		t.Errorf("%d records still versioned after every snapshot was released", n)
+	}
+
+	t.Logf("✓ %d snapshot scans saw a consistent total under %d writers", scans, writers)
+}
@@ -0,0 +1,169 @@
+package bptree
+
+import (
+	"fmt"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// Overflow chains
+//
+// writeVersion moves values larger than storage.MaxInlineValueSize to a chain
+// of overflow pages and keeps a reference in the record instead (see
+// storage/overflow_page.go). Chains are never modified: every write of a
+// large value stores a new chain, and the chain of a version is freed once
+// its record no longer holds that version, with the leaf latched exclusively.
+// Readers follow chains with the shared latch of the leaf held, so a chain
+// cannot be freed while it is read
+
+// maxInlineValueSize returns the largest value kept in a leaf record, it
+// grows with the page size of the file
+func (tree *BPTree) maxInlineValueSize() int {
+	return storage.MaxInlineValueSize(tree.pager.PageSize())
+}
+
+// storeValue returns what a record holds for value: the value itself, or
+// the reference to a new chain holding it
+func (tree *BPTree) storeValue(value string, deleted bool) (string, bool, error) {
+	if deleted || len(value) <= tree.maxInlineValueSize() {
+		return value, false, nil
+	}
+
+	ref, err := tree.writeOverflow(value)
+	if err != nil {
+		return "", false, err
+	}
+	return string(ref), true, nil
+}
+
+// writeOverflow stores value in a new chain and returns its reference
+func (tree *BPTree) writeOverflow(value string) ([]byte, error) {
+	// Written back to front, so each page is written once with its successor
+	chunkSize := storage.OverflowChunkSize(tree.pager.PageSize())
+	chunks := (len(value) + chunkSize - 1) / chunkSize
+
+	next := uint64(0)
+	for i := chunks - 1; i >= 0; i-- {
+		pageID, err := tree.pager.AllocatePage()
+		if err != nil {
+			tree.freeChain(next)
+			return nil, fmt.Errorf("failed to allocate overflow page: %w", err)
+		}
+
+		page := storage.NewPage(storage.PageTypeOverflow, tree.pager.PageSize())
+		overflow := storage.NewOverflowPage(page)
+		start := i * chunkSize
+		overflow.SetChunk([]byte(value[start:min(start+chunkSize, len(value))]))
+		overflow.SetNext(next)
+
+		if err := writePageStruct(tree.pager, pageID, page); err != nil {
+			tree.pager.FreePage(pageID)
+			tree.freeChain(next)
+			return nil, fmt.Errorf("failed to write overflow page %d: %w", pageID, err)
+		}
+		next = pageID
+	}
+
+	return storage.EncodeOverflowRef(len(value), next), nil
+}
+
+// readValue returns the value of a version, reassembled from its chain if
+// it overflowed. The caller holds the latch of the leaf the version came from
+func (tree *BPTree) readValue(version storage.Version) (string, error) {
+	if !version.Overflow {
+		return string(version.Value), nil
+	}
+
+	size, pageID, err := storage.DecodeOverflowRef(version.Value)
+	if err != nil {
+		return "", err
+	}
+
+	value := make([]byte, 0, size)
+	for pageID != 0 && len(value) < size {
+		page, err := readPageStruct(tree.pager, pageID)
+		if err != nil {
+			return "", fmt.Errorf("failed to read overflow page %d: %w", pageID, err)
+		}
+		if page.Header.PageType != storage.PageTypeOverflow {
+			return "", fmt.Errorf("page %d in overflow chain is a %s page", pageID, page.Header.PageType)
+		}
+
+		overflow := storage.NewOverflowPage(page)
+		chunk, err := overflow.Chunk()
+		if err != nil {
+			return "", fmt.Errorf("failed to read overflow page %d: %w", pageID, err)
+		}
+		value = append(value, chunk...)
+		pageID = overflow.Next()
+	}
+
+	if len(value) != size {
+		return "", fmt.Errorf("overflow chain holds %d bytes, expected %d", len(value), size)
+	}
+	return string(value), nil
+}
+
+// newestValue returns the newest value of a record
+func (tree *BPTree) newestValue(record *storage.Record) (string, error) {
+	return tree.readValue(record.Versions()[0])
+}
+
+// freeChain returns the pages of the chain starting at pageID to the free list
+func (tree *BPTree) freeChain(pageID uint64) error {
+	for pageID != 0 {
+		page, err := readPageStruct(tree.pager, pageID)
+		if err != nil {
+			return fmt.Errorf("failed to read overflow page %d: %w", pageID, err)
+		}
+		if page.Header.PageType != storage.PageTypeOverflow {
+			return fmt.Errorf("page %d in overflow chain is a %s page", pageID, page.Header.PageType)
+		}
+
+		next := storage.NewOverflowPage(page).Next()
+		if err := tree.pager.FreePage(pageID); err != nil {
+			return err
+		}
+		pageID = next
+	}
+	return nil
+}
+
+// freeChains frees the chains of the versions of before that after (nil if
+// the record was removed) no longer holds
+func (tree *BPTree) freeChains(before, after *storage.Record) error {
+	if before == nil {
+		return nil
+	}
+
+	kept := make(map[string]bool)
+	if after != nil {
+		for _, version := range after.Versions() {
+			if version.Overflow {
+				kept[string(version.Value)] = true
+			}
+		}
+	}
+
+	for _, version := range before.Versions() {
+		if !version.Overflow || kept[string(version.Value)] {
+			continue
+		}
+		if err := tree.freeOverflow(version.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeOverflow frees the chain a reference points to
+func (tree *BPTree) freeOverflow(ref []byte) error {
+	_, pageID, err := storage.DecodeOverflowRef(ref)
+	if err != nil {
+		return err
+	}
+	if err := tree.freeChain(pageID); err != nil {
+		return fmt.Errorf("failed to free overflow chain: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// largeValue returns a value of size bytes spanning several overflow pages
+func largeValue(i int, size int) string {
+	prefix := fmt.Sprintf("value-%d:", i)
+	return prefix + strings.Repeat(string(rune('a'+i%26)), size-len(prefix))
+}
+
+func TestBPTreeOverflow(t *testing.T) {
+	dbFile := "test_overflow.db"
+	walFile := "test_overflow.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	// 10000 bytes take 3 overflow pages
+	const size = 10000
+	const chain = 3
+
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		// Large and small values side by side
+		for i := 1; i <= 50; i++ {
+			value := largeValue(i, size)
+			if i%5 == 0 {
+				value = fmt.Sprintf("small-%d", i)
+			}
+			if err := tree.Insert(k(uint32(i)), value); err != nil {
+				t.Fatalf("Failed to insert key %d: %v", i, err)
+			}
+		}
+
+		value, found, err := tree.Search(k(7))
+		if err != nil || !found || value != largeValue(7, size) {
+			t.Fatalf("Search(7) returned %d bytes (found=%v, err=%v)", len(value), found, err)
+		}
+		rows, err := tree.Scan(nil, nil, 0)
+		if err != nil || len(rows) != 50 {
+			t.Fatalf("Scan returned %d rows (err=%v), expected 50", len(rows), err)
+		}
+		for i, row := range rows {
+			if i%5 != 4 && row.Value != largeValue(i+1, size) {
+				t.Errorf("Scan key %d: %d bytes, expected %d", i+1, len(row.Value), size)
+			}
+		}
+		t.Log("✓ Large values reassembled by Search and Scan")
+
+		// Replacing or deleting a large value frees its chain
+		free := pager.FreeListSize()
+		if err := tree.Update(k(1), "now small"); err != nil {
+			t.Fatalf("Failed to update: %v", err)
+		}
+		if got := pager.FreeListSize() - free; got != chain {
+			t.Errorf("Update freed %d pages, expected %d", got, chain)
+		}
+		if _, err := tree.Delete(k(2)); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+		if got := pager.FreeListSize() - free; got != 2*chain {
+			t.Errorf("Update and delete freed %d pages, expected %d", got, 2*chain)
+		}
+		t.Log("✓ Chains freed on update and delete")
+
+		// A snapshot keeps the chain of the value it sees until released
+		free = pager.FreeListSize()
+		snapshot := tree.Snapshot()
+		if err := tree.Upsert(k(3), largeValue(100, size)); err != nil {
+			t.Fatalf("Failed to upsert: %v", err)
+		}
+		if value, _, _ := snapshot.Search(k(3)); value != largeValue(3, size) {
+			t.Errorf("Snapshot sees %d bytes of the new value", len(value))
+		}
+		if value, _, _ := tree.Search(k(3)); value != largeValue(100, size) {
+			t.Errorf("Tree sees %d bytes of the old value", len(value))
+		}
+		if err := snapshot.Release(); err != nil {
+			t.Fatalf("Failed to release snapshot: %v", err)
+		}
+		if got := pager.FreeListSize(); got != free {
+			t.Errorf("Free list has %d pages after collection, expected %d", got, free)
+		}
+		t.Log("✓ Snapshot reads its large value until released")
+
+		// Crash without closing, replay writes the chains again
+		pager.Close()
+	}
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+	rootPageID, order, err := LoadMetadata(pager, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	defer tree.Close()
+
+	if value, _, err := tree.Search(k(3)); err != nil || value != largeValue(100, size) {
+		t.Errorf("Key 3 recovered with %d bytes (err=%v)", len(value), err)
+	}
+	if value, _, err := tree.Search(k(49)); err != nil || value != largeValue(49, size) {
+		t.Errorf("Key 49 recovered with %d bytes (err=%v)", len(value), err)
+	}
+	if _, found, _ := tree.Search(k(2)); found {
+		t.Error("Deleted key 2 recovered")
+	}
+	t.Log("✓ Large values recovered after replay")
+
+	// Dropping a table frees the chains of its values
+	docs, err := tree.CreateTable("docs")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for i := 1; i <= 10; i++ {
+		if err := docs.Insert(k(uint32(i)), largeValue(i, size)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	free := pager.FreeListSize()
+	if err := tree.DropTable("docs"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+	if got := pager.FreeListSize() - free; got < 10*chain {
+		t.Errorf("Dropping the table freed %d pages, expected at least %d", got, 10*chain)
+	}
+	t.Log("✓ Chains freed with their table")
+}
@@ -0,0 +1,239 @@
+package bptree
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// Point-in-time recovery
+//
+// RestoreToPoint brings a database back to an earlier point: it restores a
+// base backup (see backup.go) into temporary files, follows its WAL with the
+// later entries of the WAL archive (see wal/archive.go) and of the current
+// WAL, cuts them at the target and replays them like crash recovery. A
+// transaction that had not committed by the target is discarded
+//
+// Row writes and catalog changes are in the log, a bulk load only leaves a
+// marker. A restore whose target is past a bulk load after the backup needs
+// a backup taken after it, one stopping before the marker does not
+//
+// The entries after the target are a history the restored database no
+// longer has, and it logs new entries under the same LSNs: they are moved
+// out of the archive into an abandoned-*.wal file next to the segments
+
+// ErrUnrecoverable is returned when the backup and the log cannot reach the
+// recovery target
+var ErrUnrecoverable = errors.New("cannot recover to target")
+
+// RecoveryTarget is where a point-in-time restore stops
+type RecoveryTarget struct {
+	LSN  uint64    // last entry to replay, 0 for no limit
+	Time time.Time // replay the entries appended before this time, zero for no limit
+}
+
+// RecoveryInfo describes a point-in-time restore
+type RecoveryInfo struct {
+	BaseLSN   uint64        // last entry of the base backup
+	LSN       uint64        // last entry replayed, the database is as of this entry
+	Time      time.Time     // time of that entry, zero if unknown
+	Entries   int           // entries replayed after the base backup
+	Segments  int           // archived segments read
+	Abandoned int           // entries after the target moved out of the archive
+	Duration  time.Duration // time spent, replay included
+}
+
+// RestoreToPoint replaces the database file dbPath and its WAL walPath with
+// the base backup read from r brought forward to target, which must not be
+// before the backup. Entries come from the segments in archiveDir ("" for
+// none) and from walPath. The database must be closed
+// Nothing is replaced unless the log reaches the target without a gap
+func RestoreToPoint(r io.Reader, archiveDir string, target RecoveryTarget, dbPath, walPath string) (RecoveryInfo, error) {
+	start := time.Now()
+	tmpDB, tmpWAL := dbPath+".restore", walPath+".restore"
+
+	info, unarchived, abandoned, err := restoreToPoint(r, archiveDir, target, tmpDB, tmpWAL, walPath)
+	if err == nil {
+		err = abandonHistory(archiveDir, walPath, info.LSN, abandoned)
+	}
+	if err == nil && archiveDir != "" && len(unarchived) > 0 {
+		// The current WAL is replaced, the archive keeps its replayed entries
+		err = wal.WriteSegment(archiveDir, unarchived)
+	}
+	if err != nil {
+		os.Remove(tmpDB)
+		os.Remove(tmpWAL)
+		return RecoveryInfo{}, err
+	}
+
+	if err := replaceFiles(tmpDB, tmpWAL, dbPath, walPath); err != nil {
+		return RecoveryInfo{}, err
+	}
+
+	info.Abandoned = len(abandoned)
+	info.Duration = time.Since(start)
+	return info, nil
+}
+
+// restoreToPoint restores the backup into dbPath and walPath and replays
+// the log up to target on them
+// Returns the replayed entries that were not archived and the entries after the target
+func restoreToPoint(r io.Reader, archiveDir string, target RecoveryTarget, dbPath, walPath, livePath string) (RecoveryInfo, []*wal.Entry, []*wal.Entry, error) {
+	base, err := restoreFiles(bufio.NewReader(r), dbPath, walPath)
+	if err == nil {
+		err = checkRestored(dbPath, walPath, base)
+	}
+	if err != nil {
+		return RecoveryInfo{}, nil, nil, err
+	}
+	if target.LSN != 0 && target.LSN < base.LSN {
+		return RecoveryInfo{}, nil, nil, fmt.Errorf("%w: the backup is at LSN %d, after LSN %d", ErrUnrecoverable, base.LSN, target.LSN)
+	}
+
+	log, err := wal.ReadFile(walPath)
+	if err != nil {
+		return RecoveryInfo{}, nil, nil, err
+	}
+	if len(log) > 0 && reached(log[len(log)-1], RecoveryTarget{Time: target.Time}) {
+		return RecoveryInfo{}, nil, nil, fmt.Errorf("%w: the backup was taken after %s", ErrUnrecoverable, target.Time.Format(time.DateTime))
+	}
+
+	later, archived, segments, err := laterEntries(archiveDir, livePath, base.LSN)
+	if err != nil {
+		return RecoveryInfo{}, nil, nil, err
+	}
+
+	info := RecoveryInfo{BaseLSN: base.LSN, LSN: base.LSN, Segments: segments}
+	if len(log) > 0 {
+		info.Time = entryTime(log[len(log)-1])
+	}
+
+	end := 0
+	for ; end < len(later) && !reached(later[end], target); end++ {
+		entry := later[end]
+		switch entry.OpType {
+		case wal.OpBulkLoad:
+			return RecoveryInfo{}, nil, nil, fmt.Errorf("%w: bulk load of table %d at LSN %d is not in the log, restore a backup taken after it",
+				ErrUnrecoverable, entry.Table, entry.LSN)
+		case wal.OpCatalog:
+			if _, err := entry.CatalogChange(); err != nil {
+				return RecoveryInfo{}, nil, nil, fmt.Errorf("%w: catalog change at LSN %d cannot be replayed (%v), restore a backup taken after it",
+					ErrUnrecoverable, entry.LSN, err)
+			}
+		}
+		info.LSN, info.Time = entry.LSN, entryTime(entry)
+	}
+	if target.LSN > info.LSN {
+		return RecoveryInfo{}, nil, nil, fmt.Errorf("%w: the log ends at LSN %d, before LSN %d", ErrUnrecoverable, info.LSN, target.LSN)
+	}
+	info.Entries = end
+
+	if err := wal.WriteFile(walPath, append(log, later[:end]...)); err != nil {
+		return RecoveryInfo{}, nil, nil, err
+	}
+	if err := replayRestored(dbPath, walPath); err != nil {
+		return RecoveryInfo{}, nil, nil, err
+	}
+
+	return info, later[min(archived, end):end], later[end:], nil
+}
+
+// laterEntries returns the entries after lsn from the archive and then the
+// WAL at livePath, how many of them are archived and the number of segments read
+// Returns ErrUnrecoverable if entries are missing in between
+func laterEntries(archiveDir, livePath string, lsn uint64) ([]*wal.Entry, int, int, error) {
+	var archived []*wal.Entry
+	segments := 0
+	if archiveDir != "" {
+		var err error
+		if archived, segments, err = wal.ReadArchive(archiveDir, lsn); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	live, err := wal.ReadFile(livePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, 0, err
+	}
+
+	later := make([]*wal.Entry, 0, len(archived)+len(live))
+	last := lsn
+	for _, entry := range append(archived, live...) {
+		if entry.LSN <= last {
+			continue
+		}
+		if entry.LSN != last+1 {
+			return nil, 0, 0, fmt.Errorf("%w: entries %d to %d are missing from the archive", ErrUnrecoverable, last+1, entry.LSN-1)
+		}
+		later = append(later, entry)
+		last = entry.LSN
+	}
+	return later, len(archived), segments, nil
+}
+
+// reached reports whether entry is past target
+// Entries upgraded from a legacy WAL have no time and are before any
+func reached(entry *wal.Entry, target RecoveryTarget) bool {
+	if target.LSN != 0 && entry.LSN > target.LSN {
+		return true
+	}
+	return !target.Time.IsZero() && entry.Time != 0 && !entryTime(entry).Before(target.Time)
+}
+
+// entryTime returns the time an entry was appended, zero if unknown
+func entryTime(entry *wal.Entry) time.Time {
+	if entry.Time == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, entry.Time)
+}
+
+// replayRestored opens a restored database, which replays its WAL and
+// checkpoints, and closes it
+func replayRestored(dbPath, walPath string) error {
+	pager, err := storage.NewFilePager(dbPath)
+	if err != nil {
+		return err
+	}
+	defer pager.Close()
+
+	rootPageID, order, err := LoadMetadata(pager, walPath)
+	if err != nil {
+		return err
+	}
+	tree, err := LoadBPTree(pager, rootPageID, order, walPath)
+	if err != nil {
+		return fmt.Errorf("failed to replay: %w", err)
+	}
+	return tree.Close()
+}
+
+// abandonHistory saves the entries after lsn to an abandoned-*.wal file in
+// archiveDir (next to walPath without one) and drops them from the archive
+func abandonHistory(archiveDir, walPath string, lsn uint64, entries []*wal.Entry) error {
+	if len(entries) > 0 {
+		dir := archiveDir
+		if dir == "" {
+			dir = filepath.Dir(walPath)
+		}
+		name := fmt.Sprintf("abandoned-%d-%d-%d.wal", entries[0].LSN, entries[len(entries)-1].LSN, time.Now().Unix())
+		if err := wal.WriteFile(filepath.Join(dir, name), entries); err != nil {
+			return fmt.Errorf("failed to save abandoned entries: %w", err)
+		}
+	}
+
+	if archiveDir == "" {
+		return nil
+	}
+	if _, err := wal.TrimArchive(archiveDir, lsn); err != nil {
+		return fmt.Errorf("failed to trim WAL archive: %w", err)
+	}
+	return nil
+}
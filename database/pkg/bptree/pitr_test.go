@@ -0,0 +1,220 @@
+package bptree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+// checkRows verifies that tree holds keys 1..n with their inserted values
+func checkRows(t *testing.T, tree *BPTree, n int) {
+	t.Helper()
+
+	rows, err := tree.Scan(nil, nil, 0)
+	if err != nil || len(rows) != n {
+		t.Fatalf("Tree has %d rows (err=%v), expected %d", len(rows), err, n)
+	}
+	for i, row := range rows {
+		if expected := fmt.Sprintf("value-%d", i+1); num(row.Key) != uint32(i+1) || row.Value != expected {
+			t.Fatalf("Row %d is %d=%q, expected %q", i, num(row.Key), row.Value, expected)
+		}
+	}
+}
+
+func TestBPTreePointInTimeRestore(t *testing.T) {
+	dbFile := "test_pitr.db"
+	walFile := "test_pitr.wal"
+	archive := walFile + wal.ArchiveSuffix
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+	defer os.RemoveAll(archive)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	bufferPool := storage.NewBufferPool(pager, 64)
+
+	tree, err := NewBPTree(bufferPool, 100, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	if err := tree.EnableWALArchive(); err != nil {
+		t.Fatalf("Failed to enable archive: %v", err)
+	}
+
+	insert := func(from, to uint32) {
+		for i := from; i <= to; i++ {
+			if err := tree.Insert(k(i), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+		}
+	}
+
+	insert(1, 1000)
+	var backup bytes.Buffer
+	if _, err := tree.Backup(&backup); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	// Archived between the backup and the bad batch
+	insert(1001, 2000)
+	if _, err := tree.Checkpoint(); err != nil {
+		t.Fatalf("Failed to checkpoint: %v", err)
+	}
+	goodLSN := tree.wal.LastLSN()
+	badTime := time.Now()
+
+	// The bad batch job, partly archived, partly in the WAL only
+	for i := uint32(1); i <= 500; i++ {
+		if err := tree.Upsert(k(i), "bad"); err != nil {
+			t.Fatalf("Failed to upsert: %v", err)
+		}
+	}
+	if _, err := tree.Checkpoint(); err != nil {
+		t.Fatalf("Failed to checkpoint: %v", err)
+	}
+	for i := uint32(501); i <= 1000; i++ {
+		if _, err := tree.Delete(k(i)); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+	}
+	tree.Close()
+	bufferPool.Close()
+
+	if _, err := RestoreToPoint(bytes.NewReader(backup.Bytes()), archive, RecoveryTarget{LSN: 10}, dbFile, walFile); !errors.Is(err, ErrUnrecoverable) {
+		t.Errorf("Target before the backup: expected ErrUnrecoverable, got %v", err)
+	}
+
+	info, err := RestoreToPoint(bytes.NewReader(backup.Bytes()), archive, RecoveryTarget{Time: badTime}, dbFile, walFile)
+	if err != nil {
+		t.Fatalf("Failed to restore to %v: %v", badTime, err)
+	}
+	if info.LSN != goodLSN || info.Entries != 1000 || info.Abandoned != 1000 || info.Time.After(badTime) {
+		t.Fatalf("Restore info %+v, expected LSN %d", info, goodLSN)
+	}
+	t.Logf("✓ Restored to LSN %d (%d entries after the backup, %d abandoned) in %v",
+		info.LSN, info.Entries, info.Abandoned, info.Duration)
+
+	tree, pager = openRestored(t, dbFile, walFile)
+	checkRows(t, tree, 2000)
+
+	// The restored file logs after the target, its archive follows on
+	insert(2001, 2100)
+	if _, err := tree.Checkpoint(); err != nil {
+		t.Fatalf("Failed to checkpoint: %v", err)
+	}
+	entries, _, err := wal.ReadArchive(archive, 0)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].LSN != entries[i-1].LSN+1 || entries[i].Value == "bad" {
+			t.Fatalf("Archive has %s at LSN %d after LSN %d", entries[i].Value, entries[i].LSN, entries[i-1].LSN)
+		}
+	}
+	if abandoned, _ := filepath.Glob(filepath.Join(archive, "abandoned-*.wal")); len(abandoned) != 1 {
+		t.Errorf("Archive has %d abandoned files, expected 1", len(abandoned))
+	}
+
+	tree.Close()
+	pager.Close()
+
+	// Restoring by LSN, the newer history is abandoned in turn
+	info, err = RestoreToPoint(bytes.NewReader(backup.Bytes()), archive, RecoveryTarget{LSN: goodLSN - 500}, dbFile, walFile)
+	if err != nil {
+		t.Fatalf("Failed to restore to LSN %d: %v", goodLSN-500, err)
+	}
+	tree, pager = openRestored(t, dbFile, walFile)
+	checkRows(t, tree, 1500)
+	t.Logf("✓ Restored to LSN %d", info.LSN)
+
+	// Catalog changes after the backup are replayed
+	later, err := tree.CreateTableWithSchema("later", []byte("id INT"))
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	for i := uint32(1); i <= 50; i++ {
+		if err := later.Insert(k(i), fmt.Sprintf("later-%d", i%5)); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	if err := later.CreateIndex("later_values"); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	if err := later.Insert(k(51), "later-0"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	dropped, err := tree.CreateTable("dropped")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if err := dropped.Insert(k(1), "gone"); err != nil {
+		t.Fatalf("Failed to insert: %v", err)
+	}
+	if err := tree.DropTable("dropped"); err != nil {
+		t.Fatalf("Failed to drop table: %v", err)
+	}
+	beforeLoad := tree.wal.LastLSN()
+
+	// A bulk load is not in the log
+	loaded, err := tree.CreateTable("loaded")
+	if err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+	if _, err := loaded.BulkLoad(SliceSource(sortedRows(100)), BulkLoadOptions{}); err != nil {
+		t.Fatalf("Failed to bulk load: %v", err)
+	}
+	tree.Close()
+	pager.Close()
+	if _, err := RestoreToPoint(bytes.NewReader(backup.Bytes()), archive, RecoveryTarget{}, dbFile, walFile); !errors.Is(err, ErrUnrecoverable) {
+		t.Errorf("Restore past a bulk load: expected ErrUnrecoverable, got %v", err)
+	}
+
+	// Stopping before its marker works
+	if _, err := RestoreToPoint(bytes.NewReader(backup.Bytes()), archive, RecoveryTarget{LSN: beforeLoad}, dbFile, walFile); err != nil {
+		t.Fatalf("Failed to restore to LSN %d before the bulk load: %v", beforeLoad, err)
+	}
+	tree, pager = openRestored(t, dbFile, walFile)
+	checkRows(t, tree, 1500)
+	if tables := tree.Tables(); !slices.Equal(tables, []string{"later"}) {
+		t.Fatalf("Restored tables %v, expected [later]", tables)
+	}
+	later, _ = tree.Table("later")
+	if string(later.Schema()) != "id INT" {
+		t.Errorf("Restored schema %q, expected %q", later.Schema(), "id INT")
+	}
+	if rows, err := later.Scan(nil, nil, 0); err != nil || len(rows) != 51 {
+		t.Errorf("Restored table has %d rows (err=%v), expected 51", len(rows), err)
+	}
+	if rows, err := later.SearchValue("later-0", 0); err != nil || len(rows) != 11 {
+		t.Errorf("Restored index finds %d rows (err=%v), expected 11", len(rows), err)
+	}
+	if indexes := later.Indexes(); !slices.Equal(indexes, []string{"later_values"}) {
+		t.Errorf("Restored indexes %v, expected [later_values]", indexes)
+	}
+	tree.Close()
+	pager.Close()
+	t.Logf("✓ Restored CREATE TABLE, CREATE INDEX and DROP TABLE up to LSN %d", beforeLoad)
+
+	// A missing segment is a gap
+	segments, _ := wal.ListSegments(archive)
+	os.Remove(segments[0].Path)
+	if _, err := RestoreToPoint(bytes.NewReader(backup.Bytes()), archive, RecoveryTarget{LSN: goodLSN}, dbFile, walFile); !errors.Is(err, ErrUnrecoverable) {
+		t.Errorf("Missing segment: expected ErrUnrecoverable, got %v", err)
+	}
+	tree, pager = openRestored(t, dbFile, walFile)
+	defer pager.Close()
+	defer tree.Close()
+	checkRows(t, tree, 1500)
+	t.Log("✓ Unrecoverable targets leave the files as they were")
+}
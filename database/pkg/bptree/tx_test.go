@@ -0,0 +1,198 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+func TestTxCommitAndRollback(t *testing.T) {
+	dbFile := "test_tx.db"
+	walFile := "test_tx.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	defer pager.Close()
+
+	tree, err := NewBPTree(pager, 4, walFile)
+	if err != nil {
+		t.Fatalf("Failed to create B+ Tree: %v", err)
+	}
+	defer tree.Close()
+
+	for i := 1; i <= 5; i++ {
+		tree.Insert(k(uint32(i)), "base")
+	}
+
+	// Committed transaction
+	tx := tree.Begin()
+	for i := 6; i <= 20; i++ {
+		if err := tx.Insert(k(uint32(i)), fmt.Sprintf("tx-%d", i)); err != nil {
+			t.Fatalf("Tx insert failed: %v", err)
+		}
+	}
+	if err := tx.Update(k(1), "updated"); err != nil {
+		t.Fatalf("Tx update failed: %v", err)
+	}
+	if found, err := tx.Delete(k(2)); err != nil || !found {
+		t.Fatalf("Tx delete: found=%v err=%v", found, err)
+	}
+	if err := tx.Insert(k(3), "dup"); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Expected ErrKeyExists inside tx, got %v", err)
+	}
+
+	// The transaction sees its writes, the tree does not yet
+	if value, found, _ := tx.Search(k(10)); !found || value != "tx-10" {
+		t.Errorf("Tx should see its own insert, got %q found=%v", value, found)
+	}
+	if _, found, _ := tx.Search(k(2)); found {
+		t.Error("Tx should see its own delete")
+	}
+	if _, found, _ := tree.Search(k(10)); found {
+		t.Error("Uncommitted insert visible outside the transaction")
+	}
+
+	rows, err := tx.Scan(k(1), k(8), 0)
+	if err != nil {
+		t.Fatalf("Tx scan failed: %v", err)
+	}
+	if len(rows) != 7 || rows[0].Value != "updated" || num(rows[1].Key) != 3 {
+		t.Errorf("Tx scan merged rows incorrectly: %v", rows)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := tx.Commit(); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Expected ErrTxDone on second commit, got %v", err)
+	}
+
+	if value, found, _ := tree.Search(k(1)); !found || value != "updated" {
+		t.Errorf("Committed update missing, got %q", value)
+	}
+	if _, found, _ := tree.Search(k(2)); found {
+		t.Error("Committed delete missing")
+	}
+	keys, _ := tree.InOrderTraversal()
+	if len(keys) != 19 {
+		t.Errorf("Expected 19 keys after commit, got %d", len(keys))
+	}
+	t.Logf("✓ Committed transaction %d applied", tx.ID())
+
+	// Rolled back transaction leaves no trace
+	tx = tree.Begin()
+	tx.Insert(k(100), "gone")
+	tx.Delete(k(1))
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if _, found, _ := tree.Search(k(100)); found {
+		t.Error("Rolled back insert is visible")
+	}
+	if _, found, _ := tree.Search(k(1)); !found {
+		t.Error("Rolled back delete was applied")
+	}
+	if err := tx.Insert(k(101), "late"); !errors.Is(err, ErrTxDone) {
+		t.Errorf("Expected ErrTxDone after rollback, got %v", err)
+	}
+	t.Logf("✓ Rolled back transaction discarded")
+}
+
+func TestTxRecovery(t *testing.T) {
+	dbFile := "test_tx_recovery.db"
+	walFile := "test_tx_recovery.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	// Phase 1: one committed, one aborted and one open transaction, then crash
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+
+		tree, err := NewBPTree(pager, 4, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		committed := tree.Begin()
+		for i := 1; i <= 10; i++ {
+			committed.Insert(k(uint32(i)), "committed")
+		}
+		if err := committed.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+
+		aborted := tree.Begin()
+		aborted.Insert(k(50), "aborted")
+		aborted.Rollback()
+
+		open := tree.Begin()
+		for i := 11; i <= 20; i++ {
+			open.Insert(k(uint32(i)), "open")
+		}
+
+		// A checkpoint must keep the open transaction's records
+		if _, err := tree.Checkpoint(); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+		entries, _ := tree.wal.ReadAll()
+		if len(entries) == 0 || entries[0].OpType != wal.OpBegin || entries[0].TxID != open.ID() {
+			t.Fatalf("Checkpoint dropped the open transaction's BEGIN (%d entries left)", len(entries))
+		}
+
+		open.Insert(k(21), "open")
+
+		// Don't commit or close - simulate crash
+		pager.Close()
+	}
+
+	// Phase 2: only the committed transaction survives
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		defer pager.Close()
+
+		rootPageID, order, err := LoadMetadata(pager, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load metadata: %v", err)
+		}
+
+		tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+		if err != nil {
+			t.Fatalf("Failed to load tree: %v", err)
+		}
+		defer tree.Close()
+
+		keys, _ := tree.InOrderTraversal()
+		if len(keys) != 10 {
+			t.Errorf("Expected 10 committed keys after recovery, got %d: %v", len(keys), keys)
+		}
+		if _, found, _ := tree.Search(k(15)); found {
+			t.Error("Uncommitted transaction survived recovery")
+		}
+		if _, found, _ := tree.Search(k(50)); found {
+			t.Error("Aborted transaction survived recovery")
+		}
+
+		// New transactions don't reuse IDs found in the log
+		if tx := tree.Begin(); tx.ID() <= 3 {
+			t.Errorf("Transaction ID %d reused after recovery", tx.ID())
+		}
+
+		t.Logf("✓ Recovery applied only the committed transaction")
+	}
+}
@@ -0,0 +1,101 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
+)
+
+var (
+	// ErrKeyExists is returned by Insert when the key is already present
+	ErrKeyExists = errors.New("key already exists")
+	// ErrKeyNotFound is returned by Update when the key is absent
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// Update replaces the value of an existing key
+// Returns ErrKeyNotFound if the key is absent
+func (tree *BPTree) Update(key []byte, value string) error {
+	return tree.upsert(key, value, true)
+}
+
+// Upsert inserts a key-value pair, replacing the value if the key exists
+func (tree *BPTree) Upsert(key []byte, value string) error {
+	return tree.upsert(key, value, false)
+}
+
+// upsert logs and applies an OpUpdate, which replay applies as an upsert
+// With mustExist an absent key is rejected before anything is logged
+func (tree *BPTree) upsert(key []byte, value string, mustExist bool) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	err := tree.writeKey(key, tree.versionSafe(key, value, false), func(path *writePath, leafPageID uint64, leafPage *storage.Page) error {
+		if mustExist {
+			if record, found := tree.leafPage(leafPage).SearchRecord(key); !found || record.Deleted {
+				return fmt.Errorf("%w: %s", ErrKeyNotFound, FormatKey(key))
+			}
+		}
+		if err := tree.checkIndexedKey(key); err != nil {
+			return err
+		}
+
+		walEntry := &wal.Entry{
+			OpType: wal.OpUpdate,
+			Table:  tree.id,
+			Key:    key,
+			Value:  value,
+		}
+
+		if err := tree.wal.Append(walEntry); err != nil {
+			return fmt.Errorf("failed to write WAL: %w", err)
+		}
+
+		_, err := tree.writeVersion(path, leafPageID, leafPage, key, value, false, walEntry.LSN)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	tree.maybeCheckpoint()
+	return nil
+}
+
+// upsertIntoLeaf inserts or replaces record in its latched leaf
+// Returns true if the key already existed
+func (tree *BPTree) upsertIntoLeaf(path *writePath, leafPageID uint64, leafPage *storage.Page, record *storage.Record) (bool, error) {
+	leaf := tree.leafPage(leafPage)
+	found, err := leaf.ReplaceRecord(record)
+	if !found {
+		return false, tree.insertIntoLeaf(path, leafPageID, leafPage, record)
+	}
+
+	if err == nil {
+		return true, writePageStruct(tree.pager, leafPageID, leafPage)
+	}
+
+	// New record does not fit in this leaf: drop the old record and
+	// insert again, splitting the leaf
+	leaf.DeleteRecord(record.Key)
+	return true, tree.insertIntoLeaf(path, leafPageID, leafPage, record)
+}
+
+// insertIntoLeaf inserts record into a known leaf, propagating any split upward
+func (tree *BPTree) insertIntoLeaf(path *writePath, leafPageID uint64, leafPage *storage.Page, record *storage.Record) error {
+	newChildKey, newChildPageID, err := tree.insertIntoLeafWithSplit(path, leafPageID, leafPage, record)
+	if err != nil {
+		return err
+	}
+
+	// If split occurred, insert promoted key into parent (creates a new root
+	// when the leaf was the root)
+	if newChildPageID != 0 {
+		return tree.insertIntoParent(path, leafPageID, newChildKey, newChildPageID)
+	}
+
+	return nil
+}
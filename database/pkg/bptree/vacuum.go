@@ -0,0 +1,347 @@
+package bptree
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+// VACUUM
+//
+// Freed pages go to the free list and are reused, but the file never shrinks
+// by itself. Vacuum compacts it: it walks every live page (the superblock,
+// the free list, the catalog, and the pages and overflow chains of every
+// table and index), moves the live pages past the first N, N being their
+// number, into the holes below N, rewrites every pointer to a moved page
+// (children, parents, leaf siblings, overflow chains and references, table
+// roots) and cuts the file to N pages. Pages leaked by a crash are reclaimed
+// as well, they are not reachable
+//
+// Vacuum checkpoints first, so the WAL (which names keys, not pages) holds
+// nothing the move affects, then holds every latch of the file: reads,
+// writes and DDL wait until it is done. The free list is emptied before a
+// hole is written and moved pages are written before the pages pointing to
+// them, but like the page flush of a checkpoint the rewrite is not atomic: a
+// crash before it ends can leave pages pointing to the old location of a
+// moved page
+
+// VacuumInfo describes a completed VACUUM
+type VacuumInfo struct {
+	PagesBefore    uint64        // pages in the file before
+	PagesAfter     uint64        // pages in the file after
+	PagesMoved     int           // live pages moved down the file
+	BytesReclaimed int64         // bytes cut from the file
+	Duration       time.Duration // time spent, checkpoint included
+}
+
+// vacuum is the state of one Vacuum
+type vacuum struct {
+	tree  *BPTree
+	live  map[uint64]bool   // every page reachable from the superblock
+	pages []uint64          // live tree and overflow pages, rewritten by the move
+	moves map[uint64]uint64 // old page ID -> new page ID
+	held  []uint64          // page latches held until the end
+}
+
+// Vacuum moves the live pages of the file to its front and truncates it
+// It can be called on any table of the file
+func (tree *BPTree) Vacuum() (VacuumInfo, error) {
+	main := tree.main
+	start := time.Now()
+
+	main.ddlMu.Lock()
+	defer main.ddlMu.Unlock()
+
+	if _, err := main.Checkpoint(); err != nil {
+		return VacuumInfo{}, fmt.Errorf("failed to checkpoint: %w", err)
+	}
+
+	main.checkpointMu.Lock()
+	defer main.checkpointMu.Unlock()
+	main.writeLatch.Lock()
+	defer main.writeLatch.Unlock()
+
+	// New readers wait at the roots, the walk waits out those already inside
+	tables := main.allTables()
+	for _, table := range tables {
+		table.rootLatch.Lock()
+		defer table.rootLatch.Unlock()
+	}
+
+	v := &vacuum{tree: main, live: make(map[uint64]bool), moves: make(map[uint64]uint64)}
+	defer v.release()
+
+	super := main.pager.Superblock()
+	v.live[storage.SuperblockPageID] = true
+	v.live[super.FreeListPage] = true
+	main.catalogMu.RLock()
+	if main.catalogPage != 0 {
+		v.live[main.catalogPage] = true
+	}
+	main.catalogMu.RUnlock()
+	for _, table := range tables {
+		if err := v.walk(table, table.rootPage); err != nil {
+			return VacuumInfo{}, err
+		}
+	}
+
+	info := VacuumInfo{PagesBefore: main.pager.NumPages(), PagesAfter: uint64(len(v.live))}
+	v.plan(info.PagesAfter)
+	info.PagesMoved = len(v.moves)
+	main.freed.Add(1)
+
+	if err := v.move(tables, super.FreeListPage, info.PagesAfter); err != nil {
+		return VacuumInfo{}, err
+	}
+
+	info.BytesReclaimed = int64(info.PagesBefore-info.PagesAfter) * int64(main.pager.PageSize())
+	info.Duration = time.Since(start)
+	return info, nil
+}
+
+// walk adds a page, everything below it and the overflow chains of its
+// records to the live pages, latching tree pages exclusively
+func (v *vacuum) walk(table *BPTree, pageID uint64) error {
+	if v.live[pageID] {
+		return fmt.Errorf("page %d is reached twice", pageID)
+	}
+
+	v.tree.latches.get(pageID).Lock()
+	v.held = append(v.held, pageID)
+	v.live[pageID] = true
+	v.pages = append(v.pages, pageID)
+
+	page, err := readPageStruct(v.tree.pager, pageID)
+	if err != nil {
+		return fmt.Errorf("failed to read page %d: %w", pageID, err)
+	}
+
+	if page.IsLeaf() {
+		records, err := table.leafPage(page).GetAllRecords()
+		if err != nil {
+			return fmt.Errorf("failed to get records from page %d: %w", pageID, err)
+		}
+		for _, record := range records {
+			for _, version := range record.Versions() {
+				if !version.Overflow {
+					continue
+				}
+				if err := v.walkChain(version.Value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if page.IsInternal() {
+		internal := table.internalPage(page)
+		for i := 0; i <= internal.NumKeys(); i++ {
+			childID, err := internal.GetChild(i)
+			if err != nil {
+				return err
+			}
+			if err := v.walk(table, childID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkChain adds the pages of the overflow chain a reference points to
+// Versions of a record may share a chain, it is walked once
+func (v *vacuum) walkChain(ref []byte) error {
+	_, pageID, err := storage.DecodeOverflowRef(ref)
+	if err != nil {
+		return err
+	}
+
+	for pageID != 0 && !v.live[pageID] {
+		page, err := readPageStruct(v.tree.pager, pageID)
+		if err != nil {
+			return fmt.Errorf("failed to read overflow page %d: %w", pageID, err)
+		}
+		if page.Header.PageType != storage.PageTypeOverflow {
+			return fmt.Errorf("page %d in overflow chain is a %s page", pageID, page.Header.PageType)
+		}
+
+		v.live[pageID] = true
+		v.pages = append(v.pages, pageID)
+		pageID = uint64(page.Header.NextPage)
+	}
+	return nil
+}
+
+// plan pairs the live pages from numPages on with the holes below it
+func (v *vacuum) plan(numPages uint64) {
+	var holes, moved []uint64
+	for pageID := uint64(1); pageID < numPages; pageID++ {
+		if !v.live[pageID] {
+			holes = append(holes, pageID)
+		}
+	}
+	for pageID := range v.live {
+		if pageID >= numPages {
+			moved = append(moved, pageID)
+		}
+	}
+	slices.Sort(moved)
+
+	for i, pageID := range moved {
+		v.moves[pageID] = holes[i]
+	}
+}
+
+// remap returns the page ID a page has after the move
+func (v *vacuum) remap(pageID uint64) uint64 {
+	if moved, ok := v.moves[pageID]; ok {
+		return moved
+	}
+	return pageID
+}
+
+// move writes the moved pages to their holes, updates the pages and roots
+// pointing to them and truncates the file to numPages
+func (v *vacuum) move(tables []*BPTree, freeListPage, numPages uint64) error {
+	tree := v.tree
+
+	// From here on the holes belong to the move
+	if err := tree.pager.ClearFreeList(); err != nil {
+		return fmt.Errorf("failed to clear free list: %w", err)
+	}
+
+	// 1. Moved pages, then 2. pages that stay but point to moved ones
+	for _, moved := range []bool{true, false} {
+		for _, pageID := range v.pages {
+			if _, ok := v.moves[pageID]; ok != moved {
+				continue
+			}
+
+			page, changed, err := v.relocate(pageID)
+			if err != nil {
+				return err
+			}
+			if !changed && !moved {
+				continue
+			}
+			if err := writePageStruct(tree.pager, v.remap(pageID), page); err != nil {
+				return fmt.Errorf("failed to write page %d: %w", v.remap(pageID), err)
+			}
+		}
+
+		if err := tree.pager.Flush(); err != nil {
+			return fmt.Errorf("failed to flush pages: %w", err)
+		}
+	}
+
+	// 3. Roots, catalog and superblock
+	tree.metaMu.Lock()
+	for _, table := range tables {
+		table.rootPage = v.remap(table.rootPage)
+	}
+	tree.metaMu.Unlock()
+
+	tree.catalogMu.Lock()
+	var err error
+	if tree.catalogPage != 0 {
+		tree.catalogPage = v.remap(tree.catalogPage)
+		err = tree.writeCatalog()
+	}
+	tree.catalogMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	if err := tree.pager.Flush(); err != nil {
+		return fmt.Errorf("failed to flush pages: %w", err)
+	}
+	if err := tree.SaveMetadata(); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	// 4. Nothing points past numPages any more
+	if err := tree.pager.Truncate(numPages, v.remap(freeListPage)); err != nil {
+		return fmt.Errorf("failed to truncate file: %w", err)
+	}
+	return nil
+}
+
+// relocate returns a page with every pointer to a moved page updated and
+// whether any was
+func (v *vacuum) relocate(pageID uint64) (*storage.Page, bool, error) {
+	tree := v.tree
+	page, err := readPageStruct(tree.pager, pageID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read page %d: %w", pageID, err)
+	}
+
+	changed := false
+	for _, field := range []*uint32{&page.Header.Parent, &page.Header.NextPage, &page.Header.PrevPage} {
+		if moved, ok := v.moves[uint64(*field)]; ok {
+			*field = uint32(moved)
+			changed = true
+		}
+	}
+
+	switch page.Header.PageType {
+	case storage.PageTypeInternal:
+		internal := tree.internalPage(page)
+		for i := 0; i <= internal.NumKeys(); i++ {
+			childID, err := internal.GetChild(i)
+			if err != nil {
+				return nil, false, err
+			}
+			if moved, ok := v.moves[childID]; ok {
+				if err := internal.SetChild(i, moved); err != nil {
+					return nil, false, err
+				}
+				changed = true
+			}
+		}
+
+	case storage.PageTypeLeaf:
+		leaf := tree.leafPage(page)
+		records, err := leaf.GetAllRecords()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get records from page %d: %w", pageID, err)
+		}
+		for _, record := range records {
+			versions := record.Versions()
+			relocated := false
+			for i, version := range versions {
+				if !version.Overflow {
+					continue
+				}
+				size, first, err := storage.DecodeOverflowRef(version.Value)
+				if err != nil {
+					return nil, false, err
+				}
+				if moved, ok := v.moves[first]; ok {
+					versions[i].Value = storage.EncodeOverflowRef(size, moved)
+					relocated = true
+				}
+			}
+			if !relocated {
+				continue
+			}
+
+			// A reference has the same size wherever it points, so the record fits
+			if _, err := leaf.ReplaceRecord(storage.NewVersionedRecord(record.Key, versions)); err != nil {
+				return nil, false, fmt.Errorf("failed to relocate record in page %d: %w", pageID, err)
+			}
+			changed = true
+		}
+	}
+
+	return page, changed, nil
+}
+
+// release releases the page latches taken by the walk
+func (v *vacuum) release() {
+	for _, pageID := range v.held {
+		v.tree.latches.get(pageID).Unlock()
+	}
+	v.held = nil
+}
@@ -0,0 +1,192 @@
+package bptree
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+)
+
+func TestBPTreeVacuum(t *testing.T) {
+	dbFile := "test_vacuum.db"
+	walFile := "test_vacuum.wal"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + storage.JournalSuffix)
+	defer os.Remove(walFile)
+
+	const size = 10000 // 3 overflow pages per large value
+
+	{
+		pager, err := storage.NewFilePager(dbFile)
+		if err != nil {
+			t.Fatalf("Failed to create pager: %v", err)
+		}
+		bufferPool := storage.NewBufferPool(pager, 64)
+
+		tree, err := NewBPTree(bufferPool, 100, walFile)
+		if err != nil {
+			t.Fatalf("Failed to create B+ Tree: %v", err)
+		}
+
+		users, err := tree.CreateTable("users")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+		if err := users.CreateIndex("users_value"); err != nil {
+			t.Fatalf("Failed to create index: %v", err)
+		}
+		scratch, err := tree.CreateTable("scratch")
+		if err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+
+		for i := 1; i <= 3000; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert: %v", err)
+			}
+			if err := scratch.Insert(k(uint32(i)), fmt.Sprintf("scratch-%d", i)); err != nil {
+				t.Fatalf("Failed to insert into scratch: %v", err)
+			}
+		}
+		for i := 1; i <= 100; i++ {
+			if err := users.Insert(k(uint32(i)), largeValue(i, size)); err != nil {
+				t.Fatalf("Failed to insert into users: %v", err)
+			}
+		}
+
+		// Free most of the file: delete from the front, drop a table
+		for i := 1; i <= 2900; i++ {
+			if _, err := tree.Delete(k(uint32(i))); err != nil {
+				t.Fatalf("Failed to delete: %v", err)
+			}
+		}
+		for i := 1; i <= 80; i++ {
+			if _, err := users.Delete(k(uint32(i))); err != nil {
+				t.Fatalf("Failed to delete from users: %v", err)
+			}
+		}
+		if err := tree.DropTable("scratch"); err != nil {
+			t.Fatalf("Failed to drop table: %v", err)
+		}
+
+		// Readers wait for the vacuum and see the same data after it
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if value, found, err := users.Search(k(90)); err != nil || !found || value != largeValue(90, size) {
+						t.Errorf("Concurrent search returned %d bytes (found=%v, err=%v)", len(value), found, err)
+						return
+					}
+				}
+			}()
+		}
+
+		info, err := tree.Vacuum()
+		close(stop)
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("Failed to vacuum: %v", err)
+		}
+
+		if info.PagesAfter >= info.PagesBefore || info.PagesMoved == 0 {
+			t.Fatalf("Vacuum went from %d to %d pages moving %d", info.PagesBefore, info.PagesAfter, info.PagesMoved)
+		}
+		if info.BytesReclaimed != int64(info.PagesBefore-info.PagesAfter)*storage.DefaultPageSize {
+			t.Errorf("Reported %d bytes reclaimed for %d pages", info.BytesReclaimed, info.PagesBefore-info.PagesAfter)
+		}
+		if stat, _ := os.Stat(dbFile); stat.Size() != int64(info.PagesAfter)*storage.DefaultPageSize {
+			t.Errorf("File has %d bytes after vacuum, expected %d", stat.Size(), int64(info.PagesAfter)*storage.DefaultPageSize)
+		}
+		if pager.FreeListSize() != 0 {
+			t.Errorf("Free list has %d pages after vacuum", pager.FreeListSize())
+		}
+		t.Logf("✓ Vacuum shrank the file from %d to %d pages, moving %d", info.PagesBefore, info.PagesAfter, info.PagesMoved)
+
+		verifyVacuumed(t, tree, size)
+
+		// The file keeps working: writes split and allocate again
+		for i := 3001; i <= 4000; i++ {
+			if err := tree.Insert(k(uint32(i)), fmt.Sprintf("value-%d", i)); err != nil {
+				t.Fatalf("Failed to insert after vacuum: %v", err)
+			}
+		}
+		if err := users.Upsert(k(200), largeValue(200, size)); err != nil {
+			t.Fatalf("Failed to insert large value after vacuum: %v", err)
+		}
+
+		// Vacuuming a compact file moves nothing
+		if info, err = tree.Vacuum(); err != nil || info.PagesMoved != 0 {
+			t.Errorf("Second vacuum moved %d pages (err=%v)", info.PagesMoved, err)
+		}
+
+		tree.Close()
+		bufferPool.Close()
+	}
+
+	pager, err := storage.NewFilePager(dbFile)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	defer pager.Close()
+	rootPageID, order, err := LoadMetadata(pager, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	tree, err := LoadBPTree(pager, rootPageID, order, walFile)
+	if err != nil {
+		t.Fatalf("Failed to load tree: %v", err)
+	}
+	defer tree.Close()
+
+	verifyVacuumed(t, tree, size)
+	if value, _, err := tree.Search(k(4000)); err != nil || value != "value-4000" {
+		t.Errorf("Key written after vacuum read back as %q (err=%v)", value, err)
+	}
+	t.Log("✓ Vacuumed file reopened")
+}
+
+// verifyVacuumed checks the data TestBPTreeVacuum keeps through the vacuum
+func verifyVacuumed(t *testing.T, tree *BPTree, size int) {
+	t.Helper()
+
+	for i := 2901; i <= 3000; i++ {
+		if value, found, err := tree.Search(k(uint32(i))); err != nil || !found || value != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("Key %d read back as %q (found=%v, err=%v)", i, value, found, err)
+		}
+	}
+	if rows, err := tree.ScanReverse(nil, k(3000), 0); err != nil || len(rows) < 100 {
+		t.Fatalf("Reverse scan returned %d rows (err=%v)", len(rows), err)
+	}
+
+	users, ok := tree.Table("users")
+	if !ok {
+		t.Fatal("Table users lost")
+	}
+	rows, err := users.Scan(nil, nil, 0)
+	if err != nil || len(rows) < 20 {
+		t.Fatalf("Scan of users returned %d rows (err=%v)", len(rows), err)
+	}
+	for _, row := range rows[:20] {
+		i := int(num(row.Key))
+		if row.Value != largeValue(i, size) {
+			t.Errorf("User %d read back with %d bytes", i, len(row.Value))
+		}
+	}
+	if matches, err := users.SearchValue(largeValue(95, size), 0); err != nil || len(matches) != 1 {
+		t.Errorf("Index lookup returned %d rows (err=%v)", len(matches), err)
+	}
+	if _, ok := tree.Table("scratch"); ok {
+		t.Error("Dropped table is back")
+	}
+}
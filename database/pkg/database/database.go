@@ -1,62 +1,287 @@
 package database
 
 import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
 	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+	"github.com/spaghetti-lover/sharingan-db/internal/lock"
+	"github.com/spaghetti-lover/sharingan-db/internal/sql"
 	"github.com/spaghetti-lover/sharingan-db/internal/storage"
+	"github.com/spaghetti-lover/sharingan-db/internal/wal"
 	"github.com/spaghetti-lover/sharingan-db/pkg/query"
 )
 
+// SyncMode controls when WAL writes are fsynced
+type SyncMode = wal.SyncMode
+
+const (
+	// SyncFull fsyncs before every write is acknowledged (default)
+	SyncFull = wal.SyncFull
+	// SyncNormal fsyncs on an interval and at checkpoints
+	SyncNormal = wal.SyncNormal
+	// SyncOff leaves flushing to the OS
+	SyncOff = wal.SyncOff
+)
+
+// Tx is a transaction started by Begin
+type Tx = bptree.Tx
+
+// Snapshot is a read-only view of the database opened by Snapshot
+type Snapshot = bptree.Snapshot
+
+// Session is a SQL session opened by Session
+type Session = sql.Executor
+
+// WriteBatch collects puts and deletes applied atomically by Write
+type WriteBatch = bptree.WriteBatch
+
+// NewWriteBatch creates an empty batch
+func NewWriteBatch() *WriteBatch {
+	return bptree.NewWriteBatch()
+}
+
+// KeyValue is a row returned by Scan, ScanReverse and SearchValue
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// Comparator orders keys: negative if a < b, zero if equal, positive if a > b
+type Comparator = storage.Comparator
+
+// Options configures how a database is opened
+type Options struct {
+	SyncMode     SyncMode      // FULL (default), NORMAL or OFF
+	SyncInterval time.Duration // fsync interval of NORMAL, 0 means wal.DefaultSyncInterval
+	LockTimeout  time.Duration // lock wait of SQL sessions, 0 means lock.DefaultTimeout
+	Comparator   Comparator    // key order, nil means bytewise; reopen with the same one
+	PageSize     int           // 4, 8, 16 or 32 KB for a new file, 0 means storage.DefaultPageSize; an existing file keeps its own
+	ArchiveWAL   bool          // keep WAL entries dropped by checkpoints in path.wal.archive for RestoreToPoint; stays on once set
+}
+
+// Database is safe for concurrent use by multiple goroutines
+// (a Tx, WriteBatch or Session is not, use one per goroutine)
 type Database struct {
 	tree       *bptree.BPTree
 	pager      storage.Pager
 	bufferPool *storage.BufferPool
+	locks      *lock.Manager // shared by all SQL sessions
 }
 
-// Open opens or creates a database
+// Open opens or creates a database with default options (SyncFull)
 func Open(path string) (*Database, error) {
-	pager, err := storage.NewFilePager(path + ".db")
+	return OpenWithOptions(path, Options{})
+}
+
+// OpenWithOptions opens or creates a database
+func OpenWithOptions(path string, opts Options) (_ *Database, err error) {
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = storage.DefaultPageSize
+	}
+
+	pager, err := storage.NewFilePagerWithPageSize(path+".db", pageSize)
 	if err != nil {
 		return nil, err
 	}
 
+	// On failure close whatever was opened; the buffer pool closes the pager
 	bufferPool := storage.NewBufferPool(pager, 128)
+	var tree *bptree.BPTree
+	defer func() {
+		if err == nil {
+			return
+		}
+		if tree != nil {
+			tree.Close()
+		}
+		bufferPool.Close()
+	}()
 
-	tree, err := bptree.NewBPTree(bufferPool, 100, path+".wal")
+	tree, err = openTree(bufferPool, path+".wal", opts.Comparator)
 	if err != nil {
-		bufferPool.Close()
-		pager.Close()
 		return nil, err
 	}
 
+	if err := tree.SetWALSyncMode(opts.SyncMode, opts.SyncInterval); err != nil {
+		return nil, err
+	}
+
+	if opts.ArchiveWAL {
+		if err := tree.EnableWALArchive(); err != nil {
+			return nil, err
+		}
+	}
+
+	lockTimeout := opts.LockTimeout
+	if lockTimeout == 0 {
+		lockTimeout = lock.DefaultTimeout
+	}
+
 	return &Database{
 		tree:       tree,
 		pager:      pager,
 		bufferPool: bufferPool,
+		locks:      lock.NewManager(lockTimeout),
 	}, nil
 }
 
+// openTree loads the tree of an existing file and replays its WAL, or
+// creates a new tree if the file has none yet
+func openTree(pager storage.Pager, walPath string, cmp Comparator) (*bptree.BPTree, error) {
+	rootPageID, order, err := bptree.LoadMetadata(pager, walPath)
+	if errors.Is(err, bptree.ErrNoMetadata) {
+		return bptree.NewBPTreeWithComparator(pager, 100, walPath, cmp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bptree.LoadBPTreeWithComparator(pager, rootPageID, order, walPath, cmp)
+}
+
 // Close closes the database
+// Writes since the last checkpoint stay in the WAL and are replayed by Open
 func (db *Database) Close() error {
-	if db.bufferPool != nil {
-		db.bufferPool.Close()
-	}
+	var err error
 	if db.tree != nil {
-		db.tree.Close()
+		err = db.tree.Close()
+	}
+	// The buffer pool flushes its pages and closes the pager
+	if db.bufferPool != nil {
+		if closeErr := db.bufferPool.Close(); err == nil {
+			err = closeErr
+		}
 	}
-	if db.pager != nil {
-		return db.pager.Close()
+	return err
+}
+
+// Put inserts a key-value pair, replacing the value if the key exists
+func (db *Database) Put(key, value []byte) error {
+	return db.tree.Upsert(key, string(value))
+}
+
+// Begin starts a transaction; its writes are applied atomically on Commit
+func (db *Database) Begin() *Tx {
+	return db.tree.Begin()
+}
+
+// Write applies a batch atomically with a single WAL record and fsync
+func (db *Database) Write(batch *WriteBatch) error {
+	return db.tree.Write(batch)
+}
+
+// Batch fills a new batch with fn and writes it if fn returns nil
+//
+//	err := db.Batch(func(b *database.WriteBatch) error {
+//		b.Put([]byte("user:1"), "a")
+//		b.Delete([]byte("user:2"))
+//		return nil
+//	})
+func (db *Database) Batch(fn func(batch *WriteBatch) error) error {
+	batch := NewWriteBatch()
+	if err := fn(batch); err != nil {
+		return err
 	}
-	return nil
+	return db.tree.Write(batch)
 }
 
-// Put inserts a key-value pair
-func (db *Database) Put(key uint32, value string) error {
-	return db.tree.Insert(key, value)
+// Update replaces the value of an existing key
+// Returns bptree.ErrKeyNotFound if the key is absent
+func (db *Database) Update(key, value []byte) error {
+	return db.tree.Update(key, string(value))
 }
 
 // Get retrieves a value by key
-func (db *Database) Get(key uint32) (string, bool, error) {
-	return db.tree.Search(key)
+func (db *Database) Get(key []byte) ([]byte, bool, error) {
+	value, found, err := db.tree.Search(key)
+	if !found || err != nil {
+		return nil, found, err
+	}
+	return []byte(value), true, nil
+}
+
+// Delete removes a key
+// Returns true if the key existed
+func (db *Database) Delete(key []byte) (bool, error) {
+	return db.tree.Delete(key)
+}
+
+// Scan returns key-value pairs with start <= key <= end in ascending order
+// A nil start or end leaves that side of the range open
+// limit <= 0 means no limit
+func (db *Database) Scan(start, end []byte, limit int) ([]KeyValue, error) {
+	return keyValues(db.tree.Scan(start, end, limit))
+}
+
+// ScanReverse returns key-value pairs with start <= key <= end in descending order
+// A nil start or end leaves that side of the range open
+// limit <= 0 means no limit
+func (db *Database) ScanReverse(start, end []byte, limit int) ([]KeyValue, error) {
+	return keyValues(db.tree.ScanReverse(start, end, limit))
+}
+
+// Snapshot opens a read-only view of the database as it is now
+// Reads on it do not see later writes and do not block them; Release it when done
+func (db *Database) Snapshot() *Snapshot {
+	return db.tree.Snapshot()
+}
+
+// CollectGarbage drops the old row versions no open snapshot can see
+func (db *Database) CollectGarbage() (bptree.GCStats, error) {
+	return db.tree.CollectGarbage()
+}
+
+// Checkpoint flushes dirty pages and truncates the WAL
+func (db *Database) Checkpoint() (bptree.CheckpointInfo, error) {
+	return db.tree.Checkpoint()
+}
+
+// BulkLoad fills the empty database from rows sorted by key, writing the
+// pages directly instead of logging every row
+func (db *Database) BulkLoad(source bptree.BulkSource, opts bptree.BulkLoadOptions) (bptree.BulkLoadInfo, error) {
+	return db.tree.BulkLoad(source, opts)
+}
+
+// Vacuum moves live pages to the front of the file and truncates it
+// Reads and writes wait until it is done
+func (db *Database) Vacuum() (bptree.VacuumInfo, error) {
+	return db.tree.Vacuum()
+}
+
+// Backup writes a consistent copy of the database to w while reads and
+// writes continue
+func (db *Database) Backup(w io.Writer) (bptree.BackupInfo, error) {
+	return db.tree.Backup(w)
+}
+
+// Restore replaces the files of the database at path with a backup read from
+// r, after checking it. The database must not be open
+func Restore(r io.Reader, path string) (bptree.BackupInfo, error) {
+	return bptree.Restore(r, path+".db", path+".wal")
+}
+
+// RestoreToPoint restores a backup of the database at path and replays its
+// WAL archive up to target. The database must not be open
+func RestoreToPoint(r io.Reader, path string, target bptree.RecoveryTarget) (bptree.RecoveryInfo, error) {
+	walPath := path + ".wal"
+	archiveDir := ""
+	if info, err := os.Stat(walPath + wal.ArchiveSuffix); err == nil && info.IsDir() {
+		archiveDir = walPath + wal.ArchiveSuffix
+	}
+	return bptree.RestoreToPoint(r, archiveDir, target, path+".db", walPath)
+}
+
+// SetSyncMode changes when WAL writes are fsynced
+func (db *Database) SetSyncMode(mode SyncMode, interval time.Duration) error {
+	return db.tree.SetWALSyncMode(mode, interval)
+}
+
+// Durability describes the sync mode and its worst-case loss window
+func (db *Database) Durability() string {
+	return db.tree.GetWALDurability()
 }
 
 // Query executes SQL query
@@ -64,11 +289,53 @@ func (db *Database) Query(sql string) (string, error) {
 	return query.ExecuteSQL(sql, db.tree)
 }
 
+// Session opens a SQL session whose transactions span Execute calls
+// Sessions are isolated from each other by key-level two-phase locking;
+// a session chosen as deadlock victim has its transaction rolled back
+func (db *Database) Session() *Session {
+	return sql.NewExecutorWithLocks(db.tree, db.locks)
+}
+
 // Keys returns all keys in sorted order
-func (db *Database) Keys() ([]uint32, error) {
+func (db *Database) Keys() ([][]byte, error) {
 	return db.tree.InOrderTraversal()
 }
 
+// Tables returns the names of the tables created with CREATE TABLE
+// The default table ("kv" in SQL, the one Put and Get use) is not listed
+func (db *Database) Tables() []string {
+	return db.tree.Tables()
+}
+
+// CreateIndex indexes the values of the default table under name,
+// so SearchValue finds them without a scan
+func (db *Database) CreateIndex(name string) error {
+	return db.tree.CreateIndex(name)
+}
+
+// DropIndex removes an index
+func (db *Database) DropIndex(name string) error {
+	return db.tree.DropIndex(name)
+}
+
+// SearchValue returns the key-value pairs whose value is value in key order
+// limit <= 0 means no limit
+func (db *Database) SearchValue(value []byte, limit int) ([]KeyValue, error) {
+	return keyValues(db.tree.SearchValue(string(value), limit))
+}
+
+// keyValues converts rows of the tree, which holds values as strings
+func keyValues(rows []bptree.KeyValue, err error) ([]KeyValue, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := make([]KeyValue, len(rows))
+	for i, row := range rows {
+		result[i] = KeyValue{Key: row.Key, Value: []byte(row.Value)}
+	}
+	return result, nil
+}
+
 // Stats returns database statistics
 func (db *Database) Stats() *Stats {
 	poolStats := db.bufferPool.GetStats()
@@ -80,6 +347,9 @@ func (db *Database) Stats() *Stats {
 		TreeOrder:      db.tree.GetOrder(),
 		CacheHitRate:   poolStats.HitRate,
 		BufferPoolSize: poolStats.Size,
+		Durability:     db.tree.GetWALDurability(),
+		Snapshots:      db.tree.SnapshotCount(),
+		Locks:          db.locks.Stats(),
 	}
 }
 
@@ -89,4 +359,7 @@ type Stats struct {
 	TreeOrder      int
 	CacheHitRate   float64
 	BufferPoolSize int
+	Durability     string
+	Snapshots      int // open snapshots
+	Locks          lock.Stats
 }
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spaghetti-lover/sharingan-db/internal/bptree"
+)
+
+// putKeys writes key-0 .. key-(n-1) with values value-i
+func putKeys(t *testing.T, db *Database, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+}
+
+// checkKeys checks that db holds exactly key-0 .. key-(n-1)
+func checkKeys(t *testing.T, db *Database, n int) {
+	t.Helper()
+	keys, err := db.Keys()
+	if err != nil {
+		t.Fatalf("Failed to list keys: %v", err)
+	}
+	if len(keys) != n {
+		t.Fatalf("Expected %d keys, got %d", n, len(keys))
+	}
+	for i := 0; i < n; i++ {
+		value, found, err := db.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if err != nil || !found || string(value) != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("Key %d: got %q, found=%v, err=%v", i, value, found, err)
+		}
+	}
+}
+
+func TestDatabaseReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	putKeys(t, db, 300)
+	if _, err := db.Checkpoint(); err != nil {
+		t.Fatalf("Failed to checkpoint: %v", err)
+	}
+	// Written after the checkpoint, replayed from the WAL
+	for i := 300; i < 500; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	checkKeys(t, db, 500)
+
+	t.Logf("✓ Reopened database has all 500 keys")
+}
+
+func TestDatabaseOpenFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	putKeys(t, db, 100)
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	// Fails after the tree is loaded, so the cleanup closes it
+	if _, err := OpenWithOptions(path, Options{SyncMode: SyncMode(99)}); err == nil {
+		t.Fatalf("Expected an error for an invalid sync mode")
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	checkKeys(t, db, 100)
+
+	t.Logf("✓ Failed open left the database intact")
+}
+
+func TestDatabaseBinaryValues(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	value := []byte{0x00, 0xff, 0x10, 0x00}
+	if err := db.Put([]byte("binary"), value); err != nil {
+		t.Fatalf("Failed to put: %v", err)
+	}
+	if got, found, err := db.Get([]byte("binary")); err != nil || !found || !bytes.Equal(got, value) {
+		t.Fatalf("Get: got %x, found=%v, err=%v", got, found, err)
+	}
+	if got, found, err := db.Get([]byte("missing")); err != nil || found || got != nil {
+		t.Fatalf("Get missing: got %x, found=%v, err=%v", got, found, err)
+	}
+
+	rows, err := db.Scan(nil, nil, 0)
+	if err != nil || len(rows) != 1 || !bytes.Equal(rows[0].Value, value) {
+		t.Fatalf("Scan: got %v, err=%v", rows, err)
+	}
+
+	t.Logf("✓ Binary value round-trips through Put, Get and Scan")
+}
+
+func TestDatabaseRestoreOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "source")
+	restored := filepath.Join(dir, "restored")
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	putKeys(t, db, 400)
+
+	var backup bytes.Buffer
+	if _, err := db.Backup(&backup); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	if _, err := Restore(&backup, restored); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	restoredDB, err := Open(restored)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restoredDB.Close()
+	checkKeys(t, restoredDB, 400)
+
+	t.Logf("✓ Restored database opens with all 400 keys")
+}
+
+func TestDatabaseRestoreToPointOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test")
+
+	db, err := OpenWithOptions(path, Options{ArchiveWAL: true})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	putKeys(t, db, 200)
+
+	var backup bytes.Buffer
+	if _, err := db.Backup(&backup); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+
+	// After the backup: more rows and an index, then the target
+	for i := 200; i < 300; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	if err := db.CreateIndex("values"); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	target := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 300; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte("bad")); err != nil {
+			t.Fatalf("Failed to put key %d: %v", i, err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	info, err := RestoreToPoint(&backup, path, bptree.RecoveryTarget{Time: target})
+	if err != nil {
+		t.Fatalf("Failed to restore to %v: %v", target, err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer db.Close()
+	checkKeys(t, db, 300)
+	if rows, err := db.SearchValue([]byte("value-250"), 0); err != nil || len(rows) != 1 {
+		t.Errorf("Restored index finds %d rows (err=%v), expected 1", len(rows), err)
+	}
+
+	t.Logf("✓ Restored to LSN %d and opened with all 300 keys", info.LSN)
+}
@@ -2,7 +2,9 @@ package sql
 
 import (
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 )
 
 // Statement represents a parsed SQL statement
@@ -11,26 +13,141 @@ type Statement interface {
 }
 
 // SelectStatement represents SELECT * FROM kv WHERE key = <value>
+// or a range query: WHERE key BETWEEN <a> AND <b> [ORDER BY key DESC] [LIMIT <n>]
+// or a lookup by value: WHERE value = '<string>' [LIMIT <n>]
+// Typed tables project columns (SELECT name, age) and filter on their primary key
 type SelectStatement struct {
-	Table string
-	Key   uint32
+	Table      string
+	Columns    []string // projected columns, nil for *
+	KeyColumn  string   // column of the WHERE predicates
+	Key        uint32
+	ByValue    bool   // WHERE <column> = '<string>'
+	Value      string // the string of a lookup by value
+	IsRange    bool   // true for BETWEEN / comparison predicates
+	Start      uint32 // inclusive lower bound (range only)
+	End        uint32 // inclusive upper bound (range only, Start > End = empty)
+	Descending bool   // ORDER BY key DESC
+	Limit      int    // 0 = no limit
 }
 
 func (s *SelectStatement) Type() string {
 	return "SELECT"
 }
 
-// InsertStatement represents INSERT INTO kv VALUES (<key>, '<value>')
+// InsertStatement represents INSERT INTO kv VALUES (<key>, '<value>') [ON CONFLICT DO UPDATE]
+// or INSERT INTO users [(<column>, ...)] VALUES (<value>, ...) for typed tables
 type InsertStatement struct {
-	Table string
-	Key   uint32
-	Value string
+	Table   string
+	Columns []string // column list, nil if omitted
+	Values  []Value
+	Key     uint32 // Values as a key-value pair, if they are one
+	Value   string
+	Upsert  bool // ON CONFLICT DO UPDATE: replace value if key exists
 }
 
 func (s *InsertStatement) Type() string {
 	return "INSERT"
 }
 
+// UpdateStatement represents UPDATE kv SET value = '<value>' WHERE key = <value>
+// or UPDATE users SET <column> = <value>, ... WHERE <primary key> = <value>
+type UpdateStatement struct {
+	Table       string
+	Assignments []Assignment
+	KeyColumn   string // column of the WHERE predicate
+	Key         uint32
+	Value       string // the value assigned to "value", if that is the only assignment
+}
+
+// Assignment is one <column> = <value> of UPDATE ... SET
+type Assignment struct {
+	Column string
+	Value  Value
+}
+
+func (s *UpdateStatement) Type() string {
+	return "UPDATE"
+}
+
+// DeleteStatement represents DELETE FROM kv WHERE key = <value>
+type DeleteStatement struct {
+	Table     string
+	KeyColumn string // column of the WHERE predicate
+	Key       uint32
+}
+
+func (s *DeleteStatement) Type() string {
+	return "DELETE"
+}
+
+// BeginStatement represents BEGIN [TRANSACTION]
+type BeginStatement struct{}
+
+func (s *BeginStatement) Type() string {
+	return "BEGIN"
+}
+
+// CommitStatement represents COMMIT [TRANSACTION]
+type CommitStatement struct{}
+
+func (s *CommitStatement) Type() string {
+	return "COMMIT"
+}
+
+// RollbackStatement represents ROLLBACK [TRANSACTION] (or ABORT)
+type RollbackStatement struct{}
+
+func (s *RollbackStatement) Type() string {
+	return "ROLLBACK"
+}
+
+// CreateTableStatement represents CREATE TABLE <name> [(<column> <type> [PRIMARY KEY] [NOT NULL], ...)]
+// Without columns the table stores key-value pairs like kv
+type CreateTableStatement struct {
+	Table   string
+	Columns []Column
+}
+
+func (s *CreateTableStatement) Type() string {
+	return "CREATE TABLE"
+}
+
+// DropTableStatement represents DROP TABLE <name>
+type DropTableStatement struct {
+	Table string
+}
+
+func (s *DropTableStatement) Type() string {
+	return "DROP TABLE"
+}
+
+// CreateIndexStatement represents CREATE INDEX <name> ON <table> (<column>)
+type CreateIndexStatement struct {
+	Index  string
+	Table  string
+	Column string
+}
+
+func (s *CreateIndexStatement) Type() string {
+	return "CREATE INDEX"
+}
+
+// DropIndexStatement represents DROP INDEX <name>
+type DropIndexStatement struct {
+	Index string
+}
+
+func (s *DropIndexStatement) Type() string {
+	return "DROP INDEX"
+}
+
+// VacuumStatement represents VACUUM
+type VacuumStatement struct{}
+
+func (s *VacuumStatement) Type() string {
+	return "VACUUM"
+}
+
 // Parser parses tokens into SQL statements
 type Parser struct {
 	tokens []Token
@@ -62,21 +179,262 @@ func (p *Parser) Parse() (Statement, error) {
 		return p.parseSelect()
 	case "INSERT":
 		return p.parseInsert()
+	case "UPDATE":
+		return p.parseUpdate()
+	case "DELETE":
+		return p.parseDelete()
+	case "BEGIN", "COMMIT", "ROLLBACK", "ABORT":
+		return p.parseTransactionControl()
+	case "CREATE", "DROP":
+		return p.parseTableDefinition()
+	case "VACUUM":
+		return p.parseVacuum()
 	default:
 		return nil, fmt.Errorf("unsupported statement: %s", token.Value)
 	}
 }
 
-// parseSelect parses: SELECT * FROM kv WHERE key = <number>
+// ParseAll parses a sequence of statements separated by semicolons
+// e.g. BEGIN; INSERT INTO kv VALUES (1, 'a'); COMMIT;
+func (p *Parser) ParseAll() ([]Statement, error) {
+	statements := make([]Statement, 0)
+
+	for p.current().Type != TokenEOF {
+		// Skip empty statements (";;")
+		if p.current().Type == TokenSemicolon {
+			p.advance()
+			continue
+		}
+
+		stmt, err := p.Parse()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, stmt)
+
+		// Statements consume their own semicolon, anything else is left over
+		if next := p.current(); next.Type != TokenEOF && p.previous().Type != TokenSemicolon {
+			return nil, fmt.Errorf("unexpected token after %s: %v", stmt.Type(), next)
+		}
+	}
+
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("empty statement")
+	}
+
+	return statements, nil
+}
+
+// parseTransactionControl parses: BEGIN | COMMIT | ROLLBACK | ABORT [TRANSACTION]
+func (p *Parser) parseTransactionControl() (Statement, error) {
+	keyword := p.current().Value
+	p.advance()
+
+	// Optional TRANSACTION
+	if token := p.current(); token.Type == TokenKeyword && token.Value == "TRANSACTION" {
+		p.advance()
+	}
+
+	// Optional semicolon
+	if p.current().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	switch keyword {
+	case "BEGIN":
+		return &BeginStatement{}, nil
+	case "COMMIT":
+		return &CommitStatement{}, nil
+	default:
+		return &RollbackStatement{}, nil
+	}
+}
+
+// parseVacuum parses: VACUUM
+func (p *Parser) parseVacuum() (Statement, error) {
+	p.advance()
+
+	// Optional semicolon
+	if p.current().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	return &VacuumStatement{}, nil
+}
+
+// parseTableDefinition parses: CREATE TABLE <name> [(<column definitions>)] | DROP TABLE <name>
+// and the index statements
+func (p *Parser) parseTableDefinition() (Statement, error) {
+	keyword := p.current().Value
+	p.advance()
+
+	if token := p.current(); token.Type == TokenKeyword && token.Value == "INDEX" {
+		return p.parseIndexDefinition(keyword)
+	}
+
+	// TABLE
+	if err := p.expect(TokenKeyword, "TABLE"); err != nil {
+		return nil, err
+	}
+
+	// table name
+	tableToken := p.current()
+	if tableToken.Type != TokenIdentifier {
+		return nil, fmt.Errorf("expected table name, got %v", tableToken)
+	}
+	tableName := tableToken.Value
+	p.advance()
+
+	var columns []Column
+	if keyword == "CREATE" && p.current().Type == TokenLeftParen {
+		var err error
+		if columns, err = p.parseColumnDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Optional semicolon
+	if p.current().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	if keyword == "CREATE" {
+		return &CreateTableStatement{Table: tableName, Columns: columns}, nil
+	}
+	return &DropTableStatement{Table: tableName}, nil
+}
+
+// parseIndexDefinition parses the rest of: CREATE INDEX <name> ON <table> (<column>) | DROP INDEX <name>
+func (p *Parser) parseIndexDefinition(keyword string) (Statement, error) {
+	// INDEX
+	if err := p.expect(TokenKeyword, "INDEX"); err != nil {
+		return nil, err
+	}
+
+	// index name
+	indexToken := p.current()
+	if indexToken.Type != TokenIdentifier {
+		return nil, fmt.Errorf("expected index name, got %v", indexToken)
+	}
+	p.advance()
+
+	var stmt Statement = &DropIndexStatement{Index: indexToken.Value}
+	if keyword == "CREATE" {
+		// ON
+		if err := p.expect(TokenKeyword, "ON"); err != nil {
+			return nil, err
+		}
+
+		// table name
+		tableToken := p.current()
+		if tableToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected table name, got %v", tableToken)
+		}
+		p.advance()
+
+		// (column)
+		if err := p.expect(TokenLeftParen, "("); err != nil {
+			return nil, err
+		}
+		columnToken := p.current()
+		if columnToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected column name, got %v", columnToken)
+		}
+		p.advance()
+		if err := p.expect(TokenRightParen, ")"); err != nil {
+			return nil, err
+		}
+
+		stmt = &CreateIndexStatement{Index: indexToken.Value, Table: tableToken.Value, Column: columnToken.Value}
+	}
+
+	// Optional semicolon
+	if p.current().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	return stmt, nil
+}
+
+// parseColumnDefinitions parses: (<column> <type> [PRIMARY KEY] [NOT NULL], ...)
+func (p *Parser) parseColumnDefinitions() ([]Column, error) {
+	// (
+	if err := p.expect(TokenLeftParen, "("); err != nil {
+		return nil, err
+	}
+
+	columns := make([]Column, 0)
+	for {
+		// column name
+		nameToken := p.current()
+		if nameToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected column name, got %v", nameToken)
+		}
+		p.advance()
+
+		// type
+		typeToken := p.current()
+		if typeToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected type of column %s, got %v", nameToken.Value, typeToken)
+		}
+		columnType, err := parseColumnType(typeToken.Value)
+		if err != nil {
+			return nil, err
+		}
+		p.advance()
+
+		column := Column{Name: nameToken.Value, Type: columnType}
+
+		// Constraints in any order
+		for p.current().Type == TokenKeyword {
+			switch p.current().Value {
+			case "PRIMARY":
+				p.advance()
+				if err := p.expectKeyIdentifier(); err != nil {
+					return nil, err
+				}
+				column.PrimaryKey = true
+			case "NOT":
+				p.advance()
+				if err := p.expect(TokenKeyword, "NULL"); err != nil {
+					return nil, err
+				}
+				column.NotNull = true
+			default:
+				return nil, fmt.Errorf("unexpected %s in column %s", p.current().Value, column.Name)
+			}
+		}
+		columns = append(columns, column)
+
+		// , or )
+		if p.current().Type != TokenComma {
+			break
+		}
+		p.advance()
+	}
+
+	if err := p.expect(TokenRightParen, ")"); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// parseSelect parses: SELECT * | <column>, ... FROM <table> WHERE <key column> = <number>
 func (p *Parser) parseSelect() (Statement, error) {
 	// SELECT
 	if err := p.expect(TokenKeyword, "SELECT"); err != nil {
 		return nil, err
 	}
 
-	// *
-	if err := p.expect(TokenStar, "*"); err != nil {
-		return nil, err
+	// * or column list
+	var columns []string
+	if p.current().Type == TokenStar {
+		p.advance()
+	} else {
+		var err error
+		if columns, err = p.parseIdentifierList("column name"); err != nil {
+			return nil, err
+		}
 	}
 
 	// FROM
@@ -92,45 +450,315 @@ func (p *Parser) parseSelect() (Statement, error) {
 	tableName := tableToken.Value
 	p.advance()
 
+	stmt := &SelectStatement{Table: tableName, Columns: columns}
+
+	// WHERE <predicates>
+	if err := p.parseWhereRange(stmt); err != nil {
+		return nil, err
+	}
+
+	// Optional ORDER BY <key column> [ASC|DESC]
+	if p.current().Type == TokenKeyword && p.current().Value == "ORDER" {
+		if stmt.ByValue {
+			return nil, fmt.Errorf("ORDER BY is not supported with a lookup by value")
+		}
+		descending, err := p.parseOrderBy(stmt.KeyColumn)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Descending = descending
+	}
+
+	// Optional LIMIT <number>
+	if p.current().Type == TokenKeyword && p.current().Value == "LIMIT" {
+		p.advance()
+
+		limitToken := p.current()
+		if limitToken.Type != TokenNumber {
+			return nil, fmt.Errorf("expected number for LIMIT, got %v", limitToken)
+		}
+
+		limit, err := strconv.Atoi(limitToken.Value)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit: %s", limitToken.Value)
+		}
+		stmt.Limit = limit
+		p.advance()
+	}
+
+	// Optional semicolon
+	if p.current().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	return stmt, nil
+}
+
+// parseIdentifierList parses: <identifier>, <identifier>, ...
+func (p *Parser) parseIdentifierList(what string) ([]string, error) {
+	names := make([]string, 0)
+	for {
+		token := p.current()
+		if token.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected %s, got %v", what, token)
+		}
+		names = append(names, token.Value)
+		p.advance()
+
+		if p.current().Type != TokenComma {
+			return names, nil
+		}
+		p.advance()
+	}
+}
+
+// parseOrderBy parses: ORDER BY <key column> [ASC|DESC]
+// Returns true for descending order
+func (p *Parser) parseOrderBy(keyColumn string) (bool, error) {
+	// ORDER
+	if err := p.expect(TokenKeyword, "ORDER"); err != nil {
+		return false, err
+	}
+
+	// BY
+	if err := p.expect(TokenKeyword, "BY"); err != nil {
+		return false, err
+	}
+
+	// Only the column of the WHERE clause (the key) is ordered
+	if err := p.expect(TokenIdentifier, keyColumn); err != nil {
+		return false, err
+	}
+
+	token := p.current()
+	if token.Type == TokenKeyword && (token.Value == "ASC" || token.Value == "DESC") {
+		p.advance()
+		return token.Value == "DESC", nil
+	}
+
+	return false, nil
+}
+
+// parseWhereRange parses the SELECT predicates on the key column:
+// WHERE key = <n> | key BETWEEN <a> AND <b> | key <op> <n> [AND key <op> <n> ...]
+// A single equality stays a point lookup; everything else becomes an inclusive range
+// An equality with a string is a lookup by value: WHERE value = '<string>'
+func (p *Parser) parseWhereRange(stmt *SelectStatement) error {
 	// WHERE
 	if err := p.expect(TokenKeyword, "WHERE"); err != nil {
+		return err
+	}
+
+	// <column> = '<string>'
+	if p.peek(1).Type == TokenOperator && p.peek(1).Value == "=" && p.peek(2).Type == TokenString {
+		columnToken := p.current()
+		if columnToken.Type != TokenIdentifier {
+			return fmt.Errorf("expected column name, got %v", columnToken)
+		}
+		stmt.KeyColumn = columnToken.Value
+		stmt.ByValue = true
+		stmt.Value = p.peek(2).Value
+		p.advance() // column
+		p.advance() // =
+		p.advance() // string
+		return nil
+	}
+
+	var start, end uint32 = 0, math.MaxUint32
+	empty := false
+	predicates := 0
+	equality := false
+
+	for {
+		// key column, the same in every predicate
+		columnToken := p.current()
+		if columnToken.Type != TokenIdentifier {
+			return fmt.Errorf("expected column name, got %v", columnToken)
+		}
+		if stmt.KeyColumn == "" {
+			stmt.KeyColumn = columnToken.Value
+		} else if columnToken.Value != stmt.KeyColumn {
+			return fmt.Errorf("predicates on %s and %s: only the key column can be filtered", stmt.KeyColumn, columnToken.Value)
+		}
+		p.advance()
+
+		token := p.current()
+		switch {
+		case token.Type == TokenKeyword && token.Value == "BETWEEN":
+			p.advance()
+			low, err := p.parseKeyNumber()
+			if err != nil {
+				return err
+			}
+			if err := p.expect(TokenKeyword, "AND"); err != nil {
+				return err
+			}
+			high, err := p.parseKeyNumber()
+			if err != nil {
+				return err
+			}
+			start = max(start, low)
+			end = min(end, high)
+
+		case token.Type == TokenOperator:
+			p.advance()
+			n, err := p.parseKeyNumber()
+			if err != nil {
+				return err
+			}
+
+			switch token.Value {
+			case "=":
+				start = max(start, n)
+				end = min(end, n)
+				equality = true
+			case ">=":
+				start = max(start, n)
+			case "<=":
+				end = min(end, n)
+			case ">":
+				if n == math.MaxUint32 {
+					empty = true
+				} else {
+					start = max(start, n+1)
+				}
+			case "<":
+				if n == 0 {
+					empty = true
+				} else {
+					end = min(end, n-1)
+				}
+			default:
+				return fmt.Errorf("unsupported operator: %s", token.Value)
+			}
+
+		default:
+			return fmt.Errorf("expected operator or BETWEEN, got %v", token)
+		}
+
+		predicates++
+
+		// AND key ...
+		if p.current().Type != TokenKeyword || p.current().Value != "AND" {
+			break
+		}
+		p.advance()
+	}
+
+	// Plain "key = n" keeps point lookup semantics
+	if predicates == 1 && equality {
+		stmt.Key = start
+		return nil
+	}
+
+	if empty {
+		start, end = 1, 0
+	}
+
+	stmt.IsRange = true
+	stmt.Start = start
+	stmt.End = end
+	return nil
+}
+
+// parseDelete parses: DELETE FROM kv WHERE key = <number>
+func (p *Parser) parseDelete() (Statement, error) {
+	// DELETE
+	if err := p.expect(TokenKeyword, "DELETE"); err != nil {
 		return nil, err
 	}
 
-	// key
-	if err := p.expect(TokenIdentifier, "key"); err != nil {
+	// FROM
+	if err := p.expect(TokenKeyword, "FROM"); err != nil {
+		return nil, err
+	}
+
+	// table name
+	tableToken := p.current()
+	if tableToken.Type != TokenIdentifier {
+		return nil, fmt.Errorf("expected table name, got %v", tableToken)
+	}
+	tableName := tableToken.Value
+	p.advance()
+
+	// WHERE key = <number>
+	keyColumn, key, err := p.parseWhereKey()
+	if err != nil {
 		return nil, err
 	}
 
+	// Optional semicolon
+	if p.current().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	return &DeleteStatement{
+		Table:     tableName,
+		KeyColumn: keyColumn,
+		Key:       key,
+	}, nil
+}
+
+// parseWhereKey parses: WHERE <key column> = <number>
+func (p *Parser) parseWhereKey() (string, uint32, error) {
+	// WHERE
+	if err := p.expect(TokenKeyword, "WHERE"); err != nil {
+		return "", 0, err
+	}
+
+	// key column
+	columnToken := p.current()
+	if columnToken.Type != TokenIdentifier {
+		return "", 0, fmt.Errorf("expected column name, got %v", columnToken)
+	}
+	p.advance()
+
 	// =
 	if err := p.expect(TokenOperator, "="); err != nil {
-		return nil, err
+		return "", 0, err
 	}
 
 	// number
+	key, err := p.parseKeyNumber()
+	return columnToken.Value, key, err
+}
+
+// parseKeyNumber parses a uint32 key literal
+func (p *Parser) parseKeyNumber() (uint32, error) {
 	keyToken := p.current()
 	if keyToken.Type != TokenNumber {
-		return nil, fmt.Errorf("expected number, got %v", keyToken)
+		return 0, fmt.Errorf("expected number, got %v", keyToken)
 	}
 
-	key, err := strconv.ParseUint(keyToken.Value, 10, 32)
+	key, err := parseKey(keyToken.Value)
 	if err != nil {
-		return nil, fmt.Errorf("invalid key: %v", err)
+		return 0, err
 	}
 	p.advance()
 
-	// Optional semicolon
-	if p.current().Type == TokenSemicolon {
-		p.advance()
+	return key, nil
+}
+
+// ParseKey parses a key literal the way statements do and returns the record
+// key the executor stores for it, for tools writing tables without SQL
+func ParseKey(literal string) ([]byte, error) {
+	key, err := parseKey(literal)
+	if err != nil {
+		return nil, err
 	}
+	return encodeKey(key), nil
+}
 
-	return &SelectStatement{
-		Table: tableName,
-		Key:   uint32(key),
-	}, nil
+// parseKey parses a key literal, 0 to 4294967295
+func parseKey(literal string) (uint32, error) {
+	key, err := strconv.ParseUint(literal, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid key: %v", err)
+	}
+	return uint32(key), nil
 }
 
-// parseInsert parses: INSERT INTO kv VALUES (<number>, '<string>')
+// parseInsert parses: INSERT INTO <table> [(<column>, ...)] VALUES (<value>, ...)
 func (p *Parser) parseInsert() (Statement, error) {
 	// INSERT
 	if err := p.expect(TokenKeyword, "INSERT"); err != nil {
@@ -150,6 +778,19 @@ func (p *Parser) parseInsert() (Statement, error) {
 	tableName := tableToken.Value
 	p.advance()
 
+	// Optional column list
+	var columns []string
+	if p.current().Type == TokenLeftParen {
+		p.advance()
+		var err error
+		if columns, err = p.parseIdentifierList("column name"); err != nil {
+			return nil, err
+		}
+		if err := p.expect(TokenRightParen, ")"); err != nil {
+			return nil, err
+		}
+	}
+
 	// VALUES
 	if err := p.expect(TokenKeyword, "VALUES"); err != nil {
 		return nil, err
@@ -160,33 +801,182 @@ func (p *Parser) parseInsert() (Statement, error) {
 		return nil, err
 	}
 
-	// key (number)
-	keyToken := p.current()
-	if keyToken.Type != TokenNumber {
-		return nil, fmt.Errorf("expected number for key, got %v", keyToken)
+	// values
+	values := make([]Value, 0)
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		if p.current().Type != TokenComma {
+			break
+		}
+		p.advance()
 	}
 
-	key, err := strconv.ParseUint(keyToken.Value, 10, 32)
-	if err != nil {
-		return nil, fmt.Errorf("invalid key: %v", err)
+	// )
+	if err := p.expect(TokenRightParen, ")"); err != nil {
+		return nil, err
+	}
+
+	if columns != nil && len(columns) != len(values) {
+		return nil, fmt.Errorf("%d columns but %d values", len(columns), len(values))
+	}
+
+	// Optional ON CONFLICT [(key)] DO UPDATE
+	upsert := false
+	if p.current().Type == TokenKeyword && p.current().Value == "ON" {
+		if err := p.parseOnConflict(); err != nil {
+			return nil, err
+		}
+		upsert = true
+	}
+
+	// Optional semicolon
+	if p.current().Type == TokenSemicolon {
+		p.advance()
+	}
+
+	stmt := &InsertStatement{
+		Table:   tableName,
+		Columns: columns,
+		Values:  values,
+		Upsert:  upsert,
+	}
+	if columns == nil {
+		stmt.Key, stmt.Value, _ = keyValuePair(values)
+	}
+	return stmt, nil
+}
+
+// parseValue parses a literal: <number> | '<string>' | TRUE | FALSE | NULL
+func (p *Parser) parseValue() (Value, error) {
+	token := p.current()
+
+	switch {
+	case token.Type == TokenNumber:
+		n, err := strconv.ParseInt(token.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number: %v", err)
+		}
+		p.advance()
+		return n, nil
+
+	case token.Type == TokenString:
+		p.advance()
+		return token.Value, nil
+
+	case token.Type == TokenKeyword && (token.Value == "TRUE" || token.Value == "FALSE"):
+		p.advance()
+		return token.Value == "TRUE", nil
+
+	case token.Type == TokenKeyword && token.Value == "NULL":
+		p.advance()
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("expected value, got %v", token)
+	}
+}
+
+// keyValuePair returns values as the key and value of a key-value table row
+func keyValuePair(values []Value) (uint32, string, bool) {
+	if len(values) != 2 {
+		return 0, "", false
+	}
+	key, ok := values[0].(int64)
+	if !ok || key < 0 || key > math.MaxUint32 {
+		return 0, "", false
+	}
+	value, ok := values[1].(string)
+	if !ok {
+		return 0, "", false
+	}
+	return uint32(key), value, true
+}
+
+// parseOnConflict parses: ON CONFLICT [(key)] DO UPDATE
+func (p *Parser) parseOnConflict() error {
+	// ON
+	if err := p.expect(TokenKeyword, "ON"); err != nil {
+		return err
+	}
+
+	// CONFLICT
+	if err := p.expect(TokenKeyword, "CONFLICT"); err != nil {
+		return err
+	}
+
+	// Optional conflict target: (<key column>)
+	if p.current().Type == TokenLeftParen {
+		p.advance()
+		if err := p.expect(TokenIdentifier, ""); err != nil {
+			return err
+		}
+		if err := p.expect(TokenRightParen, ")"); err != nil {
+			return err
+		}
 	}
-	p.advance()
 
-	// ,
-	if err := p.expect(TokenComma, ","); err != nil {
+	// DO
+	if err := p.expect(TokenKeyword, "DO"); err != nil {
+		return err
+	}
+
+	// UPDATE
+	return p.expect(TokenKeyword, "UPDATE")
+}
+
+// parseUpdate parses: UPDATE <table> SET <column> = <value>, ... WHERE <key column> = <number>
+func (p *Parser) parseUpdate() (Statement, error) {
+	// UPDATE
+	if err := p.expect(TokenKeyword, "UPDATE"); err != nil {
 		return nil, err
 	}
 
-	// value (string)
-	valueToken := p.current()
-	if valueToken.Type != TokenString {
-		return nil, fmt.Errorf("expected string for value, got %v", valueToken)
+	// table name
+	tableToken := p.current()
+	if tableToken.Type != TokenIdentifier {
+		return nil, fmt.Errorf("expected table name, got %v", tableToken)
 	}
-	value := valueToken.Value
+	tableName := tableToken.Value
 	p.advance()
 
-	// )
-	if err := p.expect(TokenRightParen, ")"); err != nil {
+	// SET
+	if err := p.expect(TokenKeyword, "SET"); err != nil {
+		return nil, err
+	}
+
+	// <column> = <value>, ...
+	assignments := make([]Assignment, 0)
+	for {
+		columnToken := p.current()
+		if columnToken.Type != TokenIdentifier {
+			return nil, fmt.Errorf("expected column name, got %v", columnToken)
+		}
+		p.advance()
+
+		if err := p.expect(TokenOperator, "="); err != nil {
+			return nil, err
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, Assignment{Column: columnToken.Value, Value: value})
+
+		if p.current().Type != TokenComma {
+			break
+		}
+		p.advance()
+	}
+
+	// WHERE key = <number>
+	keyColumn, key, err := p.parseWhereKey()
+	if err != nil {
 		return nil, err
 	}
 
@@ -195,11 +985,35 @@ func (p *Parser) parseInsert() (Statement, error) {
 		p.advance()
 	}
 
-	return &InsertStatement{
-		Table: tableName,
-		Key:   uint32(key),
-		Value: value,
-	}, nil
+	stmt := &UpdateStatement{
+		Table:       tableName,
+		Assignments: assignments,
+		KeyColumn:   keyColumn,
+		Key:         key,
+	}
+	stmt.Value, _ = valueAssignment(assignments)
+	return stmt, nil
+}
+
+// valueAssignment returns the string assigned by SET value = '<value>',
+// the only assignment of a key-value table update
+func valueAssignment(assignments []Assignment) (string, bool) {
+	if len(assignments) != 1 || assignments[0].Column != "value" {
+		return "", false
+	}
+	value, ok := assignments[0].Value.(string)
+	return value, ok
+}
+
+// expectKeyIdentifier consumes the KEY of PRIMARY KEY, which is an
+// identifier so that columns can still be named key
+func (p *Parser) expectKeyIdentifier() error {
+	token := p.current()
+	if token.Type != TokenIdentifier || !strings.EqualFold(token.Value, "key") {
+		return fmt.Errorf("expected KEY, got %v", token)
+	}
+	p.advance()
+	return nil
 }
 
 func (p *Parser) current() Token {
@@ -209,6 +1023,20 @@ func (p *Parser) current() Token {
 	return p.tokens[p.pos]
 }
 
+func (p *Parser) peek(offset int) Token {
+	if p.pos+offset >= len(p.tokens) {
+		return Token{Type: TokenEOF, Value: ""}
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *Parser) previous() Token {
+	if p.pos == 0 || p.pos > len(p.tokens) {
+		return Token{Type: TokenEOF, Value: ""}
+	}
+	return p.tokens[p.pos-1]
+}
+
 func (p *Parser) advance() {
 	p.pos++
 }
@@ -0,0 +1,315 @@
+package sql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColumnType is the type of a table column
+type ColumnType uint8
+
+const (
+	TypeInt  ColumnType = 1 // 64-bit signed integer
+	TypeText ColumnType = 2 // UTF-8 string
+	TypeBool ColumnType = 3 // true or false
+)
+
+func (ct ColumnType) String() string {
+	switch ct {
+	case TypeInt:
+		return "INT"
+	case TypeText:
+		return "TEXT"
+	case TypeBool:
+		return "BOOL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// parseColumnType maps a type name of CREATE TABLE to its ColumnType
+func parseColumnType(name string) (ColumnType, error) {
+	switch strings.ToUpper(name) {
+	case "INT", "INTEGER":
+		return TypeInt, nil
+	case "TEXT":
+		return TypeText, nil
+	case "BOOL", "BOOLEAN":
+		return TypeBool, nil
+	default:
+		return 0, fmt.Errorf("unknown column type: %s", name)
+	}
+}
+
+// Value is a literal or column value: nil (NULL), int64, string or bool
+type Value any
+
+// FormatValue formats a value the way SELECT prints it
+func FormatValue(value Value) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Column is a column definition of CREATE TABLE
+type Column struct {
+	Name       string
+	Type       ColumnType
+	PrimaryKey bool
+	NotNull    bool
+}
+
+// Schema is the list of columns of a typed table, kept in its catalog entry
+// The primary key is the B+ tree key (an INT between 0 and 4294967295, like
+// the keys of kv), the other columns are encoded in the record value
+type Schema struct {
+	Columns []Column
+}
+
+// newSchema checks column definitions and returns their schema
+func newSchema(columns []Column) (*Schema, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table needs at least one column")
+	}
+	if len(columns) > math.MaxUint8 {
+		return nil, fmt.Errorf("table has %d columns, at most %d allowed", len(columns), math.MaxUint8)
+	}
+
+	primaryKeys := 0
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if len(column.Name) > math.MaxUint8 {
+			return nil, fmt.Errorf("column name of %d bytes, at most %d allowed", len(column.Name), math.MaxUint8)
+		}
+		name := strings.ToLower(column.Name)
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate column: %s", column.Name)
+		}
+		seen[name] = true
+
+		if column.PrimaryKey {
+			if column.Type != TypeInt {
+				return nil, fmt.Errorf("primary key %s must be INT, got %s", column.Name, column.Type)
+			}
+			primaryKeys++
+		}
+	}
+	if primaryKeys != 1 {
+		return nil, fmt.Errorf("table needs exactly one PRIMARY KEY column, got %d", primaryKeys)
+	}
+
+	return &Schema{Columns: columns}, nil
+}
+
+// Column returns the index of the column named name (case-insensitive), -1 if none
+func (s *Schema) Column(name string) int {
+	for i, column := range s.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// PrimaryKey returns the index of the primary key column
+func (s *Schema) PrimaryKey() int {
+	for i, column := range s.Columns {
+		if column.PrimaryKey {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check returns an error if value cannot be stored in column i
+func (s *Schema) Check(i int, value Value) error {
+	column := s.Columns[i]
+
+	if value == nil {
+		if column.PrimaryKey || column.NotNull {
+			return fmt.Errorf("column %s cannot be NULL", column.Name)
+		}
+		return nil
+	}
+
+	ok := false
+	switch value.(type) {
+	case int64:
+		ok = column.Type == TypeInt
+	case string:
+		ok = column.Type == TypeText
+	case bool:
+		ok = column.Type == TypeBool
+	}
+	if !ok {
+		return fmt.Errorf("column %s is %s, got %s", column.Name, column.Type, FormatValue(value))
+	}
+
+	if n, isInt := value.(int64); column.PrimaryKey && isInt && (n < 0 || n > math.MaxUint32) {
+		return fmt.Errorf("primary key %s must be between 0 and %d, got %d", column.Name, uint32(math.MaxUint32), n)
+	}
+	return nil
+}
+
+// Encode serializes the schema for the catalog
+// Layout: [columnCount: 1 byte] then per column [nameSize: 1 byte][name][type: 1 byte][flags: 1 byte]
+func (s *Schema) Encode() []byte {
+	buf := []byte{byte(len(s.Columns))}
+	for _, column := range s.Columns {
+		var flags byte
+		if column.PrimaryKey {
+			flags |= 1
+		}
+		if column.NotNull {
+			flags |= 2
+		}
+
+		buf = append(buf, byte(len(column.Name)))
+		buf = append(buf, column.Name...)
+		buf = append(buf, byte(column.Type), flags)
+	}
+	return buf
+}
+
+// DecodeSchema deserializes a schema written by Encode
+func DecodeSchema(data []byte) (*Schema, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("empty schema")
+	}
+
+	count := int(data[0])
+	offset := 1
+	columns := make([]Column, 0, count)
+	for i := 0; i < count; i++ {
+		if offset >= len(data) {
+			return nil, fmt.Errorf("schema column %d truncated", i)
+		}
+		nameSize := int(data[offset])
+		offset++
+		if offset+nameSize+2 > len(data) {
+			return nil, fmt.Errorf("schema column %d truncated", i)
+		}
+
+		name := string(data[offset : offset+nameSize])
+		offset += nameSize
+		columnType := ColumnType(data[offset])
+		flags := data[offset+1]
+		offset += 2
+
+		columns = append(columns, Column{
+			Name:       name,
+			Type:       columnType,
+			PrimaryKey: flags&1 != 0,
+			NotNull:    flags&2 != 0,
+		})
+	}
+
+	return &Schema{Columns: columns}, nil
+}
+
+// EncodeRow serializes the columns of a row other than the primary key, which
+// is the record key, into the record value
+// Layout: [columnCount: 2 bytes] then per column [type: 1 byte, 0 = NULL][payload]
+// INT payloads are varints, TEXT [size: uvarint][bytes], BOOL one byte
+func (s *Schema) EncodeRow(row []Value) string {
+	buf := binary.LittleEndian.AppendUint16(nil, uint16(len(s.Columns)-1))
+	for i, column := range s.Columns {
+		if column.PrimaryKey {
+			continue
+		}
+
+		switch v := row[i].(type) {
+		case nil:
+			buf = append(buf, 0)
+		case int64:
+			buf = append(buf, byte(TypeInt))
+			buf = binary.AppendVarint(buf, v)
+		case string:
+			buf = append(buf, byte(TypeText))
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		case bool:
+			buf = append(buf, byte(TypeBool))
+			if v {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		}
+	}
+	return string(buf)
+}
+
+// DecodeRow deserializes a record written by EncodeRow, putting key in the
+// primary key column
+func (s *Schema) DecodeRow(key uint32, value string) ([]Value, error) {
+	data := []byte(value)
+	if len(data) < 2 {
+		return nil, fmt.Errorf("row of key %d truncated", key)
+	}
+	count := int(binary.LittleEndian.Uint16(data[0:2]))
+	offset := 2
+
+	row := make([]Value, len(s.Columns))
+	stored := 0
+	for i, column := range s.Columns {
+		if column.PrimaryKey {
+			row[i] = int64(key)
+			continue
+		}
+
+		// Columns past those stored read as NULL
+		if stored == count {
+			continue
+		}
+		stored++
+
+		if offset >= len(data) {
+			return nil, fmt.Errorf("row of key %d truncated", key)
+		}
+		columnType := ColumnType(data[offset])
+		offset++
+
+		switch columnType {
+		case 0:
+			row[i] = nil
+		case TypeInt:
+			v, n := binary.Varint(data[offset:])
+			if n <= 0 {
+				return nil, fmt.Errorf("row of key %d: bad INT in column %s", key, column.Name)
+			}
+			row[i] = v
+			offset += n
+		case TypeText:
+			size, n := binary.Uvarint(data[offset:])
+			if n <= 0 || offset+n+int(size) > len(data) {
+				return nil, fmt.Errorf("row of key %d: bad TEXT in column %s", key, column.Name)
+			}
+			offset += n
+			row[i] = string(data[offset : offset+int(size)])
+			offset += int(size)
+		case TypeBool:
+			if offset >= len(data) {
+				return nil, fmt.Errorf("row of key %d: bad BOOL in column %s", key, column.Name)
+			}
+			row[i] = data[offset] != 0
+			offset++
+		default:
+			return nil, fmt.Errorf("row of key %d: unknown type %d in column %s", key, columnType, column.Name)
+		}
+	}
+
+	return row, nil
+}
@@ -64,8 +64,8 @@ func (t *Tokenizer) Tokenize() ([]Token, error) {
 			continue
 		}
 
-		// Handle numbers
-		if unicode.IsDigit(rune(ch)) {
+		// Handle numbers, negative ones included
+		if unicode.IsDigit(rune(ch)) || (ch == '-' && t.pos+1 < len(t.input) && unicode.IsDigit(rune(t.input[t.pos+1]))) {
 			t.readNumber()
 			continue
 		}
@@ -93,6 +93,8 @@ func (t *Tokenizer) Tokenize() ([]Token, error) {
 		case '=':
 			t.tokens = append(t.tokens, Token{Type: TokenOperator, Value: "="})
 			t.pos++
+		case '<', '>':
+			t.readComparison()
 		case '*':
 			t.tokens = append(t.tokens, Token{Type: TokenStar, Value: "*"})
 			t.pos++
@@ -105,6 +107,19 @@ func (t *Tokenizer) Tokenize() ([]Token, error) {
 	return t.tokens, nil
 }
 
+// readComparison reads <, <=, > or >=
+func (t *Tokenizer) readComparison() {
+	op := string(t.input[t.pos])
+	t.pos++
+
+	if t.pos < len(t.input) && t.input[t.pos] == '=' {
+		op += "="
+		t.pos++
+	}
+
+	t.tokens = append(t.tokens, Token{Type: TokenOperator, Value: op})
+}
+
 // readString reads a string literal enclosed in single quotes
 func (t *Tokenizer) readString() error {
 	t.pos++ // Skip opening quote
@@ -128,6 +143,9 @@ func (t *Tokenizer) readString() error {
 // readNumber reads a numeric literal
 func (t *Tokenizer) readNumber() {
 	start := t.pos
+	if t.input[t.pos] == '-' {
+		t.pos++
+	}
 
 	for t.pos < len(t.input) && unicode.IsDigit(rune(t.input[t.pos])) {
 		t.pos++
@@ -154,12 +172,40 @@ func (t *Tokenizer) readIdentifierOrKeyword() {
 
 	// Check if it's a keyword
 	keywords := map[string]bool{
-		"SELECT": true,
-		"INSERT": true,
-		"INTO":   true,
-		"VALUES": true,
-		"FROM":   true,
-		"WHERE":  true,
+		"SELECT":      true,
+		"INSERT":      true,
+		"INTO":        true,
+		"VALUES":      true,
+		"FROM":        true,
+		"WHERE":       true,
+		"DELETE":      true,
+		"UPDATE":      true,
+		"SET":         true,
+		"ON":          true,
+		"CONFLICT":    true,
+		"DO":          true,
+		"BETWEEN":     true,
+		"AND":         true,
+		"LIMIT":       true,
+		"ORDER":       true,
+		"BY":          true,
+		"ASC":         true,
+		"DESC":        true,
+		"BEGIN":       true,
+		"COMMIT":      true,
+		"ROLLBACK":    true,
+		"ABORT":       true,
+		"TRANSACTION": true,
+		"CREATE":      true,
+		"DROP":        true,
+		"TABLE":       true,
+		"INDEX":       true,
+		"PRIMARY":     true,
+		"NOT":         true,
+		"NULL":        true,
+		"TRUE":        true,
+		"FALSE":       true,
+		"VACUUM":      true,
 	}
 
 	if keywords[upper] {
@@ -1,17 +1,54 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 )
 
+// ErrPageNotPinned is returned when unpinning a page that holds no pin
+var ErrPageNotPinned = errors.New("page not pinned")
+
+// ErrPagePinned is returned when freeing a page that is still pinned
+var ErrPagePinned = errors.New("page is pinned")
+
+// WriteAheadLog is the log whose records describe the changes to the pages
+// of a buffer pool (wal.WAL), see BufferPool.SetWAL
+type WriteAheadLog interface {
+	// LastLSN returns the LSN of the last appended record
+	LastLSN() uint64
+	// SyncTo makes the records up to lsn durable
+	SyncTo(lsn uint64) error
+}
+
+// pageBatchWriter is a pager that writes several pages at a lower cost than
+// one at a time (FilePager)
+type pageBatchWriter interface {
+	WritePages(pages map[uint64][]byte) error
+}
+
 // BufferPool implements an LRU cache for pages
+//
+// A pinned page (FetchPage) stays cached until it is unpinned: eviction
+// skips it, and the pool grows past its capacity while every page is pinned.
+// Every cached page carries the LSN of the last WAL record that may have
+// changed it, and a dirty page is only written once the WAL is durable up to
+// that LSN (the write-ahead rule)
+//
+// The WAL is logical, its records cannot repair a page. Evicting between
+// checkpoints writes pages the superblock does not describe yet (half of a
+// split, a parent pointing to a page still cached), and a crash leaves them
+// in the file. The pager's rollback journal (see journal.go) puts the file
+// back to the last checkpoint on open, replay then redoes the records after
+// it. The write-ahead rule only keeps a page from holding a change whose
+// record a crash lost, the journal rolls such a page back anyway
 type BufferPool struct {
 	capacity int
 	cache    map[uint64]*cacheNode
 	head     *cacheNode // Most recently used
 	tail     *cacheNode // Least recently used
 	pager    Pager      // Underlying pager
+	log      WriteAheadLog
 	mu       sync.RWMutex
 	hits     uint64 // Cache hits
 	misses   uint64 // Cache misses
@@ -24,7 +61,9 @@ type cacheNode struct {
 	data   []byte
 	prev   *cacheNode
 	next   *cacheNode
-	dirty  bool // Track if page needs to be written back
+	dirty  bool   // Track if page needs to be written back
+	pins   int    // FetchPage calls not yet matched by UnpinPage
+	lsn    uint64 // page LSN: WAL records up to it must be durable before the page is written
 }
 
 // NewBufferPool creates a new buffer pool
@@ -48,19 +87,73 @@ func NewBufferPool(pager Pager, capacity int) *BufferPool {
 	return bp
 }
 
+// SetWAL makes the pool keep log ahead of the pages it writes
+// Pages changed before have no page LSN and are written without waiting
+func (bp *BufferPool) SetWAL(log WriteAheadLog) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.log = log
+}
+
 // ReadPage reads a page (from cache or disk)
 func (bp *BufferPool) ReadPage(id uint64) ([]byte, error) {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
+	node, err := bp.fetch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return a copy to prevent external modification
+	dataCopy := make([]byte, len(node.data))
+	copy(dataCopy, node.data)
+	return dataCopy, nil
+}
+
+// FetchPage pins a page in the pool and returns its cached bytes, which
+// stay valid until the matching UnpinPage
+// A caller changing them must hold the page exclusively and report the
+// change with UnpinPage(id, true); ReadPage and WritePage work on copies
+func (bp *BufferPool) FetchPage(id uint64) ([]byte, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	node, err := bp.fetch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	node.pins++
+	return node.data, nil
+}
+
+// UnpinPage releases a pin taken by FetchPage, dirty marks the page as
+// changed since it was fetched
+func (bp *BufferPool) UnpinPage(id uint64, dirty bool) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	node, exists := bp.cache[id]
+	if !exists || node.pins == 0 {
+		return fmt.Errorf("%w: %d", ErrPageNotPinned, id)
+	}
+
+	node.pins--
+	if dirty {
+		bp.markDirty(node)
+	}
+	return nil
+}
+
+// fetch returns the cached node of a page, reading it on a miss
+// Caller must hold bp.mu
+func (bp *BufferPool) fetch(id uint64) (*cacheNode, error) {
 	// Check cache first
 	if node, exists := bp.cache[id]; exists {
 		bp.hits++
 		bp.moveToHead(node)
-		// Return a copy to prevent external modification
-		dataCopy := make([]byte, len(node.data))
-		copy(dataCopy, node.data)
-		return dataCopy, nil
+		return node, nil
 	}
 
 	// Cache miss - read from disk
@@ -70,18 +163,13 @@ func (bp *BufferPool) ReadPage(id uint64) ([]byte, error) {
 		return nil, err
 	}
 
-	bp.addToCache(id, data, false)
-
-	// Return a copy
-	dataCopy := make([]byte, len(data))
-	copy(dataCopy, data)
-	return dataCopy, nil
+	return bp.addToCache(id, data)
 }
 
 // WritePage writes a page (to cache, deferred to disk)
 func (bp *BufferPool) WritePage(id uint64, data []byte) error {
-	if len(data) != PageSize {
-		return fmt.Errorf("invalid page size: %d, expected %d", len(data), PageSize)
+	if len(data) != bp.pager.PageSize() {
+		return fmt.Errorf("invalid page size: %d, expected %d", len(data), bp.pager.PageSize())
 	}
 
 	bp.mu.Lock()
@@ -91,7 +179,7 @@ func (bp *BufferPool) WritePage(id uint64, data []byte) error {
 	if node, exists := bp.cache[id]; exists {
 		// Update cached data
 		copy(node.data, data)
-		node.dirty = true
+		bp.markDirty(node)
 		bp.moveToHead(node)
 		return nil
 	}
@@ -100,41 +188,164 @@ func (bp *BufferPool) WritePage(id uint64, data []byte) error {
 	bp.misses++
 
 	// Add to cache
-	dataCopy := make([]byte, PageSize)
-	copy(dataCopy, data)
-	bp.addToCache(id, dataCopy, true)
+	node, err := bp.addToCache(id, data)
+	if err != nil {
+		return err
+	}
+	bp.markDirty(node)
 
 	return nil
 }
 
+// markDirty marks a cached page as changed
+// Changes are logged before they reach a page, so the last LSN of the log
+// covers them. Caller must hold bp.mu
+func (bp *BufferPool) markDirty(node *cacheNode) {
+	node.dirty = true
+	if bp.log != nil {
+		node.lsn = max(node.lsn, bp.log.LastLSN())
+	}
+}
+
 // AllocatePage allocates a new page
 func (bp *BufferPool) AllocatePage() (uint64, error) {
 	// Delegate to underlying pager
 	return bp.pager.AllocatePage()
 }
 
-// Close flushes all dirty pages and closes underlying pager
-func (bp *BufferPool) Close() error {
+// FreePage drops a page from the cache and returns it to the pager's free list
+// A pinned page cannot be freed
+func (bp *BufferPool) FreePage(id uint64) error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	// Discard cached copy - its contents are no longer needed
+	if node, exists := bp.cache[id]; exists {
+		if node.pins > 0 {
+			return fmt.Errorf("%w: %d", ErrPagePinned, id)
+		}
+		bp.removeNode(node)
+		delete(bp.cache, id)
+	}
+
+	return bp.pager.FreePage(id)
+}
+
+// NumPages returns the number of pages of the underlying pager
+func (bp *BufferPool) NumPages() uint64 {
+	return bp.pager.NumPages()
+}
+
+// ClearFreeList empties the free list of the underlying pager
+func (bp *BufferPool) ClearFreeList() error {
+	return bp.pager.ClearFreeList()
+}
+
+// Truncate drops the cached pages the truncation cuts off, dirty or not,
+// and truncates the underlying pager
+func (bp *BufferPool) Truncate(numPages, freeListPage uint64) error {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
-	// Flush all dirty pages
 	for pageID, node := range bp.cache {
-		if node.dirty {
-			if err := bp.pager.WritePage(pageID, node.data); err != nil {
-				return fmt.Errorf("failed to flush page %d: %w", pageID, err)
-			}
+		// The pager writes the free list itself, a cached copy would be stale
+		if pageID >= numPages || pageID == freeListPage {
+			bp.removeNode(node)
+			delete(bp.cache, pageID)
 		}
 	}
 
+	return bp.pager.Truncate(numPages, freeListPage)
+}
+
+// Superblock returns the superblock of the underlying pager
+func (bp *BufferPool) Superblock() Superblock {
+	return bp.pager.Superblock()
+}
+
+// WriteSuperblock writes through to the underlying pager, the superblock
+// is never cached
+func (bp *BufferPool) WriteSuperblock(sb Superblock) error {
+	return bp.pager.WriteSuperblock(sb)
+}
+
+// PageSize returns the page size of the underlying pager
+func (bp *BufferPool) PageSize() int {
+	return bp.pager.PageSize()
+}
+
+// Close flushes all dirty pages and closes underlying pager
+func (bp *BufferPool) Close() error {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	if err := bp.flushLocked(); err != nil {
+		return err
+	}
+
 	return bp.pager.Close()
 }
 
 // Flush writes all dirty pages to disk (but doesn't close pager)
+// Pinned pages are written too, their holders must not be changing them
 func (bp *BufferPool) Flush() error {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
+	if err := bp.flushLocked(); err != nil {
+		return err
+	}
+
+	return bp.pager.Flush()
+}
+
+// Snapshot writes the dirty pages to the underlying pager and opens a page
+// snapshot of it, with no eviction in between
+// The caller keeps writes out until it returns
+func (bp *BufferPool) Snapshot() (*PageSnapshot, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	if err := bp.flushLocked(); err != nil {
+		return nil, err
+	}
+
+	return bp.pager.Snapshot()
+}
+
+// flushLocked writes every dirty page to the pager, after syncing the WAL
+// once up to the highest page LSN. A pager that writes pages in batches gets
+// them all at once. Caller must hold bp.mu
+func (bp *BufferPool) flushLocked() error {
+	lsn := uint64(0)
+	for _, node := range bp.cache {
+		if node.dirty {
+			lsn = max(lsn, node.lsn)
+		}
+	}
+	if err := bp.syncLog(lsn); err != nil {
+		return err
+	}
+
+	if batch, ok := bp.pager.(pageBatchWriter); ok {
+		pages := make(map[uint64][]byte)
+		for pageID, node := range bp.cache {
+			if node.dirty {
+				pages[pageID] = node.data
+			}
+		}
+		if len(pages) == 0 {
+			return nil
+		}
+		if err := batch.WritePages(pages); err != nil {
+			return fmt.Errorf("failed to flush %d pages: %w", len(pages), err)
+		}
+		for pageID := range pages {
+			bp.cache[pageID].dirty = false
+		}
+		return nil
+	}
+
 	for pageID, node := range bp.cache {
 		if node.dirty {
 			if err := bp.pager.WritePage(pageID, node.data); err != nil {
@@ -143,25 +354,40 @@ func (bp *BufferPool) Flush() error {
 			node.dirty = false
 		}
 	}
+	return nil
+}
 
+// syncLog makes the WAL durable up to lsn before a page with that LSN is written
+func (bp *BufferPool) syncLog(lsn uint64) error {
+	if bp.log == nil || lsn == 0 {
+		return nil
+	}
+	if err := bp.log.SyncTo(lsn); err != nil {
+		return fmt.Errorf("failed to sync WAL before writing pages: %w", err)
+	}
 	return nil
 }
 
-// addToCache adds a page to the cache (evicts LRU if full)
-func (bp *BufferPool) addToCache(pageID uint64, data []byte, dirty bool) {
-	// Check if we need to evict
-	if len(bp.cache) >= bp.capacity {
-		bp.evictLRU()
+// addToCache adds a clean page to the cache (evicts LRU if full)
+func (bp *BufferPool) addToCache(pageID uint64, data []byte) (*cacheNode, error) {
+	// Evict down below capacity, the pool stays over it while pages are pinned
+	for len(bp.cache) >= bp.capacity {
+		evicted, err := bp.evictLRU()
+		if err != nil {
+			return nil, err
+		}
+		if !evicted {
+			break
+		}
 	}
 
 	// Create new node
-	dataCopy := make([]byte, PageSize)
+	dataCopy := make([]byte, len(data))
 	copy(dataCopy, data)
 
 	node := &cacheNode{
 		pageID: pageID,
 		data:   dataCopy,
-		dirty:  dirty,
 	}
 
 	// Add to map
@@ -169,21 +395,30 @@ func (bp *BufferPool) addToCache(pageID uint64, data []byte, dirty bool) {
 
 	// Add to head of list (most recently used)
 	bp.addToHead(node)
+
+	return node, nil
 }
 
-// evictLRU removes the least recently used page
-func (bp *BufferPool) evictLRU() {
-	// Get tail node (LRU)
+// evictLRU removes the least recently used page that is not pinned
+// Returns false if every page is pinned. A dirty page that cannot be
+// written stays cached and the error is returned
+func (bp *BufferPool) evictLRU() (bool, error) {
+	// Walk from the tail (LRU) to the first unpinned page
 	lru := bp.tail.prev
+	for lru != bp.head && lru.pins > 0 {
+		lru = lru.prev
+	}
 	if lru == bp.head {
-		return // Empty list
+		return false, nil // Empty list or every page pinned
 	}
 
-	// Write dirty page to disk before eviction
+	// Write dirty page to disk before eviction, after the log describing it
 	if lru.dirty {
+		if err := bp.syncLog(lru.lsn); err != nil {
+			return false, err
+		}
 		if err := bp.pager.WritePage(lru.pageID, lru.data); err != nil {
-			// Log error but continue (in production, handle this better)
-			fmt.Printf("Warning: failed to write page %d during eviction: %v\n", lru.pageID, err)
+			return false, fmt.Errorf("failed to write page %d during eviction: %w", lru.pageID, err)
 		}
 	}
 
@@ -194,6 +429,7 @@ func (bp *BufferPool) evictLRU() {
 	delete(bp.cache, lru.pageID)
 
 	bp.evicts++
+	return true, nil
 }
 
 // moveToHead moves a node to the head (mark as most recently used)
@@ -228,13 +464,14 @@ func (bp *BufferPool) GetStats() BufferPoolStats {
 	}
 
 	return BufferPoolStats{
-		Capacity:   bp.capacity,
-		Size:       len(bp.cache),
-		Hits:       bp.hits,
-		Misses:     bp.misses,
-		Evictions:  bp.evicts,
-		HitRate:    hitRate,
-		DirtyPages: bp.countDirtyPages(),
+		Capacity:    bp.capacity,
+		Size:        len(bp.cache),
+		Hits:        bp.hits,
+		Misses:      bp.misses,
+		Evictions:   bp.evicts,
+		HitRate:     hitRate,
+		DirtyPages:  bp.countDirtyPages(),
+		PinnedPages: bp.countPinnedPages(),
 	}
 }
 
@@ -249,21 +486,33 @@ func (bp *BufferPool) countDirtyPages() int {
 	return count
 }
 
+// countPinnedPages counts number of pinned pages in cache
+func (bp *BufferPool) countPinnedPages() int {
+	count := 0
+	for _, node := range bp.cache {
+		if node.pins > 0 {
+			count++
+		}
+	}
+	return count
+}
+
 // BufferPoolStats holds cache statistics
 type BufferPoolStats struct {
-	Capacity   int
-	Size       int
-	Hits       uint64
-	Misses     uint64
-	Evictions  uint64
-	HitRate    float64
-	DirtyPages int
+	Capacity    int
+	Size        int
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	HitRate     float64
+	DirtyPages  int
+	PinnedPages int
 }
 
 // String returns a formatted string of stats
 func (s BufferPoolStats) String() string {
 	return fmt.Sprintf(
-		"BufferPool{Capacity: %d, Size: %d, Hits: %d, Misses: %d, Evictions: %d, HitRate: %.2f%%, DirtyPages: %d}",
-		s.Capacity, s.Size, s.Hits, s.Misses, s.Evictions, s.HitRate*100, s.DirtyPages,
+		"BufferPool{Capacity: %d, Size: %d, Hits: %d, Misses: %d, Evictions: %d, HitRate: %.2f%%, DirtyPages: %d, PinnedPages: %d}",
+		s.Capacity, s.Size, s.Hits, s.Misses, s.Evictions, s.HitRate*100, s.DirtyPages, s.PinnedPages,
 	)
 }
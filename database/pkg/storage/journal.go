@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Rollback journal
+//
+// Pages reach the file between checkpoints: the buffer pool evicts dirty
+// pages, and a pager used without one writes every page at once. The tree
+// records its roots in the superblock only at checkpoints, so after a crash
+// those pages would be states the superblock does not describe: half of a
+// split, a parent pointing to a page never written, a root page not on disk
+//
+// The journal keeps the file as of the last superblock write instead. Before
+// a page that existed then is first overwritten, its old image is appended
+// to <file>-journal and synced. Writing the superblock commits every page
+// written since, the journal is then deleted. Opening a file whose journal
+// names its current superblock puts the old images back and cuts the pages
+// allocated since; the tree replays its WAL from that checkpoint
+//
+// Cost: each page is journaled at most once per checkpoint interval, and the
+// images of a batch of writes (FilePager.WritePages, which BufferPool.Flush
+// uses at checkpoints) are synced together, so a checkpoint adds one journal
+// fsync whatever the number of pages. A page evicted on its own still syncs
+// its image before it is written, the first time since the commit
+//
+// Crash states:
+//   - between superblock writes: the journal is rolled back
+//   - while appending to the journal: the torn record fails its checksum and
+//     is ignored, its page was not overwritten yet
+//   - while overwriting a page: the journal holds its old image
+//   - after the superblock write, before the journal is deleted: the journal
+//     names the older superblock and is discarded
+//   - while rolling back: the journal stays until the file is synced, the
+//     next open rolls back again
+//
+// Layout, integers little endian: header [magic "SGJL" 4][superblock
+// sequence 8][pages 8][CRC32 4], then per page [page ID 8][image][CRC32 4]
+
+// JournalSuffix is appended to the path of a database file to name its journal
+const JournalSuffix = "-journal"
+
+const (
+	journalMagic      = "SGJL"
+	journalHeaderSize = 24
+)
+
+// journal is the rollback journal of a FilePager
+type journal struct {
+	path string
+
+	mu       sync.Mutex
+	file     *os.File        // nil until a page is saved after the last commit
+	sequence uint64          // superblock sequence of the last commit
+	numPages uint64          // pages of the file at the last commit
+	saved    map[uint64]bool // pages whose old image is in the journal
+}
+
+// newJournal returns the journal of the database file at path
+func newJournal(path string) *journal {
+	return &journal{path: path + JournalSuffix, saved: make(map[uint64]bool)}
+}
+
+// save appends the images of pages ids to the journal before the pages are
+// first overwritten since the last commit, with one fsync for all of them
+// ids are distinct. They only count as saved once synced, so a concurrent
+// save of the same page cannot return before its image is durable
+func (j *journal) save(p *FilePager, ids ...uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var records []byte
+	var pending []uint64
+	for _, id := range ids {
+		// Pages allocated since the commit are cut off by a rollback
+		if id >= j.numPages || j.saved[id] {
+			continue
+		}
+
+		data, err := p.readFile(id)
+		if err != nil {
+			return err
+		}
+
+		start := len(records)
+		records = binary.LittleEndian.AppendUint64(records, id)
+		records = append(records, data...)
+		records = binary.LittleEndian.AppendUint32(records, crc32.ChecksumIEEE(records[start:]))
+		pending = append(pending, id)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := j.open(); err != nil {
+		return err
+	}
+	if _, err := j.file.Write(records); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+
+	for _, id := range pending {
+		j.saved[id] = true
+	}
+	return nil
+}
+
+// open creates the journal for the current commit if it is not open yet
+// The caller holds mu
+func (j *journal) open() error {
+	if j.file != nil {
+		return nil
+	}
+
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create journal: %w", err)
+	}
+
+	header := make([]byte, journalHeaderSize)
+	copy(header[0:4], journalMagic)
+	binary.LittleEndian.PutUint64(header[4:12], j.sequence)
+	binary.LittleEndian.PutUint64(header[12:20], j.numPages)
+	binary.LittleEndian.PutUint32(header[20:24], crc32.ChecksumIEEE(header[:20]))
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	// The first record is synced before its page is written, the file must
+	// be found after a crash too
+	if err := syncDir(j.path); err != nil {
+		file.Close()
+		return err
+	}
+
+	j.file = file
+	return nil
+}
+
+// commit drops the journal once the superblock with sequence is durable,
+// the file has numPages pages then
+func (j *journal) commit(sequence, numPages uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.sequence = sequence
+	j.numPages = numPages
+	j.saved = make(map[uint64]bool)
+
+	if j.file == nil {
+		return nil
+	}
+	j.file.Close()
+	j.file = nil
+
+	// A journal left behind names an older superblock, opening the file discards it
+	if err := os.Remove(j.path); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// truncate notes that the file was cut to numPages pages right after a commit
+func (j *journal) truncate(numPages uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.numPages = min(j.numPages, numPages)
+}
+
+// close closes the journal file, leaving it for the next open to roll back
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// rollback puts back the pages saved in a journal left by a crash, if it
+// belongs to the current superblock of file, and removes the journal
+func (j *journal) rollback(file *os.File) error {
+	data, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	header := make([]byte, superblockHeader)
+	if _, err := file.ReadAt(header, 0); err == nil {
+		if super, err := readSuperblock(header); err == nil && journalMatches(data, super.Sequence) {
+			if err := restoreJournal(file, data, int(super.PageSize)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.Remove(j.path); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// journalMatches reports whether journal data has a valid header for the
+// superblock with sequence
+func journalMatches(data []byte, sequence uint64) bool {
+	if len(data) < journalHeaderSize || string(data[0:4]) != journalMagic {
+		return false
+	}
+	if crc32.ChecksumIEEE(data[:20]) != binary.LittleEndian.Uint32(data[20:24]) {
+		return false
+	}
+	return binary.LittleEndian.Uint64(data[4:12]) == sequence
+}
+
+// restoreJournal writes the saved pages of journal data back into file, cuts
+// the pages allocated after the commit and syncs the file
+// A torn record ends the journal: its page was never overwritten
+func restoreJournal(file *os.File, data []byte, pageSize int) error {
+	numPages := binary.LittleEndian.Uint64(data[12:20])
+	recordSize := 8 + pageSize + 4
+
+	for offset := journalHeaderSize; offset+recordSize <= len(data); offset += recordSize {
+		record := data[offset : offset+recordSize]
+		if crc32.ChecksumIEEE(record[:8+pageSize]) != binary.LittleEndian.Uint32(record[8+pageSize:]) {
+			break
+		}
+		id := binary.LittleEndian.Uint64(record[0:8])
+		if _, err := file.WriteAt(record[8:8+pageSize], int64(id)*int64(pageSize)); err != nil {
+			return fmt.Errorf("failed to roll back page %d: %w", id, err)
+		}
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if size := int64(numPages) * int64(pageSize); stat.Size() > size {
+		if err := file.Truncate(size); err != nil {
+			return fmt.Errorf("failed to cut pages allocated after the checkpoint: %w", err)
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync rolled back file: %w", err)
+	}
+	return nil
+}
+
+// syncDir fsyncs the directory holding path, so a file created there survives a crash
+func syncDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// MaxKeySize is the largest key a record or separator may have, so an
+// internal page always holds a dozen separators and a leaf a few records
+const MaxKeySize = 256
+
+// Comparator orders keys: negative if a < b, 0 if equal, positive if a > b
+type Comparator func(a, b []byte) int
+
+// CompareBytes orders keys bytewise, the default order of pages and trees
+func CompareBytes(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+// Uint32Key encodes a number as a 4-byte big-endian key, so bytewise order
+// is numeric order
+func Uint32Key(key uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, key)
+	return buf
+}
+
+// KeyToUint32 decodes a key made by Uint32Key
+// Returns false if the key is not 4 bytes
+func KeyToUint32(key []byte) (uint32, bool) {
+	if len(key) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(key), true
+}
+
+// ShortestSeparator returns the shortest prefix of right that sorts after
+// left bytewise, so left < separator <= right
+// Splits promote it instead of right itself to keep internal pages small.
+// Only valid for keys ordered by CompareBytes: other comparators may not
+// accept a prefix of a key at all
+func ShortestSeparator(left, right []byte) []byte {
+	for n := 1; n < len(right); n++ {
+		if prefix := right[:n]; bytes.Compare(left, prefix) < 0 {
+			return bytes.Clone(prefix)
+		}
+	}
+	return bytes.Clone(right)
+}
@@ -12,6 +12,8 @@ const (
 	PageTypeFree     PageType = 0 // Page is empty
 	PageTypeInternal PageType = 1 // Internal node of B+ tree
 	PageTypeLeaf     PageType = 2 // Leaf node of B+ Tree
+	PageTypeCatalog  PageType = 3 // System catalog of tables
+	PageTypeOverflow PageType = 4 // Part of a value too large for a leaf
 )
 
 func (pt PageType) String() string {
@@ -22,6 +24,10 @@ func (pt PageType) String() string {
 		return "Internal"
 	case PageTypeLeaf:
 		return "Leaf"
+	case PageTypeCatalog:
+		return "Catalog"
+	case PageTypeOverflow:
+		return "Overflow"
 	default:
 		return "Unknown"
 	}
@@ -37,38 +43,45 @@ type PageHeader struct {
 	NumKeys  uint16   // 2 bytes - number of keys in page
 	NextPage uint32   // 4 bytes - pointer to next page (used for leaf linked list)
 	Parent   uint32   // 4 bytes - pointer to parent page
-	// 4 bytes reserved for padding
+	PrevPage uint32   // 4 bytes - pointer to previous page (used for reverse leaf iteration)
 }
 
-// Page stand for a page 4096 byte = 4 KB
+// Page stand for a page of the file, 4 KB unless the file uses larger pages
 type Page struct {
 	Header PageHeader
 	Data   []byte
 }
 
 // NewPage create a new page with defined type
-func NewPage(pageType PageType) *Page {
+// size is the page size of the file the page belongs to
+func NewPage(pageType PageType, size int) *Page {
 	return &Page{
 		Header: PageHeader{
 			PageType: pageType,
 			NumKeys:  0,
 			NextPage: 0,
 			Parent:   0,
+			PrevPage: 0,
 		},
-		Data: make([]byte, PageSize-PageHeaderSize),
+		Data: make([]byte, size-PageHeaderSize),
 	}
 }
 
+// Size returns the size of the serialized page
+func (p *Page) Size() int {
+	return PageHeaderSize + len(p.Data)
+}
+
 // Serilaize change Page to []byte to write in disk
 func (p *Page) Serialize() []byte {
-	buf := make([]byte, PageSize)
+	buf := make([]byte, p.Size())
 
 	// Serialize header
 	binary.LittleEndian.PutUint16(buf[0:2], uint16(p.Header.PageType))
 	binary.LittleEndian.PutUint16(buf[2:4], p.Header.NumKeys)
 	binary.LittleEndian.PutUint32(buf[4:8], p.Header.NextPage)
 	binary.LittleEndian.PutUint32(buf[8:12], p.Header.Parent)
-	// bytes 12-16: reserved (padding)
+	binary.LittleEndian.PutUint32(buf[12:16], p.Header.PrevPage)
 
 	// Copy data
 	copy(buf[PageHeaderSize:], p.Data)
@@ -78,12 +91,12 @@ func (p *Page) Serialize() []byte {
 
 // Deserialize change from []byte in disk to Page
 func DeserializePage(data []byte) (*Page, error) {
-	if len(data) != PageSize {
-		return nil, fmt.Errorf("invalid page size: %d, expected %d", len(data), PageSize)
+	if !ValidPageSize(len(data)) {
+		return nil, fmt.Errorf("invalid page size: %d", len(data))
 	}
 
 	page := &Page{
-		Data: make([]byte, PageSize-PageHeaderSize),
+		Data: make([]byte, len(data)-PageHeaderSize),
 	}
 
 	// Deserialize header
@@ -91,6 +104,7 @@ func DeserializePage(data []byte) (*Page, error) {
 	page.Header.NumKeys = binary.LittleEndian.Uint16(data[2:4])
 	page.Header.NextPage = binary.LittleEndian.Uint32(data[4:8])
 	page.Header.Parent = binary.LittleEndian.Uint32(data[8:12])
+	page.Header.PrevPage = binary.LittleEndian.Uint32(data[12:16])
 
 	// Copy data
 	copy(page.Data, data[PageHeaderSize:])
@@ -122,9 +136,10 @@ func (p *Page) IsFree() bool {
 
 // String return string representation of page
 func (p *Page) String() string {
-	return fmt.Sprintf("Page{Type: %s, NumKeys: %d, NextPage: %d, Parent: %d}",
+	return fmt.Sprintf("Page{Type: %s, NumKeys: %d, NextPage: %d, PrevPage: %d, Parent: %d}",
 		p.Header.PageType,
 		p.Header.NumKeys,
 		p.Header.NextPage,
+		p.Header.PrevPage,
 		p.Header.Parent)
 }
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"os"
 	"testing"
 )
 
@@ -29,8 +30,60 @@ func TestRecordSerialization(t *testing.T) {
 	}
 }
 
+func TestVersionedRecordSerialization(t *testing.T) {
+	key := NewRecordFromInts(7, "").Key
+	record := NewVersionedRecord(key, []Version{
+		{CommitTS: 30, Deleted: true},
+		{CommitTS: 20, Value: []byte("second")},
+		{CommitTS: 10, Value: []byte("first")},
+	})
+
+	serialized := record.Serialize()
+	if len(serialized) != record.Size() {
+		t.Fatalf("Serialized %d bytes, Size() = %d", len(serialized), record.Size())
+	}
+
+	deserialized, bytesRead, err := DeserializeRecord(serialized)
+	if err != nil {
+		t.Fatalf("Failed to deserialize: %v", err)
+	}
+	if bytesRead != len(serialized) {
+		t.Errorf("BytesRead = %d, expected %d", bytesRead, len(serialized))
+	}
+	if k, _ := deserialized.GetKeyAsUint32(); k != 7 {
+		t.Errorf("Key = %d, expected 7", k)
+	}
+	if !deserialized.Deleted || deserialized.CommitTS != 30 || len(deserialized.History) != 2 {
+		t.Fatalf("Decoded %+v", deserialized)
+	}
+
+	// Each timestamp sees the newest version committed at or before it
+	cases := []struct {
+		ts      uint64
+		value   string
+		visible bool
+	}{
+		{5, "", false},
+		{10, "first", true},
+		{25, "second", true},
+		{30, "", false},
+	}
+	for _, c := range cases {
+		value, visible := deserialized.VisibleAt(c.ts)
+		if visible != c.visible || string(value) != c.value {
+			t.Errorf("VisibleAt(%d) = (%q, %v), expected (%q, %v)", c.ts, value, visible, c.value, c.visible)
+		}
+	}
+
+	// Records without versions keep the original format
+	plain := NewRecordFromInts(7, "v")
+	if plain.Versioned() || plain.Size() != 4+4+4+1 {
+		t.Errorf("Plain record: versioned=%v size=%d", plain.Versioned(), plain.Size())
+	}
+}
+
 func TestRecordList(t *testing.T) {
-	page := NewPage(PageTypeLeaf)
+	page := NewPage(PageTypeLeaf, DefaultPageSize)
 	rl := NewRecordList()
 
 	// Add some records
@@ -96,7 +149,7 @@ func TestFreeList(t *testing.T) {
 	}
 
 	// Test serialization
-	page := fl.SerializeToPage()
+	page := fl.SerializeToPages(DefaultPageSize)[0]
 	deserializedFL, err := DeserializeFreeList(page)
 	if err != nil {
 		t.Fatalf("Failed to deserialize free list: %v", err)
@@ -106,3 +159,120 @@ func TestFreeList(t *testing.T) {
 		t.Errorf("Deserialized size = %d, expected 1", deserializedFL.Size())
 	}
 }
+
+func TestFreeListChain(t *testing.T) {
+	path := "test_free_list_chain.db"
+	defer os.Remove(path)
+
+	pager, err := NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+
+	// 1200 IDs take three pages at 509 per page, two of them trunks
+	const pages = 1200
+	for i := 0; i < pages; i++ {
+		if _, err := pager.AllocatePage(); err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+	}
+	for pageID := uint64(2); pageID < pages+2; pageID++ {
+		if err := pager.FreePage(pageID); err != nil {
+			t.Fatalf("Failed to free page %d: %v", pageID, err)
+		}
+	}
+	if size, trunks := pager.FreeListSize(), len(pager.freeList.Trunks()); size != pages-2 || trunks != 2 {
+		t.Fatalf("Free list has %d pages and %d trunks, expected %d and 2", size, trunks, pages-2)
+	}
+	// Writing the superblock commits the pages, closing alone leaves them to the journal
+	if err := pager.WriteSuperblock(pager.Superblock()); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+	pager.Close()
+
+	pager, err = NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	if size := pager.FreeListSize(); size != pages-2 {
+		t.Fatalf("Reopened free list has %d pages, expected %d", size, pages-2)
+	}
+	t.Log("✓ Free list spans trunk pages")
+
+	// Every freed page comes back, trunks included, before the file grows
+	for i := 0; i < pages; i++ {
+		pageID, err := pager.AllocatePage()
+		if err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		if pageID >= pages+2 {
+			t.Fatalf("Allocation %d grew the file to page %d", i, pageID)
+		}
+	}
+	if pager.FreeListSize() != 0 || len(pager.freeList.Trunks()) != 0 {
+		t.Errorf("Free list not empty after reusing every page")
+	}
+	t.Log("✓ Trunk pages reused once the list shrinks")
+
+	// Truncation moves the free list into a page it keeps
+	if err := pager.ClearFreeList(); err != nil {
+		t.Fatalf("Failed to clear free list: %v", err)
+	}
+	if err := pager.Truncate(3, 2); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+	pager.Close()
+
+	pager, err = NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen truncated file: %v", err)
+	}
+	defer pager.Close()
+	if pager.NumPages() != 3 || pager.Superblock().FreeListPage != 2 {
+		t.Errorf("Truncated file has %d pages and its free list in page %d, expected 3 and 2",
+			pager.NumPages(), pager.Superblock().FreeListPage)
+	}
+	if info, _ := os.Stat(path); info.Size() != 3*DefaultPageSize {
+		t.Errorf("Truncated file has %d bytes, expected %d", info.Size(), 3*DefaultPageSize)
+	}
+	t.Log("✓ File truncated")
+}
+
+func TestOverflowRecordSerialization(t *testing.T) {
+	ref := EncodeOverflowRef(10000, 42)
+	record := NewVersionedRecord(NewRecordFromInts(1, "").Key, []Version{
+		{CommitTS: 20, Value: ref, Overflow: true},
+		{CommitTS: 10, Value: []byte("inline")},
+	})
+
+	deserialized, _, err := DeserializeRecord(record.Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize: %v", err)
+	}
+	versions := deserialized.Versions()
+	if !versions[0].Overflow || versions[1].Overflow || string(versions[1].Value) != "inline" {
+		t.Fatalf("Decoded %+v", versions)
+	}
+
+	size, pageID, err := DecodeOverflowRef(versions[0].Value)
+	if err != nil || size != 10000 || pageID != 42 {
+		t.Errorf("Reference decoded to (%d, %d, %v), expected (10000, 42)", size, pageID, err)
+	}
+
+	page := NewPage(PageTypeOverflow, DefaultPageSize)
+	overflow := NewOverflowPage(page)
+	data := make([]byte, OverflowChunkSize(DefaultPageSize)+10)
+	if n := overflow.SetChunk(data); n != OverflowChunkSize(DefaultPageSize) {
+		t.Errorf("SetChunk stored %d bytes, expected %d", n, OverflowChunkSize(DefaultPageSize))
+	}
+	overflow.SetNext(7)
+
+	decoded, err := DeserializePage(page.Serialize())
+	if err != nil {
+		t.Fatalf("Failed to deserialize page: %v", err)
+	}
+	chunk, err := NewOverflowPage(decoded).Chunk()
+	if err != nil || len(chunk) != OverflowChunkSize(DefaultPageSize) || NewOverflowPage(decoded).Next() != 7 {
+		t.Errorf("Overflow page decoded to %d bytes, next %d (err=%v)", len(chunk), NewOverflowPage(decoded).Next(), err)
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Superblock
+//
+// Page 0 of a database file holds the superblock: what is needed to open the
+// file before any other page is read. It is kept in two copies, one per
+// 512-byte sector of the page, and each write replaces the older copy, so a
+// torn write leaves the other one intact. Opening picks the valid copy with
+// the highest sequence number
+//
+//	Copy layout (64 bytes): [magic "SHRGNDB\x00" 8][version 4][page size 4]
+//	                        [sequence 8][root page 8][order 4][free list page 8]
+//	                        [checkpoint LSN 8][catalog page 8][crc32 4]
+//
+// crc32 (IEEE) covers the bytes before it. Files written before the
+// superblock keep the free list in page 0; they are upgraded on open
+
+const (
+	// SuperblockPageID is the page holding the superblock
+	SuperblockPageID = 0
+	// FormatVersion is the version of the file format written by this build
+	FormatVersion = 1
+
+	superblockMagic = "SHRGNDB\x00"
+	superblockSize  = 64
+	superblockSlot  = 512 // offset of the second copy, the next sector
+	// superblockHeader is the part of page 0 holding both copies, read
+	// before the page size is known
+	superblockHeader = superblockSlot + superblockSize
+)
+
+// ErrUnsupportedFormat is returned when opening a file this build cannot read
+var ErrUnsupportedFormat = errors.New("unsupported database file format")
+
+// errNoSuperblock marks a page 0 without any copy of the superblock
+var errNoSuperblock = errors.New("no superblock")
+
+// Superblock describes a database file
+// The pager owns Version, PageSize, FreeListPage and Sequence, the tree the rest
+type Superblock struct {
+	Version       uint32
+	PageSize      uint32
+	Sequence      uint64 // incremented by every write, the newer copy wins
+	RootPage      uint64 // root of the default table, 0 until a tree is created
+	Order         uint32
+	FreeListPage  uint64
+	CheckpointLSN uint64 // WAL entries up to this LSN are in the pages
+	CatalogPage   uint64 // page holding the catalog, 0 until the first table
+}
+
+// encode returns one copy of the superblock
+func (sb Superblock) encode() []byte {
+	buf := make([]byte, superblockSize)
+	copy(buf[0:8], superblockMagic)
+	binary.LittleEndian.PutUint32(buf[8:12], sb.Version)
+	binary.LittleEndian.PutUint32(buf[12:16], sb.PageSize)
+	binary.LittleEndian.PutUint64(buf[16:24], sb.Sequence)
+	binary.LittleEndian.PutUint64(buf[24:32], sb.RootPage)
+	binary.LittleEndian.PutUint32(buf[32:36], sb.Order)
+	binary.LittleEndian.PutUint64(buf[36:44], sb.FreeListPage)
+	binary.LittleEndian.PutUint64(buf[44:52], sb.CheckpointLSN)
+	binary.LittleEndian.PutUint64(buf[52:60], sb.CatalogPage)
+	binary.LittleEndian.PutUint32(buf[60:64], crc32.ChecksumIEEE(buf[:60]))
+	return buf
+}
+
+// decodeSuperblock reads one copy of the superblock
+// Returns false if the copy is missing or damaged
+func decodeSuperblock(buf []byte) (Superblock, bool) {
+	if string(buf[0:8]) != superblockMagic {
+		return Superblock{}, false
+	}
+	if crc32.ChecksumIEEE(buf[:60]) != binary.LittleEndian.Uint32(buf[60:64]) {
+		return Superblock{}, false
+	}
+
+	return Superblock{
+		Version:       binary.LittleEndian.Uint32(buf[8:12]),
+		PageSize:      binary.LittleEndian.Uint32(buf[12:16]),
+		Sequence:      binary.LittleEndian.Uint64(buf[16:24]),
+		RootPage:      binary.LittleEndian.Uint64(buf[24:32]),
+		Order:         binary.LittleEndian.Uint32(buf[32:36]),
+		FreeListPage:  binary.LittleEndian.Uint64(buf[36:44]),
+		CheckpointLSN: binary.LittleEndian.Uint64(buf[44:52]),
+		CatalogPage:   binary.LittleEndian.Uint64(buf[52:60]),
+	}, true
+}
+
+// superblockOffset returns where the copy written with sequence seq lives
+func superblockOffset(seq uint64) int64 {
+	return int64(seq%2) * superblockSlot
+}
+
+// readSuperblock returns the newest valid copy in page 0 and checks that
+// this build can read the file
+// page holds at least the first superblockHeader bytes of the file
+func readSuperblock(page []byte) (Superblock, error) {
+	first, okFirst := decodeSuperblock(page[0:superblockSize])
+	second, okSecond := decodeSuperblock(page[superblockSlot : superblockSlot+superblockSize])
+
+	var sb Superblock
+	switch {
+	case okFirst && okSecond:
+		sb = first
+		if second.Sequence > first.Sequence {
+			sb = second
+		}
+	case okFirst:
+		sb = first
+	case okSecond:
+		sb = second
+	default:
+		if string(page[0:8]) == superblockMagic || string(page[superblockSlot:superblockSlot+8]) == superblockMagic {
+			return Superblock{}, fmt.Errorf("%w: superblock damaged", ErrUnsupportedFormat)
+		}
+		return Superblock{}, errNoSuperblock
+	}
+
+	if sb.Version == 0 || sb.Version > FormatVersion {
+		return Superblock{}, fmt.Errorf("%w: version %d, this build reads up to %d", ErrUnsupportedFormat, sb.Version, FormatVersion)
+	}
+	if !ValidPageSize(int(sb.PageSize)) {
+		return Superblock{}, fmt.Errorf("%w: page size %d", ErrUnsupportedFormat, sb.PageSize)
+	}
+	return sb, nil
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSuperblock(t *testing.T) {
+	path := "test_superblock.db"
+	defer os.Remove(path)
+
+	pager, err := NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to create pager: %v", err)
+	}
+	super := pager.Superblock()
+	if super.Version != FormatVersion || super.PageSize != DefaultPageSize || super.FreeListPage != 1 {
+		t.Fatalf("New file has superblock %+v", super)
+	}
+
+	if err := pager.WriteSuperblock(Superblock{RootPage: 5, Order: 100, CheckpointLSN: 42}); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+	if err := pager.WriteSuperblock(Superblock{RootPage: 7, Order: 100, CheckpointLSN: 43, CatalogPage: 6}); err != nil {
+		t.Fatalf("Failed to write superblock: %v", err)
+	}
+	pager.Close()
+
+	pager, err = NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager: %v", err)
+	}
+	if super := pager.Superblock(); super.RootPage != 7 || super.CheckpointLSN != 43 || super.CatalogPage != 6 {
+		t.Errorf("Reopened superblock %+v", super)
+	}
+	pager.Close()
+	t.Log("✓ Superblock persisted")
+
+	// A torn write of the newest copy falls back to the other one
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	newest := superblockOffset(pager.Superblock().Sequence)
+	file.WriteAt([]byte("torn"), newest+30)
+	file.Close()
+
+	pager, err = NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen pager after a torn write: %v", err)
+	}
+	if super := pager.Superblock(); super.RootPage != 5 || super.CheckpointLSN != 42 {
+		t.Errorf("Fell back to superblock %+v, expected root 5 at LSN 42", super)
+	}
+	pager.Close()
+	t.Log("✓ Torn superblock falls back to the older copy")
+
+	// Files of a newer format are refused
+	newer := Superblock{Version: FormatVersion + 1, PageSize: DefaultPageSize, Sequence: 100, FreeListPage: 1}
+	file, _ = os.OpenFile(path, os.O_RDWR, 0644)
+	file.WriteAt(newer.encode(), superblockOffset(newer.Sequence))
+	file.Close()
+
+	if _, err := NewFilePager(path); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+	t.Log("✓ Unknown format version refused")
+}
+
+func TestSuperblockUpgrade(t *testing.T) {
+	path := "test_superblock_upgrade.db"
+	defer os.Remove(path)
+
+	// Before the superblock, page 0 held the free list
+	freeList := NewFreeList()
+	freeList.Push(2)
+	freeList.Push(3)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	file.Write(freeList.SerializeToPages(DefaultPageSize)[0].Serialize())
+	file.Write(make([]byte, 3*DefaultPageSize))
+	file.Close()
+
+	pager, err := NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to open pre-superblock file: %v", err)
+	}
+	super := pager.Superblock()
+	if super.Version != FormatVersion || super.FreeListPage != 4 || super.RootPage != 0 {
+		t.Errorf("Upgraded superblock %+v", super)
+	}
+	if pager.FreeListSize() != 2 {
+		t.Errorf("Free list has %d pages after the upgrade, expected 2", pager.FreeListSize())
+	}
+	pager.Close()
+
+	pager, err = NewFilePager(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen upgraded file: %v", err)
+	}
+	defer pager.Close()
+	if pager.FreeListSize() != 2 || pager.Superblock().FreeListPage != 4 {
+		t.Errorf("Upgrade not persisted: %+v", pager.Superblock())
+	}
+	t.Log("✓ Free list moved out of page 0")
+}
+
+func TestPageSizes(t *testing.T) {
+	path := "test_page_sizes.db"
+	defer os.Remove(path)
+
+	if _, err := NewFilePagerWithPageSize(path, 6000); err == nil {
+		t.Error("Expected an error for a page size that is not a power of two")
+	}
+	if _, err := NewFilePagerWithPageSize(path, 65536); err == nil {
+		t.Error("Expected an error for a page size above MaxPageSize")
+	}
+
+	for _, size := range []int{4096, 8192, 16384, 32768} {
+		os.Remove(path)
+
+		pager, err := NewFilePagerWithPageSize(path, size)
+		if err != nil {
+			t.Fatalf("Failed to create pager with %d byte pages: %v", size, err)
+		}
+		pageID, page, err := pager.AllocatePageWithType(PageTypeLeaf)
+		if err != nil {
+			t.Fatalf("Failed to allocate page: %v", err)
+		}
+		if page.Size() != size {
+			t.Errorf("Allocated a %d byte page, expected %d", page.Size(), size)
+		}
+		leaf := NewLeafPage(page)
+		if err := leaf.InsertRecord(NewRecord([]byte("key"), []byte("value"))); err != nil {
+			t.Fatalf("Failed to insert record: %v", err)
+		}
+		if err := pager.WritePageStruct(pageID, page); err != nil {
+			t.Fatalf("Failed to write page: %v", err)
+		}
+		pager.Close()
+
+		// Reopening with the default keeps the size the file was created with
+		pager, err = NewFilePager(path)
+		if err != nil {
+			t.Fatalf("Failed to reopen pager: %v", err)
+		}
+		if pager.PageSize() != size || pager.NumPages() != 3 {
+			t.Errorf("Reopened with %d byte pages and %d pages, expected %d and 3", pager.PageSize(), pager.NumPages(), size)
+		}
+		page, err = pager.ReadPageStruct(pageID)
+		if err != nil {
+			t.Fatalf("Failed to read page: %v", err)
+		}
+		if record, found := NewLeafPage(page).SearchRecord([]byte("key")); !found || string(record.Value) != "value" {
+			t.Errorf("Record lost with %d byte pages", size)
+		}
+		pager.Close()
+	}
+	t.Log("✓ Page size recorded in the superblock")
+}
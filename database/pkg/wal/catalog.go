@@ -0,0 +1,93 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// CatalogOp is the statement of a catalog change
+type CatalogOp byte
+
+const (
+	CatalogCreateTable CatalogOp = 0x01
+	CatalogDropTable   CatalogOp = 0x02
+	CatalogCreateIndex CatalogOp = 0x03
+	CatalogDropIndex   CatalogOp = 0x04
+)
+
+// CatalogChange is a catalog change as logged by NewCatalogEntry
+type CatalogChange struct {
+	Op      CatalogOp
+	Name    string // table or index
+	Indexed uint32 // table a created index is on
+	Schema  []byte // schema of a created table
+}
+
+// String returns the statement of the change, e.g. "CREATE TABLE users"
+func (c CatalogChange) String() string {
+	switch c.Op {
+	case CatalogCreateTable:
+		return "CREATE TABLE " + c.Name
+	case CatalogDropTable:
+		return "DROP TABLE " + c.Name
+	case CatalogCreateIndex:
+		return "CREATE INDEX " + c.Name
+	case CatalogDropIndex:
+		return "DROP INDEX " + c.Name
+	default:
+		return fmt.Sprintf("catalog change %d on %s", c.Op, c.Name)
+	}
+}
+
+// catalogChangeHeaderSize is [op 1][indexed table 4][nameSize 2]
+const catalogChangeHeaderSize = 7
+
+// NewCatalogEntry returns the record of a catalog change
+//
+// Creating or dropping a table or an index changes the catalog and
+// checkpoints instead of logging its pages, so crash recovery finds the
+// change in the catalog. The record lets replay redo it from an older
+// checkpoint (a point-in-time restore from a backup taken before it). Table
+// is the table or index changed, Key is unused
+//
+//	Value: [op 1][indexed table 4][nameSize 2][name][schema]
+func NewCatalogEntry(change CatalogChange) *Entry {
+	data := make([]byte, catalogChangeHeaderSize, catalogChangeHeaderSize+len(change.Name)+len(change.Schema))
+	data[0] = byte(change.Op)
+	binary.LittleEndian.PutUint32(data[1:5], change.Indexed)
+	binary.LittleEndian.PutUint16(data[5:7], uint16(len(change.Name)))
+	data = append(data, change.Name...)
+	data = append(data, change.Schema...)
+
+	return &Entry{OpType: OpCatalog, Value: string(data)}
+}
+
+// CatalogChange unpacks the change of an OpCatalog entry
+func (e *Entry) CatalogChange() (CatalogChange, error) {
+	if e.OpType != OpCatalog {
+		return CatalogChange{}, fmt.Errorf("entry is not a catalog change: op %d", e.OpType)
+	}
+
+	data := []byte(e.Value)
+	if len(data) < catalogChangeHeaderSize {
+		return CatalogChange{}, fmt.Errorf("catalog record too short: %d bytes", len(data))
+	}
+	if op := CatalogOp(data[0]); op < CatalogCreateTable || op > CatalogDropIndex {
+		return CatalogChange{}, fmt.Errorf("unknown catalog change %d", op)
+	}
+
+	nameEnd := catalogChangeHeaderSize + int(binary.LittleEndian.Uint16(data[5:7]))
+	if nameEnd > len(data) {
+		return CatalogChange{}, fmt.Errorf("catalog record truncated")
+	}
+
+	change := CatalogChange{
+		Op:      CatalogOp(data[0]),
+		Name:    string(data[catalogChangeHeaderSize:nameEnd]),
+		Indexed: binary.LittleEndian.Uint32(data[1:5]),
+	}
+	if nameEnd < len(data) {
+		change.Schema = data[nameEnd:]
+	}
+	return change, nil
+}
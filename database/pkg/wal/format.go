@@ -0,0 +1,222 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// On-disk format
+//
+//	File header (8 bytes):   [magic "SGWL" 4][version 2][reserved 2]
+//	Record header (16 bytes): [length 4][crc32 4][lsn 8]
+//	Record payload:           [opType 1][txID 8][table 4][time 8][keySize 2][key][valueSize 4][value]
+//
+// length is the payload size, crc32 (IEEE) covers the LSN and the payload
+// A legacy WAL (no file header, bare [opType 1][key 4][valueSize 4][value]
+// entries with a little-endian key) is rewritten in this format on open
+const (
+	walMagic              = "SGWL"
+	walVersion            = 1
+	FileHeaderSize        = 8
+	recordHeaderSize      = 16
+	entryHeaderSize       = 27 // without key and value
+	legacyEntryHeaderSize = 9  // [opType 1][key 4][valueSize 4]
+)
+
+// legacyKey converts the 4-byte little-endian key of a legacy entry to the
+// big-endian encoding that sorts numerically as bytes
+func legacyKey(data []byte) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, binary.LittleEndian.Uint32(data))
+	return key
+}
+
+// ErrUnsupportedVersion is returned when the WAL was written by a newer format version
+var ErrUnsupportedVersion = errors.New("unsupported WAL version")
+
+// errLegacyFormat marks a WAL written before records were checksummed
+var errLegacyFormat = errors.New("legacy WAL format")
+
+// encodeFileHeader returns the header written at the start of every WAL file
+func encodeFileHeader() []byte {
+	header := make([]byte, FileHeaderSize)
+	copy(header[0:4], walMagic)
+	binary.LittleEndian.PutUint16(header[4:6], walVersion)
+	return header
+}
+
+// checkFileHeader validates the magic and version of a WAL file
+func checkFileHeader(header []byte) error {
+	if string(header[0:4]) != walMagic {
+		return errLegacyFormat
+	}
+
+	if version := binary.LittleEndian.Uint16(header[4:6]); version != walVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+
+	return nil
+}
+
+// encodeEntry serializes the operation part of an entry (the record payload)
+func encodeEntry(entry *Entry) []byte {
+	valueBytes := []byte(entry.Value)
+	keySize := len(entry.Key)
+
+	// Total size: 1 (opType) + 8 (txID) + 4 (table) + 8 (time) + 2 (keySize) + key + 4 (valueSize) + value
+	data := make([]byte, entryHeaderSize+keySize+len(valueBytes))
+
+	data[0] = byte(entry.OpType)
+	binary.LittleEndian.PutUint64(data[1:9], entry.TxID)
+	binary.LittleEndian.PutUint32(data[9:13], entry.Table)
+	binary.LittleEndian.PutUint64(data[13:21], uint64(entry.Time))
+	binary.LittleEndian.PutUint16(data[21:23], uint16(keySize))
+	copy(data[23:], entry.Key)
+	offset := 23 + keySize
+	binary.LittleEndian.PutUint32(data[offset:offset+4], uint32(len(valueBytes)))
+	copy(data[offset+4:], valueBytes)
+
+	return data
+}
+
+// decodeEntry parses a record payload
+// Returns false if the payload is malformed
+func decodeEntry(payload []byte) (*Entry, bool) {
+	if len(payload) < entryHeaderSize {
+		return nil, false
+	}
+	keySize := int(binary.LittleEndian.Uint16(payload[21:23]))
+	offset := 23 + keySize
+	if offset+4 > len(payload) {
+		return nil, false
+	}
+	valueSize := binary.LittleEndian.Uint32(payload[offset : offset+4])
+	if int(valueSize) != len(payload)-offset-4 {
+		return nil, false
+	}
+
+	return &Entry{
+		OpType: OpType(payload[0]),
+		TxID:   binary.LittleEndian.Uint64(payload[1:9]),
+		Table:  binary.LittleEndian.Uint32(payload[9:13]),
+		Time:   int64(binary.LittleEndian.Uint64(payload[13:21])),
+		Key:    bytes.Clone(payload[23:offset]),
+		Value:  string(payload[offset+4:]),
+	}, true
+}
+
+// encodeRecord frames an entry with its length, checksum and LSN
+func encodeRecord(entry *Entry) []byte {
+	payload := encodeEntry(entry)
+
+	data := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(data[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint64(data[8:16], entry.LSN)
+	copy(data[recordHeaderSize:], payload)
+
+	// Checksum covers LSN + payload
+	binary.LittleEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(data[8:]))
+
+	return data
+}
+
+// scanRecords reads records until the end of the log or the first record
+// that is torn, fails its checksum or breaks the LSN sequence
+// size is the number of bytes left in the file after the current position
+// Returns the valid entries and the number of bytes they occupy
+func scanRecords(r io.Reader, size int64) ([]*Entry, int64, error) {
+	reader := bufio.NewReader(r)
+	entries := make([]*Entry, 0)
+	valid := int64(0)
+	header := make([]byte, recordHeaderSize)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, valid, nil
+			}
+			return nil, 0, fmt.Errorf("failed to read record header: %w", err)
+		}
+
+		length := int64(binary.LittleEndian.Uint32(header[0:4]))
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		lsn := binary.LittleEndian.Uint64(header[8:16])
+
+		// Length pointing past the end of the file means a torn or garbage header
+		if length < entryHeaderSize || valid+recordHeaderSize+length > size {
+			return entries, valid, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, valid, nil
+			}
+			return nil, 0, fmt.Errorf("failed to read record payload: %w", err)
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[8:16])
+		crc.Write(payload)
+		if crc.Sum32() != checksum {
+			return entries, valid, nil
+		}
+
+		if len(entries) > 0 && lsn != entries[len(entries)-1].LSN+1 {
+			return entries, valid, nil
+		}
+
+		entry, ok := decodeEntry(payload)
+		if !ok {
+			return entries, valid, nil
+		}
+
+		entry.LSN = lsn
+		entries = append(entries, entry)
+		valid += recordHeaderSize + length
+	}
+}
+
+// scanLegacyEntries reads entries written before the checksummed format
+// (bare 9-byte header + value), stopping at the first incomplete entry
+func scanLegacyEntries(r io.Reader, size int64) ([]*Entry, error) {
+	reader := bufio.NewReader(r)
+	entries := make([]*Entry, 0)
+	header := make([]byte, legacyEntryHeaderSize)
+	offset := int64(0)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, nil
+			}
+			return nil, fmt.Errorf("failed to read legacy entry: %w", err)
+		}
+
+		valueSize := int64(binary.LittleEndian.Uint32(header[5:9]))
+		if offset+legacyEntryHeaderSize+valueSize > size {
+			return entries, nil
+		}
+
+		valueBytes := make([]byte, valueSize)
+		if _, err := io.ReadFull(reader, valueBytes); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, nil
+			}
+			return nil, fmt.Errorf("failed to read legacy value: %w", err)
+		}
+
+		entries = append(entries, &Entry{
+			OpType: OpType(header[0]),
+			Key:    legacyKey(header[1:5]),
+			Value:  string(valueBytes),
+			LSN:    uint64(len(entries) + 1),
+		})
+		offset += legacyEntryHeaderSize + valueSize
+	}
+}
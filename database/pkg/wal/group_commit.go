@@ -0,0 +1,234 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxBatchSize is the maximum number of entries committed by a single fsync
+const MaxBatchSize = 256
+
+// ErrClosed is returned by Append after the WAL was closed
+var ErrClosed = errors.New("WAL is closed")
+
+// appendRequest is an entry waiting for the flusher
+type appendRequest struct {
+	entry    *Entry
+	sync     bool // fsync before releasing the caller (subject to the sync mode)
+	enqueued time.Time
+	done     chan error
+}
+
+// GroupCommitStats describes how appends were batched into fsyncs
+type GroupCommitStats struct {
+	Batches          int           // Batches written (one write each, plus an fsync in SyncFull)
+	Entries          int           // Entries committed
+	MaxBatchSize     int           // Largest batch so far
+	AvgBatchSize     float64       // Entries per fsync
+	AvgSyncTime      time.Duration // Write (+ fsync) time per batch
+	AvgCommitLatency time.Duration // Time from Append until the entry is durable
+}
+
+func (s GroupCommitStats) String() string {
+	return fmt.Sprintf("Batches: %d, Entries: %d, AvgBatch: %.2f, MaxBatch: %d, AvgSync: %v, AvgLatency: %v",
+		s.Batches, s.Entries, s.AvgBatchSize, s.MaxBatchSize, s.AvgSyncTime, s.AvgCommitLatency)
+}
+
+// groupCommitCounters accumulates GroupCommitStats, guarded by WAL.mu
+type groupCommitCounters struct {
+	batches       int
+	entries       int
+	maxBatch      int
+	syncTime      time.Duration
+	commitLatency time.Duration
+}
+
+// SetCommitDelay makes the flusher wait up to delay after the first entry of a
+// batch for more writers to join it. Zero (the default) only batches entries
+// that queued up while the previous fsync was running
+func (w *WAL) SetCommitDelay(delay time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.commitDelay = delay
+}
+
+// GetGroupCommitStats returns batching statistics of the flusher
+func (w *WAL) GetGroupCommitStats() GroupCommitStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	c := w.groupCommit
+	stats := GroupCommitStats{
+		Batches:      c.batches,
+		Entries:      c.entries,
+		MaxBatchSize: c.maxBatch,
+	}
+
+	if c.batches > 0 {
+		stats.AvgBatchSize = float64(c.entries) / float64(c.batches)
+		stats.AvgSyncTime = c.syncTime / time.Duration(c.batches)
+	}
+	if c.entries > 0 {
+		stats.AvgCommitLatency = c.commitLatency / time.Duration(c.entries)
+	}
+
+	return stats
+}
+
+// flusher is the single goroutine that writes and fsyncs batches of entries
+// It also runs the interval fsync of SyncNormal
+// Runs until the request channel is closed by Close
+func (w *WAL) flusher() {
+	defer close(w.flusherDone)
+	defer w.syncTicker.Stop()
+
+	for {
+		select {
+		case first, ok := <-w.requests:
+			if !ok {
+				return
+			}
+
+			batch := w.collectBatch(first)
+			err := w.commitBatch(batch)
+
+			for _, req := range batch {
+				req.done <- err
+			}
+
+		case <-w.syncTicker.C:
+			// Nobody waits on this sync, a failure surfaces on the next fsync
+			w.periodicSync()
+		}
+	}
+}
+
+// collectBatch gathers the requests queued behind first (waiting up to the
+// commit delay for more) without exceeding MaxBatchSize
+func (w *WAL) collectBatch(first *appendRequest) []*appendRequest {
+	batch := make([]*appendRequest, 0, MaxBatchSize)
+	batch = append(batch, first)
+
+	w.mu.Lock()
+	delay := w.commitDelay
+	w.mu.Unlock()
+
+	var deadline <-chan time.Time
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for len(batch) < MaxBatchSize {
+		if deadline == nil {
+			select {
+			case req, ok := <-w.requests:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, req)
+			default:
+				return batch
+			}
+			continue
+		}
+
+		select {
+		case req, ok := <-w.requests:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, req)
+		case <-deadline:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+// commitBatch assigns LSNs, writes all records with one write and syncs
+// them as the sync mode requires
+func (w *WAL) commitBatch(batch []*appendRequest) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	start := time.Now()
+
+	// Times never go back along the log, even if the clock does
+	stamp := max(start.UnixNano(), w.lastTime)
+
+	data := make([]byte, 0)
+	sync := false
+	for i, req := range batch {
+		req.entry.LSN = w.nextLSN + uint64(i)
+		req.entry.Time = stamp
+		data = append(data, encodeRecord(req.entry)...)
+		sync = sync || req.sync
+	}
+
+	if err := w.writeBatch(data, w.nextLSN+uint64(len(batch))-1, sync); err != nil {
+		for _, req := range batch {
+			req.entry.LSN = 0
+			req.entry.Time = 0
+		}
+		return err
+	}
+
+	now := time.Now()
+	w.nextLSN += uint64(len(batch))
+	w.lastTime = stamp
+
+	c := &w.groupCommit
+	c.batches++
+	c.entries += len(batch)
+	c.maxBatch = max(c.maxBatch, len(batch))
+	c.syncTime += now.Sub(start)
+	for _, req := range batch {
+		c.commitLatency += now.Sub(req.enqueued)
+	}
+
+	return nil
+}
+
+// writeBatch appends data, the records up to lastLSN, to the file and syncs
+// it according to the sync mode, caller must hold w.mu
+// On failure the batch is cut off the file again, so its LSNs can be given
+// to the next batch without two records sharing one
+func (w *WAL) writeBatch(data []byte, lastLSN uint64, sync bool) error {
+	end := FileHeaderSize + w.size
+	written := w.writtenLSN
+
+	// Write to file
+	if _, err := w.file.Write(data); err != nil {
+		return w.undoBatch(end, written, lastLSN, fmt.Errorf("failed to write WAL entry: %w", err))
+	}
+	w.writtenLSN = lastLSN
+	w.size += int64(len(data))
+
+	// Flush to disk (fsync) unless the mode defers it
+	if err := w.syncAfterWrite(sync); err != nil {
+		return w.undoBatch(end, written, lastLSN, err)
+	}
+	return nil
+}
+
+// undoBatch removes a failed batch, the bytes after end, and returns cause
+// If the file cannot be cut, the batch may still be read back on recovery:
+// its LSNs are then used up instead, up to lastLSN
+func (w *WAL) undoBatch(end int64, written, lastLSN uint64, cause error) error {
+	if err := w.file.Truncate(end); err != nil {
+		w.writtenLSN = lastLSN
+		w.nextLSN = lastLSN + 1
+		if statErr := w.statSize(); statErr != nil {
+			return fmt.Errorf("%w (failed to remove the batch: %v, %v)", cause, err, statErr)
+		}
+		return fmt.Errorf("%w (failed to remove the batch: %v)", cause, err)
+	}
+
+	w.writtenLSN = written
+	w.size = end - FileHeaderSize
+	return cause
+}
@@ -0,0 +1,205 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// fsync syncs the log file, tests replace it to inject failures
+var fsync = (*os.File).Sync
+
+// SyncMode controls when appended records are fsynced
+type SyncMode int
+
+const (
+	// SyncFull fsyncs every batch before acknowledging it (no committed write is lost)
+	SyncFull SyncMode = iota
+	// SyncNormal fsyncs on an interval and at checkpoints
+	SyncNormal
+	// SyncOff leaves flushing to the OS page cache
+	SyncOff
+)
+
+// DefaultSyncInterval is how often SyncNormal fsyncs the WAL
+const DefaultSyncInterval = time.Second
+
+// UnboundedLossWindow is reported by LossWindow when the WAL never fsyncs on its own
+const UnboundedLossWindow time.Duration = -1
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncFull:
+		return "FULL"
+	case SyncNormal:
+		return "NORMAL"
+	case SyncOff:
+		return "OFF"
+	default:
+		return fmt.Sprintf("SyncMode(%d)", int(m))
+	}
+}
+
+// ParseSyncMode parses "full", "normal" or "off" (case-insensitive)
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "FULL":
+		return SyncFull, nil
+	case "NORMAL":
+		return SyncNormal, nil
+	case "OFF":
+		return SyncOff, nil
+	default:
+		return SyncFull, fmt.Errorf("unknown sync mode %q (expected FULL, NORMAL or OFF)", s)
+	}
+}
+
+// SetSyncMode changes when appends are fsynced
+// interval is only used by SyncNormal, <= 0 means DefaultSyncInterval
+// Records appended before the switch are synced right away
+func (w *WAL) SetSyncMode(mode SyncMode, interval time.Duration) error {
+	if mode != SyncFull && mode != SyncNormal && mode != SyncOff {
+		return fmt.Errorf("invalid sync mode: %v", mode)
+	}
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+
+	w.syncMode = mode
+	w.syncInterval = interval
+	w.syncTicker.Reset(interval)
+	return nil
+}
+
+// SyncMode returns the current sync mode
+func (w *WAL) SyncMode() SyncMode {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncMode
+}
+
+// LossWindow returns how much acknowledged work a power failure can lose in
+// the worst case: 0 for SyncFull, the sync interval for SyncNormal and
+// UnboundedLossWindow for SyncOff (until the OS writes its page cache back)
+// A process crash loses nothing in any mode, written records are in the page cache
+func (w *WAL) LossWindow() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lossWindowLocked()
+}
+
+// lossWindowLocked implements LossWindow, caller must hold w.mu
+func (w *WAL) lossWindowLocked() time.Duration {
+	switch w.syncMode {
+	case SyncFull:
+		return 0
+	case SyncNormal:
+		return w.syncInterval
+	default:
+		return UnboundedLossWindow
+	}
+}
+
+// Durability describes the sync mode and its loss window, e.g. "FULL (no acknowledged writes lost)"
+func (w *WAL) Durability() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	mode := w.syncMode
+	switch window := w.lossWindowLocked(); window {
+	case 0:
+		return fmt.Sprintf("%s (no acknowledged writes lost)", mode)
+	case UnboundedLossWindow:
+		return fmt.Sprintf("%s (writes not yet flushed by the OS lost on power failure)", mode)
+	default:
+		return fmt.Sprintf("%s (up to %v of writes lost on power failure)", mode, window)
+	}
+}
+
+// Sync fsyncs records that were written but not yet synced
+// Used by checkpoints so the log reaches disk before the pages it describes
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+// SyncTo makes the records up to lsn durable, fsyncing only if some of
+// them are not yet, whatever the sync mode
+// Used by the buffer pool before it writes a page changed by those records
+func (w *WAL) SyncTo(lsn uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if lsn <= w.durableLSN {
+		return nil
+	}
+	if lsn > w.writtenLSN {
+		return fmt.Errorf("cannot sync WAL to LSN %d, last written is %d", lsn, w.writtenLSN)
+	}
+	return w.syncLocked()
+}
+
+// DurableLSN returns the LSN up to which records are durable
+func (w *WAL) DurableLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.durableLSN
+}
+
+// syncLocked fsyncs pending records, caller must hold w.mu
+func (w *WAL) syncLocked() error {
+	if !w.unsynced {
+		w.durableLSN = w.writtenLSN
+		return nil
+	}
+
+	if err := fsync(w.file); err != nil {
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+
+	w.unsynced = false
+	w.durableLSN = w.writtenLSN
+	w.lastSync = time.Now()
+	w.syncs++
+	return nil
+}
+
+// periodicSync is run by the flusher on every sync tick (SyncNormal only)
+func (w *WAL) periodicSync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.syncMode != SyncNormal {
+		return nil
+	}
+	return w.syncLocked()
+}
+
+// syncAfterWrite applies the sync mode to a batch that was just written,
+// sync is false when no caller in the batch needs its entry durable yet,
+// caller must hold w.mu
+func (w *WAL) syncAfterWrite(sync bool) error {
+	w.unsynced = true
+
+	switch w.syncMode {
+	case SyncFull:
+		if !sync {
+			return nil
+		}
+		return w.syncLocked()
+	case SyncNormal:
+		if time.Since(w.lastSync) >= w.syncInterval {
+			return w.syncLocked()
+		}
+	}
+	return nil
+}